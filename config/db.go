@@ -100,5 +100,310 @@ func setupCollections(client *mongo.Client) {
 		}
 	}
 
+	// Booking slot holds: one booking per provider and calendar cell of a date
+	holdsIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serviceProviderId", Value: 1},
+			{Key: "date", Value: 1},
+			{Key: "timeSlot", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("booking_slot_holds").Indexes().CreateOne(ctx, holdsIndexModel); err != nil {
+		log.Printf("Error creating booking slot hold index: %v", err)
+	}
+	bookingIdIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "bookingId", Value: 1}},
+	}
+	if _, err := db.Collection("booking_slot_holds").Indexes().CreateOne(ctx, bookingIdIndexModel); err != nil {
+		log.Printf("Error creating booking slot hold bookingId index: %v", err)
+	}
+
+	// Bookings are looked up per provider and day when computing free slots
+	bookingsIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "serviceProviderId", Value: 1},
+			{Key: "bookingDate", Value: 1},
+			{Key: "status", Value: 1},
+		},
+	}
+	if _, err := db.Collection("bookings").Indexes().CreateOne(ctx, bookingsIndexModel); err != nil {
+		log.Printf("Error creating bookings index: %v", err)
+	}
+
+	// 2dsphere indexes for radius and bounding-box searches on GeoJSON points
+	for collName, path := range map[string]string{
+		"users":            "location.point",
+		"serviceProviders": "contactInfo.address.point",
+		"companies":        "branches.location.point",
+		"wholesalers":      "branches.location.point",
+	} {
+		geoIndexModel := mongo.IndexModel{
+			Keys: bson.D{{Key: path, Value: "2dsphere"}},
+		}
+		if _, err := db.Collection(collName).Indexes().CreateOne(ctx, geoIndexModel); err != nil {
+			log.Printf("Error creating 2dsphere index on %s.%s: %v", collName, path, err)
+		}
+	}
+
+	// Emergency dispatches are swept for timed out rounds
+	dispatchIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "roundExpiresAt", Value: 1},
+		},
+	}
+	if _, err := db.Collection("emergency_dispatches").Indexes().CreateOne(ctx, dispatchIndexModel); err != nil {
+		log.Printf("Error creating emergency dispatch index: %v", err)
+	}
+
+	// One stats document per sponsorable entity per day
+	dailyStatsIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "entityType", Value: 1},
+			{Key: "entityId", Value: 1},
+			{Key: "date", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("entity_daily_stats").Indexes().CreateOne(ctx, dailyStatsIndexModel); err != nil {
+		log.Printf("Error creating entity daily stats index: %v", err)
+	}
+
+	// Sponsor places are counted per category and governorate
+	slotIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "category", Value: 1},
+			{Key: "governorate", Value: 1},
+			{Key: "status", Value: 1},
+			{Key: "endDate", Value: 1},
+		},
+	}
+	if _, err := db.Collection("sponsorship_slots").Indexes().CreateOne(ctx, slotIndexModel); err != nil {
+		log.Printf("Error creating sponsorship slots index: %v", err)
+	}
+
+	capIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "category", Value: 1},
+			{Key: "governorate", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("sponsorship_inventory_caps").Indexes().CreateOne(ctx, capIndexModel); err != nil {
+		log.Printf("Error creating sponsorship inventory caps index: %v", err)
+	}
+
+	waitlistIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "createdAt", Value: 1},
+		},
+	}
+	if _, err := db.Collection("sponsorship_waitlist").Indexes().CreateOne(ctx, waitlistIndexModel); err != nil {
+		log.Printf("Error creating sponsorship waitlist index: %v", err)
+	}
+
+	promoCodeIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("promo_codes").Indexes().CreateOne(ctx, promoCodeIndexModel); err != nil {
+		log.Printf("Error creating promo codes index: %v", err)
+	}
+
+	redemptionIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "checkout", Value: 1}, {Key: "requestId", Value: 1}}},
+		{Keys: bson.D{{Key: "promoCodeId", Value: 1}, {Key: "userId", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "redeemedAt", Value: 1}}},
+	}
+	if _, err := db.Collection("promo_code_redemptions").Indexes().CreateMany(ctx, redemptionIndexModels); err != nil {
+		log.Printf("Error creating promo code redemptions indexes: %v", err)
+	}
+
+	reportIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "contentType", Value: 1},
+			{Key: "contentId", Value: 1},
+			{Key: "reporterId", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("content_reports").Indexes().CreateOne(ctx, reportIndexModel); err != nil {
+		log.Printf("Error creating content reports index: %v", err)
+	}
+
+	moderationWordIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "word", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := db.Collection("moderation_words").Indexes().CreateOne(ctx, moderationWordIndexModel); err != nil {
+		log.Printf("Error creating moderation words index: %v", err)
+	}
+
+	// One review per booking; unverified reviews have no bookingId
+	reviewBookingIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "bookingId", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"bookingId": bson.M{"$exists": true}}),
+	}
+	if _, err := db.Collection("reviews").Indexes().CreateOne(ctx, reviewBookingIndexModel); err != nil {
+		log.Printf("Error creating reviews booking index: %v", err)
+	}
+
+	ratingIndexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "entityType", Value: 1}, {Key: "entityId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "entityType", Value: 1}, {Key: "bayesianAverage", Value: -1}}},
+	}
+	if _, err := db.Collection("rating_aggregates").Indexes().CreateMany(ctx, ratingIndexModels); err != nil {
+		log.Printf("Error creating rating aggregates indexes: %v", err)
+	}
+
+	pointsLedgerIndexModel := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "accountType", Value: 1},
+			{Key: "accountId", Value: 1},
+			{Key: "createdAt", Value: -1},
+		},
+	}
+	if _, err := db.Collection("points_ledger").Indexes().CreateOne(ctx, pointsLedgerIndexModel); err != nil {
+		log.Printf("Error creating points ledger index: %v", err)
+	}
+
+	// Loyalty scores and points expiry scan the ledger by entry type and age
+	loyaltyIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: 1}}},
+	}
+	if _, err := db.Collection("points_ledger").Indexes().CreateMany(ctx, loyaltyIndexModels); err != nil {
+		log.Printf("Error creating loyalty ledger indexes: %v", err)
+	}
+	bookingLoyaltyIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "bookingDate", Value: 1}, {Key: "userId", Value: 1}},
+	}
+	if _, err := db.Collection("bookings").Indexes().CreateOne(ctx, bookingLoyaltyIndexModel); err != nil {
+		log.Printf("Error creating bookings loyalty index: %v", err)
+	}
+
+	// Commission rules are matched per sale, and earlier commissions tell a renewal from a first sale
+	commissionRuleIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "isActive", Value: 1}, {Key: "priority", Value: -1}}},
+	}
+	if _, err := db.Collection("commission_rules").Indexes().CreateMany(ctx, commissionRuleIndexModels); err != nil {
+		log.Printf("Error creating commission rule indexes: %v", err)
+	}
+	commissionIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entityType", Value: 1}, {Key: "entityId", Value: 1}, {Key: "branchId", Value: 1}}},
+	}
+	if _, err := db.Collection("commissions").Indexes().CreateMany(ctx, commissionIndexModels); err != nil {
+		log.Printf("Error creating commission indexes: %v", err)
+	}
+	commissionRecordIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ruleId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "salespersonId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "salesManagerId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	}
+	if _, err := db.Collection("commission_records").Indexes().CreateMany(ctx, commissionRecordIndexModels); err != nil {
+		log.Printf("Error creating commission record indexes: %v", err)
+	}
+
+	voucherPurchaseIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ownerType", Value: 1}, {Key: "ownerId", Value: 1}, {Key: "voucherId", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerType", Value: 1}, {Key: "ownerId", Value: 1}, {Key: "purchasedAt", Value: -1}}},
+	}
+	if _, err := db.Collection("voucher_purchases").Indexes().CreateMany(ctx, voucherPurchaseIndexModels); err != nil {
+		log.Printf("Error creating voucher purchases indexes: %v", err)
+	}
+	voucherCodeIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "redemptionCode", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	}
+	if _, err := db.Collection("voucher_purchases").Indexes().CreateOne(ctx, voucherCodeIndexModel); err != nil {
+		log.Printf("Error creating voucher redemption code index: %v", err)
+	}
+
+	redemptionAttemptIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "companyId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "wholesalerId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "purchaseId", Value: 1}}},
+		{Keys: bson.D{{Key: "voucherId", Value: 1}, {Key: "result", Value: 1}}},
+	}
+	if _, err := db.Collection("voucher_redemption_attempts").Indexes().CreateMany(ctx, redemptionAttemptIndexModels); err != nil {
+		log.Printf("Error creating voucher redemption attempts indexes: %v", err)
+	}
+
+	voucherIssuerIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "issuerType", Value: 1}, {Key: "issuerId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+	if _, err := db.Collection("vouchers").Indexes().CreateOne(ctx, voucherIssuerIndexModel); err != nil {
+		log.Printf("Error creating voucher issuer index: %v", err)
+	}
+	voucherRequestIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "submittedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "issuerType", Value: 1}, {Key: "issuerId", Value: 1}, {Key: "submittedAt", Value: -1}}},
+	}
+	if _, err := db.Collection("voucher_requests").Indexes().CreateMany(ctx, voucherRequestIndexModels); err != nil {
+		log.Printf("Error creating voucher requests indexes: %v", err)
+	}
+
+	// Referral events are rewarded once per referee
+	referralEventIndexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "event", Value: 1}, {Key: "refereeType", Value: 1}, {Key: "refereeId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "refereeId", Value: 1}, {Key: "event", Value: 1}}},
+		{Keys: bson.D{{Key: "referrerId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "fingerprint.ip", Value: 1}, {Key: "createdAt", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "fingerprint.deviceId", Value: 1}, {Key: "createdAt", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "reviewStatus", Value: 1}, {Key: "createdAt", Value: -1}}, Options: options.Index().SetSparse(true)},
+	}
+	if _, err := db.Collection("referral_events").Indexes().CreateMany(ctx, referralEventIndexModels); err != nil {
+		log.Printf("Error creating referral events indexes: %v", err)
+	}
+	referralRuleIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "event", Value: 1}, {Key: "isActive", Value: 1}},
+	}
+	if _, err := db.Collection("referral_rules").Indexes().CreateOne(ctx, referralRuleIndexModel); err != nil {
+		log.Printf("Error creating referral rules index: %v", err)
+	}
+	referralRewardIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ruleId", Value: 1}, {Key: "beneficiary", Value: 1}, {Key: "accountType", Value: 1}, {Key: "accountId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "rewardType", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "accountId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "holdReason", Value: 1}, {Key: "createdAt", Value: 1}}},
+	}
+	if _, err := db.Collection("referral_rewards").Indexes().CreateMany(ctx, referralRewardIndexModels); err != nil {
+		log.Printf("Error creating referral rewards indexes: %v", err)
+	}
+	referralLinkIndexModels := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "referrerId", Value: 1}, {Key: "refereeId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "refereeAccounts", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "referrerType", Value: 1}}},
+	}
+	if _, err := db.Collection("referral_links").Indexes().CreateMany(ctx, referralLinkIndexModels); err != nil {
+		log.Printf("Error creating referral links indexes: %v", err)
+	}
+	referralClickIndexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "deviceId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "referrerId", Value: 1}}},
+	}
+	if _, err := db.Collection("referral_clicks").Indexes().CreateMany(ctx, referralClickIndexModels); err != nil {
+		log.Printf("Error creating referral clicks indexes: %v", err)
+	}
+	for _, collection := range []string{"users", "companies", "wholesalers", "serviceProviders"} {
+		referralsIndexModel := mongo.IndexModel{Keys: bson.D{{Key: "referrals", Value: 1}}}
+		if _, err := db.Collection(collection).Indexes().CreateOne(ctx, referralsIndexModel); err != nil {
+			log.Printf("Error creating %s referrals index: %v", collection, err)
+		}
+	}
+
 	log.Println("Database collections and indexes setup complete")
 }
@@ -1772,80 +1772,19 @@ func (sc *SubscriptionController) ProcessWholesalerSubscriptionRequest(c echo.Co
 		subscription = &newSubscription
 
 		// --- Commission logic start ---
-		// Check if wholesaler was created by a salesperson or by itself (user signup)
-		log.Printf("DEBUG: Wholesaler CreatedBy field: %v (IsZero: %v)", wholesaler.CreatedBy, wholesaler.CreatedBy.IsZero())
-
-		// Check if wholesaler was created by itself (user signup) - CreatedBy equals UserID
-		if wholesaler.CreatedBy == wholesaler.UserID {
-			log.Printf("DEBUG: Wholesaler was created by user signup, adding subscription price to admin wallet")
-			// Add subscription price directly to admin wallet (no commission calculation needed)
-			log.Printf("Subscription income added to admin wallet: $%.2f from wholesaler '%s' (ID: %s) - User signup subscription",
-				plan.Price, wholesaler.BusinessName, wholesaler.ID.Hex())
-		} else if !wholesaler.CreatedBy.IsZero() {
-			log.Printf("DEBUG: Wholesaler was created by salesperson, proceeding with commission calculation")
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": wholesaler.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				log.Printf("DEBUG: Found salesperson: %s (ID: %v)", salesperson.FullName, salesperson.ID)
-				// Get sales manager - try both collection names due to inconsistency
-				var salesManager models.SalesManager
-				err := sc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-				if err != nil {
-					// Try the alternative collection name
-					log.Printf("DEBUG: Sales manager not found in sales_managers collection, trying salesManagers collection")
-					err = sc.DB.Collection("salesManagers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-				}
-				if err == nil {
-					log.Printf("DEBUG: Found sales manager: %s (ID: %v)", salesManager.FullName, salesManager.ID)
-					planPrice := plan.Price
-					salespersonPercent := salesperson.CommissionPercent
-					salesManagerPercent := salesManager.CommissionPercent
-
-					log.Printf("DEBUG: Plan price: $%.2f, Salesperson commission percent: %.2f%%, Sales Manager commission percent: %.2f%%",
-						planPrice, salespersonPercent, salesManagerPercent)
-
-					// Calculate commissions correctly
-					// Salesperson gets their percentage directly from the plan price
-					salespersonCommission := planPrice * salespersonPercent / 100.0
-					// Sales manager gets their percentage directly from the plan price
-					salesManagerCommission := planPrice * salesManagerPercent / 100.0
-
-					log.Printf("DEBUG: Calculated commissions - Sales Manager: $%.2f, Salesperson: $%.2f",
-						salesManagerCommission, salespersonCommission)
-
-					// Insert commission document using Commission model
-					commission := models.Commission{
-						ID:                            primitive.NewObjectID(),
-						SubscriptionID:                newSubscription.ID,
-						CompanyID:                     primitive.NilObjectID, // No company ID for wholesaler
-						PlanID:                        plan.ID,
-						PlanPrice:                     planPrice,
-						SalespersonID:                 salesperson.ID,
-						SalespersonCommission:         salespersonCommission,
-						SalespersonCommissionPercent:  salespersonPercent,
-						SalesManagerID:                salesManager.ID,
-						SalesManagerCommission:        salesManagerCommission,
-						SalesManagerCommissionPercent: salesManagerPercent,
-						CreatedAt:                     time.Now(),
-						Paid:                          false,
-						PaidAt:                        nil,
-					}
-					_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-					if err != nil {
-						log.Printf("Failed to insert commission: %v", err)
-					} else {
-						log.Printf("Commission inserted successfully for wholesaler subscription - Plan Price: $%.2f, Sales Manager Commission: $%.2f, Salesperson Commission: $%.2f",
-							planPrice, salesManagerCommission, salespersonCommission)
-					}
-				} else {
-					log.Printf("DEBUG: Failed to find sales manager for ID: %v, Error: %v", salesperson.SalesManagerID, err)
-				}
-			} else {
-				log.Printf("DEBUG: Failed to find salesperson for ID: %v, Error: %v", wholesaler.CreatedBy, err)
+		// Only proceed if wholesaler was created by a salesperson
+		if wholesaler.CreatedBy != wholesaler.UserID {
+			_, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: newSubscription.ID,
+				EntityType:     "wholesaler",
+				EntityID:       wholesaler.ID,
+				EntityName:     wholesaler.BusinessName,
+				CreatedBy:      wholesaler.CreatedBy,
+				Plan:           plan,
+			})
+			if err != nil {
+				log.Printf("Failed to apply commission: %v", err)
 			}
-		} else {
-			log.Printf("DEBUG: Wholesaler was not created by a salesperson (CreatedBy is zero or not set)")
 		}
 		// --- Commission logic end ---
 
@@ -5030,6 +4969,7 @@ func (ac *AdminController) UpdateSalesperson(c echo.Context) error {
 		"Image":             "Image",
 		"image":             "Image",
 		"commissionPercent": "commissionPercent",
+		"commissionTier":    "commissionTier", // Matched by commission rules
 	}
 
 	for requestField, dbField := range fieldMappings {
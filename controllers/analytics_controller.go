@@ -0,0 +1,533 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+)
+
+var (
+	errUnknownEntityType     = errors.New("unknown entity type")
+	errInvalidAnalyticsRange = errors.New("from and to must be YYYY-MM-DD dates at most a year apart")
+)
+
+// clientTrackedEvents are the events the apps report; the server records the rest itself
+var clientTrackedEvents = map[string]bool{
+	models.EntityEventProfileView: true,
+	models.EntityEventBranchView:  true,
+	models.EntityEventPhoneTap:    true,
+	models.EntityEventWhatsAppTap: true,
+}
+
+// AnalyticsController handles engagement tracking and sponsorship performance dashboards
+type AnalyticsController struct {
+	db *mongo.Client
+}
+
+// NewAnalyticsController creates a new analytics controller
+func NewAnalyticsController(db *mongo.Client) *AnalyticsController {
+	return &AnalyticsController{db: db}
+}
+
+// analyticsEntity is a sponsorable entity shown in a dashboard
+type analyticsEntity struct {
+	entityType string
+	id         primitive.ObjectID
+	name       string
+	sponsored  bool
+}
+
+// TrackEvent records a view or contact tap reported by the apps. Repeats from the
+// same visitor within a short window are counted once.
+func (ac *AnalyticsController) TrackEvent(c echo.Context) error {
+	var req models.TrackEntityEventRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+
+	if !clientTrackedEvents[req.Event] {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "event must be 'profile_view', 'branch_view', 'phone_tap' or 'whatsapp_tap'",
+		})
+	}
+	entityID, err := primitive.ObjectIDFromHex(req.EntityID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid entity ID",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entity, err := ac.findEntity(ctx, req.EntityType, entityID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Entity not found",
+			})
+		}
+		if err == errUnknownEntityType {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid entity type",
+			})
+		}
+		log.Printf("Failed to find %s %s for tracking: %v", req.EntityType, req.EntityID, err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to record event",
+		})
+	}
+
+	visitor := c.RealIP()
+	if claims := middleware.GetUserFromToken(c); claims != nil && claims.UserID != "" {
+		visitor = claims.UserID
+	}
+	if utils.ShouldCountEntityEvent(visitor, entity.entityType, entity.id, req.Event) {
+		go utils.RecordEntityEvent(ac.db, entity.entityType, entity.id, req.Event)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Event recorded",
+	})
+}
+
+// GetMyAnalytics returns the daily stats, totals and sponsored-period comparisons
+// of the caller's service provider profile, or of their company or wholesaler
+// and its branches.
+//
+// Query params: from, to (YYYY-MM-DD, default the last 30 days), entityId
+func (ac *AnalyticsController) GetMyAnalytics(c echo.Context) error {
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Unauthorized",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	from, to, err := parseAnalyticsRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	entities, err := ac.ownedEntities(ctx, userID, claims.UserType)
+	if err != nil {
+		log.Printf("Failed to load entities of user %s: %v", claims.UserID, err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to load analytics",
+		})
+	}
+
+	if entityID := c.QueryParam("entityId"); entityID != "" {
+		var selected []analyticsEntity
+		for _, e := range entities {
+			if e.id.Hex() == entityID {
+				selected = append(selected, e)
+			}
+		}
+		if len(selected) == 0 {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Entity not found among your profiles",
+			})
+		}
+		entities = selected
+	}
+
+	dashboards := make([]models.EntityAnalytics, 0, len(entities))
+	var overall []models.EntityDailyStats
+	for _, e := range entities {
+		dashboard, err := ac.entityAnalytics(ctx, e, from, to)
+		if err != nil {
+			log.Printf("Failed to load analytics of %s %s: %v", e.entityType, e.id.Hex(), err)
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to load analytics",
+			})
+		}
+		overall = append(overall, dashboard.Daily...)
+		dashboards = append(dashboards, dashboard)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Analytics retrieved successfully",
+		Data: map[string]interface{}{
+			"from":     from,
+			"to":       to,
+			"totals":   utils.SumEntityStats(overall),
+			"entities": dashboards,
+		},
+	})
+}
+
+// GetEntityAnalyticsForAdmin returns the dashboard of any sponsorable entity.
+//
+// Query params: from, to (YYYY-MM-DD, default the last 30 days)
+func (ac *AnalyticsController) GetEntityAnalyticsForAdmin(c echo.Context) error {
+	entityID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid entity ID",
+		})
+	}
+	from, to, err := parseAnalyticsRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	entity, err := ac.findEntity(ctx, c.Param("type"), entityID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Entity not found",
+			})
+		}
+		if err == errUnknownEntityType {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid entity type",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to find entity",
+		})
+	}
+
+	dashboard, err := ac.entityAnalytics(ctx, entity, from, to)
+	if err != nil {
+		log.Printf("Failed to load analytics of %s %s: %v", entity.entityType, entity.id.Hex(), err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to load analytics",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Analytics retrieved successfully",
+		Data: map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"analytics": dashboard,
+		},
+	})
+}
+
+// GetSponsorshipAnalyticsForAdmin ranks entities by engagement over a period and
+// totals it separately for entities that were sponsored during the period and
+// for the rest.
+//
+// Query params: from, to (YYYY-MM-DD, default the last 30 days), entityType,
+// sponsored ("true" or "false"), page, limit
+func (ac *AnalyticsController) GetSponsorshipAnalyticsForAdmin(c echo.Context) error {
+	from, to, err := parseAnalyticsRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	summaries, err := utils.AggregateEntityStats(ctx, ac.db, c.QueryParam("entityType"), from, to)
+	if err != nil {
+		log.Printf("Failed to aggregate entity stats: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to load analytics",
+		})
+	}
+
+	// An entity counts as sponsored if any of its sponsorships overlaps the period
+	fromTime, _ := time.Parse(utils.StatsDateLayout, from)
+	toTime, _ := time.Parse(utils.StatsDateLayout, to)
+	cursor, err := ac.db.Database("barrim").Collection("sponsorship_subscriptions").Find(ctx,
+		bson.M{"startDate": bson.M{"$lt": toTime.AddDate(0, 0, 1)}, "endDate": bson.M{"$gte": fromTime}},
+		options.Find().SetProjection(bson.M{"entityType": 1, "entityId": 1}),
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to load sponsorships",
+		})
+	}
+	var subscriptions []models.SponsorshipSubscription
+	err = cursor.All(ctx, &subscriptions)
+	cursor.Close(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to load sponsorships",
+		})
+	}
+	sponsored := make(map[string]bool, len(subscriptions))
+	for _, sub := range subscriptions {
+		sponsored[sub.EntityType+":"+sub.EntityID.Hex()] = true
+	}
+
+	onlySponsored := c.QueryParam("sponsored")
+	var listed []models.EntityStatsSummary
+	var sponsoredTotals, organicTotals []models.EntityDailyStats
+	for _, s := range summaries {
+		s.Sponsored = sponsored[s.EntityType+":"+s.EntityID.Hex()]
+		asDay := models.EntityDailyStats{
+			Impressions:       s.Totals.Impressions,
+			PinnedImpressions: s.Totals.PinnedImpressions,
+			ProfileViews:      s.Totals.ProfileViews,
+			BranchViews:       s.Totals.BranchViews,
+			PhoneTaps:         s.Totals.PhoneTaps,
+			WhatsAppTaps:      s.Totals.WhatsAppTaps,
+			Favorites:         s.Totals.Favorites,
+			Bookings:          s.Totals.Bookings,
+		}
+		if s.Sponsored {
+			sponsoredTotals = append(sponsoredTotals, asDay)
+		} else {
+			organicTotals = append(organicTotals, asDay)
+		}
+		if (onlySponsored == "true" && !s.Sponsored) || (onlySponsored == "false" && s.Sponsored) {
+			continue
+		}
+		listed = append(listed, s)
+	}
+
+	total := len(listed)
+	start := (page - 1) * limit
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Sponsorship analytics retrieved successfully",
+		Data: map[string]interface{}{
+			"from": from,
+			"to":   to,
+			"totals": map[string]interface{}{
+				"sponsored":         utils.SumEntityStats(sponsoredTotals),
+				"notSponsored":      utils.SumEntityStats(organicTotals),
+				"sponsoredEntities": len(sponsoredTotals),
+				"otherEntities":     len(organicTotals),
+			},
+			"entities": listed[start:end],
+			"pagination": map[string]interface{}{
+				"totalCount": total,
+				"page":       page,
+				"limit":      limit,
+				"totalPages": int(math.Ceil(float64(total) / float64(limit))),
+			},
+		},
+	})
+}
+
+// entityAnalytics builds the dashboard of one entity
+func (ac *AnalyticsController) entityAnalytics(ctx context.Context, e analyticsEntity, from, to string) (models.EntityAnalytics, error) {
+	days, err := utils.LoadEntityDailyStats(ctx, ac.db, e.entityType, e.id, from, to)
+	if err != nil {
+		return models.EntityAnalytics{}, err
+	}
+	comparisons, err := utils.CompareSponsoredPeriods(ctx, ac.db, e.entityType, e.id)
+	if err != nil {
+		return models.EntityAnalytics{}, err
+	}
+	return models.EntityAnalytics{
+		EntityType:  e.entityType,
+		EntityID:    e.id,
+		Name:        e.name,
+		Sponsored:   e.sponsored,
+		Totals:      utils.SumEntityStats(days),
+		Daily:       days,
+		Comparisons: comparisons,
+	}, nil
+}
+
+// ownedEntities returns the sponsorable entities of a service provider, company or wholesaler user
+func (ac *AnalyticsController) ownedEntities(ctx context.Context, userID primitive.ObjectID, userType string) ([]analyticsEntity, error) {
+	db := ac.db.Database("barrim")
+	var entities []analyticsEntity
+
+	switch userType {
+	case "serviceProvider":
+		or := bson.A{bson.M{"userId": userID}, bson.M{"_id": userID}}
+		var user models.User
+		if err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err == nil && user.ServiceProviderID != nil {
+			or = append(or, bson.M{"_id": *user.ServiceProviderID})
+		}
+		cursor, err := db.Collection("serviceProviders").Find(ctx, bson.M{"$or": or},
+			options.Find().SetProjection(bson.M{"businessName": 1, "sponsorship": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var providers []models.ServiceProvider
+		err = cursor.All(ctx, &providers)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, sp := range providers {
+			entities = append(entities, analyticsEntity{utils.SponsoredEntityServiceProvider, sp.ID, sp.BusinessName, sp.Sponsorship})
+		}
+
+	case "company", "wholesaler":
+		collection, ownerType, branchType := "companies", utils.SponsoredEntityCompany, utils.SponsoredEntityCompanyBranch
+		if userType == "wholesaler" {
+			collection, ownerType, branchType = "wholesalers", utils.SponsoredEntityWholesaler, utils.SponsoredEntityWholesalerBranch
+		}
+		// Wholesalers embed branches the same way companies do
+		var owner models.Company
+		err := db.Collection(collection).FindOne(ctx, bson.M{"userId": userID},
+			options.FindOne().SetProjection(bson.M{"businessName": 1, "sponsorship": 1, "branches._id": 1, "branches.name": 1, "branches.sponsorship": 1}),
+		).Decode(&owner)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return entities, nil
+			}
+			return nil, err
+		}
+		entities = append(entities, analyticsEntity{ownerType, owner.ID, owner.BusinessName, owner.Sponsorship})
+		for _, branch := range owner.Branches {
+			entities = append(entities, analyticsEntity{branchType, branch.ID, branch.Name, branch.Sponsorship})
+		}
+	}
+	return entities, nil
+}
+
+// findEntity loads a sponsorable entity by type and ID. It returns
+// mongo.ErrNoDocuments if there is none and errUnknownEntityType for bad types.
+func (ac *AnalyticsController) findEntity(ctx context.Context, entityType string, id primitive.ObjectID) (analyticsEntity, error) {
+	db := ac.db.Database("barrim")
+	entity := analyticsEntity{entityType: entityType, id: id}
+
+	switch entityType {
+	case utils.SponsoredEntityServiceProvider:
+		var sp models.ServiceProvider
+		err := db.Collection("serviceProviders").FindOne(ctx, bson.M{"_id": id},
+			options.FindOne().SetProjection(bson.M{"businessName": 1, "sponsorship": 1})).Decode(&sp)
+		if err != nil {
+			return entity, err
+		}
+		entity.name, entity.sponsored = sp.BusinessName, sp.Sponsorship
+
+	case utils.SponsoredEntityCompany, utils.SponsoredEntityWholesaler:
+		collection := "companies"
+		if entityType == utils.SponsoredEntityWholesaler {
+			collection = "wholesalers"
+		}
+		var owner models.Company
+		err := db.Collection(collection).FindOne(ctx, bson.M{"_id": id},
+			options.FindOne().SetProjection(bson.M{"businessName": 1, "sponsorship": 1})).Decode(&owner)
+		if err != nil {
+			return entity, err
+		}
+		entity.name, entity.sponsored = owner.BusinessName, owner.Sponsorship
+
+	case utils.SponsoredEntityCompanyBranch, utils.SponsoredEntityWholesalerBranch:
+		collection := "companies"
+		if entityType == utils.SponsoredEntityWholesalerBranch {
+			collection = "wholesalers"
+		}
+		var owner models.Company
+		err := db.Collection(collection).FindOne(ctx, bson.M{"branches._id": id},
+			options.FindOne().SetProjection(bson.M{"branches.$": 1})).Decode(&owner)
+		if err != nil {
+			return entity, err
+		}
+		if len(owner.Branches) == 0 {
+			return entity, mongo.ErrNoDocuments
+		}
+		entity.name, entity.sponsored = owner.Branches[0].Name, owner.Branches[0].Sponsorship
+
+	default:
+		return entity, errUnknownEntityType
+	}
+	return entity, nil
+}
+
+// parseAnalyticsRange reads the from and to days of a dashboard, defaulting to the last 30 days
+func parseAnalyticsRange(c echo.Context) (string, string, error) {
+	to := time.Now().UTC()
+	if v := c.QueryParam("to"); v != "" {
+		t, err := time.Parse(utils.StatsDateLayout, v)
+		if err != nil {
+			return "", "", errInvalidAnalyticsRange
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -(defaultAnalyticsDays - 1))
+	if v := c.QueryParam("from"); v != "" {
+		t, err := time.Parse(utils.StatsDateLayout, v)
+		if err != nil {
+			return "", "", errInvalidAnalyticsRange
+		}
+		from = t
+	}
+	if from.After(to) || to.Sub(from) > maxAnalyticsDays*24*time.Hour {
+		return "", "", errInvalidAnalyticsRange
+	}
+	return utils.StatsDay(from), utils.StatsDay(to), nil
+}
@@ -370,6 +370,9 @@ func (ac *AuthController) Signup(c echo.Context) error {
 		req.WholesalerData.Address.City = utils.SanitizeInput(req.WholesalerData.Address.City)
 	}
 
+	// Attribute the signup to the tracked referral link it came from
+	ac.attributeSignupReferral(ctx, c, &req)
+
 	// If phone is provided, generate and send OTP
 	if req.Phone != "" {
 		// Generate OTP
@@ -470,7 +473,7 @@ func (ac *AuthController) Signup(c echo.Context) error {
 			ac.logger.Printf("Processing referral during signup for user type: %s with referral code: %s", req.UserType, req.ReferralCode)
 
 			// Process the referral using the unified referral system
-			err := ac.processSignupReferral(ctx, req.ReferralCode, userID, req.UserType)
+			err := ac.processSignupReferral(ctx, req.ReferralCode, userID, req.UserType, referralFingerprint(c, req.Phone), signupReferralChannel(&req))
 			if err != nil {
 				ac.logger.Printf("Failed to process referral during signup: %v", err)
 				// Don't fail the signup if referral processing fails, just log the error
@@ -507,6 +510,7 @@ func (ac *AuthController) Signup(c echo.Context) error {
 				Message: "Failed to create user account",
 			})
 		}
+		ac.convertSignupReferralClick(ctx, &req, user.ID)
 
 		// Generate JWT token
 		token, refreshToken, err := middleware.GenerateJWT(user.ID.Hex(), user.Email, user.UserType)
@@ -544,7 +548,7 @@ func generateReferralCode() string {
 }
 
 // processSignupReferral processes a referral during user signup
-func (ac *AuthController) processSignupReferral(ctx context.Context, referralCode string, newUserID primitive.ObjectID, userType string) error {
+func (ac *AuthController) processSignupReferral(ctx context.Context, referralCode string, newUserID primitive.ObjectID, userType string, fingerprint models.ReferralFingerprint, channel string) error {
 	ac.logger.Printf("Processing signup referral - Code: %s, New User ID: %s, User Type: %s", referralCode, newUserID.Hex(), userType)
 
 	// Find the referrer by referral code across all collections
@@ -556,17 +560,100 @@ func (ac *AuthController) processSignupReferral(ctx context.Context, referralCod
 
 	ac.logger.Printf("Found referrer - Type: %s, ID: %s", referrerEntity.Type, referrerEntity.ID.Hex())
 
-	// Award 5 points to the referrer
-	err = ac.updateReferrerPoints(ctx, referrerEntity, newUserID, 5)
+	// The new user is rewarded on the account it manages, if already created
+	refereeType, refereeID, err := utils.PointsAccountForUser(ctx, ac.DB, userType, newUserID)
+	if err != nil {
+		refereeType, refereeID = models.PointsAccountUser, newUserID
+	}
+
+	// Reward by the referral rules
+	outcome, err := utils.ApplyReferralEvent(ctx, ac.DB, utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  referrerEntity.Type,
+		ReferrerID:    referrerEntity.ID,
+		RefereeType:   refereeType,
+		RefereeID:     refereeID,
+		RefereeUserID: &newUserID,
+		Fingerprint:   fingerprint,
+		Code:          referralCode,
+		Channel:       channel,
+	})
 	if err != nil {
-		ac.logger.Printf("Failed to update referrer points: %v", err)
+		ac.logger.Printf("Failed to reward referral: %v", err)
 		return err
 	}
 
-	ac.logger.Printf("Successfully processed referral - Referrer %s (%s) awarded 5 points", referrerEntity.ID.Hex(), referrerEntity.Type)
+	ac.logger.Printf("Successfully processed referral - Referrer %s (%s) awarded %d points", referrerEntity.ID.Hex(), referrerEntity.Type, outcome.ReferrerPoints())
 	return nil
 }
 
+// attributeSignupReferral fills in the referral code of a signup that entered
+// none from the tracked referral link it came from, and keeps the click so it
+// can be marked converted once the account exists
+func (ac *AuthController) attributeSignupReferral(ctx context.Context, c echo.Context, req *models.SignupRequest) {
+	clickID := req.ReferralClickID
+	req.ReferralClickID = ""
+	click, found, err := utils.FindReferralClick(ctx, ac.DB, clickID, referralFingerprint(c, req.Phone))
+	if err != nil {
+		ac.logger.Printf("Failed to look up referral click: %v", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	// The code goes where the signup form of the account type would carry it
+	salespersonReferrer := click.ReferrerType == models.ReferrerSalesperson
+	var code *string
+	switch {
+	case req.UserType == "company" && req.CompanyData != nil && (salespersonReferrer || click.ReferrerType == models.PointsAccountCompany):
+		code = &req.CompanyData.ReferralCode
+	case req.UserType == "wholesaler" && req.WholesalerData != nil && (salespersonReferrer || click.ReferrerType == models.PointsAccountWholesaler):
+		code = &req.WholesalerData.ReferralCode
+	case salespersonReferrer && req.UserType != "serviceProvider":
+		return
+	default:
+		code = &req.ReferralCode
+	}
+
+	entered := req.ReferralCode
+	if req.CompanyData != nil && req.CompanyData.ReferralCode != "" {
+		entered = req.CompanyData.ReferralCode
+	}
+	if req.WholesalerData != nil && req.WholesalerData.ReferralCode != "" {
+		entered = req.WholesalerData.ReferralCode
+	}
+	switch entered {
+	case "":
+		*code = click.Code
+	case click.Code:
+	default:
+		// A code typed by hand wins over the link
+		return
+	}
+	req.ReferralClickID = click.ID.Hex()
+	ac.logger.Printf("Attributed signup to referral code %s from click %s", click.Code, req.ReferralClickID)
+}
+
+// signupReferralChannel tells whether a signup's referral code came from a tracked link
+func signupReferralChannel(req *models.SignupRequest) string {
+	if req.ReferralClickID != "" {
+		return models.ReferralChannelLink
+	}
+	return models.ReferralChannelSignup
+}
+
+// convertSignupReferralClick marks the referral click a signup came from as converted
+func (ac *AuthController) convertSignupReferralClick(ctx context.Context, req *models.SignupRequest, userID primitive.ObjectID) {
+	clickID, err := primitive.ObjectIDFromHex(req.ReferralClickID)
+	if err != nil {
+		return
+	}
+	if err := utils.ConvertReferralClick(ctx, ac.DB, clickID, req.UserType, userID); err != nil {
+		ac.logger.Printf("Failed to mark referral click %s converted: %v", req.ReferralClickID, err)
+	}
+}
+
 // findReferrerByCode finds a referrer by their referral code across all collections
 func (ac *AuthController) findReferrerByCode(ctx context.Context, referralCode string) (*ReferralEntity, error) {
 	// Search in users collection
@@ -624,50 +711,6 @@ func (ac *AuthController) findReferrerByCode(ctx context.Context, referralCode s
 	return nil, fmt.Errorf("referral code not found")
 }
 
-// updateReferrerPoints updates the referrer's points and adds the new user to their referrals list
-func (ac *AuthController) updateReferrerPoints(ctx context.Context, referrerEntity *ReferralEntity, newUserID primitive.ObjectID, pointsToAdd int) error {
-	switch referrerEntity.Type {
-	case "user":
-		usersCollection := ac.DB.Database("barrim").Collection("users")
-		_, err := usersCollection.UpdateByID(ctx, referrerEntity.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": newUserID},
-			"$set":  bson.M{"updatedAt": time.Now()},
-		})
-		return err
-
-	case "company":
-		companiesCollection := ac.DB.Database("barrim").Collection("companies")
-		_, err := companiesCollection.UpdateByID(ctx, referrerEntity.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": newUserID},
-			"$set":  bson.M{"updatedAt": time.Now()},
-		})
-		return err
-
-	case "wholesaler":
-		wholesalersCollection := ac.DB.Database("barrim").Collection("wholesalers")
-		_, err := wholesalersCollection.UpdateByID(ctx, referrerEntity.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": newUserID},
-			"$set":  bson.M{"updatedAt": time.Now()},
-		})
-		return err
-
-	case "serviceProvider":
-		serviceProvidersCollection := ac.DB.Database("barrim").Collection("serviceProviders")
-		_, err := serviceProvidersCollection.UpdateByID(ctx, referrerEntity.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": newUserID},
-			"$set":  bson.M{"updatedAt": time.Now()},
-		})
-		return err
-
-	default:
-		return fmt.Errorf("unknown referrer type: %s", referrerEntity.Type)
-	}
-}
-
 func (ac *AuthController) SignupWithLogo(c echo.Context) error {
 	// Parse multipart form
 	if err := c.Request().ParseMultipartForm(10 << 20); err != nil { // 10MB max
@@ -955,6 +998,9 @@ func (ac *AuthController) SignupWholesalerWithLogo(c echo.Context) error {
 		signupRequest.LogoPath = logoPath
 	}
 
+	// Attribute the signup to the tracked referral link it came from
+	ac.attributeSignupReferral(context.Background(), c, &signupRequest)
+
 	// If phone is provided, generate and send OTP
 	if signupRequest.Phone != "" {
 		// Store OTP and signup data in database
@@ -1222,6 +1268,9 @@ func (ac *AuthController) SignupServiceProviderWithLogo(ctx echo.Context) error
 		signupRequest.LogoPath = logoPath
 	}
 
+	// Attribute the signup to the tracked referral link it came from
+	ac.attributeSignupReferral(context.Background(), ctx, &signupRequest)
+
 	// If phone is provided, generate and send OTP
 	if signupRequest.Phone != "" {
 		// Store OTP and signup data in database (like company/wholesaler)
@@ -1929,7 +1978,7 @@ func (ac *AuthController) VerifyOTP(c echo.Context) error {
 		ac.logger.Printf("Processing referral during signup for user type: %s with referral code: %s", signupData.UserType, signupData.ReferralCode)
 
 		// Process the referral using the unified referral system
-		err := ac.processSignupReferral(ctx, signupData.ReferralCode, userID, signupData.UserType)
+		err := ac.processSignupReferral(ctx, signupData.ReferralCode, userID, signupData.UserType, referralFingerprint(c, signupData.Phone), signupReferralChannel(signupData))
 		if err != nil {
 			ac.logger.Printf("Failed to process referral during signup: %v", err)
 			// Don't fail the signup if referral processing fails, just log the error
@@ -2044,20 +2093,37 @@ func (ac *AuthController) VerifyOTP(c echo.Context) error {
 					CreatedAt:     time.Now(),
 				}
 				_, _ = ac.DB.Database("barrim").Collection("referral_commissions").InsertOne(ctx, referralCommission)
+				if _, err := utils.RecordReferralLink(ctx, ac.DB, models.ReferralLink{
+					ReferrerType:  models.ReferrerSalesperson,
+					ReferrerID:    referrerSalesperson.ID,
+					RefereeType:   models.PointsAccountCompany,
+					RefereeID:     company.ID,
+					RefereeUserID: &userID,
+					Code:          signupData.CompanyData.ReferralCode,
+					Channel:       signupReferralChannel(signupData),
+				}); err != nil {
+					log.Printf("Failed to record referral of company %s: %v", company.ID.Hex(), err)
+				}
 			} else {
 				// If not a salesperson, check if it's a company referral
 				companiesCollection := ac.DB.Database("barrim").Collection("companies")
 				var referrerCompany models.Company
 				err := companiesCollection.FindOne(ctx, bson.M{"referralCode": signupData.CompanyData.ReferralCode}).Decode(&referrerCompany)
 				if err == nil && referrerCompany.ID != company.ID {
-					// Increment points for the referring company
-					update := bson.M{
-						"$inc": bson.M{"points": 5}, // All referrals award 5 points
-						"$push": bson.M{
-							"referrals": company.ID,
-						},
+					// Reward the referring company by the referral rules
+					if _, err := utils.ApplyReferralEvent(ctx, ac.DB, utils.ReferralEvent{
+						Event:         models.ReferralEventSignup,
+						ReferrerType:  models.PointsAccountCompany,
+						ReferrerID:    referrerCompany.ID,
+						RefereeType:   models.PointsAccountCompany,
+						RefereeID:     company.ID,
+						RefereeUserID: &userID,
+						Fingerprint:   referralFingerprint(c, signupData.Phone),
+						Code:          signupData.CompanyData.ReferralCode,
+						Channel:       signupReferralChannel(signupData),
+					}); err != nil {
+						log.Printf("Failed to reward referral of company %s: %v", referrerCompany.ID.Hex(), err)
 					}
-					_, _ = companiesCollection.UpdateOne(ctx, bson.M{"_id": referrerCompany.ID}, update)
 				}
 			}
 		}
@@ -2219,20 +2285,35 @@ func (ac *AuthController) VerifyOTP(c echo.Context) error {
 					CreatedAt:     time.Now(),
 				}
 				_, _ = ac.DB.Database("barrim").Collection("referral_commissions").InsertOne(ctx, referralCommission)
+				if _, err := utils.RecordReferralLink(ctx, ac.DB, models.ReferralLink{
+					ReferrerType: models.ReferrerSalesperson,
+					ReferrerID:   referrerSalesperson.ID,
+					RefereeType:  models.PointsAccountWholesaler,
+					RefereeID:    wholesalerID,
+					Code:         signupData.WholesalerData.ReferralCode,
+					Channel:      signupReferralChannel(signupData),
+				}); err != nil {
+					log.Printf("Failed to record referral of wholesaler %s: %v", wholesalerID.Hex(), err)
+				}
 			} else {
 				// If not a salesperson, check if it's a wholesaler referral
 				wholesalersCollection := ac.DB.Database("barrim").Collection("wholesalers")
 				var referrerWholesaler models.Wholesaler
 				err := wholesalersCollection.FindOne(ctx, bson.M{"referralCode": signupData.WholesalerData.ReferralCode}).Decode(&referrerWholesaler)
 				if err == nil && referrerWholesaler.ID != wholesalerID {
-					// Increment points for the referring wholesaler
-					update := bson.M{
-						"$inc": bson.M{"points": 5}, // 5 points for wholesaler referrals
-						"$push": bson.M{
-							"referrals": wholesalerID,
-						},
+					// Reward the referring wholesaler by the referral rules
+					if _, err := utils.ApplyReferralEvent(ctx, ac.DB, utils.ReferralEvent{
+						Event:        models.ReferralEventSignup,
+						ReferrerType: models.PointsAccountWholesaler,
+						ReferrerID:   referrerWholesaler.ID,
+						RefereeType:  models.PointsAccountWholesaler,
+						RefereeID:    wholesalerID,
+						Fingerprint:  referralFingerprint(c, signupData.Phone),
+						Code:         signupData.WholesalerData.ReferralCode,
+						Channel:      signupReferralChannel(signupData),
+					}); err != nil {
+						log.Printf("Failed to reward referral of wholesaler %s: %v", referrerWholesaler.ID.Hex(), err)
 					}
-					_, _ = wholesalersCollection.UpdateOne(ctx, bson.M{"_id": referrerWholesaler.ID}, update)
 				}
 			}
 		}
@@ -2360,14 +2441,48 @@ func (ac *AuthController) VerifyOTP(c echo.Context) error {
 				CreatedAt:     time.Now(),
 			}
 			_, _ = ac.DB.Database("barrim").Collection("referral_commissions").InsertOne(ctx, referralCommission)
+			link := models.ReferralLink{
+				ReferrerType:  models.ReferrerSalesperson,
+				ReferrerID:    referrerSalesperson.ID,
+				RefereeType:   models.PointsAccountUser,
+				RefereeID:     userID,
+				RefereeUserID: &userID,
+				Code:          signupData.ReferralCode,
+				Channel:       signupReferralChannel(signupData),
+			}
+			if user.ServiceProviderID != nil {
+				link.RefereeType, link.RefereeID = models.PointsAccountServiceProvider, *user.ServiceProviderID
+			}
+			if _, err := utils.RecordReferralLink(ctx, ac.DB, link); err != nil {
+				log.Printf("Failed to record referral of user %s: %v", userID.Hex(), err)
+			}
 		} else {
 			// If not a salesperson, check if it's a service provider referral
 			usersCollection := ac.DB.Database("barrim").Collection("users")
 			var referrer models.User
 			err := usersCollection.FindOne(ctx, bson.M{"referralCode": signupData.ReferralCode, "userType": "serviceProvider"}).Decode(&referrer)
 			if err == nil && referrer.ID != userID {
-				// Increment points for the referring service provider (prevent self-referral)
-				_, _ = usersCollection.UpdateOne(ctx, bson.M{"_id": referrer.ID}, bson.M{"$inc": bson.M{"points": 5}})
+				// Reward the referring service provider by the referral rules (prevent self-referral)
+				event := utils.ReferralEvent{
+					Event:         models.ReferralEventSignup,
+					ReferrerType:  models.PointsAccountUser,
+					ReferrerID:    referrer.ID,
+					RefereeType:   models.PointsAccountUser,
+					RefereeID:     userID,
+					RefereeUserID: &userID,
+					Fingerprint:   referralFingerprint(c, user.Phone),
+					Code:          signupData.ReferralCode,
+					Channel:       signupReferralChannel(signupData),
+				}
+				if referrer.ServiceProviderID != nil {
+					event.ReferrerType, event.ReferrerID = models.PointsAccountServiceProvider, *referrer.ServiceProviderID
+				}
+				if user.ServiceProviderID != nil {
+					event.RefereeType, event.RefereeID = models.PointsAccountServiceProvider, *user.ServiceProviderID
+				}
+				if _, err := utils.ApplyReferralEvent(ctx, ac.DB, event); err != nil {
+					log.Printf("Failed to reward referral by user %s: %v", referrer.ID.Hex(), err)
+				}
 			}
 		}
 	}
@@ -2380,6 +2495,7 @@ func (ac *AuthController) VerifyOTP(c echo.Context) error {
 			Message: "Failed to create user account",
 		})
 	}
+	ac.convertSignupReferralClick(ctx, signupData, user.ID)
 
 	// Generate JWT token after all records are created
 	token, refreshToken, err := middleware.GenerateJWT(user.ID.Hex(), user.Email, user.UserType)
@@ -3,12 +3,12 @@ package controllers
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/HSouheill/barrim_backend/middleware"
@@ -166,47 +166,49 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 		})
 	}
 
-	// Check service provider availability using ServiceProviderInfo from serviceProviders collection
-	// if !isServiceProviderAvailable(serviceProvider, request.BookingDate, request.TimeSlot) {
-	// 	return ctx.JSON(http.StatusBadRequest, models.Response{
-	// 		Status:  http.StatusBadRequest,
-	// 		Message: "Service provider is not available at this time",
-	// 	})
-	// }
+	// Check the requested slot against the provider's calendar and existing bookings
+	timeSlot, err := utils.NormalizeTimeSlot(request.TimeSlot)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid time slot format",
+		})
+	}
+
+	bookedServiceType := request.ServiceType
+	if bookedServiceType == "" && serviceProvider.ServiceProviderInfo != nil {
+		bookedServiceType = serviceProvider.ServiceProviderInfo.ServiceType
+	}
+	durationMinutes := utils.ServiceDurationMinutes(serviceProvider.ServiceProviderInfo, bookedServiceType)
 
-	// Check if the time slot is already booked
 	bookingsCollection := c.db.Database("barrim").Collection("bookings")
-	bookingDate := time.Date(
-		request.BookingDate.Year(),
-		request.BookingDate.Month(),
-		request.BookingDate.Day(),
-		0, 0, 0, 0,
-		request.BookingDate.Location(),
-	)
+	bookingDate := utils.BookingDateFor(request.BookingDate)
 
-	// Check if the time slot is already booked
-	var existingBooking models.Booking
-	err = bookingsCollection.FindOne(context.Background(), bson.M{
-		"serviceProviderId": serviceProviderID,
-		"bookingDate":       bookingDate,
-		"timeSlot":          request.TimeSlot,
-		"status":            bson.M{"$ne": "cancelled"},
-	}).Decode(&existingBooking)
-
-	if err == nil {
-		// Found an existing booking for this time slot
-		return ctx.JSON(http.StatusConflict, models.Response{
-			Status:  http.StatusConflict,
-			Message: "This time slot is already booked",
-		})
-	} else if err != mongo.ErrNoDocuments {
-		// A database error occurred
+	freeSlots, err := c.freeSlotsForDate(context.Background(), serviceProvider, bookingDate, durationMinutes, primitive.NilObjectID)
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Error checking booking availability",
 		})
 	}
 
+	slotAvailable := false
+	for _, slot := range freeSlots {
+		if slot.Label == timeSlot {
+			slotAvailable = true
+			break
+		}
+	}
+	if !slotAvailable {
+		return ctx.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "This time slot is not available",
+			Data: map[string]interface{}{
+				"alternatives": c.alternativeSlots(context.Background(), serviceProvider, bookingDate, durationMinutes, primitive.NilObjectID),
+			},
+		})
+	}
+
 	// Create new booking
 	now := time.Now()
 	booking := models.Booking{
@@ -214,11 +216,12 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 		UserID:            user.ID,
 		ServiceProviderID: serviceProviderID,
 		BookingDate:       bookingDate,
-		TimeSlot:          request.TimeSlot,
+		TimeSlot:          timeSlot,
+		DurationMinutes:   durationMinutes,
 		PhoneNumber:       request.PhoneNumber,
 		Details:           request.Details,
 		IsEmergency:       request.IsEmergency,
-		Status:            "pending",
+		Status:            models.BookingStatusPending,
 		MediaTypes:        mediaTypes,
 		MediaURLs:         mediaURLs,
 		ThumbnailURLs:     thumbnailURLs,
@@ -226,15 +229,53 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 		UpdatedAt:         now,
 	}
 
+	booking.StatusHistory = []models.BookingStatusChange{{
+		To:        models.BookingStatusPending,
+		Actor:     models.BookingActorUser,
+		ActorID:   user.ID,
+		ChangedAt: now,
+	}}
+
+	// Reserve the slot atomically so concurrent requests can't book it twice.
+	// The hold lapses if the provider hasn't answered before it expires or the appointment starts.
+	holdExpiresAt := now.Add(utils.BookingHoldDuration())
+	for _, slot := range freeSlots {
+		if slot.Label == timeSlot && slot.Start.Before(holdExpiresAt) {
+			holdExpiresAt = slot.Start
+		}
+	}
+	booking.HoldExpiresAt = &holdExpiresAt
+
+	if err := utils.ReserveBookingSlot(c.db, serviceProvider.ServiceProviderInfo, booking, holdExpiresAt); err != nil {
+		if err == utils.ErrSlotTaken {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "This time slot was just booked by someone else",
+				Data: map[string]interface{}{
+					"alternatives": c.alternativeSlots(context.Background(), serviceProvider, bookingDate, durationMinutes, primitive.NilObjectID),
+				},
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error reserving time slot",
+		})
+	}
+
 	// Insert booking into database
 	_, err = bookingsCollection.InsertOne(context.Background(), booking)
 	if err != nil {
+		if releaseErr := utils.ReleaseBookingSlot(c.db, booking.ID); releaseErr != nil {
+			log.Printf("Failed to release slot hold for booking %s: %v", booking.ID.Hex(), releaseErr)
+		}
 		return ctx.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create booking",
 		})
 	}
 
+	go utils.RecordEntityEvent(c.db, utils.SponsoredEntityServiceProvider, booking.ServiceProviderID, models.EntityEventBooking)
+
 	// Send WebSocket notification to service provider
 	if err := c.hub.SendToUser(serviceProviderID, websocket.Notification{
 		Type:    "new_booking",
@@ -262,7 +303,7 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 		"customerName": user.FullName,
 		"serviceType":  serviceType,
 		"bookingDate":  bookingDate.Format("2006-01-02"),
-		"timeSlot":     request.TimeSlot,
+		"timeSlot":     timeSlot,
 		"isEmergency":  fmt.Sprintf("%t", request.IsEmergency),
 	}
 
@@ -450,7 +491,6 @@ func (c *BookingController) GetProviderBookings(ctx echo.Context) error {
 	})
 }
 
-// GetAvailableTimeSlots returns available time slots for a service provider on a specific date
 // GetAvailableTimeSlots returns available time slots for a service provider on a specific date
 func (c *BookingController) GetAvailableTimeSlots(ctx echo.Context) error {
 	// Extract parameters
@@ -494,39 +534,20 @@ func (c *BookingController) GetAvailableTimeSlots(ctx echo.Context) error {
 		})
 	}
 
-	// Check if the provider is available on this date (by checking availableDays)
-	dateStr = date.Format("2006-01-02")
-	isDateAvailable := false
-
-	// Check availableDays from serviceProvider data
-	if serviceProvider.ServiceProviderInfo != nil && serviceProvider.ServiceProviderInfo.AvailableDays != nil {
-		for _, availableDate := range serviceProvider.ServiceProviderInfo.AvailableDays {
-			if availableDate == dateStr {
-				isDateAvailable = true
-				break
-			}
-		}
+	serviceType := ctx.QueryParam("serviceType")
+	if serviceType == "" && serviceProvider.ServiceProviderInfo != nil {
+		serviceType = serviceProvider.ServiceProviderInfo.ServiceType
 	}
 
-	// If not directly in availableDays, check if the weekday is available
-	if !isDateAvailable && serviceProvider.ServiceProviderInfo != nil && serviceProvider.ServiceProviderInfo.AvailableWeekdays != nil {
-		dayOfWeek := date.Weekday().String()
-		for _, weekdayStr := range serviceProvider.ServiceProviderInfo.AvailableWeekdays {
-			// Handle comma-separated weekdays
-			weekdays := strings.Split(weekdayStr, ",")
-			for _, weekday := range weekdays {
-				if strings.TrimSpace(weekday) == dayOfWeek {
-					isDateAvailable = true
-					break
-				}
-			}
-			if isDateAvailable {
-				break
-			}
-		}
+	freeSlots, err := c.freeSlotsForDate(context.Background(), serviceProvider, date, utils.ServiceDurationMinutes(serviceProvider.ServiceProviderInfo, serviceType), primitive.NilObjectID)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error retrieving bookings",
+		})
 	}
 
-	if !isDateAvailable {
+	if len(freeSlots) == 0 {
 		return ctx.JSON(http.StatusOK, models.Response{
 			Status:  http.StatusOK,
 			Message: "No available slots on this day",
@@ -534,139 +555,276 @@ func (c *BookingController) GetAvailableTimeSlots(ctx echo.Context) error {
 		})
 	}
 
-	// Generate time slots based on provider's available hours
-	var startHour, endHour time.Time
-	var availableSlots []string
-
-	// Get available hours from serviceProvider data
-	if serviceProvider.ServiceProviderInfo != nil && len(serviceProvider.ServiceProviderInfo.AvailableHours) > 0 {
-		// Handle comma-separated hours format: "09:00,10:00,11:00,12:00,13:00,14:00,15:00"
-		hoursStr := serviceProvider.ServiceProviderInfo.AvailableHours[0]
-		hours := strings.Split(hoursStr, ",")
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Available time slots retrieved successfully",
+		Data:    utils.SlotLabels(freeSlots),
+	})
+}
 
-		if len(hours) >= 2 {
-			// Parse start and end hours
-			startTimeStr := strings.TrimSpace(hours[0])
-			endTimeStr := strings.TrimSpace(hours[len(hours)-1])
+// freeSlotsForDate returns the provider's bookable slots on a date for a
+// booking lasting durationMinutes, excluding
+// slots overlapping active bookings and everything once daily capacity is reached.
+// excludeBookingID lets a booking being rescheduled ignore its own slot.
+func (c *BookingController) freeSlotsForDate(ctx context.Context, serviceProvider models.ServiceProvider, date time.Time, durationMinutes int, excludeBookingID primitive.ObjectID) ([]utils.TimeSlot, error) {
+	info := serviceProvider.ServiceProviderInfo
+	slots := utils.GenerateTimeSlots(info, date, durationMinutes)
+	if len(slots) == 0 {
+		return slots, nil
+	}
 
-			// Parse times in 24-hour format
-			startHour, err = time.Parse("15:04", startTimeStr)
-			if err != nil {
-				return ctx.JSON(http.StatusInternalServerError, models.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "Error parsing provider start time",
-				})
-			}
+	bookings, err := c.activeBookingsOn(ctx, serviceProvider, date, excludeBookingID)
+	if err != nil {
+		return nil, err
+	}
 
-			endHour, err = time.Parse("15:04", endTimeStr)
-			if err != nil {
-				return ctx.JSON(http.StatusInternalServerError, models.Response{
-					Status:  http.StatusInternalServerError,
-					Message: "Error parsing provider end time",
-				})
-			}
+	if capacity := utils.DailyCapacity(info); capacity > 0 && len(bookings) >= capacity {
+		return []utils.TimeSlot{}, nil
+	}
 
-			// Generate time slots at 30-minute intervals
-			for h := startHour; h.Before(endHour); h = h.Add(30 * time.Minute) {
-				// Format time as "3:04 PM" for UI display
-				timeSlot := h.Format("3:04 PM")
-				availableSlots = append(availableSlots, timeSlot)
-			}
-		} else {
-			// If we have individual hours, create slots for each hour
-			for _, hourStr := range hours {
-				hourStr = strings.TrimSpace(hourStr)
-				if hourStr != "" {
-					// Parse the hour and create a slot
-					hour, err := time.Parse("15:04", hourStr)
-					if err == nil {
-						timeSlot := hour.Format("3:04 PM")
-						availableSlots = append(availableSlots, timeSlot)
-					}
-				}
-			}
-		}
-	} else {
-		// Default time slots if provider hasn't specified hours
-		availableSlots = []string{
-			"9:00 AM", "9:30 AM", "10:00 AM", "10:30 AM", "11:00 AM", "11:30 AM",
-			"1:00 PM", "1:30 PM", "2:00 PM", "2:30 PM", "3:00 PM", "3:30 PM",
-			"4:00 PM", "4:30 PM", "5:00 PM",
+	booked := make([]utils.BookedInterval, 0, len(bookings))
+	for _, booking := range bookings {
+		if interval, ok := utils.BookedIntervalFor(info, booking); ok {
+			booked = append(booked, interval)
 		}
 	}
 
-	// Get bookings for this provider on this date to filter out booked slots
-	bookingsCollection := c.db.Database("barrim").Collection("bookings")
-	bookingDate := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
-
-	cursor, err := bookingsCollection.Find(context.Background(), bson.M{
-		"serviceProviderId": serviceProviderID,
-		"bookingDate":       bookingDate,
-		"status":            bson.M{"$ne": "cancelled"},
-	})
+	return utils.FilterFreeSlots(info, slots, booked, time.Now()), nil
+}
 
-	if err != nil && err != mongo.ErrNoDocuments {
-		return ctx.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Error retrieving bookings",
-		})
+// activeBookingsOn returns the provider's active bookings on a date, other than excludeBookingID
+func (c *BookingController) activeBookingsOn(ctx context.Context, serviceProvider models.ServiceProvider, date time.Time, excludeBookingID primitive.ObjectID) ([]models.Booking, error) {
+	// Match older bookings stored as midnight in the client's zone as well
+	day := utils.BookingDateFor(date)
+	filter := bson.M{
+		"serviceProviderId": serviceProvider.ID,
+		"bookingDate":       bson.M{"$gte": day.Add(-12 * time.Hour), "$lt": day.Add(12 * time.Hour)},
+		"status":            bson.M{"$in": models.ActiveBookingStatuses},
 	}
+	if !excludeBookingID.IsZero() {
+		filter["_id"] = bson.M{"$ne": excludeBookingID}
+	}
+	cursor, err := c.db.Database("barrim").Collection("bookings").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	// Get booked time slots
 	var bookings []models.Booking
-	if err != mongo.ErrNoDocuments {
-		defer cursor.Close(context.Background())
-		if err := cursor.All(context.Background(), &bookings); err != nil {
-			return ctx.JSON(http.StatusInternalServerError, models.Response{
-				Status:  http.StatusInternalServerError,
-				Message: "Error decoding bookings",
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// alternativeSlots suggests free slots on the requested day and the following week
+func (c *BookingController) alternativeSlots(ctx context.Context, serviceProvider models.ServiceProvider, date time.Time, durationMinutes int, excludeBookingID primitive.ObjectID) []models.AlternativeSlots {
+	alternatives := []models.AlternativeSlots{}
+	for i := 0; i < 7 && len(alternatives) < 3; i++ {
+		day := date.AddDate(0, 0, i)
+		slots, err := c.freeSlotsForDate(ctx, serviceProvider, day, durationMinutes, excludeBookingID)
+		if err != nil {
+			log.Printf("Failed to compute alternative slots for %s: %v", day.Format("2006-01-02"), err)
+			break
+		}
+		if len(slots) > 0 {
+			alternatives = append(alternatives, models.AlternativeSlots{
+				Date:  day.Format("2006-01-02"),
+				Slots: utils.SlotLabels(slots),
 			})
 		}
 	}
+	return alternatives
+}
 
-	// Filter out booked slots
-	bookedSlots := make(map[string]bool)
-	for _, booking := range bookings {
-		bookedSlots[booking.TimeSlot] = true
+// ExpireStaleBookings expires pending bookings whose slot hold ran out before the
+// provider responded, freeing the slot and telling the customer
+func (c *BookingController) ExpireStaleBookings() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := c.db.Database("barrim").Collection("bookings").Find(ctx, bson.M{
+		"status":        models.BookingStatusPending,
+		"holdExpiresAt": bson.M{"$lte": now},
+	})
+	if err != nil {
+		log.Printf("Error finding stale bookings: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		log.Printf("Error decoding stale bookings: %v", err)
+		return
 	}
 
-	var freeSlots []string
-	for _, slot := range availableSlots {
-		if !bookedSlots[slot] {
-			freeSlots = append(freeSlots, slot)
+	for i := range bookings {
+		if _, err := c.transitionBooking(ctx, &bookings[i], models.BookingStatusExpired, models.BookingActorSystem,
+			primitive.NilObjectID, "Provider did not respond in time", nil); err != nil && !errors.Is(err, errBookingChanged) {
+			log.Printf("Failed to expire booking %s: %v", bookings[i].ID.Hex(), err)
 		}
 	}
 
-	// Filter out past time slots if the date is today
+	// Drop holds left behind by bookings that no longer exist
+	if _, err := c.db.Database("barrim").Collection("booking_slot_holds").DeleteMany(ctx, bson.M{
+		"expiresAt": bson.M{"$lte": now.Add(-time.Hour)},
+	}); err != nil {
+		log.Printf("Error cleaning up expired slot holds: %v", err)
+	}
+}
+
+// bookingReminders are the reminders sent before an accepted booking starts, furthest first
+var bookingReminders = []struct {
+	Key    string
+	Before time.Duration
+	Label  string
+}{
+	{Key: "24h", Before: 24 * time.Hour, Label: "tomorrow"},
+	{Key: "1h", Before: time.Hour, Label: "in 1 hour"},
+}
+
+// SendBookingReminders reminds both parties of upcoming accepted bookings and asks
+// providers to record the outcome of appointments that have ended
+func (c *BookingController) SendBookingReminders() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	bookingsCollection := c.db.Database("barrim").Collection("bookings")
 	now := time.Now()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-
-	if bookingDate.Equal(today) {
-		var filteredSlots []string
-		currentHour := now.Hour()
-		currentMinute := now.Minute()
-
-		for _, slot := range freeSlots {
-			// Parse time like "9:00 AM" or "1:30 PM"
-			timeComponents, err := time.Parse("3:04 PM", slot)
-			if err == nil {
-				slotHour := timeComponents.Hour()
-				slotMinute := timeComponents.Minute()
-
-				// If slot time is after current time, keep it
-				if slotHour > currentHour || (slotHour == currentHour && slotMinute > currentMinute) {
-					filteredSlots = append(filteredSlots, slot)
-				}
+	cursor, err := bookingsCollection.Find(ctx, bson.M{
+		"status":            bson.M{"$in": []string{models.BookingStatusAccepted, models.BookingStatusConfirmed}},
+		"bookingDate":       bson.M{"$gte": now.AddDate(0, 0, -2), "$lte": now.AddDate(0, 0, 2)},
+		"outcomePromptedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		log.Printf("Error finding bookings for reminders: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		log.Printf("Error decoding bookings for reminders: %v", err)
+		return
+	}
+
+	providers := make(map[primitive.ObjectID]*models.ServiceProviderInfo)
+	for _, booking := range bookings {
+		info, ok := providers[booking.ServiceProviderID]
+		if !ok {
+			var serviceProvider models.ServiceProvider
+			if err := c.db.Database("barrim").Collection("serviceProviders").FindOne(ctx, bson.M{"_id": booking.ServiceProviderID}).Decode(&serviceProvider); err != nil {
+				log.Printf("Error fetching service provider for booking %s: %v", booking.ID.Hex(), err)
 			}
+			info = serviceProvider.ServiceProviderInfo
+			providers[booking.ServiceProviderID] = info
+		}
+
+		interval, ok := utils.BookedIntervalFor(info, booking)
+		if !ok {
+			continue
+		}
+
+		if now.After(interval.End) {
+			c.promptBookingOutcome(ctx, booking, now)
+			continue
+		}
+
+		// Only the closest reminder due is sent: a booking accepted within the
+		// hour gets the 1h reminder, and the 24h one is marked as sent with it
+		var due []string
+		label := ""
+		for _, reminder := range bookingReminders {
+			if now.Before(interval.Start.Add(-reminder.Before)) || !now.Before(interval.Start) {
+				continue
+			}
+			due = append(due, reminder.Key)
+			label = reminder.Label
+		}
+		if len(due) == 0 {
+			continue
 		}
-		freeSlots = filteredSlots
+		key := due[len(due)-1]
+		// Claim the reminder first so concurrent runs never send it twice
+		result, err := bookingsCollection.UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "remindersSent": bson.M{"$ne": key}},
+			bson.M{"$addToSet": bson.M{"remindersSent": bson.M{"$each": due}}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		c.sendBookingReminder(booking, key, label)
 	}
+}
 
-	return ctx.JSON(http.StatusOK, models.Response{
-		Status:  http.StatusOK,
-		Message: "Available time slots retrieved successfully",
-		Data:    freeSlots,
-	})
+// sendBookingReminder notifies the customer and the provider about an upcoming booking
+func (c *BookingController) sendBookingReminder(booking models.Booking, key, label string) {
+	data := map[string]interface{}{
+		"type":        "booking_reminder",
+		"reminder":    key,
+		"bookingId":   booking.ID.Hex(),
+		"bookingDate": booking.BookingDate.Format("2006-01-02"),
+		"timeSlot":    booking.TimeSlot,
+	}
+
+	userMessage := fmt.Sprintf("Reminder: your booking is %s at %s", label, booking.TimeSlot)
+	if err := utils.SendFCMNotificationToUser(c.db, booking.UserID, "Booking Reminder", userMessage, data); err != nil {
+		log.Printf("Failed to send FCM reminder to user: %v", err)
+	}
+	if err := utils.SaveNotification(c.db, booking.UserID, "Booking Reminder", userMessage, "booking_reminder", data); err != nil {
+		log.Printf("Failed to save reminder notification for user: %v", err)
+	}
+
+	providerMessage := fmt.Sprintf("Reminder: you have a booking %s at %s", label, booking.TimeSlot)
+	if err := utils.SendFCMNotificationToServiceProvider(c.db, booking.ServiceProviderID, "Booking Reminder", providerMessage, data); err != nil {
+		log.Printf("Failed to send FCM reminder to service provider: %v", err)
+	}
+	if err := utils.SaveNotification(c.db, booking.ServiceProviderID, "Booking Reminder", providerMessage, "booking_reminder", data); err != nil {
+		log.Printf("Failed to save reminder notification for service provider: %v", err)
+	}
+}
+
+// promptBookingOutcome asks the provider to mark a finished appointment as completed or no-show
+func (c *BookingController) promptBookingOutcome(ctx context.Context, booking models.Booking, now time.Time) {
+	result, err := c.db.Database("barrim").Collection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "outcomePromptedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"outcomePromptedAt": now}},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"type":        "booking_outcome_request",
+		"bookingId":   booking.ID.Hex(),
+		"bookingDate": booking.BookingDate.Format("2006-01-02"),
+		"timeSlot":    booking.TimeSlot,
+	}
+	message := fmt.Sprintf("How did your booking on %s at %s go? Mark it as completed or no-show", booking.BookingDate.Format("2006-01-02"), booking.TimeSlot)
+	if err := utils.SendFCMNotificationToServiceProvider(c.db, booking.ServiceProviderID, "Update Booking Outcome", message, data); err != nil {
+		log.Printf("Failed to send FCM outcome request to service provider: %v", err)
+	}
+	if err := utils.SaveNotification(c.db, booking.ServiceProviderID, "Update Booking Outcome", message, "booking_outcome_request", data); err != nil {
+		log.Printf("Failed to save outcome request notification: %v", err)
+	}
+}
+
+// promptReview asks the customer to review the provider after a completed booking
+func (c *BookingController) promptReview(booking models.Booking) {
+	data := map[string]interface{}{
+		"type":              "review_prompt",
+		"bookingId":         booking.ID.Hex(),
+		"serviceProviderId": booking.ServiceProviderID.Hex(),
+	}
+	message := "Your booking is complete. Tell others how it went by leaving a review"
+	if err := utils.SendFCMNotificationToUser(c.db, booking.UserID, "Leave a Review", message, data); err != nil {
+		log.Printf("Failed to send FCM review prompt to user: %v", err)
+	}
+	if err := utils.SaveNotification(c.db, booking.UserID, "Leave a Review", message, "review_prompt", data); err != nil {
+		log.Printf("Failed to save review prompt notification: %v", err)
+	}
 }
 
 // UpdateBookingStatus updates the status of a booking
@@ -701,6 +859,7 @@ func (c *BookingController) UpdateBookingStatus(ctx echo.Context) error {
 	// Extract parameters
 	bookingID := ctx.Param("id")
 	status := ctx.FormValue("status")
+	reason := ctx.FormValue("reason")
 
 	// Validate booking ID
 	objectID, err := primitive.ObjectIDFromHex(bookingID)
@@ -711,20 +870,6 @@ func (c *BookingController) UpdateBookingStatus(ctx echo.Context) error {
 		})
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"confirmed": true,
-		"completed": true,
-		"cancelled": true,
-	}
-	if !validStatuses[status] {
-		return ctx.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid status. Use 'pending', 'confirmed', 'completed', or 'cancelled'",
-		})
-	}
-
 	// Get booking
 	collection := c.db.Database("barrim").Collection("bookings")
 	var booking models.Booking
@@ -742,230 +887,718 @@ func (c *BookingController) UpdateBookingStatus(ctx echo.Context) error {
 		})
 	}
 
-	// Check if user has permission to update this booking
-	isServiceProvider := user.ID == booking.ServiceProviderID
-	isCustomer := user.ID == booking.UserID
-
-	if !(isServiceProvider || isCustomer) {
+	// Work out which side of the booking the caller is on
+	actor := bookingActorFor(user, booking)
+	if actor == "" {
 		return ctx.JSON(http.StatusForbidden, models.Response{
 			Status:  http.StatusForbidden,
 			Message: "You don't have permission to update this booking",
 		})
 	}
 
-	// Customers can only cancel bookings
-	if isCustomer && status != "cancelled" {
-		return ctx.JSON(http.StatusForbidden, models.Response{
+	updated, err := c.transitionBooking(context.Background(), &booking, status, actor, user.ID, reason, nil)
+	if err != nil {
+		return ctx.JSON(bookingTransitionErrorStatus(err), models.Response{
+			Status:  bookingTransitionErrorStatus(err),
+			Message: err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Booking status updated successfully",
+		Data:    updated,
+	})
+}
+
+// UpdateBookingStatusForAdmin lets admins cancel or complete a booking on behalf of either party
+func (bc *BookingController) UpdateBookingStatusForAdmin(c echo.Context) error {
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Unauthorized",
+		})
+	}
+
+	// Check if user is admin, super_admin, or manager
+	if claims.UserType != "admin" && claims.UserType != "super_admin" && claims.UserType != "manager" {
+		return c.JSON(http.StatusForbidden, models.Response{
 			Status:  http.StatusForbidden,
-			Message: "Customers can only cancel bookings",
+			Message: "Only admins, super admins, and managers can update bookings",
 		})
 	}
 
-	// Update booking status
-	_, err = collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": objectID},
-		bson.M{
-			"$set": bson.M{
-				"status":    status,
-				"updatedAt": time.Now(),
-			},
-		},
-	)
+	adminID, err := primitive.ObjectIDFromHex(claims.UserID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, models.Response{
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid booking ID",
+		})
+	}
+
+	var req models.BookingStatusUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request format",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = bc.db.Database("barrim").Collection("bookings").FindOne(ctx, bson.M{"_id": objID}).Decode(&booking)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Booking not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
-			Message: "Error updating booking status",
+			Message: "Error finding booking",
 		})
 	}
 
-	return ctx.JSON(http.StatusOK, models.Response{
+	updated, err := bc.transitionBooking(ctx, &booking, req.Status, models.BookingActorAdmin, adminID, req.Reason, nil)
+	if err != nil {
+		return c.JSON(bookingTransitionErrorStatus(err), models.Response{
+			Status:  bookingTransitionErrorStatus(err),
+			Message: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Booking status updated successfully",
+		Data:    updated,
 	})
 }
 
-// CancelBooking specifically handles booking cancellation
-func (c *BookingController) CancelBooking(ctx echo.Context) error {
-	ctx.FormValue("status")
-	ctx.Request().Form.Set("status", "cancelled")
-	return c.UpdateBookingStatus(ctx)
+// errBookingChanged is returned when a booking's status changed between reading and updating it
+var errBookingChanged = errors.New("booking status was changed by someone else, please refresh")
+
+// bookingTransitionErrorStatus maps a transitionBooking error to an HTTP status
+func bookingTransitionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInvalidBookingTransition):
+		return http.StatusBadRequest
+	case errors.Is(err, errBookingChanged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-// Helper function to check if a service provider is available at a specific time
-func isProviderAvailable(provider models.User, bookingDate time.Time, timeSlot string) bool {
-	// Format the date for comparison with available days
-	formattedDate := bookingDate.Format("2006-01-02")
+// transitionBooking moves a booking to a new status through the booking state machine.
+// The update only applies if the status is unchanged since the booking was read; the
+// change is appended to the booking's status history, the slot hold is confirmed or
+// released, and the other party is notified.
+func (c *BookingController) transitionBooking(ctx context.Context, booking *models.Booking, to, actor string, actorID primitive.ObjectID, reason string, extra bson.M) (*models.Booking, error) {
+	if err := models.CheckBookingTransition(booking.Status, to, actor); err != nil {
+		return nil, err
+	}
+	if models.IsBookingOutcome(to) {
+		if err := c.checkBookingStarted(ctx, *booking, to); err != nil {
+			return nil, err
+		}
+	}
 
-	if provider.ServiceProviderInfo == nil {
-		return false
+	now := time.Now()
+	change := models.BookingStatusChange{
+		From:      booking.Status,
+		To:        to,
+		Actor:     actor,
+		ActorID:   actorID,
+		Reason:    reason,
+		ChangedAt: now,
 	}
 
-	// Check if provider works on this specific date
-	dateAvailable := false
-	if provider.ServiceProviderInfo.AvailableDays != nil {
-		for _, day := range provider.ServiceProviderInfo.AvailableDays {
-			if day == formattedDate {
-				dateAvailable = true
-				break
-			}
+	set := bson.M{
+		"status":    to,
+		"updatedAt": now,
+	}
+	for key, value := range extra {
+		set[key] = value
+	}
+	update := bson.M{
+		"$set":   set,
+		"$push":  bson.M{"statusHistory": change},
+		"$unset": bson.M{"holdExpiresAt": ""},
+	}
+
+	var updated models.Booking
+	err := c.db.Database("barrim").Collection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": booking.ID, "status": booking.Status},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errBookingChanged
 		}
+		return nil, err
 	}
 
-	// If not available on this specific date, check weekdays
-	if !dateAvailable && provider.ServiceProviderInfo.AvailableWeekdays != nil {
-		dayOfWeek := bookingDate.Weekday().String()
-		for _, day := range provider.ServiceProviderInfo.AvailableWeekdays {
-			if day == dayOfWeek {
-				dateAvailable = true
-				break
+	// Active bookings keep their slot; every other status frees it
+	if to == models.BookingStatusAccepted || to == models.BookingStatusConfirmed {
+		err = utils.ConfirmBookingSlot(c.db, updated.ID)
+	} else {
+		err = utils.ReleaseBookingSlot(c.db, updated.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to update slot hold for booking %s: %v", updated.ID.Hex(), err)
+	}
+
+	c.notifyBookingTransition(updated, actor)
+	if to == models.BookingStatusCompleted {
+		c.promptReview(updated)
+		// Only the customer's first completed booking is rewarded
+		go utils.TriggerReferralEvent(c.db, utils.ReferralEvent{
+			Event:       models.ReferralEventFirstBooking,
+			RefereeType: models.PointsAccountUser,
+			RefereeID:   updated.UserID,
+		})
+	}
+	return &updated, nil
+}
+
+// checkBookingStarted refuses to record the outcome of an appointment that has
+// not started yet
+func (c *BookingController) checkBookingStarted(ctx context.Context, booking models.Booking, to string) error {
+	var serviceProvider models.ServiceProvider
+	err := c.db.Database("barrim").Collection("serviceProviders").FindOne(ctx,
+		bson.M{"_id": booking.ServiceProviderID},
+		options.FindOne().SetProjection(bson.M{"serviceProviderInfo": 1}),
+	).Decode(&serviceProvider)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	start := utils.BookingDay(booking.BookingDate)
+	if interval, ok := utils.BookedIntervalFor(serviceProvider.ServiceProviderInfo, booking); ok {
+		start = interval.Start
+	}
+	if time.Now().Before(start) {
+		return fmt.Errorf("%w: a booking cannot be marked %s before it starts", models.ErrInvalidBookingTransition, to)
+	}
+	return nil
+}
+
+// bookingStatusMessages are the customer-facing messages for each status a booking can move to
+var bookingStatusMessages = map[string]string{
+	models.BookingStatusAccepted:  "Your booking has been accepted",
+	models.BookingStatusRejected:  "Your booking has been rejected",
+	models.BookingStatusConfirmed: "Your booking has been confirmed",
+	models.BookingStatusCompleted: "Your booking has been marked as completed",
+	models.BookingStatusCancelled: "Your booking has been cancelled",
+	models.BookingStatusExpired:   "Your booking request expired because the provider did not respond in time",
+	models.BookingStatusNoShow:    "Your booking was marked as a no-show",
+}
+
+// notifyBookingTransition tells the other party about a status change: the provider when
+// the customer acted, the customer otherwise
+func (c *BookingController) notifyBookingTransition(booking models.Booking, actor string) {
+	data := map[string]interface{}{
+		"type":        "booking_update",
+		"bookingId":   booking.ID.Hex(),
+		"status":      booking.Status,
+		"bookingDate": booking.BookingDate.Format("2006-01-02"),
+		"timeSlot":    booking.TimeSlot,
+	}
+
+	if actor == models.BookingActorUser {
+		message := "A customer has " + booking.Status + " their booking"
+		if c.hub != nil {
+			if err := c.hub.SendToUser(booking.ServiceProviderID, websocket.Notification{
+				Type:    "booking_update",
+				Message: message,
+				Data:    booking,
+			}); err != nil {
+				log.Printf("Failed to send WebSocket notification to service provider: %v", err)
 			}
 		}
+		if err := utils.SendFCMNotificationToServiceProvider(c.db, booking.ServiceProviderID, "Booking Update", message, data); err != nil {
+			log.Printf("Failed to send FCM notification to service provider: %v", err)
+		}
+		if err := utils.SaveNotification(c.db, booking.ServiceProviderID, "Booking Update", message, "booking_update", booking); err != nil {
+			log.Printf("Failed to save notification: %v", err)
+		}
+		return
 	}
 
-	if !dateAvailable {
-		return false
+	message, ok := bookingStatusMessages[booking.Status]
+	if !ok {
+		message = "Your booking status has been updated"
+	}
+	if c.hub != nil {
+		if err := c.hub.NotifyBookingResponse(booking.UserID, booking); err != nil {
+			log.Printf("Failed to send WebSocket notification to user: %v", err)
+		}
+	}
+	if err := utils.SendFCMNotificationToUser(c.db, booking.UserID, "Booking Update", message, data); err != nil {
+		log.Printf("Failed to send FCM notification to user: %v", err)
 	}
+	if err := utils.SaveNotification(c.db, booking.UserID, "Booking Update", message, "booking_update", booking); err != nil {
+		log.Printf("Failed to save notification: %v", err)
+	}
+}
 
-	// If the provider has available hours, check if the requested time slot is within those hours
-	if provider.ServiceProviderInfo.AvailableHours != nil && len(provider.ServiceProviderInfo.AvailableHours) >= 2 {
-		// Parse the start and end hours
-		startHourStr := provider.ServiceProviderInfo.AvailableHours[0]
-		endHourStr := provider.ServiceProviderInfo.AvailableHours[1]
+// bookingActorFor returns which side of a booking a user is on, or "" if neither
+func bookingActorFor(user models.User, booking models.Booking) string {
+	if user.ID == booking.ServiceProviderID ||
+		(user.ServiceProviderID != nil && *user.ServiceProviderID == booking.ServiceProviderID) {
+		return models.BookingActorProvider
+	}
+	if user.ID == booking.UserID {
+		return models.BookingActorUser
+	}
+	return ""
+}
 
-		startHour, err := time.Parse("15:04", startHourStr)
-		if err != nil {
-			return false
+// notifyBookingParty sends a booking event to one side of a booking over WebSocket, FCM and in-app notifications
+func (c *BookingController) notifyBookingParty(booking models.Booking, recipient, notifType, title, message string) {
+	data := map[string]interface{}{
+		"type":        notifType,
+		"bookingId":   booking.ID.Hex(),
+		"bookingDate": booking.BookingDate.Format("2006-01-02"),
+		"timeSlot":    booking.TimeSlot,
+	}
+
+	recipientID := booking.UserID
+	if recipient == models.BookingActorProvider {
+		recipientID = booking.ServiceProviderID
+	}
+
+	if c.hub != nil {
+		if err := c.hub.SendToUser(recipientID, websocket.Notification{
+			Type:    notifType,
+			Message: message,
+			Data:    booking,
+		}); err != nil {
+			log.Printf("Failed to send WebSocket notification: %v", err)
 		}
+	}
 
-		endHour, err := time.Parse("15:04", endHourStr)
-		if err != nil {
-			return false
+	var err error
+	if recipient == models.BookingActorProvider {
+		err = utils.SendFCMNotificationToServiceProvider(c.db, recipientID, title, message, data)
+	} else {
+		err = utils.SendFCMNotificationToUser(c.db, recipientID, title, message, data)
+	}
+	if err != nil {
+		log.Printf("Failed to send FCM notification: %v", err)
+	}
+
+	if err := utils.SaveNotification(c.db, recipientID, title, message, notifType, booking); err != nil {
+		log.Printf("Failed to save notification: %v", err)
+	}
+}
+
+// counterpartyOf returns the other side of a booking
+func counterpartyOf(actor string) string {
+	if actor == models.BookingActorProvider {
+		return models.BookingActorUser
+	}
+	return models.BookingActorProvider
+}
+
+// checkReschedulePolicy enforces how often and how late a booking can be rescheduled
+func checkReschedulePolicy(booking models.Booking, serviceProvider models.ServiceProvider) error {
+	switch booking.Status {
+	case models.BookingStatusPending, models.BookingStatusAccepted, models.BookingStatusConfirmed:
+	default:
+		return fmt.Errorf("a %s booking cannot be rescheduled", booking.Status)
+	}
+	if booking.RescheduleCount >= utils.MaxReschedules() {
+		return fmt.Errorf("this booking has already been rescheduled %d times", booking.RescheduleCount)
+	}
+	if interval, ok := utils.BookedIntervalFor(serviceProvider.ServiceProviderInfo, booking); ok {
+		if time.Until(interval.Start) < utils.RescheduleMinNotice() {
+			return fmt.Errorf("bookings can't be rescheduled less than %s before the appointment", utils.RescheduleMinNotice())
 		}
+	}
+	return nil
+}
 
-		// Parse the requested time slot (format: "3:04 PM")
-		requestedTime, err := time.Parse("3:04 PM", timeSlot)
-		if err != nil {
-			return false
+// validateRescheduleSlot checks that a proposed date and slot are free for a booking,
+// returning the normalized date and slot label, or alternatives when they're not
+func (c *BookingController) validateRescheduleSlot(ctx context.Context, booking models.Booking, serviceProvider models.ServiceProvider, date time.Time, slot string) (time.Time, string, []models.AlternativeSlots, error) {
+	timeSlot, err := utils.NormalizeTimeSlot(slot)
+	if err != nil {
+		return time.Time{}, "", nil, err
+	}
+	bookingDate := utils.BookingDateFor(date)
+
+	// The booking keeps its own length when it moves; the provider's buffer is
+	// added around it as for any booking
+	durationMinutes := booking.DurationMinutes
+	if durationMinutes <= 0 {
+		durationMinutes = utils.ServiceDurationMinutes(serviceProvider.ServiceProviderInfo, "")
+	}
+	freeSlots, err := c.freeSlotsForDate(ctx, serviceProvider, bookingDate, durationMinutes, booking.ID)
+	if err != nil {
+		return time.Time{}, "", nil, err
+	}
+	for _, s := range freeSlots {
+		if s.Label == timeSlot {
+			return bookingDate, timeSlot, nil, nil
 		}
+	}
+	return bookingDate, timeSlot, c.alternativeSlots(ctx, serviceProvider, bookingDate, durationMinutes, booking.ID), utils.ErrSlotTaken
+}
 
-		// Convert to comparable format (hours and minutes only)
-		requestedHour := time.Date(0, 1, 1, requestedTime.Hour(), requestedTime.Minute(), 0, 0, time.UTC)
-		startHourNormalized := time.Date(0, 1, 1, startHour.Hour(), startHour.Minute(), 0, 0, time.UTC)
-		endHourNormalized := time.Date(0, 1, 1, endHour.Hour(), endHour.Minute(), 0, 0, time.UTC)
+// loadBookingForReschedule loads the authenticated user, the booking and its provider
+func (c *BookingController) loadBookingForReschedule(ctx echo.Context) (*models.Booking, *models.ServiceProvider, string, primitive.ObjectID, error) {
+	claims := middleware.GetUserFromToken(ctx)
+	if claims == nil {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	var user models.User
+	if err := c.db.Database("barrim").Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+	}
 
-		// Check if the requested time is within the provider's working hours
-		return (requestedHour.Equal(startHourNormalized) || requestedHour.After(startHourNormalized)) &&
-			requestedHour.Before(endHourNormalized)
+	bookingID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusBadRequest, "Invalid booking ID")
+	}
+	var booking models.Booking
+	if err := c.db.Database("barrim").Collection("bookings").FindOne(context.Background(), bson.M{"_id": bookingID}).Decode(&booking); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusNotFound, "Booking not found")
+		}
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusInternalServerError, "Error finding booking")
 	}
 
-	// If no specific hours are defined, use default business hours logic
-	// This could be improved with more specific default hour ranges if needed
-	return true
+	actor := bookingActorFor(user, booking)
+	if actor == "" {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusForbidden, "You don't have permission to reschedule this booking")
+	}
 
+	var serviceProvider models.ServiceProvider
+	if err := c.db.Database("barrim").Collection("serviceProviders").FindOne(context.Background(), bson.M{"_id": booking.ServiceProviderID}).Decode(&serviceProvider); err != nil {
+		return nil, nil, "", primitive.NilObjectID, echo.NewHTTPError(http.StatusInternalServerError, "Error finding service provider")
+	}
+
+	return &booking, &serviceProvider, actor, user.ID, nil
 }
 
-// Helper function to check if a service provider is available at a specific time using ServiceProviderInfo from serviceProviders collection
-func isServiceProviderAvailable(serviceProvider models.ServiceProvider, bookingDate time.Time, timeSlot string) bool {
-	// Format the date for comparison with available days
-	formattedDate := bookingDate.Format("2006-01-02")
-
-	// Debug logging
-	log.Printf("DEBUG: Checking availability for date: %s, timeSlot: %s", formattedDate, timeSlot)
-	log.Printf("DEBUG: ServiceProviderInfo: %+v", serviceProvider.ServiceProviderInfo)
-
-	if serviceProvider.ServiceProviderInfo == nil {
-		log.Printf("DEBUG: ServiceProviderInfo is nil")
-		return false
-	}
-
-	// Check if provider works on this specific date
-	dateAvailable := false
-	if serviceProvider.ServiceProviderInfo.AvailableDays != nil {
-		log.Printf("DEBUG: Checking AvailableDays: %v", serviceProvider.ServiceProviderInfo.AvailableDays)
-		for _, day := range serviceProvider.ServiceProviderInfo.AvailableDays {
-			if day == formattedDate {
-				dateAvailable = true
-				log.Printf("DEBUG: Found date in AvailableDays: %s", day)
-				break
-			}
+// httpErrorResponse renders an echo.HTTPError in the standard response format
+func httpErrorResponse(ctx echo.Context, err error) error {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return ctx.JSON(he.Code, models.Response{
+			Status:  he.Code,
+			Message: fmt.Sprint(he.Message),
+		})
+	}
+	return ctx.JSON(http.StatusInternalServerError, models.Response{
+		Status:  http.StatusInternalServerError,
+		Message: err.Error(),
+	})
+}
+
+// RequestReschedule lets either party propose a new date and slot for a booking
+func (c *BookingController) RequestReschedule(ctx echo.Context) error {
+	booking, serviceProvider, actor, actorID, err := c.loadBookingForReschedule(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
+	}
+
+	var req models.RescheduleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request",
+		})
+	}
+
+	if booking.PendingReschedule != nil {
+		return ctx.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "A reschedule request is already pending for this booking",
+		})
+	}
+
+	if err := checkReschedulePolicy(*booking, *serviceProvider); err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+
+	bookingDate, timeSlot, alternatives, err := c.validateRescheduleSlot(context.Background(), *booking, *serviceProvider, req.BookingDate, req.TimeSlot)
+	if err != nil {
+		if err == utils.ErrSlotTaken {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "This time slot is not available",
+				Data:    map[string]interface{}{"alternatives": alternatives},
+			})
 		}
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid time slot format",
+		})
 	}
 
-	// If not available on this specific date, check weekdays
-	if !dateAvailable && serviceProvider.ServiceProviderInfo.AvailableWeekdays != nil {
-		dayOfWeek := bookingDate.Weekday().String()
-		log.Printf("DEBUG: Checking AvailableWeekdays: %v for day: %s", serviceProvider.ServiceProviderInfo.AvailableWeekdays, dayOfWeek)
-		for _, day := range serviceProvider.ServiceProviderInfo.AvailableWeekdays {
-			if day == dayOfWeek {
-				dateAvailable = true
-				log.Printf("DEBUG: Found weekday in AvailableWeekdays: %s", day)
-				break
-			}
+	proposal := models.BookingReschedule{
+		ID:           primitive.NewObjectID(),
+		ProposedBy:   actor,
+		ProposedByID: actorID,
+		FromDate:     booking.BookingDate,
+		FromTimeSlot: booking.TimeSlot,
+		BookingDate:  bookingDate,
+		TimeSlot:     timeSlot,
+		Reason:       req.Reason,
+		Status:       "pending",
+		CreatedAt:    time.Now(),
+	}
+
+	var updated models.Booking
+	err = c.db.Database("barrim").Collection("bookings").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": booking.ID, "status": booking.Status, "pendingReschedule": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pendingReschedule": proposal, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: errBookingChanged.Error(),
+			})
 		}
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to request reschedule",
+		})
 	}
 
-	if !dateAvailable {
-		log.Printf("DEBUG: Date not available - AvailableDays: %v, AvailableWeekdays: %v", serviceProvider.ServiceProviderInfo.AvailableDays, serviceProvider.ServiceProviderInfo.AvailableWeekdays)
-		return false
+	c.notifyBookingParty(updated, counterpartyOf(actor), "booking_reschedule_requested", "Reschedule Requested",
+		fmt.Sprintf("A new time was proposed for your booking: %s at %s", bookingDate.Format("2006-01-02"), timeSlot))
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Reschedule requested successfully",
+		Data:    updated,
+	})
+}
+
+// RespondToReschedule lets the other party accept, decline or counter a reschedule proposal
+func (c *BookingController) RespondToReschedule(ctx echo.Context) error {
+	booking, serviceProvider, actor, actorID, err := c.loadBookingForReschedule(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
 	}
 
-	// If the provider has available hours, check if the requested time slot is within those hours
-	if serviceProvider.ServiceProviderInfo.AvailableHours != nil && len(serviceProvider.ServiceProviderInfo.AvailableHours) >= 2 {
-		// Parse the start and end hours
-		startHourStr := serviceProvider.ServiceProviderInfo.AvailableHours[0]
-		endHourStr := serviceProvider.ServiceProviderInfo.AvailableHours[1]
+	var req models.RescheduleResponseRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request",
+		})
+	}
 
-		log.Printf("DEBUG: Checking AvailableHours: %v", serviceProvider.ServiceProviderInfo.AvailableHours)
-		log.Printf("DEBUG: Start hour: %s, End hour: %s", startHourStr, endHourStr)
+	proposal := booking.PendingReschedule
+	if proposal == nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "There is no pending reschedule request for this booking",
+		})
+	}
+	if proposal.ProposedBy == actor {
+		return ctx.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "You can't respond to your own reschedule request",
+		})
+	}
 
-		startHour, err := time.Parse("15:04", startHourStr)
-		if err != nil {
-			log.Printf("DEBUG: Error parsing start hour %s: %v", startHourStr, err)
-			return false
+	now := time.Now()
+	bookingsCollection := c.db.Database("barrim").Collection("bookings")
+	filter := bson.M{"_id": booking.ID, "status": booking.Status, "pendingReschedule._id": proposal.ID}
+	answered := *proposal
+	answered.RespondedAt = &now
+
+	var update bson.M
+	var notifType, title, message string
+
+	switch req.Action {
+	case "accept":
+		if err := checkReschedulePolicy(*booking, *serviceProvider); err != nil {
+			return ctx.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: err.Error(),
+			})
+		}
+		if _, _, alternatives, err := c.validateRescheduleSlot(context.Background(), *booking, *serviceProvider, proposal.BookingDate, proposal.TimeSlot); err != nil {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "The proposed time slot is no longer available",
+				Data:    map[string]interface{}{"alternatives": alternatives},
+			})
 		}
 
-		endHour, err := time.Parse("15:04", endHourStr)
+		// Reserve the new slot before committing so nobody else can take it meanwhile
+		reservationID, err := utils.ReserveRescheduledSlot(c.db, serviceProvider.ServiceProviderInfo, *booking, proposal.BookingDate, proposal.TimeSlot, booking.HoldExpiresAt)
 		if err != nil {
-			log.Printf("DEBUG: Error parsing end hour %s: %v", endHourStr, err)
-			return false
+			if err == utils.ErrSlotTaken {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: "The proposed time slot is no longer available",
+				})
+			}
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Error reserving time slot",
+			})
 		}
 
-		// Parse the requested time slot (format: "11:00" - 24-hour format)
-		requestedTime, err := time.Parse("15:04", timeSlot)
+		answered.Status = "accepted"
+		update = bson.M{
+			"$set": bson.M{
+				"bookingDate": proposal.BookingDate,
+				"timeSlot":    proposal.TimeSlot,
+				"updatedAt":   now,
+			},
+			"$inc":   bson.M{"rescheduleCount": 1},
+			"$push":  bson.M{"rescheduleHistory": answered},
+			"$unset": bson.M{"pendingReschedule": "", "remindersSent": "", "outcomePromptedAt": ""},
+		}
+
+		var updated models.Booking
+		err = bookingsCollection.FindOneAndUpdate(context.Background(), filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
 		if err != nil {
-			log.Printf("DEBUG: Error parsing timeSlot as 24-hour format %s: %v", timeSlot, err)
-			// Try parsing as 12-hour format ("3:04 PM")
-			requestedTime, err = time.Parse("3:04 PM", timeSlot)
-			if err != nil {
-				log.Printf("DEBUG: Error parsing timeSlot as 12-hour format %s: %v", timeSlot, err)
-				return false
+			if releaseErr := utils.ReleaseBookingSlotHold(c.db, reservationID); releaseErr != nil {
+				log.Printf("Failed to release slot reservation %s: %v", reservationID.Hex(), releaseErr)
+			}
+			if err == mongo.ErrNoDocuments {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: errBookingChanged.Error(),
+				})
 			}
-			log.Printf("DEBUG: Successfully parsed timeSlot as 12-hour format: %s", timeSlot)
-		} else {
-			log.Printf("DEBUG: Successfully parsed timeSlot as 24-hour format: %s", timeSlot)
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to reschedule booking",
+			})
 		}
+		if err := utils.ReleaseBookingSlotsExcept(c.db, serviceProvider.ServiceProviderInfo, updated); err != nil {
+			log.Printf("Failed to release previous slot hold for booking %s: %v", booking.ID.Hex(), err)
+		}
+
+		c.notifyBookingParty(updated, proposal.ProposedBy, "booking_reschedule_accepted", "Reschedule Accepted",
+			fmt.Sprintf("Your booking was moved to %s at %s", proposal.BookingDate.Format("2006-01-02"), proposal.TimeSlot))
 
-		// Convert to comparable format (hours and minutes only)
-		requestedHour := time.Date(0, 1, 1, requestedTime.Hour(), requestedTime.Minute(), 0, 0, time.UTC)
-		startHourNormalized := time.Date(0, 1, 1, startHour.Hour(), startHour.Minute(), 0, 0, time.UTC)
-		endHourNormalized := time.Date(0, 1, 1, endHour.Hour(), endHour.Minute(), 0, 0, time.UTC)
+		return ctx.JSON(http.StatusOK, models.Response{
+			Status:  http.StatusOK,
+			Message: "Booking rescheduled successfully",
+			Data:    updated,
+		})
+
+	case "decline":
+		answered.Status = "declined"
+		update = bson.M{
+			"$set":   bson.M{"updatedAt": now},
+			"$push":  bson.M{"rescheduleHistory": answered},
+			"$unset": bson.M{"pendingReschedule": ""},
+		}
+		notifType, title, message = "booking_reschedule_declined", "Reschedule Declined", "Your reschedule request was declined"
+
+	case "counter":
+		if err := checkReschedulePolicy(*booking, *serviceProvider); err != nil {
+			return ctx.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: err.Error(),
+			})
+		}
+		bookingDate, timeSlot, alternatives, err := c.validateRescheduleSlot(context.Background(), *booking, *serviceProvider, req.BookingDate, req.TimeSlot)
+		if err != nil {
+			if err == utils.ErrSlotTaken {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: "This time slot is not available",
+					Data:    map[string]interface{}{"alternatives": alternatives},
+				})
+			}
+			return ctx.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid time slot format",
+			})
+		}
 
-		log.Printf("DEBUG: Requested hour: %02d:%02d, Start hour: %02d:%02d, End hour: %02d:%02d",
-			requestedTime.Hour(), requestedTime.Minute(),
-			startHour.Hour(), startHour.Minute(),
-			endHour.Hour(), endHour.Minute())
+		answered.Status = "countered"
+		counter := models.BookingReschedule{
+			ID:           primitive.NewObjectID(),
+			ProposedBy:   actor,
+			ProposedByID: actorID,
+			FromDate:     booking.BookingDate,
+			FromTimeSlot: booking.TimeSlot,
+			BookingDate:  bookingDate,
+			TimeSlot:     timeSlot,
+			Reason:       req.Reason,
+			Status:       "pending",
+			CreatedAt:    now,
+		}
+		update = bson.M{
+			"$set":  bson.M{"pendingReschedule": counter, "updatedAt": now},
+			"$push": bson.M{"rescheduleHistory": answered},
+		}
+		notifType, title = "booking_reschedule_countered", "New Time Proposed"
+		message = fmt.Sprintf("A different time was proposed for your booking: %s at %s", bookingDate.Format("2006-01-02"), timeSlot)
 
-		// Check if the requested time is within the provider's working hours
-		isWithinHours := (requestedHour.Equal(startHourNormalized) || requestedHour.After(startHourNormalized)) &&
-			requestedHour.Before(endHourNormalized)
+	default:
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Action must be 'accept', 'decline' or 'counter'",
+		})
+	}
 
-		log.Printf("DEBUG: Time slot within hours: %v", isWithinHours)
-		return isWithinHours
+	var updated models.Booking
+	err = bookingsCollection.FindOneAndUpdate(context.Background(), filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: errBookingChanged.Error(),
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to respond to reschedule request",
+		})
 	}
 
-	// If no specific hours are defined, use default business hours logic
-	return true
+	c.notifyBookingParty(updated, proposal.ProposedBy, notifType, title, message)
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Reschedule request " + answered.Status,
+		Data:    updated,
+	})
+}
+
+// CancelBooking specifically handles booking cancellation
+func (c *BookingController) CancelBooking(ctx echo.Context) error {
+	ctx.FormValue("status")
+	ctx.Request().Form.Set("status", "cancelled")
+	return c.UpdateBookingStatus(ctx)
 }
 
 // AcceptBooking allows a service provider to accept a booking request
@@ -1079,73 +1712,24 @@ func (bc *BookingController) AcceptBooking(c echo.Context) error {
 		})
 	}
 
-	// Check if the booking is already accepted, rejected, or cancelled
-	if booking.Status != "pending" {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Cannot update status: booking is already " + booking.Status,
-		})
-	}
-
-	// Update the booking status
-	update := bson.M{
-		"$set": bson.M{
-			"status":    req.Status,
-			"updatedAt": time.Now(),
-		},
-	}
-
-	// Add provider response if provided
+	// Move the booking through the state machine; this also records history and notifies the customer
+	var extra bson.M
 	if req.ProviderResponse != "" {
-		update["$set"].(bson.M)["providerResponse"] = req.ProviderResponse
+		extra = bson.M{"providerResponse": req.ProviderResponse}
 	}
-
-	// Update the booking in the database
-	_, err = bookingCollection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	updated, err := bc.transitionBooking(ctx, &booking, req.Status, models.BookingActorProvider, user.ID, req.Reason, extra)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to update booking status: " + err.Error(),
-		})
-	}
-
-	// Fetch the updated booking
-	err = bookingCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&booking)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve updated booking: " + err.Error(),
+		return c.JSON(bookingTransitionErrorStatus(err), models.Response{
+			Status:  bookingTransitionErrorStatus(err),
+			Message: "Cannot update status: " + err.Error(),
 		})
 	}
 
-	notification := websocket.Notification{
-		Type:    "booking_update",
-		Message: "Your booking has been " + req.Status,
-		Data:    booking,
-	}
-
-	// Save the notification to the database
-	err = utils.SaveNotification(bc.db, booking.UserID, "Booking Update", notification.Message, notification.Type, booking)
-	if err != nil {
-		log.Printf("Failed to save notification: %v", err)
-	}
-
-	// Send the notification via WebSocket
-	if err := bc.hub.SendToUser(booking.UserID, notification); err != nil {
-		log.Printf("Failed to send WebSocket notification to user: %v", err)
-		// Optionally, fall back to another notification method (e.g., email or FCM)
-	}
-
 	// Return success response
-	statusMsg := "accepted"
-	if req.Status == "rejected" {
-		statusMsg = "rejected"
-	}
-
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
-		Message: "Booking " + statusMsg + " successfully",
-		Data:    booking,
+		Message: "Booking " + req.Status + " successfully",
+		Data:    updated,
 	})
 }
 
@@ -1519,6 +2103,8 @@ func (bc *BookingController) getBookingStatistics(ctx context.Context, filter bs
 		"confirmed": 0,
 		"completed": 0,
 		"cancelled": 0,
+		"expired":   0,
+		"no_show":   0,
 	}
 
 	// Populate status counts from aggregation results
@@ -1583,6 +2169,8 @@ func (bc *BookingController) getBookingStatistics(ctx context.Context, filter bs
 		"confirmedBookings": statusCounts["confirmed"],
 		"completedBookings": statusCounts["completed"],
 		"cancelledBookings": statusCounts["cancelled"],
+		"expiredBookings":   statusCounts["expired"],
+		"noShowBookings":    statusCounts["no_show"],
 		"emergencyBookings": emergencyBookings,
 		"avgCompletionTime": avgDuration, // in milliseconds
 	}, nil
@@ -1655,6 +2243,10 @@ func (bc *BookingController) DeleteBookingForAdmin(c echo.Context) error {
 		})
 	}
 
+	if err := utils.ReleaseBookingSlot(bc.db, objID); err != nil {
+		log.Printf("Failed to release slot hold for booking %s: %v", objID.Hex(), err)
+	}
+
 	// If booking had media files, log them for cleanup
 	// Note: File deletion from storage would need to be implemented based on your storage solution
 	if len(booking.MediaURLs) > 0 {
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CommissionRuleController lets admins configure subscription commissions and audit them
+type CommissionRuleController struct {
+	db *mongo.Client
+}
+
+// NewCommissionRuleController creates a new commission rule controller
+func NewCommissionRuleController(db *mongo.Client) *CommissionRuleController {
+	return &CommissionRuleController{db: db}
+}
+
+// bindCommissionRule reads a rule from the request body and returns why it is invalid, if it is
+func bindCommissionRule(c echo.Context) (models.CommissionRule, string) {
+	var rule models.CommissionRule
+	if err := c.Bind(&rule); err != nil {
+		return rule, "Invalid request body"
+	}
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.SalespersonTier = strings.TrimSpace(rule.SalespersonTier)
+	if err := utils.ValidateCommissionRule(rule); err != nil {
+		return rule, err.Error()
+	}
+	return rule, ""
+}
+
+// GetCommissionRules lists the commission rules, highest priority first
+func (cc *CommissionRuleController) GetCommissionRules(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	for _, field := range []string{"planType", "entityType", "salespersonTier", "saleKind"} {
+		if value := c.QueryParam(field); value != "" {
+			filter[field] = value
+		}
+	}
+	if active := c.QueryParam("active"); active != "" {
+		filter["isActive"] = active == "true"
+	}
+	cursor, err := cc.db.Database("barrim").Collection("commission_rules").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve commission rules",
+		})
+	}
+	rules := []models.CommissionRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode commission rules",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Commission rules retrieved successfully",
+		Data: map[string]interface{}{
+			"count": len(rules),
+			"rules": rules,
+		},
+	})
+}
+
+// CreateCommissionRule adds a commission rule
+func (cc *CommissionRuleController) CreateCommissionRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rule, msg := bindCommissionRule(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	now := time.Now()
+	rule.ID = primitive.NewObjectID()
+	rule.IsActive = true
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		rule.CreatedBy = adminID
+	}
+
+	if _, err := cc.db.Database("barrim").Collection("commission_rules").InsertOne(ctx, rule); err != nil {
+		log.Printf("Error creating commission rule: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to create commission rule",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: "Commission rule created successfully",
+		Data:    rule,
+	})
+}
+
+// UpdateCommissionRule replaces the settings of a commission rule. Commissions
+// already recorded keep the rates of the rule at the time.
+func (cc *CommissionRuleController) UpdateCommissionRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ruleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid rule ID",
+		})
+	}
+	rule, msg := bindCommissionRule(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	var updated models.CommissionRule
+	err = cc.db.Database("barrim").Collection("commission_rules").FindOneAndUpdate(ctx,
+		bson.M{"_id": ruleID},
+		bson.M{"$set": bson.M{
+			"name":                   rule.Name,
+			"planType":               rule.PlanType,
+			"entityType":             rule.EntityType,
+			"salespersonTier":        rule.SalespersonTier,
+			"saleKind":               rule.SaleKind,
+			"salespersonPercent":     rule.SalespersonPercent,
+			"managerOverridePercent": rule.ManagerOverridePercent,
+			"priority":               rule.Priority,
+			"startsAt":               rule.StartsAt,
+			"endsAt":                 rule.EndsAt,
+			"isActive":               rule.IsActive,
+			"updatedAt":              time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Commission rule not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update commission rule",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Commission rule updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeactivateCommissionRule stops a commission rule from applying. It is kept
+// for the commissions that refer to it.
+func (cc *CommissionRuleController) DeactivateCommissionRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ruleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid rule ID",
+		})
+	}
+	result, err := cc.db.Database("barrim").Collection("commission_rules").UpdateOne(ctx,
+		bson.M{"_id": ruleID},
+		bson.M{"$set": bson.M{"isActive": false, "updatedAt": time.Now()}})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to deactivate commission rule",
+		})
+	}
+	if result.MatchedCount == 0 {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Commission rule not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Commission rule deactivated successfully",
+	})
+}
+
+// GetCommissionRecords lists the commission records, newest first, filtered
+// by role, status, sale kind, entity type, rule, salesperson or sales manager
+func (cc *CommissionRuleController) GetCommissionRecords(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := bson.M{}
+	for _, field := range []string{"role", "status", "saleKind", "entityType"} {
+		if value := c.QueryParam(field); value != "" {
+			filter[field] = value
+		}
+	}
+	for _, field := range []string{"ruleId", "salespersonId", "salesManagerId", "subscriptionId"} {
+		if value := c.QueryParam(field); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.Response{
+					Status:  http.StatusBadRequest,
+					Message: "Invalid " + field,
+				})
+			}
+			filter[field] = id
+		}
+	}
+
+	records := cc.db.Database("barrim").Collection("commission_records")
+	total, err := records.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve commission records",
+		})
+	}
+	cursor, err := records.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve commission records",
+		})
+	}
+	result := []models.CommissionRecord{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode commission records",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Commission records retrieved successfully",
+		Data: map[string]interface{}{
+			"records": result,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
@@ -136,7 +136,7 @@ func (cc *CompanyController) GetAllCompanyData(c echo.Context) error {
 
 		// Get branch comments
 		commentsCollection := config.GetCollection(cc.DB, "branch_comments")
-		cursor, err = commentsCollection.Find(ctx, bson.M{"branchId": bson.M{"$in": branchIDs}})
+		cursor, err = commentsCollection.Find(ctx, bson.M{"branchId": bson.M{"$in": branchIDs}, "moderationStatus": utils.PublishedModeration()})
 		if err != nil && err != mongo.ErrNoDocuments {
 			return c.JSON(http.StatusInternalServerError, models.Response{
 				Status:  http.StatusInternalServerError,
@@ -1411,6 +1411,15 @@ func (cc *CompanyController) UpdateCompanyData(c echo.Context) error {
 		})
 	}
 
+	// Keep the GeoJSON point in step with coordinates set field by field
+	_, latSet := updateFields["contactInfo.address.lat"]
+	_, lngSet := updateFields["contactInfo.address.lng"]
+	if latSet || lngSet {
+		if err := utils.SyncGeoPoint(ctx, companyCollection, bson.M{"_id": company.ID}, "contactInfo.address"); err != nil {
+			log.Printf("Failed to sync company geo point: %v", err)
+		}
+	}
+
 	// Get updated company data to return
 	var updatedCompany models.Company
 	err = companyCollection.FindOne(ctx, bson.M{"_id": company.ID}).Decode(&updatedCompany)
@@ -1439,12 +1448,17 @@ func (cc *CompanyController) UpdateCompanyData(c echo.Context) error {
 	})
 }
 
-// GetAllBranches retrieves all branches from all companies with branch status
+// GetAllBranches retrieves all branches from all companies with branch status.
+// Optional category and governorate query params narrow the listing; sponsored
+// branches are pinned or boosted within it and labelled.
 func (cc *CompanyController) GetAllBranches(c echo.Context) error {
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	category := c.QueryParam("category")
+	governorate := c.QueryParam("governorate")
+
 	// Get company collection
 	companyCollection := config.GetCollection(cc.DB, "companies")
 
@@ -1493,9 +1507,13 @@ func (cc *CompanyController) GetAllBranches(c echo.Context) error {
 
 	// Prepare response data - flatten all branches with company reference, status, and email
 	var allBranches []map[string]interface{}
+	var candidates []utils.SponsoredCandidate
 	for _, company := range companies {
 		user := userMap[company.UserID.Hex()]
 		for _, branch := range company.Branches {
+			if !matchesListingFilter(category, branch.Category) || !matchesListingFilter(governorate, branch.Location.Governorate) {
+				continue
+			}
 			branchData := map[string]interface{}{
 				"id":          branch.ID.Hex(),
 				"name":        branch.Name,
@@ -1524,9 +1542,17 @@ func (cc *CompanyController) GetAllBranches(c echo.Context) error {
 				},
 			}
 			allBranches = append(allBranches, branchData)
+			candidates = append(candidates, utils.SponsoredCandidate{
+				EntityType: utils.SponsoredEntityCompanyBranch,
+				EntityID:   branch.ID,
+				Sponsored:  branch.Sponsorship,
+			})
 		}
 	}
 
+	listingKey := sponsoredListingKey("company_branches", category, governorate)
+	allBranches = rankSponsoredEntries(cc.DB, listingKey, allBranches, candidates)
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "All branches retrieved successfully",
@@ -1620,6 +1646,16 @@ func (cc *CompanyController) CreateBranchComment(c echo.Context) error {
 		UpdatedAt:  time.Now(),
 	}
 
+	// Hold the comment for moderation if the automatic filter flags it
+	check, err := utils.CheckContent(ctx, cc.DB, models.ModerationContentBranchComment, userID, comment.Comment)
+	if err != nil {
+		log.Printf("Failed to check comment content: %v", err)
+	}
+	if check.Flagged {
+		comment.ModerationStatus = models.ModerationPending
+		comment.FlagReasons = check.Reasons
+	}
+
 	// Insert comment into database
 	commentsCollection := config.GetCollection(cc.DB, "branch_comments")
 	_, err = commentsCollection.InsertOne(ctx, comment)
@@ -1630,6 +1666,19 @@ func (cc *CompanyController) CreateBranchComment(c echo.Context) error {
 		})
 	}
 
+	if check.Flagged {
+		return c.JSON(http.StatusAccepted, models.Response{
+			Status:  http.StatusAccepted,
+			Message: "Comment submitted and awaiting moderation",
+			Data:    comment,
+		})
+	}
+
+	// Count the comment's rating in the branch rating
+	if comment.Rating > 0 {
+		go utils.RefreshContentRating(cc.DB, models.ModerationContentBranchComment, comment.ID, utils.RatingTarget{}, nil)
+	}
+
 	return c.JSON(http.StatusCreated, models.Response{
 		Status:  http.StatusCreated,
 		Message: "Comment posted successfully",
@@ -1674,7 +1723,7 @@ func (cc *CompanyController) GetBranchComments(c echo.Context) error {
 
 	// Find comments for this branch
 	commentsCollection := config.GetCollection(cc.DB, "branch_comments")
-	filter := bson.M{"branchId": branchObjectID}
+	filter := bson.M{"branchId": branchObjectID, "moderationStatus": utils.PublishedModeration()}
 	opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetSkip(int64(skip)).SetLimit(int64(limit))
 
 	cursor, err := commentsCollection.Find(ctx, filter, opts)
@@ -2125,53 +2174,6 @@ func (cc *CompanyController) uploadCompanyLogo(c echo.Context, file *multipart.F
 	return filepath, nil
 }
 
-// GetBranchRating retrieves the average rating for a branch
-// CalculateBranchRating calculates the average rating for a branch
-func (cc *CompanyController) CalculateBranchRating(branchID primitive.ObjectID) (float64, int, error) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Find all comments for this branch that have ratings
-	commentsCollection := config.GetCollection(cc.DB, "branch_comments")
-	filter := bson.M{
-		"branchId": branchID,
-		"rating": bson.M{
-			"$exists": true,
-			"$ne":     nil,
-		},
-	}
-
-	// Find all comments with ratings
-	cursor, err := commentsCollection.Find(ctx, filter)
-	if err != nil {
-		return 0, 0, err
-	}
-	defer cursor.Close(ctx)
-
-	var comments []models.BranchComment
-	if err = cursor.All(ctx, &comments); err != nil {
-		return 0, 0, err
-	}
-
-	// If no ratings found, return 0
-	if len(comments) == 0 {
-		return 0, 0, nil
-	}
-
-	// Calculate the sum of all ratings
-	var totalRating int
-	for _, comment := range comments {
-		totalRating += comment.Rating
-	}
-
-	// Calculate the average rating
-	averageRating := float64(totalRating) / float64(len(comments))
-
-	// Return the average rating and the count of ratings
-	return averageRating, len(comments), nil
-}
-
 // GetBranchRating retrieves the average rating for a branch
 func (cc *CompanyController) GetBranchRating(c echo.Context) error {
 	// Get branch ID from URL parameter
@@ -2192,18 +2194,19 @@ func (cc *CompanyController) GetBranchRating(c echo.Context) error {
 		})
 	}
 
-	// Calculate the average rating
-	averageRating, ratingCount, err := cc.CalculateBranchRating(branchObjectID)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Read the maintained rating of the branch
+	rating, err := utils.GetRatingAggregate(ctx, cc.DB, utils.SponsoredEntityCompanyBranch, branchObjectID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
-			Message: "Failed to calculate branch rating",
+			Message: "Failed to get branch rating",
 		})
 	}
 
 	// Fetch the branch to include in response
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
 	branchesCollection := config.GetCollection(cc.DB, "companies")
 
@@ -2255,10 +2258,12 @@ func (cc *CompanyController) GetBranchRating(c echo.Context) error {
 		Status:  http.StatusOK,
 		Message: "Branch rating retrieved successfully",
 		Data: map[string]interface{}{
-			"branchId":      branchObjectID,
-			"averageRating": averageRating,
-			"ratingCount":   ratingCount,
-			"branch":        results[0]["branch"],
+			"branchId":        branchObjectID,
+			"averageRating":   rating.Average,
+			"ratingCount":     rating.Count,
+			"bayesianAverage": rating.BayesianAverage,
+			"histogram":       rating.Histogram,
+			"branch":          results[0]["branch"],
 		},
 	})
 }
@@ -2419,6 +2424,8 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 	var req struct {
 		SponsorshipID primitive.ObjectID `json:"sponsorshipId" validate:"required"`
 		AdminNote     string             `json:"adminNote,omitempty"`
+		PromoCode     string             `json:"promoCode,omitempty"`
+		models.SponsorshipSlotOptions
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, models.Response{
@@ -2518,7 +2525,7 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 		"sponsorshipId": req.SponsorshipID,
 		"entityId":      branchObjectID,
 		"entityType":    "company_branch",
-		"status":        bson.M{"$in": []string{"active", "pending"}},
+		"status":        bson.M{"$in": []string{"active", "pending", models.SponsorshipSubscriptionScheduled}},
 	}).Decode(&existingSubscription)
 	if err == nil {
 		return c.JSON(http.StatusConflict, models.Response{
@@ -2560,6 +2567,57 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 		PaymentStatus: "pending",
 	}
 
+	// Hold a sponsor place in the branch's category and governorate while the payment is made
+	sponsorshipSubscriptionController := NewSponsorshipSubscriptionController(cc.DB.Database("barrim"))
+	slot, refusal, err := sponsorshipSubscriptionController.reserveRequestSlot(ctx, sponsorshipSlotRequest{
+		Sponsorship: sponsorship,
+		EntityType:  subscriptionRequest.EntityType,
+		EntityID:    branchObjectID,
+		EntityName:  subscriptionRequest.EntityName,
+		RequestID:   subscriptionRequest.ID,
+		RequestedBy: userID,
+		Options:     req.SponsorshipSlotOptions,
+		HoldFor:     utils.SponsorshipPaymentHold(),
+	})
+	if err != nil {
+		log.Printf("Failed to reserve sponsorship place: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to reserve sponsorship place",
+		})
+	}
+	if refusal != nil {
+		return c.JSON(refusal.Status, models.Response{
+			Status:  refusal.Status,
+			Message: refusal.Message,
+			Data:    refusal.Data,
+		})
+	}
+	subscriptionRequest.SlotID = slot.ID
+	subscriptionRequest.StartDate = slot.StartDate
+
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := sponsorship.Price
+	var promo *models.PromoCodeRedemption
+	if req.PromoCode != "" {
+		promo, err = utils.ApplyPromoCode(ctx, sponsorshipSubscriptionController.DB.Client(), utils.PromoCheckout{
+			Code:       req.PromoCode,
+			Checkout:   models.PromoCheckoutSponsorship,
+			PlanType:   models.PromoPlanSponsorship,
+			EntityType: subscriptionRequest.EntityType,
+			EntityID:   branchObjectID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     sponsorship.Price,
+		})
+		if err != nil {
+			sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callback URLs (backend API)
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -2589,7 +2647,7 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &sponsorship.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for sponsorship payments
 		Invoice:            fmt.Sprintf("Company Branch Sponsorship - %s - %s - Sponsorship: %s", company.BusinessName, branch.Name, sponsorship.Title),
 		ExternalID:         &externalID,
@@ -2603,6 +2661,7 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -2616,6 +2675,7 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 	_, err = existingRequestCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save sponsorship subscription request: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create sponsorship subscription request",
@@ -2639,15 +2699,18 @@ func (cc *CompanyController) CreateCompanyBranchSponsorshipRequest(c echo.Contex
 		Status:  http.StatusCreated,
 		Message: "Sponsorship subscription request created successfully. Please complete the payment.",
 		Data: map[string]interface{}{
-			"requestId":   subscriptionRequest.ID,
-			"sponsorship": sponsorship,
-			"branch":      branch,
-			"company":     company.BusinessName,
-			"status":      subscriptionRequest.Status,
-			"submittedAt": subscriptionRequest.RequestedAt,
-			"adminNote":   subscriptionRequest.AdminNote,
-			"paymentUrl":  collectURL,
-			"price":       sponsorship.Price,
+			"requestId":     subscriptionRequest.ID,
+			"sponsorship":   sponsorship,
+			"branch":        branch,
+			"company":       company.BusinessName,
+			"status":        subscriptionRequest.Status,
+			"submittedAt":   subscriptionRequest.RequestedAt,
+			"adminNote":     subscriptionRequest.AdminNote,
+			"paymentUrl":    collectURL,
+			"price":         sponsorship.Price,
+			"startDate":     subscriptionRequest.StartDate,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 		},
 	})
 }
@@ -2716,6 +2779,7 @@ func (cc *CompanyController) HandleWhishSponsorshipPaymentSuccess(c echo.Context
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		NewSponsorshipSubscriptionController(db).releaseSponsorshipRequest(ctx, subscriptionRequest)
 		log.Printf("==========================================")
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
@@ -2818,6 +2882,7 @@ func (cc *CompanyController) HandleWhishSponsorshipPaymentFailure(c echo.Context
 	if err == nil {
 		log.Printf("   Request ID: %s", subscriptionRequest.ID.Hex())
 		log.Printf("   Entity: %s (%s)", subscriptionRequest.EntityName, subscriptionRequest.EntityType)
+		NewSponsorshipSubscriptionController(db).releaseSponsorshipRequest(ctx, subscriptionRequest)
 	}
 
 	_, err = requestCollection.UpdateOne(ctx,
@@ -3025,6 +3090,7 @@ func (cc *CompanyController) GetCompanyBranchSponsorshipRemainingTime(c echo.Con
 						"status":        "failed",
 						"processedAt":   time.Now(),
 					}})
+				NewSponsorshipSubscriptionController(cc.DB.Database("barrim")).releaseSponsorshipRequest(ctx, sponsorshipRequest)
 			}
 		}
 	}
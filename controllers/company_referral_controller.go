@@ -2,18 +2,13 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
-	"image/png"
 	"net/http"
-	"time"
 
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/qr"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -139,16 +134,25 @@ func (rc *CompanyReferralController) handleCompanyReferral(c echo.Context, ctx c
 		}
 	}
 
-	// Update the referrer company - add points and add to referrals list
-	const pointsToAdd = 5
-	update := bson.M{
-		"$inc":  bson.M{"points": pointsToAdd},
-		"$push": bson.M{"referrals": currentCompany.ID},
-		"$set":  bson.M{"updatedAt": time.Now()},
-	}
-
-	_, err = companyCollection.UpdateByID(ctx, referrerCompany.ID, update)
+	// Reward the referrer company by the referral rules and add to referrals list
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.DB, utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  models.PointsAccountCompany,
+		ReferrerID:    referrerCompany.ID,
+		RefereeType:   models.PointsAccountCompany,
+		RefereeID:     currentCompany.ID,
+		RefereeUserID: &currentCompany.UserID,
+		Fingerprint:   referralFingerprint(c, ""),
+		Code:          referralCode,
+		Channel:       models.ReferralChannelCode,
+	})
 	if err != nil {
+		if err == utils.ErrReferralEventRecorded {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "This referral code has already been used",
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to update referrer company",
@@ -160,7 +164,7 @@ func (rc *CompanyReferralController) handleCompanyReferral(c echo.Context, ctx c
 		ReferrerID:      referrerCompany.ID,
 		Referrer:        referrerCompany,
 		NewCompany:      currentCompany,
-		PointsAdded:     pointsToAdd,
+		PointsAdded:     outcome.ReferrerPoints(),
 		NewReferralCode: currentCompany.ReferralCode,
 	}
 
@@ -220,16 +224,24 @@ func (rc *CompanyReferralController) handleUserReferral(c echo.Context, ctx cont
 		}
 	}
 
-	// Update the referrer - add points and add to referrals list
-	const pointsToAdd = 5
-	update := bson.M{
-		"$inc":  bson.M{"points": pointsToAdd},
-		"$push": bson.M{"referrals": currentUser.ID},
-		"$set":  bson.M{"updatedAt": time.Now()},
-	}
-
-	_, err = userCollection.UpdateByID(ctx, referrer.ID, update)
+	// Reward the referrer by the referral rules and add to referrals list
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.DB, utils.ReferralEvent{
+		Event:        models.ReferralEventSignup,
+		ReferrerType: models.PointsAccountUser,
+		ReferrerID:   referrer.ID,
+		RefereeType:  models.PointsAccountUser,
+		RefereeID:    currentUser.ID,
+		Fingerprint:  referralFingerprint(c, ""),
+		Code:         referralCode,
+		Channel:      models.ReferralChannelCode,
+	})
 	if err != nil {
+		if err == utils.ErrReferralEventRecorded {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "This referral code has already been used",
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to update referrer",
@@ -241,7 +253,7 @@ func (rc *CompanyReferralController) handleUserReferral(c echo.Context, ctx cont
 		ReferrerID:      referrer.ID,
 		Referrer:        referrer,
 		NewUser:         currentUser,
-		PointsAdded:     pointsToAdd,
+		PointsAdded:     outcome.ReferrerPoints(),
 		NewReferralCode: currentUser.ReferralCode,
 	}
 
@@ -319,7 +331,7 @@ func (rc *CompanyReferralController) getCompanyReferralData(c echo.Context, ctx
 		ReferralCode:  company.ReferralCode,
 		ReferralCount: len(company.Referrals),
 		Points:        company.Points,
-		ReferralLink:  fmt.Sprintf("https://barrim.com/referral?code=%s", company.ReferralCode),
+		ReferralLink:  utils.ReferralLinkURL(company.ReferralCode, models.ReferralSourceLink),
 	}
 
 	responseData := map[string]interface{}{
@@ -348,7 +360,7 @@ func (rc *CompanyReferralController) getUserReferralData(c echo.Context, ctx con
 		"referralCode":  user.ReferralCode,
 		"referralCount": len(user.Referrals),
 		"points":        user.Points,
-		"referralLink":  fmt.Sprintf("https://barrim.com/referral?code=%s", user.ReferralCode),
+		"referralLink":  utils.ReferralLinkURL(user.ReferralCode, models.ReferralSourceLink),
 		"qrCode":        qrCode,
 	}
 
@@ -359,33 +371,9 @@ func (rc *CompanyReferralController) getUserReferralData(c echo.Context, ctx con
 	})
 }
 
-// GenerateReferralQRCode creates a QR code image for a referral code
+// GenerateReferralQRCode creates a QR code image of the tracked link of a referral code
 func (rc *CompanyReferralController) GenerateReferralQRCode(referralCode string) (string, error) {
-	// Create the QR code content - usually a URL or the code itself
-	content := fmt.Sprintf("https://barrim.com/referral?code=%s", referralCode)
-
-	// Generate the QR code
-	qrCode, err := qr.Encode(content, qr.M, qr.Auto)
-	if err != nil {
-		return "", err
-	}
-
-	// Scale the QR code to a reasonable size (300x300 pixels)
-	qrCode, err = barcode.Scale(qrCode, 300, 300)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert the QR code to a PNG image
-	var buf bytes.Buffer
-	err = png.Encode(&buf, qrCode)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert to base64 for embedding in responses
-	base64QR := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return "data:image/png;base64," + base64QR, nil
+	return utils.ReferralQRCode(referralCode)
 }
 
 // GetReferralQRCode endpoint to get QR code for a referral code
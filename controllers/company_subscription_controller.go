@@ -17,6 +17,7 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/services"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -239,6 +240,27 @@ func (sc *BranchSubscriptionController) CreateBranchSubscriptionRequest(c echo.C
 	subscriptionRequest.Status = "pending_payment"
 	subscriptionRequest.PaymentStatus = "pending"
 
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := plan.Price
+	var promo *models.PromoCodeRedemption
+	if code := c.FormValue("promoCode"); code != "" {
+		promo, err = utils.ApplyPromoCode(ctx, sc.DB.Client(), utils.PromoCheckout{
+			Code:       code,
+			Checkout:   models.PromoCheckoutBranchSubscription,
+			PlanType:   plan.Type,
+			EntityType: utils.SponsoredEntityCompanyBranch,
+			EntityID:   branch.ID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     plan.Price,
+		})
+		if err != nil {
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callbacks
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -270,7 +292,7 @@ func (sc *BranchSubscriptionController) CreateBranchSubscriptionRequest(c echo.C
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &plan.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for subscription payments
 		Invoice:            fmt.Sprintf("Branch Subscription - %s - Plan: %s", branch.Name, plan.Title),
 		ExternalID:         &externalID,
@@ -284,6 +306,7 @@ func (sc *BranchSubscriptionController) CreateBranchSubscriptionRequest(c echo.C
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -297,6 +320,7 @@ func (sc *BranchSubscriptionController) CreateBranchSubscriptionRequest(c echo.C
 	_, err = subscriptionRequestsCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save subscription request: %v", err)
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create subscription request",
@@ -313,7 +337,8 @@ func (sc *BranchSubscriptionController) CreateBranchSubscriptionRequest(c echo.C
 			"plan":          plan,
 			"status":        subscriptionRequest.Status,
 			"submittedAt":   subscriptionRequest.RequestedAt,
-			"paymentAmount": plan.Price,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 			"collectUrl":    collectURL,
 			"externalId":    externalID,
 		},
@@ -386,6 +411,7 @@ func (sc *BranchSubscriptionController) HandleWhishPaymentSuccess(c echo.Context
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, subscriptionRequest.ID)
 		log.Printf("==========================================")
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
@@ -437,12 +463,16 @@ func (sc *BranchSubscriptionController) HandleWhishPaymentFailure(c echo.Context
 		return c.String(http.StatusInternalServerError, "Failed to update status")
 	}
 
+	utils.ReleasePromoCodeByExternalID(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, externalID)
 	log.Printf("Payment failed for externalId: %d", externalID)
 	return c.String(http.StatusOK, "Payment failure recorded")
 }
 
 // activateBranchSubscription activates the subscription after successful payment
 func (sc *BranchSubscriptionController) activateBranchSubscription(ctx context.Context, subscriptionRequest models.BranchSubscriptionRequest, payerPhone string) error {
+	// The payment went through, so its promo code is used
+	utils.RedeemPromoCode(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, subscriptionRequest.ID)
+
 	// Get plan details
 	var plan models.SubscriptionPlan
 	err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": subscriptionRequest.PlanID}).Decode(&plan)
@@ -547,51 +577,30 @@ func (sc *BranchSubscriptionController) activateBranchSubscription(ctx context.C
 		log.Printf("Failed to update user status to active: %v", err)
 	}
 
-	// Handle commission and admin wallet (30% salesperson, 70% admin)
-	planPrice := plan.Price
-	if company.CreatedBy != company.UserID && !company.CreatedBy.IsZero() {
-		// Company was created by a salesperson - split commission
-		var salesperson models.Salesperson
-		err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": company.CreatedBy}).Decode(&salesperson)
-		if err == nil {
-			// Calculate commissions: 30% salesperson, 70% admin
-			salespersonPercent := 30.0
-			adminPercent := 70.0
-
-			salespersonCommission := planPrice * salespersonPercent / 100.0
-			adminCommission := planPrice * adminPercent / 100.0
-
-			// Add salesperson commission (using SubscriptionController method through type assertion/conversion)
-			// We need to access the method - let's create a helper or use direct implementation
-			err = sc.addCommissionToSalespersonWalletDirect(ctx, salesperson.ID, salespersonCommission, newSubscription.ID, "branch_subscription", company.BusinessName)
-			if err != nil {
-				log.Printf("Failed to add salesperson commission: %v", err)
-			} else {
-				log.Printf("Added salesperson commission: $%.2f (30%% of $%.2f)", salespersonCommission, planPrice)
-			}
-
-			// Add admin commission
-			err = sc.addSubscriptionIncomeToAdminWalletDirect(ctx, adminCommission, newSubscription.ID, "branch_subscription_commission", company.BusinessName, branch.Name)
-			if err != nil {
-				log.Printf("Failed to add admin commission: %v", err)
-			} else {
-				log.Printf("Added admin commission: $%.2f (70%% of $%.2f)", adminCommission, planPrice)
-			}
-		} else {
-			log.Printf("Salesperson not found, adding full amount to admin wallet")
-			// Salesperson not found, add full amount to admin
-			err = sc.addSubscriptionIncomeToAdminWalletDirect(ctx, planPrice, newSubscription.ID, "branch_subscription", company.BusinessName, branch.Name)
-			if err != nil {
-				log.Printf("Failed to add subscription income to admin wallet: %v", err)
-			}
-		}
-	} else {
-		// Company created by itself - full amount to admin
-		err = sc.addSubscriptionIncomeToAdminWalletDirect(ctx, planPrice, newSubscription.ID, "branch_subscription", company.BusinessName, branch.Name)
+	// Pay the salesperson who created the company, if any, and the admin the rest
+	adminAmount, adminEntityType := plan.Price, "branch_subscription"
+	if company.CreatedBy != company.UserID {
+		commission, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+			SubscriptionID: newSubscription.ID,
+			EntityType:     "company",
+			EntityID:       company.ID,
+			EntityName:     company.BusinessName,
+			BranchID:       &branch.ID,
+			CreatedBy:      company.CreatedBy,
+			Plan:           plan,
+			AmountPaid:     utils.PromoAmountPaid(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, subscriptionRequest.ID),
+		})
 		if err != nil {
-			log.Printf("Failed to add subscription income to admin wallet: %v", err)
+			log.Printf("Failed to apply commission: %v", err)
+		}
+		if commission != nil {
+			adminAmount, adminEntityType = utils.AdminWalletShare(commission), "branch_subscription_commission"
 		}
 	}
+	err = sc.addSubscriptionIncomeToAdminWalletDirect(ctx, adminAmount, newSubscription.ID, adminEntityType, company.BusinessName, branch.Name)
+	if err != nil {
+		log.Printf("Failed to add subscription income to admin wallet: %v", err)
+	}
 
 	// Update subscription request status
 	subscriptionRequestsCollection := sc.DB.Collection("branch_subscription_requests")
@@ -608,7 +617,15 @@ func (sc *BranchSubscriptionController) activateBranchSubscription(ctx context.C
 		log.Printf("Failed to update subscription request status: %v", err)
 	}
 
-	log.Printf("Branch subscription activated successfully: Branch=%s, Plan=%s, Amount=$%.2f", branch.Name, plan.Title, planPrice)
+	log.Printf("Branch subscription activated successfully: Branch=%s, Plan=%s, Amount=$%.2f", branch.Name, plan.Title, plan.Price)
+	if plan.Price > 0 {
+		go utils.TriggerReferralEvent(sc.DB.Client(), utils.ReferralEvent{
+			Event:         models.ReferralEventFirstPaidSubscription,
+			RefereeType:   models.PointsAccountCompany,
+			RefereeID:     company.ID,
+			RefereeUserID: &company.UserID,
+		})
+	}
 	return nil
 }
 
@@ -668,50 +685,6 @@ func (sc *BranchSubscriptionController) addSubscriptionIncomeToAdminWalletDirect
 	return nil
 }
 
-func (sc *BranchSubscriptionController) addCommissionToSalespersonWalletDirect(ctx context.Context, salespersonID primitive.ObjectID, amount float64, subscriptionID primitive.ObjectID, entityType, companyName string) error {
-	// Note: CommissionRecord model might need to be checked - using similar structure
-	type CommissionRecord struct {
-		ID             primitive.ObjectID `bson:"_id,omitempty"`
-		SubscriptionID primitive.ObjectID `bson:"subscriptionId"`
-		SalespersonID  primitive.ObjectID `bson:"salespersonId"`
-		Amount         float64            `bson:"amount"`
-		Role           string             `bson:"role"`
-		Status         string             `bson:"status"`
-		CreatedAt      time.Time          `bson:"createdAt"`
-	}
-
-	commissionRecord := CommissionRecord{
-		ID:             primitive.NewObjectID(),
-		SubscriptionID: subscriptionID,
-		SalespersonID:  salespersonID,
-		Amount:         amount,
-		Role:           "salesperson",
-		Status:         "pending",
-		CreatedAt:      time.Now(),
-	}
-
-	_, err := sc.DB.Collection("commission_records").InsertOne(ctx, commissionRecord)
-	if err != nil {
-		return fmt.Errorf("failed to insert salesperson commission record: %w", err)
-	}
-
-	_, err = sc.DB.Collection("salespersons").UpdateOne(
-		ctx,
-		bson.M{"_id": salespersonID},
-		bson.M{
-			"$inc": bson.M{"commissionBalance": amount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update salesperson commission balance: %w", err)
-	}
-
-	log.Printf("Commission $%.2f added to salesperson wallet (ID: %s) from %s - %s",
-		amount, salespersonID.Hex(), entityType, companyName)
-	return nil
-}
-
 func (sc *SubscriptionController) CancelCompanySubscription(c echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -948,61 +921,26 @@ func (sc *SubscriptionController) ApproveCompanySubscriptionRequest(c echo.Conte
 
 		// --- Commission logic start ---
 		// Only proceed if company was created by a salesperson
-		if !request.CompanyID.IsZero() {
-			// Get company details first
-			var company models.Company
-			err := sc.DB.Collection("companies").FindOne(ctx, bson.M{"_id": request.CompanyID}).Decode(&company)
-			if err == nil && !company.CreatedBy.IsZero() {
-				// Get salesperson
-				var salesperson models.Salesperson
-				err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": company.CreatedBy}).Decode(&salesperson)
-				if err == nil {
-					// Get admin who created the salesperson
-					var admin models.Admin
-					err := sc.DB.Collection("admins").FindOne(ctx, bson.M{"_id": salesperson.CreatedBy}).Decode(&admin)
-					if err == nil {
-						// Get plan details
-						var plan models.SubscriptionPlan
-						err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-						if err == nil {
-							planPrice := plan.Price
-							salespersonPercent := salesperson.CommissionPercent
-
-							// Calculate admin commission (remaining percentage after salesperson commission)
-							adminPercent := 100.0 - salespersonPercent
-
-							// Calculate commissions correctly
-							// Salesperson gets their percentage directly from the plan price
-							salespersonCommission := planPrice * salespersonPercent / 100.0
-							// Admin gets the remaining percentage
-							adminCommission := planPrice * adminPercent / 100.0
-
-							// Insert commission document using Commission model
-							commission := models.Commission{
-								ID:                           primitive.NewObjectID(),
-								SubscriptionID:               request.ID,
-								CompanyID:                    request.CompanyID,
-								PlanID:                       plan.ID,
-								PlanPrice:                    planPrice,
-								AdminID:                      admin.ID,
-								AdminCommission:              adminCommission,
-								AdminCommissionPercent:       adminPercent,
-								SalespersonID:                salesperson.ID,
-								SalespersonCommission:        salespersonCommission,
-								SalespersonCommissionPercent: salespersonPercent,
-								CreatedAt:                    time.Now(),
-								Paid:                         false,
-								PaidAt:                       nil,
-							}
-							_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-							if err != nil {
-								log.Printf("Failed to insert commission: %v", err)
-							} else {
-								log.Printf("Commission inserted successfully - Plan Price: $%.2f, Admin Commission: $%.2f (%.1f%%), Salesperson Commission: $%.2f (%.1f%%)",
-									planPrice, adminCommission, adminPercent, salespersonCommission, salespersonPercent)
-							}
-						}
-					}
+		var company models.Company
+		err := companyCollection.FindOne(ctx, bson.M{"_id": request.CompanyID}).Decode(&company)
+		if err == nil && company.CreatedBy != company.UserID {
+			var plan models.SubscriptionPlan
+			err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
+			if err == nil {
+				sale := utils.CommissionSale{
+					SubscriptionID: request.ID,
+					EntityType:     "company",
+					EntityID:       company.ID,
+					EntityName:     company.BusinessName,
+					CreatedBy:      company.CreatedBy,
+					Plan:           plan,
+				}
+				if !branchSubReq.BranchID.IsZero() {
+					sale.BranchID = &branchSubReq.BranchID
+					sale.AmountPaid = utils.PromoAmountPaid(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, branchSubReq.ID)
+				}
+				if _, err := utils.ApplyCommission(ctx, sc.DB.Client(), sale); err != nil {
+					log.Printf("Failed to apply commission: %v", err)
 				}
 			}
 		}
@@ -2658,168 +2596,30 @@ func (sc *SubscriptionController) ProcessBranchSubscriptionRequest(c echo.Contex
 		subscription = &newSubscription
 
 		// --- Commission logic start ---
-		// Check if company was created by a salesperson or by itself (user signup)
-		log.Printf("DEBUG: Company CreatedBy field: %v (IsZero: %v)", company.CreatedBy, company.CreatedBy.IsZero())
-
-		// Check if company was created by itself (user signup) - CreatedBy equals UserID
-		if company.CreatedBy == company.UserID {
-			log.Printf("DEBUG: Company was created by user signup, adding subscription price to admin wallet")
-			// Add subscription price directly to admin wallet (no commission calculation needed)
-			err := sc.addSubscriptionIncomeToAdminWallet(ctx, plan.Price, newSubscription.ID, "branch_subscription", company.BusinessName, branch.Name)
+		// Pay the salesperson who created the company, if any, and the admin the rest
+		adminAmount, adminEntityType := plan.Price, "branch_subscription"
+		if company.CreatedBy != company.UserID {
+			commission, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: newSubscription.ID,
+				EntityType:     "company",
+				EntityID:       company.ID,
+				EntityName:     company.BusinessName,
+				BranchID:       &branch.ID,
+				CreatedBy:      company.CreatedBy,
+				Plan:           plan,
+				AmountPaid:     utils.PromoAmountPaid(ctx, sc.DB.Client(), models.PromoCheckoutBranchSubscription, branchSubscriptionRequest.ID),
+			})
 			if err != nil {
-				log.Printf("Failed to add subscription income to admin wallet: %v", err)
-			} else {
-				log.Printf("Subscription income added to admin wallet: $%.2f from company '%s' (ID: %s) - User signup subscription",
-					plan.Price, company.BusinessName, company.ID.Hex())
+				log.Printf("Failed to apply commission: %v", err)
 			}
-		} else if !company.CreatedBy.IsZero() {
-			log.Printf("DEBUG: Company was created by salesperson, proceeding with commission calculation")
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": company.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				log.Printf("DEBUG: Found salesperson: %s (ID: %v)", salesperson.FullName, salesperson.ID)
-				planPrice := plan.Price
-				salespersonPercent := salesperson.CommissionPercent
-
-				// Check if salesperson was created by admin (admin-created salesperson)
-				var admin models.Admin
-				err := sc.DB.Collection("admins").FindOne(ctx, bson.M{"_id": salesperson.CreatedBy}).Decode(&admin)
-				if err == nil {
-					// Admin-created salesperson: Admin gets remaining percentage after salesperson commission
-					adminPercent := 100.0 - salespersonPercent
-
-					// Calculate commissions correctly
-					// Salesperson gets their percentage directly from the plan price
-					salespersonCommission := planPrice * salespersonPercent / 100.0
-					// Admin gets the remaining percentage
-					adminCommission := planPrice * adminPercent / 100.0
-
-					// Insert commission document using Commission model
-					commission := models.Commission{
-						ID:                           primitive.NewObjectID(),
-						SubscriptionID:               newSubscription.ID,
-						CompanyID:                    company.ID,
-						PlanID:                       plan.ID,
-						PlanPrice:                    planPrice,
-						AdminID:                      admin.ID,
-						AdminCommission:              adminCommission,
-						AdminCommissionPercent:       adminPercent,
-						SalespersonID:                salesperson.ID,
-						SalespersonCommission:        salespersonCommission,
-						SalespersonCommissionPercent: salespersonPercent,
-						CreatedAt:                    time.Now(),
-						Paid:                         false,
-						PaidAt:                       nil,
-					}
-					_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-					if err != nil {
-						log.Printf("Failed to insert commission: %v", err)
-					} else {
-						log.Printf("Commission inserted successfully for branch subscription (admin-created salesperson) - Plan Price: $%.2f, Admin Commission: $%.2f (%.1f%%), Salesperson Commission: $%.2f (%.1f%%)",
-							planPrice, adminCommission, adminPercent, salespersonCommission, salespersonPercent)
-
-						// Add salesperson commission to their wallet
-						err = sc.addCommissionToSalespersonWallet(ctx, salesperson.ID, salespersonCommission, newSubscription.ID, "branch_subscription", company.BusinessName)
-						if err != nil {
-							log.Printf("Failed to add commission to salesperson wallet: %v", err)
-						}
-
-						// Add admin commission to admin wallet
-						err = sc.addSubscriptionIncomeToAdminWallet(ctx, adminCommission, newSubscription.ID, "branch_subscription_admin_commission", company.BusinessName, branch.Name)
-						if err != nil {
-							log.Printf("Failed to add admin commission to admin wallet: %v", err)
-						}
-					}
-				} else {
-					// Salesperson was created by sales manager: Handle sales manager commission
-					var salesManager models.SalesManager
-					err := sc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-					if err != nil {
-						// Try alternative collection name
-						err = sc.DB.Collection("salesManagers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-					}
-					if err == nil {
-						log.Printf("DEBUG: Found sales manager: %s (ID: %v)", salesManager.FullName, salesManager.ID)
-						salesManagerPercent := salesManager.CommissionPercent
-
-						log.Printf("DEBUG: Plan price: $%.2f, Salesperson commission percent: %.2f%%, Sales Manager commission percent: %.2f%%",
-							planPrice, salespersonPercent, salesManagerPercent)
-
-						// Calculate commissions correctly
-						// Salesperson gets their percentage directly from the plan price
-						salespersonCommission := planPrice * salespersonPercent / 100.0
-						// Sales manager gets their percentage directly from the plan price
-						salesManagerCommission := planPrice * salesManagerPercent / 100.0
-
-						log.Printf("DEBUG: Calculated commissions - Sales Manager: $%.2f, Salesperson: $%.2f",
-							salesManagerCommission, salespersonCommission)
-
-						// Insert commission document using Commission model
-						commission := models.Commission{
-							ID:                            primitive.NewObjectID(),
-							SubscriptionID:                newSubscription.ID,
-							CompanyID:                     company.ID,
-							PlanID:                        plan.ID,
-							PlanPrice:                     planPrice,
-							SalespersonID:                 salesperson.ID,
-							SalespersonCommission:         salespersonCommission,
-							SalespersonCommissionPercent:  salespersonPercent,
-							SalesManagerID:                salesManager.ID,
-							SalesManagerCommission:        salesManagerCommission,
-							SalesManagerCommissionPercent: salesManagerPercent,
-							CreatedAt:                     time.Now(),
-							Paid:                          false,
-							PaidAt:                        nil,
-						}
-						_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-						if err != nil {
-							log.Printf("Failed to insert commission: %v", err)
-						} else {
-							log.Printf("Commission inserted successfully for branch subscription (sales manager-created salesperson) - Plan Price: $%.2f, Sales Manager Commission: $%.2f, Salesperson Commission: $%.2f",
-								planPrice, salesManagerCommission, salespersonCommission)
-
-							// Add salesperson commission to their wallet
-							err = sc.addCommissionToSalespersonWallet(ctx, salesperson.ID, salespersonCommission, newSubscription.ID, "branch_subscription", company.BusinessName)
-							if err != nil {
-								log.Printf("Failed to add commission to salesperson wallet: %v", err)
-							}
-
-							// Add sales manager commission to their wallet
-							err = sc.addCommissionToSalesManagerWallet(ctx, salesManager.ID, salesManagerCommission, newSubscription.ID, "branch_subscription", company.BusinessName)
-							if err != nil {
-								log.Printf("Failed to add commission to sales manager wallet: %v", err)
-							}
-
-							// Calculate remaining amount for admin wallet
-							totalCommissions := salesManagerCommission + salespersonCommission
-							adminWalletAmount := planPrice - totalCommissions
-
-							// Add remaining amount to admin wallet
-							if adminWalletAmount > 0 {
-								err = sc.addSubscriptionIncomeToAdminWallet(ctx, adminWalletAmount, newSubscription.ID, "branch_subscription_remaining", company.BusinessName, branch.Name)
-								if err != nil {
-									log.Printf("Failed to add remaining subscription income to admin wallet: %v", err)
-								} else {
-									log.Printf("Remaining subscription income added to admin wallet: $%.2f (Plan: $%.2f - Commissions: $%.2f) from company '%s'",
-										adminWalletAmount, planPrice, totalCommissions, company.BusinessName)
-								}
-							}
-						}
-					} else {
-						log.Printf("DEBUG: Failed to find sales manager for ID: %v, Error: %v", salesperson.SalesManagerID, err)
-						// Fallback: Give all to admin if sales manager not found
-						err = sc.addSubscriptionIncomeToAdminWallet(ctx, planPrice, newSubscription.ID, "branch_subscription_fallback", company.BusinessName, branch.Name)
-						if err != nil {
-							log.Printf("Failed to add fallback amount to admin wallet: %v", err)
-						}
-					}
-				}
-			} else {
-				log.Printf("DEBUG: Failed to find salesperson for ID: %v, Error: %v", company.CreatedBy, err)
+			if commission != nil {
+				adminAmount, adminEntityType = utils.AdminWalletShare(commission), "branch_subscription_admin_commission"
+			}
+		}
+		if adminAmount > 0 {
+			if err := sc.addSubscriptionIncomeToAdminWallet(ctx, adminAmount, newSubscription.ID, adminEntityType, company.BusinessName, branch.Name); err != nil {
+				log.Printf("Failed to add subscription income to admin wallet: %v", err)
 			}
-		} else {
-			log.Printf("DEBUG: Company was not created by a salesperson (CreatedBy is zero or not set)")
 		}
 		// --- Commission logic end ---
 
@@ -3753,77 +3553,3 @@ func (sc *SubscriptionController) addSubscriptionIncomeToAdminWallet(ctx context
 
 	return nil
 }
-
-// addCommissionToSalespersonWallet adds commission amount to salesperson's wallet
-func (sc *SubscriptionController) addCommissionToSalespersonWallet(ctx context.Context, salespersonID primitive.ObjectID, amount float64, subscriptionID primitive.ObjectID, entityType, companyName string) error {
-	// Create commission record for salesperson wallet
-	commissionRecord := models.CommissionRecord{
-		ID:             primitive.NewObjectID(),
-		SubscriptionID: subscriptionID,
-		SalespersonID:  salespersonID,
-		Amount:         amount,
-		Role:           "salesperson",
-		Status:         "pending", // Will be marked as paid when processed
-		CreatedAt:      time.Now(),
-	}
-
-	// Insert the commission record
-	_, err := sc.DB.Collection("commission_records").InsertOne(ctx, commissionRecord)
-	if err != nil {
-		return fmt.Errorf("failed to insert salesperson commission record: %w", err)
-	}
-
-	// Update salesperson's commission balance
-	_, err = sc.DB.Collection("salespersons").UpdateOne(
-		ctx,
-		bson.M{"_id": salespersonID},
-		bson.M{
-			"$inc": bson.M{"commissionBalance": amount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update salesperson commission balance: %w", err)
-	}
-
-	log.Printf("Commission $%.2f added to salesperson wallet (ID: %s) from %s - %s",
-		amount, salespersonID.Hex(), entityType, companyName)
-	return nil
-}
-
-// addCommissionToSalesManagerWallet adds commission amount to sales manager's wallet
-func (sc *SubscriptionController) addCommissionToSalesManagerWallet(ctx context.Context, salesManagerID primitive.ObjectID, amount float64, subscriptionID primitive.ObjectID, entityType, companyName string) error {
-	// Create commission record for sales manager wallet
-	commissionRecord := models.CommissionRecord{
-		ID:             primitive.NewObjectID(),
-		SubscriptionID: subscriptionID,
-		SalesManagerID: salesManagerID,
-		Amount:         amount,
-		Role:           "sales_manager",
-		Status:         "pending", // Will be marked as paid when processed
-		CreatedAt:      time.Now(),
-	}
-
-	// Insert the commission record
-	_, err := sc.DB.Collection("commission_records").InsertOne(ctx, commissionRecord)
-	if err != nil {
-		return fmt.Errorf("failed to insert sales manager commission record: %w", err)
-	}
-
-	// Update sales manager's commission balance
-	_, err = sc.DB.Collection("sales_managers").UpdateOne(
-		ctx,
-		bson.M{"_id": salesManagerID},
-		bson.M{
-			"$inc": bson.M{"commissionBalance": amount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update sales manager commission balance: %w", err)
-	}
-
-	log.Printf("Commission $%.2f added to sales manager wallet (ID: %s) from %s - %s",
-		amount, salesManagerID.Hex(), entityType, companyName)
-	return nil
-}
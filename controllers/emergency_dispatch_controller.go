@@ -0,0 +1,703 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/HSouheill/barrim_backend/websocket"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Emergency dispatch WebSocket events
+const (
+	emergencyEventRequest   = "emergency_request"
+	emergencyEventTaken     = "emergency_request_taken"
+	emergencyEventWithdrawn = "emergency_request_withdrawn"
+	emergencyEventUpdate    = "emergency_dispatch_update"
+)
+
+// CreateEmergencyBooking offers an emergency request to the nearest available providers of a service type
+func (c *BookingController) CreateEmergencyBooking(ctx echo.Context) error {
+	claims := middleware.GetUserFromToken(ctx)
+	if claims == nil {
+		return ctx.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Unauthorized",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	var request models.EmergencyBookingRequest
+	if err := ctx.Bind(&request); err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request",
+		})
+	}
+	if request.ServiceType == "" {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Service type is required",
+		})
+	}
+	if request.Lat == 0 && request.Lng == 0 || request.Lat < -90 || request.Lat > 90 || request.Lng < -180 || request.Lng > 180 {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "A valid location is required",
+		})
+	}
+
+	point := models.GeoPoint{Lat: request.Lat, Lng: request.Lng}
+	round, radius, candidates, err := c.nextDispatchRound(request.ServiceType, point, 0, nil)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error finding nearby providers",
+		})
+	}
+	if len(candidates) == 0 {
+		return ctx.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No available providers found nearby",
+		})
+	}
+
+	now := time.Now()
+	ids := candidateIDs(candidates)
+	dispatch := models.EmergencyDispatch{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		ServiceType:    request.ServiceType,
+		Location:       point,
+		PhoneNumber:    request.PhoneNumber,
+		Details:        request.Details,
+		Status:         models.EmergencyDispatchStatusDispatching,
+		Round:          round,
+		RadiusKm:       radius,
+		CandidateIDs:   ids,
+		NotifiedIDs:    ids,
+		RoundExpiresAt: now.Add(utils.EmergencyTimeout()),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if _, err := c.db.Database("barrim").Collection("emergency_dispatches").InsertOne(context.Background(), dispatch); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to create emergency request",
+		})
+	}
+
+	c.offerEmergencyDispatch(dispatch, candidates)
+
+	return ctx.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: fmt.Sprintf("Emergency request sent to %d nearby providers", len(candidates)),
+		Data:    dispatch,
+	})
+}
+
+// GetEmergencyDispatch returns the state of one of the user's emergency requests
+func (c *BookingController) GetEmergencyDispatch(ctx echo.Context) error {
+	dispatch, userID, err := c.loadEmergencyDispatch(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
+	}
+	if dispatch.UserID != userID {
+		return ctx.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "You don't have permission to view this request",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Emergency request retrieved successfully",
+		Data:    dispatch,
+	})
+}
+
+// CancelEmergencyDispatch withdraws an emergency request nobody has accepted yet
+func (c *BookingController) CancelEmergencyDispatch(ctx echo.Context) error {
+	dispatch, userID, err := c.loadEmergencyDispatch(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
+	}
+	if dispatch.UserID != userID {
+		return ctx.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "You don't have permission to cancel this request",
+		})
+	}
+
+	var updated models.EmergencyDispatch
+	err = c.db.Database("barrim").Collection("emergency_dispatches").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": dispatch.ID, "status": models.EmergencyDispatchStatusDispatching},
+		bson.M{"$set": bson.M{"status": models.EmergencyDispatchStatusCancelled, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: fmt.Sprintf("This request is already %s", dispatch.Status),
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to cancel emergency request",
+		})
+	}
+
+	c.withdrawEmergencyDispatch(updated, emergencyEventWithdrawn, "The emergency request was cancelled", primitive.NilObjectID)
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Emergency request cancelled successfully",
+		Data:    updated,
+	})
+}
+
+// GetOpenEmergencyDispatches lists the emergency requests a provider can still accept
+func (c *BookingController) GetOpenEmergencyDispatches(ctx echo.Context) error {
+	providerIDs, err := c.providerIDsFromToken(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
+	}
+
+	cursor, err := c.db.Database("barrim").Collection("emergency_dispatches").Find(context.Background(), bson.M{
+		"status":      models.EmergencyDispatchStatusDispatching,
+		"notifiedIds": bson.M{"$in": providerIDs},
+		"declinedIds": bson.M{"$nin": providerIDs},
+	}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error retrieving emergency requests",
+		})
+	}
+	defer cursor.Close(context.Background())
+
+	dispatches := []models.EmergencyDispatch{}
+	if err := cursor.All(context.Background(), &dispatches); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error decoding emergency requests",
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Emergency requests retrieved successfully",
+		Data:    dispatches,
+	})
+}
+
+// RespondToEmergencyDispatch lets a provider accept or decline an emergency request.
+// The first provider to accept wins; everyone else is told the request was taken.
+func (c *BookingController) RespondToEmergencyDispatch(ctx echo.Context) error {
+	providerIDs, err := c.providerIDsFromToken(ctx)
+	if err != nil {
+		return httpErrorResponse(ctx, err)
+	}
+
+	dispatchID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid emergency request ID",
+		})
+	}
+
+	var request models.EmergencyDispatchResponseRequest
+	if err := ctx.Bind(&request); err != nil {
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request",
+		})
+	}
+
+	collection := c.db.Database("barrim").Collection("emergency_dispatches")
+	filter := bson.M{
+		"_id":         dispatchID,
+		"status":      models.EmergencyDispatchStatusDispatching,
+		"notifiedIds": bson.M{"$in": providerIDs},
+		"declinedIds": bson.M{"$nin": providerIDs},
+	}
+
+	var current models.EmergencyDispatch
+	if err := collection.FindOne(context.Background(), filter).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "This emergency request is no longer available",
+			})
+		}
+		return ctx.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Error finding emergency request",
+		})
+	}
+	providerID := matchingProviderID(current.NotifiedIDs, providerIDs)
+
+	switch request.Action {
+	case "accept":
+		now := time.Now()
+		booking, serviceProvider, err := c.buildEmergencyBooking(current, providerID, now)
+		if err != nil {
+			log.Printf("Failed to prepare booking for emergency request %s: %v", current.ID.Hex(), err)
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to accept emergency request",
+			})
+		}
+
+		// Emergency work takes the provider's time like any booking, so it must
+		// not overlap their other bookings and holds the slot the same way
+		busy, err := c.providerBusy(context.Background(), serviceProvider, booking)
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Error checking booking availability",
+			})
+		}
+		if busy {
+			return ctx.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "You already have a booking at this time",
+			})
+		}
+		if err := utils.ReserveBookingSlot(c.db, serviceProvider.ServiceProviderInfo, booking, now.Add(utils.BookingHoldDuration())); err != nil {
+			if err == utils.ErrSlotTaken {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: "You already have a booking at this time",
+				})
+			}
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Error reserving time slot",
+			})
+		}
+		releaseHold := func() {
+			if err := utils.ReleaseBookingSlot(c.db, booking.ID); err != nil {
+				log.Printf("Failed to release slot hold for booking %s: %v", booking.ID.Hex(), err)
+			}
+		}
+
+		// Only one accept can flip the status, so exactly one provider wins the request
+		var won models.EmergencyDispatch
+		err = collection.FindOneAndUpdate(context.Background(), filter, bson.M{
+			"$set": bson.M{
+				"status":     models.EmergencyDispatchStatusAccepted,
+				"acceptedBy": providerID,
+				"bookingId":  booking.ID,
+				"updatedAt":  now,
+			},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&won)
+		if err != nil {
+			releaseHold()
+			if err == mongo.ErrNoDocuments {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: "Another provider already accepted this emergency request",
+				})
+			}
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to accept emergency request",
+			})
+		}
+
+		if _, err := c.db.Database("barrim").Collection("bookings").InsertOne(context.Background(), booking); err != nil {
+			log.Printf("Failed to create booking for emergency request %s: %v", won.ID.Hex(), err)
+			releaseHold()
+			// Put the request back so this or another provider can still take it
+			if _, revertErr := collection.UpdateOne(context.Background(),
+				bson.M{"_id": won.ID, "status": models.EmergencyDispatchStatusAccepted, "bookingId": booking.ID},
+				bson.M{
+					"$set":   bson.M{"status": models.EmergencyDispatchStatusDispatching, "updatedAt": time.Now()},
+					"$unset": bson.M{"acceptedBy": "", "bookingId": ""},
+				}); revertErr != nil {
+				log.Printf("Failed to reopen emergency request %s: %v", won.ID.Hex(), revertErr)
+			}
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to create booking",
+			})
+		}
+		if err := utils.ConfirmBookingSlot(c.db, booking.ID); err != nil {
+			log.Printf("Failed to confirm slot hold for booking %s: %v", booking.ID.Hex(), err)
+		}
+		go utils.RecordEntityEvent(c.db, utils.SponsoredEntityServiceProvider, booking.ServiceProviderID, models.EntityEventBooking)
+
+		c.withdrawEmergencyDispatch(won, emergencyEventTaken, "This emergency request was taken by another provider", providerID)
+		c.notifyEmergencyUser(won, "Emergency Request Accepted", "A provider accepted your emergency request and is on the way", &booking)
+
+		return ctx.JSON(http.StatusOK, models.Response{
+			Status:  http.StatusOK,
+			Message: "Emergency request accepted successfully",
+			Data:    booking,
+		})
+
+	case "decline":
+		var updated models.EmergencyDispatch
+		err := collection.FindOneAndUpdate(context.Background(), filter, bson.M{
+			"$addToSet": bson.M{"declinedIds": providerID},
+			"$set":      bson.M{"updatedAt": time.Now()},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ctx.JSON(http.StatusConflict, models.Response{
+					Status:  http.StatusConflict,
+					Message: "This emergency request is no longer available",
+				})
+			}
+			return ctx.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to decline emergency request",
+			})
+		}
+
+		// Don't wait for the timeout once every provider offered the request has declined
+		if len(updated.DeclinedIDs) >= len(updated.NotifiedIDs) {
+			c.escalateEmergencyDispatch(updated)
+		}
+
+		return ctx.JSON(http.StatusOK, models.Response{
+			Status:  http.StatusOK,
+			Message: "Emergency request declined",
+		})
+
+	default:
+		return ctx.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Action must be 'accept' or 'decline'",
+		})
+	}
+}
+
+// ProcessEmergencyDispatches escalates emergency requests whose round timed out without an answer
+func (c *BookingController) ProcessEmergencyDispatches() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cursor, err := c.db.Database("barrim").Collection("emergency_dispatches").Find(ctx, bson.M{
+		"status":         models.EmergencyDispatchStatusDispatching,
+		"roundExpiresAt": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to find timed out emergency requests: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var dispatch models.EmergencyDispatch
+		if err := cursor.Decode(&dispatch); err != nil {
+			log.Printf("Failed to decode emergency request: %v", err)
+			continue
+		}
+		c.escalateEmergencyDispatch(dispatch)
+	}
+}
+
+// escalateEmergencyDispatch offers a request to providers further away, or gives up
+// once the last round is exhausted. Earlier candidates can still accept.
+func (c *BookingController) escalateEmergencyDispatch(dispatch models.EmergencyDispatch) {
+	collection := c.db.Database("barrim").Collection("emergency_dispatches")
+	now := time.Now()
+
+	round, radius, candidates, err := c.nextDispatchRound(dispatch.ServiceType, dispatch.Location, dispatch.Round, dispatch.NotifiedIDs)
+	if err != nil {
+		log.Printf("Failed to find providers for emergency request %s: %v", dispatch.ID.Hex(), err)
+		return
+	}
+
+	if len(candidates) == 0 {
+		var updated models.EmergencyDispatch
+		err := collection.FindOneAndUpdate(context.Background(),
+			bson.M{"_id": dispatch.ID, "status": models.EmergencyDispatchStatusDispatching, "round": dispatch.Round},
+			bson.M{"$set": bson.M{"status": models.EmergencyDispatchStatusUnanswered, "updatedAt": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Failed to close emergency request %s: %v", dispatch.ID.Hex(), err)
+			}
+			return
+		}
+		c.withdrawEmergencyDispatch(updated, emergencyEventWithdrawn, "The emergency request is no longer available", primitive.NilObjectID)
+		c.notifyEmergencyUser(updated, "No Provider Available", "No provider accepted your emergency request. Please try again or contact support.", updated)
+		return
+	}
+
+	ids := candidateIDs(candidates)
+	var updated models.EmergencyDispatch
+	err = collection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": dispatch.ID, "status": models.EmergencyDispatchStatusDispatching, "round": dispatch.Round},
+		bson.M{
+			"$set": bson.M{
+				"round":          round,
+				"radiusKm":       radius,
+				"candidateIds":   ids,
+				"roundExpiresAt": now.Add(utils.EmergencyTimeout()),
+				"updatedAt":      now,
+			},
+			"$addToSet": bson.M{"notifiedIds": bson.M{"$each": ids}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to escalate emergency request %s: %v", dispatch.ID.Hex(), err)
+		}
+		return
+	}
+
+	c.offerEmergencyDispatch(updated, candidates)
+	if c.hub != nil {
+		if err := c.hub.SendToUser(updated.UserID, websocket.Notification{
+			Type:    emergencyEventUpdate,
+			Message: fmt.Sprintf("Still looking: your request was sent to %d more providers", len(candidates)),
+			Data:    updated,
+		}); err != nil {
+			log.Printf("Failed to send WebSocket notification: %v", err)
+		}
+	}
+}
+
+// nextDispatchRound finds candidates for the round after fromRound, widening the
+// radius until providers are found or the maximum number of rounds is reached
+func (c *BookingController) nextDispatchRound(serviceType string, point models.GeoPoint, fromRound int, exclude []primitive.ObjectID) (int, float64, []models.EmergencyCandidate, error) {
+	for round := fromRound + 1; round <= utils.EmergencyMaxRounds(); round++ {
+		radius := utils.EmergencyRadiusKm(round)
+		candidates, err := utils.FindEmergencyCandidates(c.db, serviceType, point, radius, utils.EmergencyFanout(), exclude)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(candidates) > 0 {
+			return round, radius, candidates, nil
+		}
+	}
+	return fromRound, 0, nil, nil
+}
+
+// buildEmergencyBooking prepares the booking for a provider accepting an
+// emergency request. It is only inserted once the provider has won the request.
+func (c *BookingController) buildEmergencyBooking(dispatch models.EmergencyDispatch, providerID primitive.ObjectID, now time.Time) (models.Booking, models.ServiceProvider, error) {
+	var serviceProvider models.ServiceProvider
+	if err := c.db.Database("barrim").Collection("serviceProviders").FindOne(context.Background(), bson.M{"_id": providerID}).Decode(&serviceProvider); err != nil {
+		return models.Booking{}, serviceProvider, err
+	}
+
+	// Emergency work starts right away, so it's booked from now rather than into a calendar slot
+	loc := utils.ProviderLocation(serviceProvider.ServiceProviderInfo)
+	start := now.In(loc)
+	booking := models.Booking{
+		ID:                primitive.NewObjectID(),
+		UserID:            dispatch.UserID,
+		ServiceProviderID: providerID,
+		BookingDate:       utils.BookingDateFor(start),
+		TimeSlot:          start.Format(utils.TimeSlotLayout),
+		DurationMinutes:   utils.ServiceDurationMinutes(serviceProvider.ServiceProviderInfo, dispatch.ServiceType),
+		PhoneNumber:       dispatch.PhoneNumber,
+		Details:           dispatch.Details,
+		IsEmergency:       true,
+		Status:            models.BookingStatusAccepted,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	booking.StatusHistory = []models.BookingStatusChange{
+		{To: models.BookingStatusPending, Actor: models.BookingActorUser, ActorID: dispatch.UserID, ChangedAt: dispatch.CreatedAt},
+		{From: models.BookingStatusPending, To: models.BookingStatusAccepted, Actor: models.BookingActorProvider, ActorID: providerID, Reason: "emergency request accepted", ChangedAt: now},
+	}
+	return booking, serviceProvider, nil
+}
+
+// providerBusy reports whether a booking would overlap, buffer included, one
+// of the provider's active bookings
+func (c *BookingController) providerBusy(ctx context.Context, serviceProvider models.ServiceProvider, booking models.Booking) (bool, error) {
+	interval, ok := utils.BookedIntervalFor(serviceProvider.ServiceProviderInfo, booking)
+	if !ok {
+		return false, nil
+	}
+	bookings, err := c.activeBookingsOn(ctx, serviceProvider, booking.BookingDate, booking.ID)
+	if err != nil {
+		return false, err
+	}
+	buffer := time.Duration(utils.BufferMinutes(serviceProvider.ServiceProviderInfo)) * time.Minute
+	for _, other := range bookings {
+		if b, ok := utils.BookedIntervalFor(serviceProvider.ServiceProviderInfo, other); ok &&
+			interval.Start.Before(b.End.Add(buffer)) && b.Start.Before(interval.End.Add(buffer)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// offerEmergencyDispatch notifies the candidates of a round about an emergency request
+func (c *BookingController) offerEmergencyDispatch(dispatch models.EmergencyDispatch, candidates []models.EmergencyCandidate) {
+	title := "Emergency Request Nearby"
+	for _, candidate := range candidates {
+		message := fmt.Sprintf("Emergency %s request %.1f km away. First to accept gets the job.", dispatch.ServiceType, candidate.DistanceKm)
+		data := map[string]interface{}{
+			"type":        emergencyEventRequest,
+			"dispatchId":  dispatch.ID.Hex(),
+			"serviceType": dispatch.ServiceType,
+			"distanceKm":  fmt.Sprintf("%.1f", candidate.DistanceKm),
+			"expiresAt":   dispatch.RoundExpiresAt.Format(time.RFC3339),
+		}
+
+		if c.hub != nil {
+			if err := c.hub.SendToUser(candidate.ServiceProviderID, websocket.Notification{
+				Type:    emergencyEventRequest,
+				Message: message,
+				Data: map[string]interface{}{
+					"dispatch":   dispatch,
+					"distanceKm": candidate.DistanceKm,
+				},
+			}); err != nil {
+				log.Printf("Failed to send WebSocket notification: %v", err)
+			}
+		}
+		if err := utils.SendFCMNotificationToServiceProvider(c.db, candidate.ServiceProviderID, title, message, data); err != nil {
+			log.Printf("Failed to send FCM notification: %v", err)
+		}
+		if err := utils.SaveNotification(c.db, candidate.ServiceProviderID, title, message, emergencyEventRequest, data); err != nil {
+			log.Printf("Failed to save notification: %v", err)
+		}
+	}
+}
+
+// withdrawEmergencyDispatch tells every provider offered a request, except the winner, that it's gone
+func (c *BookingController) withdrawEmergencyDispatch(dispatch models.EmergencyDispatch, event, message string, except primitive.ObjectID) {
+	if c.hub == nil {
+		return
+	}
+	for _, id := range dispatch.NotifiedIDs {
+		if id == except {
+			continue
+		}
+		if err := c.hub.SendToUser(id, websocket.Notification{
+			Type:    event,
+			Message: message,
+			Data:    map[string]interface{}{"dispatchId": dispatch.ID.Hex(), "status": dispatch.Status},
+		}); err != nil {
+			log.Printf("Failed to send WebSocket notification: %v", err)
+		}
+	}
+}
+
+// notifyEmergencyUser tells the requesting user how their emergency request is going
+func (c *BookingController) notifyEmergencyUser(dispatch models.EmergencyDispatch, title, message string, payload interface{}) {
+	data := map[string]interface{}{
+		"type":       emergencyEventUpdate,
+		"dispatchId": dispatch.ID.Hex(),
+		"status":     dispatch.Status,
+	}
+	if dispatch.BookingID != nil {
+		data["bookingId"] = dispatch.BookingID.Hex()
+	}
+
+	if c.hub != nil {
+		if err := c.hub.SendToUser(dispatch.UserID, websocket.Notification{
+			Type:    emergencyEventUpdate,
+			Message: message,
+			Data:    payload,
+		}); err != nil {
+			log.Printf("Failed to send WebSocket notification: %v", err)
+		}
+	}
+	if err := utils.SendFCMNotificationToUser(c.db, dispatch.UserID, title, message, data); err != nil {
+		log.Printf("Failed to send FCM notification: %v", err)
+	}
+	if err := utils.SaveNotification(c.db, dispatch.UserID, title, message, emergencyEventUpdate, data); err != nil {
+		log.Printf("Failed to save notification: %v", err)
+	}
+}
+
+// loadEmergencyDispatch loads the dispatch named in the path and the caller's user ID
+func (c *BookingController) loadEmergencyDispatch(ctx echo.Context) (*models.EmergencyDispatch, primitive.ObjectID, error) {
+	claims := middleware.GetUserFromToken(ctx)
+	if claims == nil {
+		return nil, primitive.NilObjectID, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, primitive.NilObjectID, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	dispatchID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return nil, primitive.NilObjectID, echo.NewHTTPError(http.StatusBadRequest, "Invalid emergency request ID")
+	}
+
+	var dispatch models.EmergencyDispatch
+	if err := c.db.Database("barrim").Collection("emergency_dispatches").FindOne(context.Background(), bson.M{"_id": dispatchID}).Decode(&dispatch); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, primitive.NilObjectID, echo.NewHTTPError(http.StatusNotFound, "Emergency request not found")
+		}
+		return nil, primitive.NilObjectID, echo.NewHTTPError(http.StatusInternalServerError, "Error finding emergency request")
+	}
+	return &dispatch, userID, nil
+}
+
+// providerIDsFromToken returns the IDs a provider's bookings and dispatches may be filed under
+func (c *BookingController) providerIDsFromToken(ctx echo.Context) ([]primitive.ObjectID, error) {
+	claims := middleware.GetUserFromToken(ctx)
+	if claims == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	var user models.User
+	if err := c.db.Database("barrim").Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+	}
+
+	ids := []primitive.ObjectID{user.ID}
+	if user.ServiceProviderID != nil && *user.ServiceProviderID != user.ID {
+		ids = append(ids, *user.ServiceProviderID)
+	}
+	return ids, nil
+}
+
+// matchingProviderID returns the first of a provider's IDs present in ids
+func matchingProviderID(ids, providerIDs []primitive.ObjectID) primitive.ObjectID {
+	for _, id := range ids {
+		for _, pid := range providerIDs {
+			if id == pid {
+				return id
+			}
+		}
+	}
+	return providerIDs[0]
+}
+
+func candidateIDs(candidates []models.EmergencyCandidate) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.ServiceProviderID)
+	}
+	return ids
+}
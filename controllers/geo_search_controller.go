@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultGeoSearchRadiusKm = 5.0
+	maxGeoSearchRadiusKm     = 50.0
+	defaultGeoSearchLimit    = 20
+	maxGeoSearchLimit        = 100
+	maxGeoSearchDepth        = 1000 // Results a page may reach into; each collection is read up to it
+)
+
+// GeoSearchController handles radius and bounding-box searches over branches and service providers
+type GeoSearchController struct {
+	db *mongo.Client
+}
+
+// NewGeoSearchController creates a new geo search controller
+func NewGeoSearchController(db *mongo.Client) *GeoSearchController {
+	return &GeoSearchController{db: db}
+}
+
+// SearchNearby returns branches and service providers near a point or inside a
+// bounding box, nearest first, paged by distance.
+//
+// Query params: lat, lng, radius (km), bbox ("minLng,minLat,maxLng,maxLat"),
+// type ("branch", "serviceProvider" or "all"), category, page, limit
+func (gc *GeoSearchController) SearchNearby(c echo.Context) error {
+	params := utils.GeoSearchParams{
+		Category: c.QueryParam("category"),
+		Page:     1,
+		Limit:    defaultGeoSearchLimit,
+	}
+
+	latStr, lngStr := c.QueryParam("lat"), c.QueryParam("lng")
+	if latStr != "" || lngStr != "" {
+		lat, err1 := strconv.ParseFloat(latStr, 64)
+		lng, err2 := strconv.ParseFloat(lngStr, 64)
+		if err1 != nil || err2 != nil || models.NewGeoJSONPoint(lat, lng) == nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid latitude or longitude",
+			})
+		}
+		params.Lat, params.Lng = lat, lng
+	}
+
+	if bbox := c.QueryParam("bbox"); bbox != "" {
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "bbox must be minLng,minLat,maxLng,maxLat",
+			})
+		}
+		var box [4]float64
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.Response{
+					Status:  http.StatusBadRequest,
+					Message: "bbox must be minLng,minLat,maxLng,maxLat",
+				})
+			}
+			box[i] = v
+		}
+		if box[0] >= box[2] || box[1] >= box[3] || box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid bounding box",
+			})
+		}
+		params.BBox = &box
+	} else if latStr == "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "lat and lng, or bbox, are required",
+		})
+	}
+
+	params.RadiusKm = defaultGeoSearchRadiusKm
+	if radiusStr := c.QueryParam("radius"); radiusStr != "" {
+		radius, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radius <= 0 {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid radius",
+			})
+		}
+		params.RadiusKm = math.Min(radius, maxGeoSearchRadiusKm)
+	}
+
+	switch t := c.QueryParam("type"); t {
+	case "", "all":
+	case utils.GeoResultBranch, utils.GeoResultServiceProvider:
+		params.Types = []string{t}
+	default:
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "type must be 'branch', 'serviceProvider' or 'all'",
+		})
+	}
+
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 0 {
+		params.Page = page
+	}
+	if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil && limit > 0 {
+		params.Limit = limit
+		if params.Limit > maxGeoSearchLimit {
+			params.Limit = maxGeoSearchLimit
+		}
+	}
+	if params.Page*params.Limit > maxGeoSearchDepth {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "page is too far into the results; narrow the search area instead",
+		})
+	}
+
+	results, total, err := utils.SearchNearby(gc.db, params)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to search nearby places",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Nearby results retrieved successfully",
+		Data: map[string]interface{}{
+			"results": results,
+			"pagination": map[string]interface{}{
+				"totalCount": total,
+				"page":       params.Page,
+				"limit":      params.Limit,
+				"totalPages": int(math.Ceil(float64(total) / float64(params.Limit))),
+			},
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LoyaltyController handles points expiry and loyalty tiers
+type LoyaltyController struct {
+	db *mongo.Client
+}
+
+// NewLoyaltyController creates a new loyalty controller
+func NewLoyaltyController(db *mongo.Client) *LoyaltyController {
+	return &LoyaltyController{db: db}
+}
+
+// loyaltyStatusResponse answers with the loyalty status of a points account
+func (lc *LoyaltyController) loyaltyStatusResponse(c echo.Context, ctx context.Context, accountType string, accountID primitive.ObjectID) error {
+	status, err := utils.LoyaltyStatusFor(ctx, lc.db, accountType, accountID)
+	if err == utils.ErrPointsAccountNotFound {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Account not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve loyalty status",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Loyalty status retrieved successfully",
+		Data:    status,
+	})
+}
+
+// GetMyLoyaltyStatus returns the tier and expiring points of the logged-in account
+func (lc *LoyaltyController) GetMyLoyaltyStatus(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Authentication required",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	accountType, accountID, err := utils.PointsAccountForUser(ctx, lc.db, claims.UserType, userID)
+	if err == utils.ErrPointsAccountNotFound {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No points account for this user",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to resolve points account",
+		})
+	}
+	return lc.loyaltyStatusResponse(c, ctx, accountType, accountID)
+}
+
+// GetAccountLoyaltyStatus returns the tier and expiring points of any account (admin only)
+func (lc *LoyaltyController) GetAccountLoyaltyStatus(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accountType := c.Param("accountType")
+	if _, ok := utils.PointsCollection(accountType); !ok {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "accountType must be 'user', 'company', 'wholesaler' or 'serviceProvider'",
+		})
+	}
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+	return lc.loyaltyStatusResponse(c, ctx, accountType, accountID)
+}
+
+// GetLoyaltySettings returns the points expiry and tier settings (admin only)
+func (lc *LoyaltyController) GetLoyaltySettings(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	settings, err := utils.GetLoyaltySettings(ctx, lc.db)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve loyalty settings",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Loyalty settings retrieved successfully",
+		Data:    settings,
+	})
+}
+
+// UpdateLoyaltySettings replaces the points expiry and tier settings and
+// recomputes every tier by them (admin only)
+func (lc *LoyaltyController) UpdateLoyaltySettings(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var settings models.LoyaltySettings
+	if err := c.Bind(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if err := utils.ValidateLoyaltySettings(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		settings.UpdatedBy = &adminID
+	}
+
+	settings, err := utils.SaveLoyaltySettings(ctx, lc.db, settings)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to save loyalty settings",
+		})
+	}
+
+	go func() {
+		if changed, err := utils.RefreshLoyaltyTiers(context.Background(), lc.db); err != nil {
+			log.Printf("Failed to refresh loyalty tiers: %v", err)
+		} else if changed > 0 {
+			log.Printf("Changed %d loyalty tiers after a settings update", changed)
+		}
+	}()
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Loyalty settings updated successfully",
+		Data:    settings,
+	})
+}
@@ -0,0 +1,700 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ModerationController handles reports on reviews and branch comments and the
+// admin moderation queue
+type ModerationController struct {
+	db *mongo.Client
+}
+
+// NewModerationController creates a new moderation controller
+func NewModerationController(db *mongo.Client) *ModerationController {
+	return &ModerationController{db: db}
+}
+
+// moderatedContent holds the fields of a review or branch comment that moderation needs
+type moderatedContent struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	UserID            primitive.ObjectID `bson:"userId"`
+	ServiceProviderID primitive.ObjectID `bson:"serviceProviderId,omitempty"`
+	BranchID          primitive.ObjectID `bson:"branchId,omitempty"`
+	Comment           string             `bson:"comment"`
+
+	models.ModerationInfo `bson:",inline"`
+}
+
+// ReportReview lets a user report a published review
+func (mc *ModerationController) ReportReview(c echo.Context) error {
+	return mc.reportContent(c, models.ModerationContentReview)
+}
+
+// ReportBranchComment lets a user report a published branch comment
+func (mc *ModerationController) ReportBranchComment(c echo.Context) error {
+	return mc.reportContent(c, models.ModerationContentBranchComment)
+}
+
+func (mc *ModerationController) reportContent(c echo.Context, contentType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Unauthorized",
+		})
+	}
+	reporterID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+	contentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+
+	var req models.ContentReportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "A reason is required",
+		})
+	}
+
+	collectionName, _ := utils.ModerationCollection(contentType)
+	var content moderatedContent
+	err = mc.db.Database("barrim").Collection(collectionName).FindOne(ctx, bson.M{
+		"_id":              contentID,
+		"moderationStatus": utils.PublishedModeration(),
+	}).Decode(&content)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Content not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve content",
+		})
+	}
+	if content.UserID == reporterID {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "You cannot report your own content",
+		})
+	}
+
+	ratingTarget, ratingVote, err := utils.LoadContentRating(ctx, mc.db, contentType, contentID)
+	if err != nil {
+		log.Printf("Failed to load rating of %s %s: %v", contentType, contentID.Hex(), err)
+	}
+
+	held, err := utils.ReportContent(ctx, mc.db, contentType, contentID, reporterID, req)
+	if err == utils.ErrAlreadyReported {
+		return c.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "You have already reported this content",
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to report %s %s: %v", contentType, contentID.Hex(), err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to report content",
+		})
+	}
+
+	// Held content no longer counts towards the rating
+	if held {
+		go utils.RefreshContentRating(mc.db, contentType, contentID, ratingTarget, ratingVote)
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: "Report submitted successfully",
+	})
+}
+
+// openReportCounts returns the number of open reports per content, keyed by type and ID
+func (mc *ModerationController) openReportCounts(ctx context.Context) (map[string]int, error) {
+	cursor, err := mc.db.Database("barrim").Collection("content_reports").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"status": models.ContentReportOpen}},
+		{"$group": bson.M{
+			"_id":   bson.M{"contentType": "$contentType", "contentId": "$contentId"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			ContentType string             `bson:"contentType"`
+			ContentID   primitive.ObjectID `bson:"contentId"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ID.ContentType+":"+row.ID.ContentID.Hex()] = row.Count
+	}
+	return counts, nil
+}
+
+// queueItems loads the reviews or branch comments matching a filter as queue items
+func (mc *ModerationController) queueItems(ctx context.Context, contentType string, filter bson.M) ([]models.ModerationQueueItem, error) {
+	collectionName, _ := utils.ModerationCollection(contentType)
+	cursor, err := mc.db.Database("barrim").Collection(collectionName).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.ModerationQueueItem
+	if contentType == models.ModerationContentReview {
+		var reviews []models.Review
+		if err := cursor.All(ctx, &reviews); err != nil {
+			return nil, err
+		}
+		for _, r := range reviews {
+			items = append(items, models.ModerationQueueItem{
+				ContentType:    contentType,
+				ContentID:      r.ID,
+				AuthorID:       r.UserID,
+				AuthorName:     r.Username,
+				TargetID:       r.ServiceProviderID,
+				Text:           r.Comment,
+				Rating:         r.Rating,
+				ModerationInfo: r.ModerationInfo,
+				CreatedAt:      r.CreatedAt,
+			})
+		}
+		return items, nil
+	}
+
+	var comments []models.BranchComment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, err
+	}
+	for _, cm := range comments {
+		items = append(items, models.ModerationQueueItem{
+			ContentType:    contentType,
+			ContentID:      cm.ID,
+			AuthorID:       cm.UserID,
+			AuthorName:     cm.UserName,
+			TargetID:       cm.BranchID,
+			Text:           cm.Comment,
+			Rating:         cm.Rating,
+			ModerationInfo: cm.ModerationInfo,
+			CreatedAt:      cm.CreatedAt,
+		})
+	}
+	return items, nil
+}
+
+// GetModerationQueue lists reviews and comments awaiting moderation, oldest
+// first (admin only). status is "pending" (default), "reported" for content
+// with open reports, or "rejected"; type narrows it to "review" or "branch_comment".
+func (mc *ModerationController) GetModerationQueue(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	contentTypes := []string{models.ModerationContentReview, models.ModerationContentBranchComment}
+	if t := c.QueryParam("type"); t != "" {
+		if _, ok := utils.ModerationCollection(t); !ok {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "type must be 'review' or 'branch_comment'",
+			})
+		}
+		contentTypes = []string{t}
+	}
+	status := c.QueryParam("status")
+	if status == "" {
+		status = models.ModerationPending
+	}
+
+	reportCounts, err := mc.openReportCounts(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to count reports",
+		})
+	}
+
+	items := []models.ModerationQueueItem{}
+	for _, contentType := range contentTypes {
+		var filter bson.M
+		switch status {
+		case models.ModerationPending, models.ModerationRejected:
+			filter = bson.M{"moderationStatus": status}
+		case "reported":
+			var ids []primitive.ObjectID
+			for key := range reportCounts {
+				if hex, ok := strings.CutPrefix(key, contentType+":"); ok {
+					if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+						ids = append(ids, id)
+					}
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			filter = bson.M{"_id": bson.M{"$in": ids}}
+		default:
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "status must be 'pending', 'reported' or 'rejected'",
+			})
+		}
+
+		found, err := mc.queueItems(ctx, contentType, filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to retrieve moderation queue",
+			})
+		}
+		items = append(items, found...)
+	}
+
+	for i := range items {
+		items[i].OpenReports = reportCounts[items[i].ContentType+":"+items[i].ContentID.Hex()]
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	total := len(items)
+	start := (page - 1) * limit
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Moderation queue retrieved successfully",
+		Data: map[string]interface{}{
+			"items": items[start:end],
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// ModerateContent approves, rejects or edits a review or branch comment (admin
+// only). Open reports on it are closed and its author is notified.
+func (mc *ModerationController) ModerateContent(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	contentType := c.Param("type")
+	collectionName, ok := utils.ModerationCollection(contentType)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "type must be 'review' or 'branch_comment'",
+		})
+	}
+	contentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+	adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Invalid admin ID",
+		})
+	}
+
+	var req models.ModerationDecisionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch req.Action {
+	case models.ModerationActionApprove:
+	case models.ModerationActionReject, models.ModerationActionEdit:
+		if req.Reason == "" {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "A reason is required to reject or edit content",
+			})
+		}
+		if req.Action == models.ModerationActionEdit && strings.TrimSpace(req.Text) == "" {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "text is required to edit content",
+			})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "action must be 'approve', 'reject' or 'edit'",
+		})
+	}
+
+	collection := mc.db.Database("barrim").Collection(collectionName)
+	var content moderatedContent
+	if err := collection.FindOne(ctx, bson.M{"_id": contentID}).Decode(&content); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Content not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve content",
+		})
+	}
+
+	ratingTarget, ratingVote, err := utils.LoadContentRating(ctx, mc.db, contentType, contentID)
+	if err != nil {
+		log.Printf("Failed to load rating of %s %s: %v", contentType, contentID.Hex(), err)
+	}
+
+	now := time.Now()
+	status := models.ModerationApproved
+	reportStatus := models.ContentReportDismissed
+	if req.Action == models.ModerationActionReject {
+		status = models.ModerationRejected
+		reportStatus = models.ContentReportResolved
+	}
+	set := bson.M{
+		"moderationStatus": status,
+		"moderationReason": req.Reason,
+		"moderatedBy":      adminID,
+		"moderatedAt":      now,
+		"updatedAt":        now,
+	}
+	if req.Action == models.ModerationActionEdit {
+		set["comment"] = strings.TrimSpace(req.Text)
+		reportStatus = models.ContentReportResolved
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": contentID}, bson.M{"$set": set}); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to moderate content",
+		})
+	}
+	if err := utils.CloseContentReports(ctx, mc.db, contentType, contentID, adminID, reportStatus); err != nil {
+		log.Printf("Failed to close reports of %s %s: %v", contentType, contentID.Hex(), err)
+	}
+	go utils.RefreshContentRating(mc.db, contentType, contentID, ratingTarget, ratingVote)
+
+	// Content held when it was posted was never announced to the provider
+	heldOnCreation := content.ModerationStatus == models.ModerationPending && content.ReportCount == 0
+	go mc.notifyModeration(contentType, content, req, status, heldOnCreation)
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: fmt.Sprintf("Content %s successfully", status),
+		Data: map[string]interface{}{
+			"contentType":      contentType,
+			"contentId":        contentID,
+			"moderationStatus": status,
+		},
+	})
+}
+
+// notifyModeration tells the author about a moderation decision, and the
+// provider about a review that was held when it was posted
+func (mc *ModerationController) notifyModeration(contentType string, content moderatedContent, req models.ModerationDecisionRequest, status string, heldOnCreation bool) {
+	kind := "review"
+	if contentType == models.ModerationContentBranchComment {
+		kind = "comment"
+	}
+
+	var title, message string
+	switch req.Action {
+	case models.ModerationActionApprove:
+		title = fmt.Sprintf("Your %s is published", kind)
+		message = fmt.Sprintf("Your %s has been approved and is now visible.", kind)
+	case models.ModerationActionEdit:
+		title = fmt.Sprintf("Your %s was edited", kind)
+		message = fmt.Sprintf("Your %s was edited by a moderator before being published. Reason: %s", kind, req.Reason)
+	case models.ModerationActionReject:
+		title = fmt.Sprintf("Your %s was removed", kind)
+		message = fmt.Sprintf("Your %s was not published. Reason: %s", kind, req.Reason)
+	}
+	data := map[string]interface{}{
+		"contentType":      contentType,
+		"contentId":        content.ID.Hex(),
+		"moderationStatus": status,
+	}
+	_ = utils.SaveNotification(mc.db, content.UserID, title, message, "content_moderation", data)
+	_ = utils.SendFCMNotificationToUser(mc.db, content.UserID, title, message, data)
+
+	if contentType == models.ModerationContentReview && heldOnCreation && status == models.ModerationApproved {
+		text := content.Comment
+		if req.Action == models.ModerationActionEdit {
+			text = strings.TrimSpace(req.Text)
+		}
+		title := "You have a new review"
+		message := fmt.Sprintf("You received a new review: %s", text)
+		data := map[string]interface{}{
+			"reviewId":   content.ID.Hex(),
+			"reviewerId": content.UserID.Hex(),
+		}
+		_ = utils.SaveNotification(mc.db, content.ServiceProviderID, title, message, "new_review", data)
+		_ = utils.SendFCMNotificationToServiceProvider(mc.db, content.ServiceProviderID, title, message, data)
+	}
+}
+
+// GetContentReports lists user reports, newest first (admin only)
+func (mc *ModerationController) GetContentReports(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if status := c.QueryParam("status"); status != "" {
+		filter["status"] = status
+	}
+	if contentType := c.QueryParam("type"); contentType != "" {
+		filter["contentType"] = contentType
+	}
+	if contentID, err := primitive.ObjectIDFromHex(c.QueryParam("contentId")); err == nil {
+		filter["contentId"] = contentID
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	collection := mc.db.Database("barrim").Collection("content_reports")
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to count reports",
+		})
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve reports",
+		})
+	}
+	reports := []models.ContentReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode reports",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Reports retrieved successfully",
+		Data: map[string]interface{}{
+			"reports": reports,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// GetModerationWords lists the blocked word list (admin only)
+func (mc *ModerationController) GetModerationWords(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if language := c.QueryParam("language"); language != "" {
+		filter["language"] = language
+	}
+	cursor, err := mc.db.Database("barrim").Collection("moderation_words").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "language", Value: 1}, {Key: "word", Value: 1}}))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve blocked words",
+		})
+	}
+	words := []models.ModerationWord{}
+	if err := cursor.All(ctx, &words); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode blocked words",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Blocked words retrieved successfully",
+		Data:    words,
+	})
+}
+
+// AddModerationWords adds words to the blocked word list (admin only). Words
+// already listed are skipped; the language is detected when not given.
+func (mc *ModerationController) AddModerationWords(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ModerationWordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if req.Language != "" && req.Language != "ar" && req.Language != "en" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "language must be 'ar' or 'en'",
+		})
+	}
+
+	var adminID primitive.ObjectID
+	if id, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		adminID = id
+	}
+
+	collection := mc.db.Database("barrim").Collection("moderation_words")
+	added := 0
+	for _, raw := range req.Words {
+		word := utils.NormalizeModerationWord(raw)
+		if word == "" {
+			continue
+		}
+		language := req.Language
+		if language == "" {
+			language = "en"
+			for _, r := range word {
+				if unicode.Is(unicode.Arabic, r) {
+					language = "ar"
+					break
+				}
+			}
+		}
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"word": word},
+			bson.M{"$setOnInsert": models.ModerationWord{
+				ID:        primitive.NewObjectID(),
+				Word:      word,
+				Language:  language,
+				CreatedBy: adminID,
+				CreatedAt: time.Now(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to add blocked words",
+			})
+		}
+		if result.UpsertedCount > 0 {
+			added++
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: fmt.Sprintf("%d blocked words added", added),
+		Data:    map[string]interface{}{"added": added},
+	})
+}
+
+// DeleteModerationWord removes a word from the blocked word list (admin only)
+func (mc *ModerationController) DeleteModerationWord(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+	result, err := mc.db.Database("barrim").Collection("moderation_words").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to delete blocked word",
+		})
+	}
+	if result.DeletedCount == 0 {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Blocked word not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Blocked word deleted successfully",
+	})
+}
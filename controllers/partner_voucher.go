@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// trimmedList trims the entries of a list and drops the empty ones
+func trimmedList(values []string) []string {
+	list := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// submitPartnerVoucher submits a voucher funded by the logged-in company or
+// wholesaler for admin approval. Partner vouchers are sold to users and
+// redeemed at the issuer's branches.
+func submitPartnerVoucher(c echo.Context, db *mongo.Database, issuerType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	issuerID, userID, err := voucherMerchant(ctx, c, db.Client(), issuerType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	// Partners send the same multipart form as the admin voucher form, the
+	// image being an uploaded file rather than a URL fetched by the server
+	if err := c.Request().ParseMultipartForm(10 << 20); err != nil { // 10MB max
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Failed to parse form data",
+			Data:    err.Error(),
+		})
+	}
+	name := strings.TrimSpace(c.FormValue("name"))
+	description := strings.TrimSpace(c.FormValue("description"))
+	if name == "" || description == "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Name and description are required",
+		})
+	}
+	var points int
+	if value := c.FormValue("points"); value != "" {
+		if points, err = strconv.Atoi(value); err != nil || points < 0 {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Points must be a non-negative integer",
+			})
+		}
+	}
+	limits, _, msg := voucherLimitsFromForm(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+	form, _ := c.FormParams()
+
+	// The voucher can only be tied to the issuer's own branches
+	collection, _ := utils.PointsCollection(issuerType)
+	var issuer struct {
+		Category string          `bson:"category"`
+		Branches []models.Branch `bson:"branches"`
+	}
+	err = db.Collection(collection).FindOne(ctx, bson.M{"_id": issuerID},
+		options.FindOne().SetProjection(bson.M{"category": 1, "branches": 1})).Decode(&issuer)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	branches := make(map[primitive.ObjectID]models.Branch, len(issuer.Branches))
+	for _, b := range issuer.Branches {
+		branches[b.ID] = b
+	}
+	var branchIDs []primitive.ObjectID
+	for _, id := range trimmedList(form["branchIds"]) {
+		branchID, err := primitive.ObjectIDFromHex(id)
+		if _, ok := branches[branchID]; err != nil || !ok {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Every branch must be one of your branches",
+			})
+		}
+		branchIDs = append(branchIDs, branchID)
+	}
+
+	audience := models.VoucherAudience{
+		Category:     strings.TrimSpace(form.Get("category")),
+		TargetDeals:  trimmedList(form["targetDeals"]),
+		TargetCities: trimmedList(form["targetCities"]),
+	}
+	if audience.Category == "" && len(branchIDs) > 0 {
+		audience.Category = branches[branchIDs[0]].Category
+	}
+	if audience.Category == "" {
+		audience.Category = issuer.Category
+	}
+
+	var imagePath string
+	if file, err := c.FormFile("image"); err == nil {
+		imagePath, err = (&VoucherController{DB: db}).saveVoucherImage(file)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Failed to save image",
+				Data:    err.Error(),
+			})
+		}
+	}
+
+	now := time.Now()
+	request := models.VoucherApprovalRequest{
+		ID:         primitive.NewObjectID(),
+		IssuerType: issuerType,
+		IssuerID:   issuerID,
+		VoucherData: models.Voucher{
+			ID:             primitive.NewObjectID(),
+			Name:           name,
+			Description:    description,
+			Image:          imagePath,
+			Points:         points,
+			CreatedBy:      userID,
+			TargetUserType: models.VoucherOwnerUser,
+			IssuerType:     issuerType,
+			IssuerID:       &issuerID,
+			BranchIDs:      branchIDs,
+
+			VoucherLimits:   limits,
+			VoucherAudience: audience,
+		},
+		Status:      "pending",
+		SubmittedBy: userID,
+		SubmittedAt: now,
+	}
+	if _, err := db.Collection("voucher_requests").InsertOne(ctx, request); err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: "Voucher submitted for approval",
+		Data:    request,
+	})
+}
+
+// partnerVoucherRequests lists the vouchers the logged-in company or wholesaler submitted for approval
+func partnerVoucherRequests(c echo.Context, db *mongo.Database, issuerType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	issuerID, _, err := voucherMerchant(ctx, c, db.Client(), issuerType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	filter := bson.M{"issuerType": issuerType, "issuerId": issuerID}
+	if status := c.QueryParam("status"); status != "" {
+		filter["status"] = status
+	}
+	cursor, err := db.Collection("voucher_requests").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "submittedAt", Value: -1}}))
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	requests := []models.VoucherApprovalRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher requests retrieved successfully",
+		Data: map[string]interface{}{
+			"count":    len(requests),
+			"requests": requests,
+		},
+	})
+}
+
+// issuedVouchersFor lists the approved vouchers of the logged-in company or
+// wholesaler with their sales and redemption analytics
+func issuedVouchersFor(c echo.Context, db *mongo.Database, issuerType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	issuerID, _, err := voucherMerchant(ctx, c, db.Client(), issuerType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	issued, err := utils.IssuedVouchers(ctx, db.Client(), issuerType, issuerID)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Issued vouchers retrieved successfully",
+		Data: map[string]interface{}{
+			"count":    len(issued),
+			"vouchers": issued,
+		},
+	})
+}
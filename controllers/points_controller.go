@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PointsController serves the points ledger of users, companies, wholesalers and service providers
+type PointsController struct {
+	db *mongo.Client
+}
+
+// NewPointsController creates a new points controller
+func NewPointsController(db *mongo.Client) *PointsController {
+	return &PointsController{db: db}
+}
+
+// pointsHistoryResponse answers with an account's balance and a page of its ledger
+func (pc *PointsController) pointsHistoryResponse(c echo.Context, ctx context.Context, accountType string, accountID primitive.ObjectID) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	balance, err := utils.PointsBalance(ctx, pc.db, accountType, accountID)
+	if err == utils.ErrPointsAccountNotFound {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Account not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve points balance",
+		})
+	}
+	entries, total, err := utils.PointsHistory(ctx, pc.db, accountType, accountID, page, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve points history",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Points history retrieved successfully",
+		Data: map[string]interface{}{
+			"accountType": accountType,
+			"accountId":   accountID,
+			"balance":     balance,
+			"entries":     entries,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// GetPointsHistory returns the balance and ledger of the logged-in account
+func (pc *PointsController) GetPointsHistory(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Authentication required",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	accountType, accountID, err := utils.PointsAccountForUser(ctx, pc.db, claims.UserType, userID)
+	if err == utils.ErrPointsAccountNotFound {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No points account for this user",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to resolve points account",
+		})
+	}
+	return pc.pointsHistoryResponse(c, ctx, accountType, accountID)
+}
+
+// GetAccountPointsHistory returns the balance and ledger of any account (admin only)
+func (pc *PointsController) GetAccountPointsHistory(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accountType := c.Param("accountType")
+	if _, ok := utils.PointsCollection(accountType); !ok {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "accountType must be 'user', 'company', 'wholesaler' or 'serviceProvider'",
+		})
+	}
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+	return pc.pointsHistoryResponse(c, ctx, accountType, accountID)
+}
+
+// AdjustPoints credits or debits an account by hand with a reason (admin only)
+func (pc *PointsController) AdjustPoints(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.PointsAdjustmentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if _, ok := utils.PointsCollection(req.AccountType); !ok {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "accountType must be 'user', 'company', 'wholesaler' or 'serviceProvider'",
+		})
+	}
+	accountID, err := primitive.ObjectIDFromHex(req.AccountID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid account ID",
+		})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Points == 0 || req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "A non-zero points value and a reason are required",
+		})
+	}
+
+	change := utils.PointsChange{
+		AccountType: req.AccountType,
+		AccountID:   accountID,
+		Type:        models.PointsAdjust,
+		Points:      req.Points,
+		SourceType:  models.PointsSourceAdmin,
+		Reason:      req.Reason,
+	}
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		change.CreatedBy = &adminID
+	}
+
+	entry, err := utils.ApplyPoints(ctx, pc.db, change)
+	switch err {
+	case nil:
+	case utils.ErrInsufficientPoints:
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "The adjustment would take the balance below zero",
+		})
+	case utils.ErrPointsAccountNotFound:
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Account not found",
+		})
+	default:
+		log.Printf("Error adjusting points of %s %s: %v", req.AccountType, accountID.Hex(), err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to adjust points",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Points adjusted successfully",
+		Data:    entry,
+	})
+}
@@ -0,0 +1,456 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// promoEntityTypes maps the user types that check out to the entity type they pay for
+var promoEntityTypes = map[string]string{
+	"company":         utils.SponsoredEntityCompanyBranch,
+	"wholesaler":      utils.SponsoredEntityWholesalerBranch,
+	"serviceProvider": utils.SponsoredEntityServiceProvider,
+}
+
+// PromoCodeController handles admin-managed promo codes for subscription and sponsorship checkout
+type PromoCodeController struct {
+	db *mongo.Client
+}
+
+// NewPromoCodeController creates a new promo code controller
+func NewPromoCodeController(db *mongo.Client) *PromoCodeController {
+	return &PromoCodeController{db: db}
+}
+
+// promoCodeErrorResponse answers a checkout whose promo code could not be applied
+func promoCodeErrorResponse(c echo.Context, err error) error {
+	var promoErr *utils.PromoCodeError
+	if errors.As(err, &promoErr) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: promoErr.Message,
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, models.Response{
+		Status:  http.StatusInternalServerError,
+		Message: "Failed to apply promo code",
+	})
+}
+
+// validatePromoCodeRequest checks the fields of a promo code sent by an admin
+func validatePromoCodeRequest(req models.PromoCodeRequest) string {
+	switch {
+	case utils.NormalizePromoCode(req.Code) == "":
+		return "code is required"
+	case req.DiscountType != models.PromoDiscountPercentage && req.DiscountType != models.PromoDiscountFixed:
+		return "discountType must be 'percentage' or 'fixed'"
+	case req.Value <= 0:
+		return "value must be greater than 0"
+	case req.DiscountType == models.PromoDiscountPercentage && req.Value > 100:
+		return "a percentage value cannot be over 100"
+	case req.MaxDiscount < 0 || req.MaxRedemptions < 0 || req.PerAccountLimit < 0:
+		return "maxDiscount, maxRedemptions and perAccountLimit cannot be negative"
+	case req.ValidFrom != nil && req.ValidUntil != nil && req.ValidUntil.Before(*req.ValidFrom):
+		return "validUntil must be after validFrom"
+	}
+	return ""
+}
+
+// CreatePromoCode creates a promo code (admin only)
+func (pc *PromoCodeController) CreatePromoCode(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.PromoCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if msg := validatePromoCodeRequest(req); msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	now := time.Now()
+	promo := models.PromoCode{
+		ID:              primitive.NewObjectID(),
+		Code:            utils.NormalizePromoCode(req.Code),
+		Description:     req.Description,
+		DiscountType:    req.DiscountType,
+		Value:           req.Value,
+		MaxDiscount:     req.MaxDiscount,
+		MaxRedemptions:  req.MaxRedemptions,
+		PerAccountLimit: req.PerAccountLimit,
+		ValidFrom:       req.ValidFrom,
+		ValidUntil:      req.ValidUntil,
+		PlanTypes:       req.PlanTypes,
+		EntityTypes:     req.EntityTypes,
+		IsActive:        req.IsActive == nil || *req.IsActive,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		promo.CreatedBy = adminID
+	}
+
+	if _, err := pc.db.Database("barrim").Collection("promo_codes").InsertOne(ctx, promo); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "A promo code with this code already exists",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to create promo code",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: "Promo code created successfully",
+		Data:    promo,
+	})
+}
+
+// GetPromoCodes lists promo codes, newest first (admin only)
+func (pc *PromoCodeController) GetPromoCodes(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if active := c.QueryParam("active"); active != "" {
+		filter["isActive"] = active == "true"
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := pc.db.Database("barrim").Collection("promo_codes").Find(ctx, filter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve promo codes",
+		})
+	}
+	promos := []models.PromoCode{}
+	if err := cursor.All(ctx, &promos); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode promo codes",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo codes retrieved successfully",
+		Data:    promos,
+	})
+}
+
+// UpdatePromoCode replaces the settings of a promo code, keeping its use count (admin only)
+func (pc *PromoCodeController) UpdatePromoCode(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	promoID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid promo code ID",
+		})
+	}
+
+	var req models.PromoCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if msg := validatePromoCodeRequest(req); msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	set := bson.M{
+		"code":            utils.NormalizePromoCode(req.Code),
+		"description":     req.Description,
+		"discountType":    req.DiscountType,
+		"value":           req.Value,
+		"maxDiscount":     req.MaxDiscount,
+		"maxRedemptions":  req.MaxRedemptions,
+		"perAccountLimit": req.PerAccountLimit,
+		"validFrom":       req.ValidFrom,
+		"validUntil":      req.ValidUntil,
+		"planTypes":       req.PlanTypes,
+		"entityTypes":     req.EntityTypes,
+		"updatedAt":       time.Now(),
+	}
+	if req.IsActive != nil {
+		set["isActive"] = *req.IsActive
+	}
+
+	var promo models.PromoCode
+	err = pc.db.Database("barrim").Collection("promo_codes").FindOneAndUpdate(ctx,
+		bson.M{"_id": promoID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&promo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Promo code not found",
+			})
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return c.JSON(http.StatusConflict, models.Response{
+				Status:  http.StatusConflict,
+				Message: "A promo code with this code already exists",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update promo code",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo code updated successfully",
+		Data:    promo,
+	})
+}
+
+// DeactivatePromoCode stops a promo code from being applied. Codes are never
+// deleted so their redemptions stay in the finance reports (admin only).
+func (pc *PromoCodeController) DeactivatePromoCode(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	promoID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid promo code ID",
+		})
+	}
+
+	result, err := pc.db.Database("barrim").Collection("promo_codes").UpdateOne(ctx,
+		bson.M{"_id": promoID},
+		bson.M{"$set": bson.M{"isActive": false, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to deactivate promo code",
+		})
+	}
+	if result.MatchedCount == 0 {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Promo code not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo code deactivated successfully",
+	})
+}
+
+// GetPromoCodeRedemptions lists the redemptions of promo codes, newest first (admin only)
+func (pc *PromoCodeController) GetPromoCodeRedemptions(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if code := c.QueryParam("code"); code != "" {
+		filter["code"] = utils.NormalizePromoCode(code)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		filter["status"] = status
+	}
+	if checkout := c.QueryParam("checkout"); checkout != "" {
+		filter["checkout"] = checkout
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	collection := pc.db.Database("barrim").Collection("promo_code_redemptions")
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to count promo code redemptions",
+		})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve promo code redemptions",
+		})
+	}
+	redemptions := []models.PromoCodeRedemption{}
+	if err := cursor.All(ctx, &redemptions); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode promo code redemptions",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo code redemptions retrieved successfully",
+		Data: map[string]interface{}{
+			"redemptions": redemptions,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// GetPromoCodeReport sums the discounts given through promo codes between two
+// dates, per code and checkout (admin only)
+func (pc *PromoCodeController) GetPromoCodeReport(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	from, to, err := parseAnalyticsRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	fromTime, _ := time.Parse(utils.StatsDateLayout, from)
+	toTime, _ := time.Parse(utils.StatsDateLayout, to)
+	toTime = toTime.Add(24*time.Hour - time.Nanosecond)
+
+	rows, err := utils.PromoCodeReport(ctx, pc.db, fromTime, toTime)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to build promo code report",
+		})
+	}
+
+	var totals models.PromoCodeReportRow
+	for _, row := range rows {
+		totals.Redemptions += row.Redemptions
+		totals.GrossAmount += row.GrossAmount
+		totals.DiscountTotal += row.DiscountTotal
+		totals.NetAmount += row.NetAmount
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo code report retrieved successfully",
+		Data: map[string]interface{}{
+			"from": from,
+			"to":   to,
+			"rows": rows,
+			"totals": map[string]interface{}{
+				"redemptions":   totals.Redemptions,
+				"grossAmount":   totals.GrossAmount,
+				"discountTotal": totals.DiscountTotal,
+				"netAmount":     totals.NetAmount,
+			},
+		},
+	})
+}
+
+// ValidatePromoCode previews the discount of a promo code on a price at checkout
+// without using it
+func (pc *PromoCodeController) ValidatePromoCode(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Authentication required",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	var req models.PromoCodeValidateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if req.Code == "" || req.Amount <= 0 {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "code and a positive amount are required",
+		})
+	}
+	entityType := req.EntityType
+	if entityType == "" {
+		entityType = promoEntityTypes[claims.UserType]
+	}
+
+	preview, err := utils.PreviewPromoCode(ctx, pc.db, utils.PromoCheckout{
+		Code:       req.Code,
+		PlanType:   req.PlanType,
+		EntityType: entityType,
+		UserID:     userID,
+		Amount:     req.Amount,
+	})
+	if err != nil {
+		return promoCodeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Promo code is valid",
+		Data: map[string]interface{}{
+			"code":           preview.Code,
+			"originalAmount": preview.OriginalAmount,
+			"discountAmount": preview.DiscountAmount,
+			"finalAmount":    preview.FinalAmount,
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RatingController serves the maintained ratings of service providers and branches
+type RatingController struct {
+	db *mongo.Client
+}
+
+// NewRatingController creates a new rating controller
+func NewRatingController(db *mongo.Client) *RatingController {
+	return &RatingController{db: db}
+}
+
+// GetEntityRating returns the rating, histogram and Bayesian average of an entity
+func (rc *RatingController) GetEntityRating(c echo.Context) error {
+	entityType := c.Param("entityType")
+	if !utils.IsRatedEntity(entityType) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "entityType must be 'service_provider', 'company_branch' or 'wholesaler_branch'",
+		})
+	}
+	entityID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rating, err := utils.GetRatingAggregate(ctx, rc.db, entityType, entityID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to get rating",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Rating retrieved successfully",
+		Data:    rating,
+	})
+}
+
+// GetTopRated lists the best rated entities of a type by Bayesian average
+func (rc *RatingController) GetTopRated(c echo.Context) error {
+	entityType := c.QueryParam("entityType")
+	if entityType == "" {
+		entityType = utils.SponsoredEntityServiceProvider
+	}
+	if !utils.IsRatedEntity(entityType) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "entityType must be 'service_provider', 'company_branch' or 'wholesaler_branch'",
+		})
+	}
+
+	minCount := 1
+	if v, err := strconv.Atoi(c.QueryParam("minCount")); err == nil && v >= 0 {
+		minCount = v
+	}
+	limit := 20
+	if v, err := strconv.Atoi(c.QueryParam("limit")); err == nil && v > 0 && v <= 100 {
+		limit = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ratings, err := utils.TopRatedEntities(ctx, rc.db, entityType, minCount, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to get top rated entities",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Top rated entities retrieved successfully",
+		Data:    ratings,
+	})
+}
+
+// RecomputeRatings rebuilds every rating aggregate from the published reviews and comments (admin only)
+func (rc *RatingController) RecomputeRatings(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	count, err := utils.RecomputeRatings(ctx, rc.db)
+	if err != nil {
+		log.Printf("Error recomputing ratings: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to recompute ratings",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Ratings recomputed successfully",
+		Data:    map[string]interface{}{"aggregates": count},
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
 )
 
 type ReferralController struct {
@@ -141,14 +142,24 @@ func (rc *ReferralController) HandleReferral(c echo.Context) error {
 	// Generate a new referral code for the new user
 	newReferralCode := generateUniqueReferralCode()
 
-	// Update the referrer's points and referrals
-	update := bson.M{
-		"$inc":  bson.M{"points": 5}, // Add  points
-		"$push": bson.M{"referrals": objID},
-	}
-
-	_, err = usersCollection.UpdateByID(ctx, referrer.ID, update)
+	// Reward the referrer by the referral rules and add to their referrals
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.db, utils.ReferralEvent{
+		Event:        models.ReferralEventSignup,
+		ReferrerType: models.PointsAccountUser,
+		ReferrerID:   referrer.ID,
+		RefereeType:  models.PointsAccountUser,
+		RefereeID:    objID,
+		Fingerprint:  referralFingerprint(c, ""),
+		Code:         req.ReferralCode,
+		Channel:      models.ReferralChannelCode,
+	})
 	if err != nil {
+		if err == utils.ErrReferralEventRecorded {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "This referral code has already been used",
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to update referrer",
@@ -160,7 +171,6 @@ func (rc *ReferralController) HandleReferral(c echo.Context) error {
 	_, err = usersCollection.UpdateByID(ctx, objID, bson.M{
 		"$set": bson.M{
 			"referralCode": newReferralCode,
-			"points":       0,
 		},
 	})
 	if err != nil {
@@ -175,7 +185,7 @@ func (rc *ReferralController) HandleReferral(c echo.Context) error {
 		Message: "Referral processed successfully",
 		Data: models.ReferralResponse{
 			ReferrerID:      referrer.ID,
-			PointsAdded:     10,
+			PointsAdded:     outcome.ReferrerPoints(),
 			NewReferralCode: newReferralCode,
 		},
 	})
@@ -392,41 +402,29 @@ func (rc *ReferralController) HandleCompanyReferral(c echo.Context) error {
 	// Generate a new referral code for the new company
 	newReferralCode := generateUniqueReferralCode()
 
-	// All referrals award 5 points regardless of referrer type
-	pointsToAdd := 5
-
-	if isCompanyReferrer {
-		// Update referring company with 5 points
-		result, err := companiesCollection.UpdateByID(ctx, referrerCompany.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": company.ID},
-		})
-		log.Printf("Updated company referrer: %v, error: %v", result, err)
-	} else {
-		// Update referring user with 5 points
-		result, err := usersCollection.UpdateByID(ctx, referrerUser.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": company.ID},
-		})
-		log.Printf("Updated user referrer: %v, error: %v", result, err)
+	// Reward the referrer by the referral rules and add to their referrals
+	event := utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  models.PointsAccountUser,
+		ReferrerID:    referrerUser.ID,
+		RefereeType:   models.PointsAccountCompany,
+		RefereeID:     company.ID,
+		RefereeUserID: &objID,
+		Fingerprint:   referralFingerprint(c, ""),
+		Code:          req.ReferralCode,
+		Channel:       models.ReferralChannelCode,
 	}
-
-	// Update the referrer's points and referrals
 	if isCompanyReferrer {
-		// Update referring company with 5 points
-		_, err = companiesCollection.UpdateByID(ctx, referrerCompany.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": company.ID},
-		})
-	} else {
-		// Update referring user with 5 points
-		_, err = usersCollection.UpdateByID(ctx, referrerUser.ID, bson.M{
-			"$inc":  bson.M{"points": pointsToAdd},
-			"$push": bson.M{"referrals": company.ID},
-		})
+		event.ReferrerType, event.ReferrerID = models.PointsAccountCompany, referrerCompany.ID
 	}
-
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.db, event)
 	if err != nil {
+		if err == utils.ErrReferralEventRecorded {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "This referral code has already been used",
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to update referrer",
@@ -438,7 +436,6 @@ func (rc *ReferralController) HandleCompanyReferral(c echo.Context) error {
 	_, err = companiesCollection.UpdateByID(ctx, company.ID, bson.M{
 		"$set": bson.M{
 			"referralCode": newReferralCode,
-			"points":       0,
 			"referrals":    []primitive.ObjectID{},
 		},
 	})
@@ -459,7 +456,7 @@ func (rc *ReferralController) HandleCompanyReferral(c echo.Context) error {
 		Message: "Company referral processed successfully",
 		Data: bson.M{
 			"referrerID":      referrerID,
-			"pointsAdded":     pointsToAdd,
+			"pointsAdded":     outcome.ReferrerPoints(),
 			"newReferralCode": newReferralCode,
 		},
 	})
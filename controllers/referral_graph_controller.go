@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Bounds of a referral subtree request
+const (
+	defaultReferralTreeDepth = 3
+	maxReferralTreeDepth     = 10
+)
+
+// ReferralGraphController serves the referral graph: who brought whom, and how referrals convert
+type ReferralGraphController struct {
+	db *mongo.Client
+}
+
+// NewReferralGraphController creates a new referral graph controller
+func NewReferralGraphController(db *mongo.Client) *ReferralGraphController {
+	return &ReferralGraphController{db: db}
+}
+
+// parseReferralPeriod reads the from and to days of a report, defaulting to
+// the last 30 days, and returns the times they span
+func parseReferralPeriod(c echo.Context) (time.Time, time.Time, error) {
+	from, to, err := parseAnalyticsRange(c)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	start, _ := time.Parse(utils.StatsDateLayout, from)
+	end, _ := time.Parse(utils.StatsDateLayout, to)
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// referralTreeResponse answers with the referral subtree under an account's IDs
+func (gc *ReferralGraphController) referralTreeResponse(c echo.Context, ctx context.Context, rootIDs []primitive.ObjectID) error {
+	depth, _ := strconv.Atoi(c.QueryParam("depth"))
+	if depth < 1 {
+		depth = defaultReferralTreeDepth
+	}
+	if depth > maxReferralTreeDepth {
+		depth = maxReferralTreeDepth
+	}
+
+	tree, total, err := utils.ReferralSubtree(ctx, gc.db, rootIDs, depth)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral tree",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral tree retrieved successfully",
+		Data: map[string]interface{}{
+			"depth":     depth,
+			"total":     total,
+			"referrals": tree,
+		},
+	})
+}
+
+// GetMyReferralTree returns the referees the logged-in account brought and the ones they brought in turn
+func (gc *ReferralGraphController) GetMyReferralTree(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Authentication required",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	rootIDs := []primitive.ObjectID{userID}
+	if _, accountID, err := utils.PointsAccountForUser(ctx, gc.db, claims.UserType, userID); err == nil && accountID != userID {
+		rootIDs = append(rootIDs, accountID)
+	}
+	return gc.referralTreeResponse(c, ctx, rootIDs)
+}
+
+// GetAccountReferralTree returns the referral subtree of any account (admin only)
+func (gc *ReferralGraphController) GetAccountReferralTree(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	accountType := c.Param("type")
+	if !utils.ReferralGraphType(accountType) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "type must be 'user', 'company', 'wholesaler', 'serviceProvider' or 'salesperson'",
+		})
+	}
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid ID format",
+		})
+	}
+	return gc.referralTreeResponse(c, ctx, utils.ReferralAccountIDs(ctx, gc.db, accountType, accountID))
+}
+
+// GetReferralFunnel returns how the referrals of a period converted from
+// signup to a verified phone to a paid subscription, optionally for one
+// referrer or channel (admin only)
+func (gc *ReferralGraphController) GetReferralFunnel(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	from, to, err := parseReferralPeriod(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	filter := bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}
+	for _, field := range []string{"referrerType", "refereeType", "channel"} {
+		if value := c.QueryParam(field); value != "" {
+			filter[field] = value
+		}
+	}
+	if value := c.QueryParam("referrerId"); value != "" {
+		referrerID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid referrerId",
+			})
+		}
+		filter["referrerId"] = referrerID
+	}
+
+	funnel, err := utils.ReferralFunnel(ctx, gc.db, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to compute referral funnel",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral funnel retrieved successfully",
+		Data: map[string]interface{}{
+			"from":   from,
+			"to":     to,
+			"funnel": funnel,
+		},
+	})
+}
+
+// GetTopReferrers ranks the referrers of a period by the referees they brought (admin only)
+func (gc *ReferralGraphController) GetTopReferrers(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	from, to, err := parseReferralPeriod(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	referrerType := c.QueryParam("referrerType")
+	if referrerType != "" && !utils.ReferralGraphType(referrerType) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "referrerType must be 'user', 'company', 'wholesaler', 'serviceProvider' or 'salesperson'",
+		})
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	top, err := utils.TopReferrers(ctx, gc.db, from, to, referrerType, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve top referrers",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Top referrers retrieved successfully",
+		Data: map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"referrers": top,
+		},
+	})
+}
+
+// GetReferralClickStats counts the scans and clicks of referral links over a
+// period by source and platform, with the signups they brought (admin only)
+func (gc *ReferralGraphController) GetReferralClickStats(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	from, to, err := parseReferralPeriod(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+	filter := bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}
+	for _, field := range []string{"referrerType", "code"} {
+		if value := c.QueryParam(field); value != "" {
+			filter[field] = value
+		}
+	}
+	if value := c.QueryParam("referrerId"); value != "" {
+		referrerID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid referrerId",
+			})
+		}
+		filter["referrerId"] = referrerID
+	}
+
+	stats, err := utils.ReferralClickStatsFor(ctx, gc.db, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral click stats",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral click stats retrieved successfully",
+		Data: map[string]interface{}{
+			"from":  from,
+			"to":    to,
+			"stats": stats,
+		},
+	})
+}
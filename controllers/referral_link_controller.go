@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxReferralCodeLength bounds the referral codes a tracked link accepts
+const maxReferralCodeLength = 32
+
+// ReferralLinkController serves the tracked referral links behind referral QR codes
+type ReferralLinkController struct {
+	db *mongo.Client
+}
+
+// NewReferralLinkController creates a new referral link controller
+func NewReferralLinkController(db *mongo.Client) *ReferralLinkController {
+	return &ReferralLinkController{db: db}
+}
+
+// FollowReferralLink records the scan or click of a referral link and sends
+// the visitor to the App Store, the Play Store or the web signup
+func (lc *ReferralLinkController) FollowReferralLink(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	code := strings.TrimSpace(c.Param("code"))
+	if code == "" || len(code) > maxReferralCodeLength {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid referral code",
+		})
+	}
+
+	click, err := utils.RecordReferralClick(ctx, lc.db, code, c.QueryParam("src"),
+		referralFingerprint(c, ""), c.Request().UserAgent())
+	if err == utils.ErrReferralCodeNotFound {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Referral code not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error recording referral click for %s: %v", code, err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to follow referral link",
+		})
+	}
+
+	return c.Redirect(http.StatusFound, utils.ReferralRedirectURL(click))
+}
+
+// GetReferralAttribution tells a freshly installed app which referral link
+// it came from, by the click ID the store carried or else by the device, so
+// it can prefill the referral code and send the click ID with the signup.
+// Failing both, a click from the same IP address is returned as a suggestion
+// that the user must confirm before the app sends it.
+func (lc *ReferralLinkController) GetReferralAttribution(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fingerprint := referralFingerprint(c, "")
+	click, found, err := utils.FindReferralClick(ctx, lc.db, c.QueryParam("clickId"), fingerprint)
+	suggested := false
+	if err == nil && !found {
+		click, found, err = utils.SuggestReferralClick(ctx, lc.db, fingerprint)
+		suggested = found
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to look up referral attribution",
+		})
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No referral link found for this install",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral attribution retrieved successfully",
+		Data: map[string]interface{}{
+			"referralCode":    click.Code,
+			"referralClickId": click.ID.Hex(),
+			"suggested":       suggested, // Ask the user to confirm before using it
+		},
+	})
+}
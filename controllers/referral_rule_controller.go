@@ -0,0 +1,436 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReferralRuleController lets admins configure referral rewards and pay out cash rewards
+type ReferralRuleController struct {
+	db *mongo.Client
+}
+
+// NewReferralRuleController creates a new referral rule controller
+func NewReferralRuleController(db *mongo.Client) *ReferralRuleController {
+	return &ReferralRuleController{db: db}
+}
+
+// referralFingerprint returns where a referee's request came from, for the referral fraud checks
+func referralFingerprint(c echo.Context, phone string) models.ReferralFingerprint {
+	return models.ReferralFingerprint{
+		IP:       c.RealIP(),
+		DeviceID: c.Request().Header.Get("X-Device-ID"),
+		Phone:    phone,
+	}
+}
+
+// bindReferralRule reads a rule from the request body and returns why it is invalid, if it is
+func bindReferralRule(c echo.Context) (models.ReferralRule, string) {
+	var rule models.ReferralRule
+	if err := c.Bind(&rule); err != nil {
+		return rule, "Invalid request body"
+	}
+	rule.Name = strings.TrimSpace(rule.Name)
+	if err := utils.ValidateReferralRule(rule); err != nil {
+		return rule, err.Error()
+	}
+	if rule.CapPerPeriod == 0 {
+		rule.CapPeriod = ""
+	}
+	return rule, ""
+}
+
+// GetReferralRules lists the referral rules, highest priority first
+func (rc *ReferralRuleController) GetReferralRules(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if event := c.QueryParam("event"); event != "" {
+		filter["event"] = event
+	}
+	if active := c.QueryParam("active"); active != "" {
+		filter["isActive"] = active == "true"
+	}
+	cursor, err := rc.db.Database("barrim").Collection("referral_rules").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral rules",
+		})
+	}
+	rules := []models.ReferralRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode referral rules",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral rules retrieved successfully",
+		Data: map[string]interface{}{
+			"count": len(rules),
+			"rules": rules,
+		},
+	})
+}
+
+// CreateReferralRule adds a referral rule or campaign
+func (rc *ReferralRuleController) CreateReferralRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rule, msg := bindReferralRule(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	now := time.Now()
+	rule.ID = primitive.NewObjectID()
+	rule.IsActive = true
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		rule.CreatedBy = adminID
+	}
+
+	if _, err := rc.db.Database("barrim").Collection("referral_rules").InsertOne(ctx, rule); err != nil {
+		log.Printf("Error creating referral rule: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to create referral rule",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Status:  http.StatusCreated,
+		Message: "Referral rule created successfully",
+		Data:    rule,
+	})
+}
+
+// UpdateReferralRule replaces the settings of a referral rule. Rewards
+// already given keep the amounts of the rule at the time.
+func (rc *ReferralRuleController) UpdateReferralRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ruleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid rule ID",
+		})
+	}
+	rule, msg := bindReferralRule(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
+	var updated models.ReferralRule
+	err = rc.db.Database("barrim").Collection("referral_rules").FindOneAndUpdate(ctx,
+		bson.M{"_id": ruleID},
+		bson.M{"$set": bson.M{
+			"name":           rule.Name,
+			"referrerType":   rule.ReferrerType,
+			"refereeType":    rule.RefereeType,
+			"event":          rule.Event,
+			"rewardType":     rule.RewardType,
+			"referrerReward": rule.ReferrerReward,
+			"refereeReward":  rule.RefereeReward,
+			"capPerPeriod":   rule.CapPerPeriod,
+			"capPeriod":      rule.CapPeriod,
+			"priority":       rule.Priority,
+			"startsAt":       rule.StartsAt,
+			"endsAt":         rule.EndsAt,
+			"isActive":       rule.IsActive,
+			"updatedAt":      time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Referral rule not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update referral rule",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral rule updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeactivateReferralRule stops a referral rule from applying. It is kept
+// for the rewards that refer to it.
+func (rc *ReferralRuleController) DeactivateReferralRule(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ruleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid rule ID",
+		})
+	}
+	result, err := rc.db.Database("barrim").Collection("referral_rules").UpdateOne(ctx,
+		bson.M{"_id": ruleID},
+		bson.M{"$set": bson.M{"isActive": false, "updatedAt": time.Now()}})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to deactivate referral rule",
+		})
+	}
+	if result.MatchedCount == 0 {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Referral rule not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral rule deactivated successfully",
+	})
+}
+
+// GetReferralRewards lists referral rewards, newest first, filtered by
+// status, reward type, rule or account
+func (rc *ReferralRuleController) GetReferralRewards(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := bson.M{}
+	for _, field := range []string{"status", "rewardType", "event", "accountType"} {
+		if value := c.QueryParam(field); value != "" {
+			filter[field] = value
+		}
+	}
+	for _, field := range []string{"ruleId", "accountId"} {
+		if value := c.QueryParam(field); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.Response{
+					Status:  http.StatusBadRequest,
+					Message: "Invalid " + field,
+				})
+			}
+			filter[field] = id
+		}
+	}
+
+	rewards := rc.db.Database("barrim").Collection("referral_rewards")
+	total, err := rewards.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral rewards",
+		})
+	}
+	cursor, err := rewards.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral rewards",
+		})
+	}
+	result := []models.ReferralReward{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode referral rewards",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral rewards retrieved successfully",
+		Data: map[string]interface{}{
+			"rewards": result,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// MarkReferralRewardPaid records that a pending cash reward was paid out
+func (rc *ReferralRuleController) MarkReferralRewardPaid(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rewardID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid reward ID",
+		})
+	}
+	set := bson.M{"status": models.ReferralRewardPaid, "paidAt": time.Now()}
+	if adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		set["paidBy"] = adminID
+	}
+
+	var reward models.ReferralReward
+	err = rc.db.Database("barrim").Collection("referral_rewards").FindOneAndUpdate(ctx,
+		bson.M{"_id": rewardID, "rewardType": models.ReferralRewardCash, "status": models.ReferralRewardPending},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&reward)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No pending cash reward with this ID",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to mark referral reward as paid",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral reward marked as paid",
+		Data:    reward,
+	})
+}
+
+// GetReferralReviews lists the referrals fraud checks sent to review, by
+// review status, pending by default
+func (rc *ReferralRuleController) GetReferralReviews(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	status := c.QueryParam("status")
+	if status == "" {
+		status = models.ReferralReviewPending
+	}
+
+	referrals, total, err := utils.ReferralReviews(ctx, rc.db, status, page, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve referral reviews",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral reviews retrieved successfully",
+		Data: map[string]interface{}{
+			"referrals": referrals,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// ProcessReferralReview approves a suspicious referral, releasing its held
+// rewards, or rejects it, withholding them
+func (rc *ReferralRuleController) ProcessReferralReview(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid referral ID",
+		})
+	}
+	var req models.BranchApprovalRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if req.Status != models.ReferralReviewApproved && req.Status != models.ReferralReviewRejected {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Status must be 'approved' or 'rejected'",
+		})
+	}
+	var adminID *primitive.ObjectID
+	if id, err := primitive.ObjectIDFromHex(c.Get("userId").(string)); err == nil {
+		adminID = &id
+	}
+
+	record, err := utils.ReviewReferral(ctx, rc.db, eventID, req.Status == models.ReferralReviewApproved, adminID, strings.TrimSpace(req.AdminNote))
+	if err == utils.ErrReferralNotInReview {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "No referral awaiting review with this ID",
+		})
+	}
+	if err != nil {
+		log.Printf("Error reviewing referral %s: %v", eventID.Hex(), err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to process referral review",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Referral " + req.Status + " successfully",
+		Data:    record,
+	})
+}
@@ -54,7 +54,10 @@ func (rc *ReviewController) GetReviewsByProviderID(c echo.Context) error {
 	findOptions := options.Find()
 	findOptions.SetSort(bson.D{{Key: "createdAt", Value: -1}})
 
-	cursor, err := reviewsCollection.Find(ctx, bson.M{"serviceProviderId": objectID}, findOptions)
+	cursor, err := reviewsCollection.Find(ctx, bson.M{
+		"serviceProviderId": objectID,
+		"moderationStatus":  utils.PublishedModeration(),
+	}, findOptions)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -129,6 +132,23 @@ func (rc *ReviewController) CreateReview(c echo.Context) error {
 		})
 	}
 
+	// Tie the review to a completed booking with the provider, which verifies it
+	var bookingID *primitive.ObjectID
+	if v := c.FormValue("bookingId"); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid booking ID",
+			})
+		}
+		bookingID = &id
+	}
+	booking, err := rc.findReviewBooking(user.ID, providerID, bookingID)
+	if err != nil {
+		return reviewBookingErrorResponse(c, err)
+	}
+
 	// Handle media upload if present
 	var mediaURL, thumbnailURL string
 	if mediaType != "" {
@@ -219,17 +239,33 @@ func (rc *ReviewController) CreateReview(c echo.Context) error {
 		MediaType:         mediaType,
 		MediaURL:          mediaURL,
 		ThumbnailURL:      thumbnailURL,
-		IsVerified:        false, // Default to false, can be updated by admin
+		IsVerified:        booking != nil,
 		CreatedAt:         now,
 		UpdatedAt:         now,
 	}
+	if booking != nil {
+		newReview.BookingID = &booking.ID
+	}
 
 	// Insert review into database
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Hold the review for moderation if the automatic filter flags it
+	check, err := utils.CheckContent(ctx, rc.db, models.ModerationContentReview, user.ID, comment)
+	if err != nil {
+		log.Printf("Failed to check review content: %v", err)
+	}
+	if check.Flagged {
+		newReview.ModerationStatus = models.ModerationPending
+		newReview.FlagReasons = check.Reasons
+	}
+
 	reviewsCollection := rc.db.Database("barrim").Collection("reviews")
 	_, err = reviewsCollection.InsertOne(ctx, newReview)
+	if mongo.IsDuplicateKeyError(err) {
+		return reviewBookingErrorResponse(c, utils.ErrReviewBookingReviewed)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -237,6 +273,14 @@ func (rc *ReviewController) CreateReview(c echo.Context) error {
 		})
 	}
 
+	if check.Flagged {
+		return c.JSON(http.StatusAccepted, models.ReviewResponse{
+			Status:  http.StatusAccepted,
+			Message: "Review submitted and awaiting moderation",
+			Data:    &newReview,
+		})
+	}
+
 	// Send notification to the service provider (in-app + FCM)
 	go func() {
 		title := "You have a new review"
@@ -250,8 +294,8 @@ func (rc *ReviewController) CreateReview(c echo.Context) error {
 		_ = utils.SendFCMNotificationToServiceProvider(rc.db, providerID, title, message, data)
 	}()
 
-	// Update service provider average rating
-	go rc.updateProviderRating(providerID)
+	// Count the review in the service provider's rating
+	go utils.RefreshContentRating(rc.db, models.ModerationContentReview, newReview.ID, utils.RatingTarget{}, nil)
 
 	return c.JSON(http.StatusCreated, models.ReviewResponse{
 		Status:  http.StatusCreated,
@@ -260,51 +304,145 @@ func (rc *ReviewController) CreateReview(c echo.Context) error {
 	})
 }
 
-// updateProviderRating calculates and updates the average rating for a service provider
-func (rc *ReviewController) updateProviderRating(providerID primitive.ObjectID) {
+// findReviewBooking returns the completed booking a new review is tied to, if any
+func (rc *ReviewController) findReviewBooking(userID, providerID primitive.ObjectID, bookingID *primitive.ObjectID) (*models.Booking, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	return utils.FindReviewBooking(ctx, rc.db, userID, providerID, bookingID)
+}
 
-	// Calculate average rating
-	reviewsCollection := rc.db.Database("barrim").Collection("reviews")
+// reviewBookingErrorResponse answers a review that has no booking it may be tied to
+func reviewBookingErrorResponse(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch err {
+	case utils.ErrReviewBookingNotFound:
+		status = http.StatusNotFound
+	case utils.ErrReviewBookingNotCompleted, utils.ErrReviewBookingRequired:
+		status = http.StatusForbidden
+	case utils.ErrReviewBookingReviewed, utils.ErrReviewAlreadyExists:
+		status = http.StatusConflict
+	default:
+		log.Printf("Failed to check review booking: %v", err)
+		return c.JSON(status, models.Response{
+			Status:  status,
+			Message: "Failed to check bookings",
+		})
+	}
+	return c.JSON(status, models.Response{
+		Status:  status,
+		Message: err.Error(),
+	})
+}
 
-	// Pipeline to calculate average rating
-	pipeline := []bson.M{
-		{"$match": bson.M{"serviceProviderId": providerID}},
-		{"$group": bson.M{
-			"_id":           nil,
-			"averageRating": bson.M{"$avg": "$rating"},
-			"count":         bson.M{"$sum": 1},
-		}},
+// UpdateReview lets the author edit the rating and comment of their review
+// within the edit window
+func (rc *ReviewController) UpdateReview(c echo.Context) error {
+	user, err := utils.GetUserFromToken(c, rc.db)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, models.Response{
+			Status:  http.StatusUnauthorized,
+			Message: "Unauthorized",
+		})
 	}
 
-	cursor, err := reviewsCollection.Aggregate(ctx, pipeline)
+	reviewID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		return
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid review ID format",
+		})
 	}
-	defer cursor.Close(ctx)
 
-	// Get aggregation result
-	var results []bson.M
-	if err := cursor.All(ctx, &results); err != nil || len(results) == 0 {
-		return
+	var req models.ReviewUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+	if req.Rating < 1 || req.Rating > 5 {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Rating must be between 1 and 5",
+		})
 	}
 
-	// Extract average rating
-	avgRating := results[0]["averageRating"].(float64)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Update service provider info
-	usersCollection := rc.db.Database("barrim").Collection("users")
-	_, err = usersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": providerID},
-		bson.M{
-			"$set": bson.M{
-				"serviceProviderInfo.rating": avgRating,
-				"updatedAt":                  time.Now(),
-			},
-		},
-	)
+	reviewsCollection := rc.db.Database("barrim").Collection("reviews")
+	var review models.Review
+	err = reviewsCollection.FindOne(ctx, bson.M{"_id": reviewID, "userId": user.ID}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Review not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to fetch review",
+		})
+	}
+	if review.ModerationStatus == models.ModerationRejected {
+		return c.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "A removed review cannot be edited",
+		})
+	}
+	if time.Since(review.CreatedAt) > utils.ReviewEditWindow() {
+		return c.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "The edit window for this review has closed",
+		})
+	}
+
+	before := utils.ReviewVote(review)
+	now := time.Now()
+	set := bson.M{
+		"rating":    req.Rating,
+		"comment":   req.Comment,
+		"editedAt":  now,
+		"updatedAt": now,
+	}
+	held := false
+	if req.Comment != review.Comment {
+		check, err := utils.CheckContent(ctx, rc.db, models.ModerationContentReview, user.ID, req.Comment)
+		if err != nil {
+			log.Printf("Failed to check review content: %v", err)
+		}
+		if check.Flagged {
+			held = true
+			set["moderationStatus"] = models.ModerationPending
+			set["flagReasons"] = check.Reasons
+		}
+	}
+
+	if _, err := reviewsCollection.UpdateOne(ctx, bson.M{"_id": reviewID}, bson.M{"$set": set}); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update review",
+		})
+	}
+	if err := reviewsCollection.FindOne(ctx, bson.M{"_id": reviewID}).Decode(&review); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to fetch updated review",
+		})
+	}
+
+	go utils.RefreshContentRating(rc.db, models.ModerationContentReview, reviewID, utils.RatingTarget{}, before)
+
+	message := "Review updated successfully"
+	if held {
+		message = "Review updated and awaiting moderation"
+	}
+	return c.JSON(http.StatusOK, models.ReviewResponse{
+		Status:  http.StatusOK,
+		Message: message,
+		Data:    &review,
+	})
 }
 
 func (rc *ReviewController) PostReviewReply(c echo.Context) error {
@@ -755,7 +893,8 @@ func (rc *ReviewController) ToggleReviewVerification(c echo.Context) error {
 	defer cancel()
 
 	reviewsCollection := rc.db.Database("barrim").Collection("reviews")
-	result, err := reviewsCollection.UpdateOne(
+	var review models.Review
+	err = reviewsCollection.FindOneAndUpdate(
 		ctx,
 		bson.M{"_id": objID},
 		bson.M{
@@ -764,7 +903,13 @@ func (rc *ReviewController) ToggleReviewVerification(c echo.Context) error {
 				"updatedAt":  time.Now(),
 			},
 		},
-	)
+	).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Review not found",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -772,12 +917,8 @@ func (rc *ReviewController) ToggleReviewVerification(c echo.Context) error {
 		})
 	}
 
-	if result.MatchedCount == 0 {
-		return c.JSON(http.StatusNotFound, models.Response{
-			Status:  http.StatusNotFound,
-			Message: "Review not found",
-		})
-	}
+	// Verification changes the weight of the review in the provider rating
+	go utils.RefreshContentRating(rc.db, models.ModerationContentReview, objID, utils.RatingTarget{}, utils.ReviewVote(review))
 
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
@@ -832,6 +973,11 @@ func (rc *ReviewController) DeleteReview(c echo.Context) error {
 		})
 	}
 
+	ratingTarget, ratingVote, err := utils.LoadContentRating(ctx, rc.db, models.ModerationContentReview, objID)
+	if err != nil {
+		log.Printf("Failed to load rating of review %s: %v", objID.Hex(), err)
+	}
+
 	// Delete the review
 	result, err := reviewsCollection.DeleteOne(ctx, bson.M{"_id": objID})
 	if err != nil {
@@ -848,8 +994,8 @@ func (rc *ReviewController) DeleteReview(c echo.Context) error {
 		})
 	}
 
-	// Update service provider average rating after deletion
-	go rc.updateProviderRating(review.ServiceProviderID)
+	// Take the review out of the service provider's rating
+	go utils.RefreshContentRating(rc.db, models.ModerationContentReview, objID, ratingTarget, ratingVote)
 
 	// TODO: Clean up media files if needed
 	// if review.MediaURL != "" {
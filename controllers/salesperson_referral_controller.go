@@ -171,6 +171,17 @@ func (src *SalespersonReferralController) HandleReferral(c echo.Context) error {
 		log.Printf("Failed to create referral commission record: %v", err)
 		// Don't fail the entire operation, just log the error
 	}
+	_, err = utils.RecordReferralLink(ctx, src.DB.Client(), models.ReferralLink{
+		ReferrerType: models.ReferrerSalesperson,
+		ReferrerID:   salesperson.ID,
+		RefereeType:  models.PointsAccountUser,
+		RefereeID:    user.ID,
+		Code:         req.ReferralCode,
+		Channel:      models.ReferralChannelCode,
+	})
+	if err != nil {
+		log.Printf("Failed to record salesperson referral: %v", err)
+	}
 
 	// Fetch updated salesperson data
 	var updatedSalesperson models.Salesperson
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchController handles unified search across companies, wholesalers, branches and service providers
+type SearchController struct {
+	db *mongo.Client
+}
+
+// NewSearchController creates a new search controller
+func NewSearchController(db *mongo.Client) *SearchController {
+	return &SearchController{db: db}
+}
+
+// Search runs a full-text query with typo tolerance over every searchable entity.
+//
+// Query params: q, type (comma-separated "company", "wholesaler", "branch",
+// "serviceProvider"), category, governorate, district, page, limit
+func (sc *SearchController) Search(c echo.Context) error {
+	params := utils.SearchParams{
+		Query:       strings.TrimSpace(c.QueryParam("q")),
+		Category:    c.QueryParam("category"),
+		Governorate: c.QueryParam("governorate"),
+		District:    c.QueryParam("district"),
+		Page:        1,
+		Limit:       defaultSearchLimit,
+	}
+
+	if types := c.QueryParam("type"); types != "" && types != "all" {
+		for _, t := range strings.Split(types, ",") {
+			t = strings.TrimSpace(t)
+			switch t {
+			case utils.SearchTypeCompany, utils.SearchTypeWholesaler, utils.SearchTypeBranch, utils.SearchTypeServiceProvider:
+				params.Types = append(params.Types, t)
+			default:
+				return c.JSON(http.StatusBadRequest, models.Response{
+					Status:  http.StatusBadRequest,
+					Message: "Invalid type: " + t,
+				})
+			}
+		}
+	}
+
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 0 {
+		params.Page = page
+	}
+	if limit, err := strconv.Atoi(c.QueryParam("limit")); err == nil && limit > 0 {
+		params.Limit = limit
+		if params.Limit > maxSearchLimit {
+			params.Limit = maxSearchLimit
+		}
+	}
+
+	// The index is normally built at startup; build it on demand if a search arrives first
+	if !utils.SearchIndexReady() {
+		if err := utils.RefreshSearchIndex(sc.db); err != nil {
+			log.Printf("Failed to build search index: %v", err)
+			return c.JSON(http.StatusServiceUnavailable, models.Response{
+				Status:  http.StatusServiceUnavailable,
+				Message: "Search is temporarily unavailable",
+			})
+		}
+	}
+
+	results := utils.SearchEntities(params)
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Search results retrieved successfully",
+		Data: map[string]interface{}{
+			"results": results.Hits,
+			"facets":  results.Facets,
+			"pagination": map[string]interface{}{
+				"totalCount": results.Total,
+				"page":       params.Page,
+				"limit":      params.Limit,
+				"totalPages": int(math.Ceil(float64(results.Total) / float64(params.Limit))),
+			},
+		},
+	})
+}
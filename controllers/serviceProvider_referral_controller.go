@@ -2,10 +2,9 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
-	"image/png"
 	"io"
 	"log"
 	"math/rand"
@@ -23,8 +22,6 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/utils"
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/qr"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -207,6 +204,7 @@ func (c *ServiceProviderReferralController) GetAllServiceProviders(ctx echo.Cont
 
 		enhancedSP := ServiceProviderWithUserData{
 			ServiceProvider: sp,
+			Rating:          sp.Rating,
 		}
 
 		// If service provider has a userId, fetch additional user data
@@ -216,7 +214,9 @@ func (c *ServiceProviderReferralController) GetAllServiceProviders(ctx echo.Cont
 			if err == nil && user.ServiceProviderInfo != nil {
 				// Populate additional fields from ServiceProviderInfo
 				enhancedSP.Description = user.ServiceProviderInfo.Description
-				enhancedSP.Rating = user.ServiceProviderInfo.Rating
+				if enhancedSP.Rating == 0 {
+					enhancedSP.Rating = user.ServiceProviderInfo.Rating
+				}
 				enhancedSP.ProfilePhoto = user.ServiceProviderInfo.ProfilePhoto
 				enhancedSP.CertificateImages = user.ServiceProviderInfo.CertificateImages
 				enhancedSP.ServiceType = user.ServiceProviderInfo.ServiceType
@@ -285,9 +285,14 @@ func (c *ServiceProviderReferralController) GetServiceProviderByID(ctx echo.Cont
 	// Remove sensitive information
 	serviceProvider.Password = ""
 
+	if utils.ShouldCountEntityEvent(ctx.RealIP(), utils.SponsoredEntityServiceProvider, objID, models.EntityEventProfileView) {
+		go utils.RecordEntityEvent(c.DB, utils.SponsoredEntityServiceProvider, objID, models.EntityEventProfileView)
+	}
+
 	// Create enhanced service provider with user data
 	enhancedSP := ServiceProviderWithUserData{
 		ServiceProvider: serviceProvider,
+		Rating:          serviceProvider.Rating,
 	}
 
 	// If service provider has a userId, fetch additional user data
@@ -516,57 +521,88 @@ func (rc *ServiceProviderReferralController) HandleServiceProviderReferral(c ech
 		})
 	}
 
-	// Check if this user has already used a referral code
-	if user.ServiceProviderInfo != nil && user.ServiceProviderInfo.Points > 0 {
-		return c.JSON(http.StatusConflict, models.Response{
-			Status:  http.StatusConflict,
-			Message: "You have already used a referral code",
+	// Resolve the points accounts of both service providers
+	_, userAccountID, err := utils.PointsAccountForUser(ctx, rc.DB, models.PointsAccountServiceProvider, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Service provider not found for user",
+		})
+	}
+	_, referrerAccountID, err := utils.PointsAccountForUser(ctx, rc.DB, models.PointsAccountServiceProvider, referrer.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Invalid referral code",
 		})
 	}
 
-	// Update the referrer's points and add this user to their referred list
-	_, err = usersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": referrer.ID},
-		bson.M{
-			"$inc":  bson.M{"serviceProviderInfo.points": 5},
-			"$push": bson.M{"serviceProviderInfo.referredServiceProviders": user.ID},
-		},
-	)
-
+	// Check if this user has already used a referral code
+	alreadyReferred, err := utils.HasPointsEntry(ctx, rc.DB, models.PointsAccountServiceProvider, userAccountID, models.PointsSourceReferralSignup)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
-			Message: "Failed to update referrer's points: " + err.Error(),
+			Message: "Database error: " + err.Error(),
+		})
+	}
+	if alreadyReferred || (user.ServiceProviderInfo != nil && user.ServiceProviderInfo.Points > 0) {
+		return c.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "You have already used a referral code",
 		})
 	}
 
-	// Update the referred user's points
-	_, err = usersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": user.ID},
-		bson.M{
-			"$inc": bson.M{"serviceProviderInfo.points": 1},
-		},
-	)
-
+	// Reward both service providers by the referral rules
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.DB, utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  models.PointsAccountServiceProvider,
+		ReferrerID:    referrerAccountID,
+		RefereeType:   models.PointsAccountServiceProvider,
+		RefereeID:     userAccountID,
+		RefereeUserID: &user.ID,
+		Fingerprint:   referralFingerprint(c, ""),
+		Code:          req.ReferralCode,
+		Channel:       models.ReferralChannelCode,
+	})
+	if err == utils.ErrReferralEventRecorded {
+		return c.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "You have already used a referral code",
+		})
+	}
+	if err == nil {
+		// Add this user to the referrer's referred list
+		_, err = usersCollection.UpdateOne(ctx,
+			bson.M{"_id": referrer.ID},
+			bson.M{"$push": bson.M{"serviceProviderInfo.referredServiceProviders": user.ID}},
+		)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
-			Message: "Failed to update your points: " + err.Error(),
+			Message: "Failed to update referral points: " + err.Error(),
 		})
 	}
 
+	// The referee's reward and the referrer's balance after theirs
+	pointsAdded := 0
+	referrerPoints, _ := utils.PointsBalance(ctx, rc.DB, models.PointsAccountServiceProvider, referrerAccountID)
+	for _, reward := range outcome.Rewards {
+		if reward.Beneficiary == models.ReferralBeneficiaryReferee && reward.Status == models.ReferralRewardGranted {
+			pointsAdded += int(reward.Amount)
+		}
+	}
+
 	// Return success response
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Referral processed successfully",
 		Data: map[string]interface{}{
-			"pointsAdded": 1,
+			"pointsAdded": pointsAdded,
 			"referrer": map[string]interface{}{
 				"id":       referrer.ID.Hex(),
 				"fullName": referrer.FullName,
-				"points":   referrer.ServiceProviderInfo.Points + 5, // Updated points
+				"points":   referrerPoints, // Updated points
 			},
 		},
 	})
@@ -649,12 +685,20 @@ func (rc *ServiceProviderReferralController) GetServiceProviderReferralData(c ec
 		qrCodeURL = "/api/qrcode/referral/" + referralCode
 	}
 
+	// Points are kept on the service provider's account
+	points := info.Points
+	if accountType, accountID, err := utils.PointsAccountForUser(ctx, rc.DB, models.PointsAccountServiceProvider, user.ID); err == nil {
+		if balance, err := utils.PointsBalance(ctx, rc.DB, accountType, accountID); err == nil {
+			points = balance
+		}
+	}
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Referral data retrieved successfully",
 		Data: map[string]interface{}{
 			"referralCode":  referralCode,
-			"points":        info.Points,
+			"points":        points,
 			"referredCount": len(info.ReferredServiceProviders),
 			"referredUsers": referredUsers,
 			"qrCodeURL":     qrCodeURL,
@@ -684,12 +728,8 @@ func (qc *ServiceProviderReferralController) GenerateReferralQRCode(c echo.Conte
 		})
 	}
 
-	// Create content string for QR code
-	// You could include app URL or other information as needed
-	content := "barrim://referral/" + referralCode
-
-	// Generate QR code
-	qrCode, err := qr.Encode(content, qr.M, qr.Auto)
+	// Render the tracked link of the referral code
+	image, err := utils.ReferralQRCodePNG(referralCode, 200)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -697,32 +737,12 @@ func (qc *ServiceProviderReferralController) GenerateReferralQRCode(c echo.Conte
 		})
 	}
 
-	// Scale the QR code to a reasonable size
-	qrCode, err = barcode.Scale(qrCode, 200, 200)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to scale QR code: " + err.Error(),
-		})
-	}
-
-	// Create a buffer to store the image
-	buffer := new(bytes.Buffer)
-
-	// Encode the QR code as PNG
-	if err := png.Encode(buffer, qrCode); err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to encode QR code as PNG: " + err.Error(),
-		})
-	}
-
 	// Set appropriate headers
 	c.Response().Header().Set("Content-Type", "image/png")
 	c.Response().Header().Set("Content-Disposition", "inline; filename=referral-"+referralCode+".png")
 
 	// Write the image to the response
-	return c.Blob(http.StatusOK, "image/png", buffer.Bytes())
+	return c.Blob(http.StatusOK, "image/png", image)
 }
 
 // GenerateReferralQRCodeAsBase64 generates a QR code for a referral code and returns as base64
@@ -736,11 +756,8 @@ func (qc *ServiceProviderReferralController) GenerateReferralQRCodeAsBase64(c ec
 		})
 	}
 
-	// Create content string for QR code
-	content := "barrim://referral/" + referralCode
-
-	// Generate QR code
-	qrCode, err := qr.Encode(content, qr.M, qr.Auto)
+	// Render the tracked link of the referral code
+	image, err := utils.ReferralQRCodePNG(referralCode, 200)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -748,32 +765,12 @@ func (qc *ServiceProviderReferralController) GenerateReferralQRCodeAsBase64(c ec
 		})
 	}
 
-	// Scale the QR code to a reasonable size
-	qrCode, err = barcode.Scale(qrCode, 200, 200)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to scale QR code: " + err.Error(),
-		})
-	}
-
-	// Create a buffer to store the image
-	buffer := new(bytes.Buffer)
-
-	// Encode the QR code as PNG
-	if err := png.Encode(buffer, qrCode); err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to encode QR code as PNG: " + err.Error(),
-		})
-	}
-
 	// Return the Base64 encoded image
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "QR code generated successfully",
 		Data: map[string]interface{}{
-			"qrCodeBase64": "data:image/png;base64," + buffer.String(),
+			"qrCodeBase64": "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
 			"referralCode": referralCode,
 		},
 	})
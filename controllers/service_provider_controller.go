@@ -14,6 +14,7 @@ import (
 
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
@@ -512,9 +513,6 @@ func (spc *ServiceProviderController) UpdateServiceProviderData(c echo.Context)
 	if updateData.ReferralCode != "" {
 		updateFields["referralCode"] = updateData.ReferralCode
 	}
-	if updateData.Points != 0 {
-		updateFields["points"] = updateData.Points
-	}
 	if updateData.CommissionPercent != 0 {
 		updateFields["commissionPercent"] = updateData.CommissionPercent
 	}
@@ -1371,3 +1369,133 @@ func (spc *ServiceProviderController) UpdateServiceProviderDescription(c echo.Co
 		Message: "Service provider description updated successfully",
 	})
 }
+
+// findServiceProviderForUser finds the service provider owned by the authenticated user
+func (spc *ServiceProviderController) findServiceProviderForUser(ctx context.Context, userID primitive.ObjectID) (*models.ServiceProvider, error) {
+	var serviceProvider models.ServiceProvider
+	err := spc.DB.Collection("serviceProviders").FindOne(ctx, bson.M{
+		"$or": []bson.M{
+			{"_id": userID},
+			{"userId": userID},
+			{"createdBy": userID},
+		},
+	}).Decode(&serviceProvider)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceProvider, nil
+}
+
+// GetAvailabilityCalendar returns the authenticated service provider's booking calendar
+func (spc *ServiceProviderController) GetAvailabilityCalendar(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	serviceProvider, err := spc.findServiceProviderForUser(ctx, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Service provider not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to find service provider",
+		})
+	}
+
+	var availability *models.ProviderAvailability
+	if serviceProvider.ServiceProviderInfo != nil {
+		availability = serviceProvider.ServiceProviderInfo.Availability
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Availability calendar retrieved successfully",
+		Data:    availability,
+	})
+}
+
+// UpdateAvailabilityCalendar replaces the authenticated service provider's booking calendar
+func (spc *ServiceProviderController) UpdateAvailabilityCalendar(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := middleware.GetUserFromToken(c)
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid user ID",
+		})
+	}
+
+	var req models.ProviderAvailability
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+		})
+	}
+
+	if err := utils.ValidateProviderAvailability(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: err.Error(),
+		})
+	}
+
+	serviceProvider, err := spc.findServiceProviderForUser(ctx, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Status:  http.StatusNotFound,
+				Message: "Service provider not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to find service provider",
+		})
+	}
+
+	req.UpdatedAt = time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"serviceProviderInfo.availability": req,
+			"updatedAt":                        time.Now(),
+		},
+	}
+	if serviceProvider.ServiceProviderInfo == nil {
+		update = bson.M{
+			"$set": bson.M{
+				"serviceProviderInfo": models.ServiceProviderInfo{Availability: &req},
+				"updatedAt":           time.Now(),
+			},
+		}
+	}
+
+	if _, err := spc.DB.Collection("serviceProviders").UpdateOne(ctx, bson.M{"_id": serviceProvider.ID}, update); err != nil {
+		log.Printf("Failed to update availability calendar: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update availability calendar",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Availability calendar updated successfully",
+		Data:    req,
+	})
+}
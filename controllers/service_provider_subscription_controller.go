@@ -16,6 +16,7 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/services"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -313,6 +314,27 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSubscript
 	subscriptionRequest.Status = "pending_payment"
 	subscriptionRequest.PaymentStatus = "pending"
 
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := plan.Price
+	var promo *models.PromoCodeRedemption
+	if code := c.FormValue("promoCode"); code != "" {
+		promo, err = utils.ApplyPromoCode(ctx, spc.DB.Client(), utils.PromoCheckout{
+			Code:       code,
+			Checkout:   models.PromoCheckoutServiceProviderSubscription,
+			PlanType:   plan.Type,
+			EntityType: utils.SponsoredEntityServiceProvider,
+			EntityID:   serviceProvider.ID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     plan.Price,
+		})
+		if err != nil {
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callbacks
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -341,7 +363,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSubscript
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &plan.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for subscription payments
 		Invoice:            fmt.Sprintf("Service Provider Subscription - %s - Plan: %s", serviceProvider.BusinessName, plan.Title),
 		ExternalID:         &externalID,
@@ -355,6 +377,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSubscript
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		utils.ReleasePromoCode(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -368,6 +391,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSubscript
 	_, err = subscriptionRequestsCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save subscription request: %v", err)
+		utils.ReleasePromoCode(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create subscription request",
@@ -384,7 +408,8 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSubscript
 			"plan":          plan,
 			"status":        subscriptionRequest.Status,
 			"submittedAt":   subscriptionRequest.RequestedAt,
-			"paymentAmount": plan.Price,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 			"collectUrl":    collectURL,
 			"externalId":    externalID,
 		},
@@ -446,6 +471,7 @@ func (spc *ServiceProviderSubscriptionController) HandleWhishPaymentSuccess(c ec
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		utils.ReleasePromoCode(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID)
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
 
@@ -490,12 +516,16 @@ func (spc *ServiceProviderSubscriptionController) HandleWhishPaymentFailure(c ec
 		return c.String(http.StatusInternalServerError, "Failed to update status")
 	}
 
+	utils.ReleasePromoCodeByExternalID(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, externalID)
 	log.Printf("Payment failed for externalId: %d", externalID)
 	return c.String(http.StatusOK, "Payment failure recorded")
 }
 
 // activateServiceProviderSubscription activates the subscription after successful payment
 func (spc *ServiceProviderSubscriptionController) activateServiceProviderSubscription(ctx context.Context, subscriptionRequest models.SubscriptionRequest, payerPhone string) error {
+	// The payment went through, so its promo code is used
+	utils.RedeemPromoCode(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID)
+
 	// Get plan details
 	var plan models.SubscriptionPlan
 	err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": subscriptionRequest.PlanID}).Decode(&plan)
@@ -567,50 +597,29 @@ func (spc *ServiceProviderSubscriptionController) activateServiceProviderSubscri
 		log.Printf("Failed to update user status to active: %v", err)
 	}
 
-	// Handle commission and admin wallet (30% salesperson, 70% admin)
-	planPrice := plan.Price
-	if !serviceProvider.CreatedBy.IsZero() && serviceProvider.CreatedBy != serviceProvider.UserID {
-		// Service provider was created by a salesperson - split commission
-		var salesperson models.Salesperson
-		err := spc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": serviceProvider.CreatedBy}).Decode(&salesperson)
-		if err == nil {
-			// Calculate commissions: 30% salesperson, 70% admin
-			salespersonPercent := 30.0
-			adminPercent := 70.0
-
-			salespersonCommission := planPrice * salespersonPercent / 100.0
-			adminCommission := planPrice * adminPercent / 100.0
-
-			// Add salesperson commission
-			err = spc.addCommissionToSalespersonWallet(ctx, salesperson.ID, salespersonCommission, newSubscription.ID, "service_provider_subscription", serviceProvider.BusinessName)
-			if err != nil {
-				log.Printf("Failed to add salesperson commission: %v", err)
-			} else {
-				log.Printf("Added salesperson commission: $%.2f (30%% of $%.2f)", salespersonCommission, planPrice)
-			}
-
-			// Add admin commission
-			err = spc.addSubscriptionIncomeToAdminWallet(ctx, adminCommission, newSubscription.ID, "service_provider_subscription_commission", serviceProvider.BusinessName, "")
-			if err != nil {
-				log.Printf("Failed to add admin commission: %v", err)
-			} else {
-				log.Printf("Added admin commission: $%.2f (70%% of $%.2f)", adminCommission, planPrice)
-			}
-		} else {
-			log.Printf("Salesperson not found, adding full amount to admin wallet")
-			// Salesperson not found, add full amount to admin
-			err = spc.addSubscriptionIncomeToAdminWallet(ctx, planPrice, newSubscription.ID, "service_provider_subscription", serviceProvider.BusinessName, "")
-			if err != nil {
-				log.Printf("Failed to add subscription income to admin wallet: %v", err)
-			}
-		}
-	} else {
-		// Service provider created by itself - full amount to admin
-		err = spc.addSubscriptionIncomeToAdminWallet(ctx, planPrice, newSubscription.ID, "service_provider_subscription", serviceProvider.BusinessName, "")
+	// Pay the salesperson who created the service provider, if any, and the admin the rest
+	adminAmount, adminEntityType := plan.Price, "service_provider_subscription"
+	if serviceProvider.CreatedBy != serviceProvider.UserID {
+		commission, err := utils.ApplyCommission(ctx, spc.DB.Client(), utils.CommissionSale{
+			SubscriptionID: newSubscription.ID,
+			EntityType:     "serviceProvider",
+			EntityID:       serviceProvider.ID,
+			EntityName:     serviceProvider.BusinessName,
+			CreatedBy:      serviceProvider.CreatedBy,
+			Plan:           plan,
+			AmountPaid:     utils.PromoAmountPaid(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID),
+		})
 		if err != nil {
-			log.Printf("Failed to add subscription income to admin wallet: %v", err)
+			log.Printf("Failed to apply commission: %v", err)
+		}
+		if commission != nil {
+			adminAmount, adminEntityType = utils.AdminWalletShare(commission), "service_provider_subscription_commission"
 		}
 	}
+	err = spc.addSubscriptionIncomeToAdminWallet(ctx, adminAmount, newSubscription.ID, adminEntityType, serviceProvider.BusinessName, "")
+	if err != nil {
+		log.Printf("Failed to add subscription income to admin wallet: %v", err)
+	}
 
 	// Update subscription request status
 	subscriptionRequestsCollection := spc.DB.Collection("subscription_requests")
@@ -627,7 +636,15 @@ func (spc *ServiceProviderSubscriptionController) activateServiceProviderSubscri
 		log.Printf("Failed to update subscription request status: %v", err)
 	}
 
-	log.Printf("Service provider subscription activated successfully: ServiceProvider=%s, Plan=%s, Amount=$%.2f", serviceProvider.BusinessName, plan.Title, planPrice)
+	log.Printf("Service provider subscription activated successfully: ServiceProvider=%s, Plan=%s, Amount=$%.2f", serviceProvider.BusinessName, plan.Title, plan.Price)
+	if plan.Price > 0 {
+		go utils.TriggerReferralEvent(spc.DB.Client(), utils.ReferralEvent{
+			Event:         models.ReferralEventFirstPaidSubscription,
+			RefereeType:   models.PointsAccountServiceProvider,
+			RefereeID:     serviceProvider.ID,
+			RefereeUserID: &serviceProvider.UserID,
+		})
+	}
 	return nil
 }
 
@@ -687,50 +704,6 @@ func (spc *ServiceProviderSubscriptionController) addSubscriptionIncomeToAdminWa
 	return nil
 }
 
-func (spc *ServiceProviderSubscriptionController) addCommissionToSalespersonWallet(ctx context.Context, salespersonID primitive.ObjectID, amount float64, subscriptionID primitive.ObjectID, entityType, serviceProviderName string) error {
-	// CommissionRecord structure
-	type CommissionRecord struct {
-		ID             primitive.ObjectID `bson:"_id,omitempty"`
-		SubscriptionID primitive.ObjectID `bson:"subscriptionId"`
-		SalespersonID  primitive.ObjectID `bson:"salespersonId"`
-		Amount         float64            `bson:"amount"`
-		Role           string             `bson:"role"`
-		Status         string             `bson:"status"`
-		CreatedAt      time.Time          `bson:"createdAt"`
-	}
-
-	commissionRecord := CommissionRecord{
-		ID:             primitive.NewObjectID(),
-		SubscriptionID: subscriptionID,
-		SalespersonID:  salespersonID,
-		Amount:         amount,
-		Role:           "salesperson",
-		Status:         "pending",
-		CreatedAt:      time.Now(),
-	}
-
-	_, err := spc.DB.Collection("commission_records").InsertOne(ctx, commissionRecord)
-	if err != nil {
-		return fmt.Errorf("failed to insert salesperson commission record: %w", err)
-	}
-
-	_, err = spc.DB.Collection("salespersons").UpdateOne(
-		ctx,
-		bson.M{"_id": salespersonID},
-		bson.M{
-			"$inc": bson.M{"commissionBalance": amount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update salesperson commission balance: %w", err)
-	}
-
-	log.Printf("Commission $%.2f added to salesperson wallet (ID: %s) from %s - %s",
-		amount, salespersonID.Hex(), entityType, serviceProviderName)
-	return nil
-}
-
 // GetSubscriptionTimeRemaining returns the remaining time for the current subscription
 func (spc *ServiceProviderSubscriptionController) GetSubscriptionTimeRemaining(c echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -1308,69 +1281,19 @@ func (spc *ServiceProviderSubscriptionController) ProcessServiceProviderSubscrip
 
 		// --- Commission logic start ---
 		// Only proceed if service provider was created by a salesperson
-		log.Printf("DEBUG: Service Provider CreatedBy field: %v (IsZero: %v)", serviceProvider.CreatedBy, serviceProvider.CreatedBy.IsZero())
-		if !serviceProvider.CreatedBy.IsZero() {
-			log.Printf("DEBUG: Service provider was created by salesperson, proceeding with commission calculation")
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := spc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": serviceProvider.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				log.Printf("DEBUG: Found salesperson: %s (ID: %v)", salesperson.FullName, salesperson.ID)
-				// Get admin who created the salesperson
-				var admin models.Admin
-				err := spc.DB.Collection("admins").FindOne(ctx, bson.M{"_id": salesperson.CreatedBy}).Decode(&admin)
-				if err == nil {
-					log.Printf("DEBUG: Found admin: %s (ID: %v)", admin.Email, admin.ID)
-					planPrice := plan.Price
-					salespersonPercent := salesperson.CommissionPercent
-
-					// Calculate admin commission (remaining percentage after salesperson commission)
-					adminPercent := 100.0 - salespersonPercent
-
-					log.Printf("DEBUG: Plan price: $%.2f, Salesperson commission percent: %.2f%%, Admin commission percent: %.2f%%",
-						planPrice, salespersonPercent, adminPercent)
-
-					// Calculate commissions correctly
-					// Salesperson gets their percentage directly from the plan price
-					salespersonCommission := planPrice * salespersonPercent / 100.0
-					// Admin gets the remaining percentage
-					adminCommission := planPrice * adminPercent / 100.0
-
-					log.Printf("DEBUG: Calculated commissions - Admin: $%.2f, Salesperson: $%.2f",
-						adminCommission, salespersonCommission)
-
-					// Insert commission document using Commission model
-					commission := models.Commission{
-						ID:                           primitive.NewObjectID(),
-						SubscriptionID:               newSubscription.ID,
-						CompanyID:                    primitive.NilObjectID, // No company ID for service provider
-						PlanID:                       plan.ID,
-						PlanPrice:                    planPrice,
-						AdminID:                      admin.ID,
-						AdminCommission:              adminCommission,
-						AdminCommissionPercent:       adminPercent,
-						SalespersonID:                salesperson.ID,
-						SalespersonCommission:        salespersonCommission,
-						SalespersonCommissionPercent: salespersonPercent,
-						CreatedAt:                    time.Now(),
-						Paid:                         false,
-						PaidAt:                       nil,
-					}
-					_, err := spc.DB.Collection("commissions").InsertOne(ctx, commission)
-					if err != nil {
-						log.Printf("Failed to insert commission: %v", err)
-					} else {
-						log.Printf("Commission inserted successfully for service provider subscription - Plan Price: $%.2f, Admin Commission: $%.2f (%.1f%%), Salesperson Commission: $%.2f (%.1f%%)",
-							planPrice, adminCommission, adminPercent, salespersonCommission, salespersonPercent)
-					}
-				} else {
-					log.Printf("DEBUG: Failed to find admin for ID: %v, Error: %v", salesperson.CreatedBy, err)
-				}
-			} else {
-				log.Printf("DEBUG: Failed to find salesperson for ID: %v, Error: %v", serviceProvider.CreatedBy, err)
+		if serviceProvider.CreatedBy != serviceProvider.UserID {
+			_, err := utils.ApplyCommission(ctx, spc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: newSubscription.ID,
+				EntityType:     "serviceProvider",
+				EntityID:       serviceProvider.ID,
+				EntityName:     serviceProvider.BusinessName,
+				CreatedBy:      serviceProvider.CreatedBy,
+				Plan:           plan,
+				AmountPaid:     utils.PromoAmountPaid(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, subscriptionRequest.ID),
+			})
+			if err != nil {
+				log.Printf("Failed to apply commission: %v", err)
 			}
-		} else {
-			log.Printf("DEBUG: Service provider was not created by a salesperson (CreatedBy is zero or not set)")
 		}
 		// --- Commission logic end ---
 
@@ -1662,54 +1585,22 @@ func (spc *ServiceProviderSubscriptionController) ApproveServiceProviderSubscrip
 		// --- Commission logic start ---
 		// Get service provider details
 		var serviceProvider models.ServiceProvider
-		if err := spCollection.FindOne(ctx, bson.M{"_id": request.ServiceProviderID}).Decode(&serviceProvider); err == nil {
-			if !serviceProvider.CreatedBy.IsZero() {
-				// Get salesperson
-				var salesperson models.Salesperson
-				err := spc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": serviceProvider.CreatedBy}).Decode(&salesperson)
-				if err == nil {
-					// Get sales manager
-					var salesManager models.SalesManager
-					err := spc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-					if err == nil {
-						// Get plan details
-						var plan models.SubscriptionPlan
-						err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-						if err == nil {
-							planPrice := plan.Price
-							salespersonPercent := salesperson.CommissionPercent
-							salesManagerPercent := salesManager.CommissionPercent
-							// Calculate commissions correctly
-							// Salesperson gets their percentage directly from the plan price
-							salespersonCommission := planPrice * salespersonPercent / 100.0
-							// Sales manager gets their percentage directly from the plan price
-							salesManagerCommission := planPrice * salesManagerPercent / 100.0
-							// Insert commission documents
-							commissionCollection := spc.DB.Collection("commissions")
-							commissionDocs := []interface{}{
-								models.Commission{
-									ID:                            primitive.NewObjectID(),
-									SubscriptionID:                request.ID,
-									CompanyID:                     primitive.NilObjectID,
-									PlanID:                        plan.ID,
-									PlanPrice:                     planPrice,
-									SalespersonID:                 salesperson.ID,
-									SalespersonCommission:         salespersonCommission,
-									SalespersonCommissionPercent:  salespersonPercent,
-									SalesManagerID:                salesManager.ID,
-									SalesManagerCommission:        salesManagerCommission,
-									SalesManagerCommissionPercent: salesManagerPercent,
-									CreatedAt:                     time.Now(),
-									Paid:                          false,
-									PaidAt:                        nil,
-								},
-							}
-							_, err := commissionCollection.InsertMany(ctx, commissionDocs)
-							if err != nil {
-								log.Printf("Failed to insert commission docs: %v", err)
-							}
-						}
-					}
+		if err := spCollection.FindOne(ctx, bson.M{"_id": request.ServiceProviderID}).Decode(&serviceProvider); err == nil && serviceProvider.CreatedBy != serviceProvider.UserID {
+			// Get plan details
+			var plan models.SubscriptionPlan
+			err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
+			if err == nil {
+				_, err := utils.ApplyCommission(ctx, spc.DB.Client(), utils.CommissionSale{
+					SubscriptionID: request.ID,
+					EntityType:     "serviceProvider",
+					EntityID:       serviceProvider.ID,
+					EntityName:     serviceProvider.BusinessName,
+					CreatedBy:      serviceProvider.CreatedBy,
+					Plan:           plan,
+					AmountPaid:     utils.PromoAmountPaid(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, request.ID),
+				})
+				if err != nil {
+					log.Printf("Failed to apply commission: %v", err)
 				}
 			}
 		}
@@ -1861,64 +1752,22 @@ func (spc *ServiceProviderSubscriptionController) ApproveServiceProviderSubscrip
 		// --- Commission logic start ---
 		// Get service provider details
 		var serviceProvider models.ServiceProvider
-		if err := spCollection.FindOne(ctx, bson.M{"_id": request.ServiceProviderID}).Decode(&serviceProvider); err == nil {
-			// Check if service provider was created by itself (user signup) - CreatedBy equals UserID
-			if serviceProvider.CreatedBy == serviceProvider.UserID {
-				log.Printf("DEBUG: Service provider was created by user signup, adding subscription price to admin wallet")
-				// Get plan details to get the price
-				var plan models.SubscriptionPlan
-				err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-				if err == nil {
-					log.Printf("Subscription income added to admin wallet: $%.2f from service provider '%s' (ID: %s) - User signup subscription",
-						plan.Price, serviceProvider.BusinessName, serviceProvider.ID.Hex())
-				}
-			} else if !serviceProvider.CreatedBy.IsZero() {
-				// Get salesperson
-				var salesperson models.Salesperson
-				err := spc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": serviceProvider.CreatedBy}).Decode(&salesperson)
-				if err == nil {
-					// Get sales manager
-					var salesManager models.SalesManager
-					err := spc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-					if err == nil {
-						// Get plan details
-						var plan models.SubscriptionPlan
-						err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-						if err == nil {
-							planPrice := plan.Price
-							salespersonPercent := salesperson.CommissionPercent
-							salesManagerPercent := salesManager.CommissionPercent
-							// Calculate commissions correctly
-							// Salesperson gets their percentage directly from the plan price
-							salespersonCommission := planPrice * salespersonPercent / 100.0
-							// Sales manager gets their percentage directly from the plan price
-							salesManagerCommission := planPrice * salesManagerPercent / 100.0
-							// Insert commission documents
-							commissionCollection := spc.DB.Collection("commissions")
-							commissionDocs := []interface{}{
-								models.Commission{
-									ID:                            primitive.NewObjectID(),
-									SubscriptionID:                request.ID,
-									CompanyID:                     primitive.NilObjectID,
-									PlanID:                        plan.ID,
-									PlanPrice:                     planPrice,
-									SalespersonID:                 salesperson.ID,
-									SalespersonCommission:         salespersonCommission,
-									SalespersonCommissionPercent:  salespersonPercent,
-									SalesManagerID:                salesManager.ID,
-									SalesManagerCommission:        salesManagerCommission,
-									SalesManagerCommissionPercent: salesManagerPercent,
-									CreatedAt:                     time.Now(),
-									Paid:                          false,
-									PaidAt:                        nil,
-								},
-							}
-							_, err := commissionCollection.InsertMany(ctx, commissionDocs)
-							if err != nil {
-								log.Printf("Failed to insert commission docs: %v", err)
-							}
-						}
-					}
+		if err := spCollection.FindOne(ctx, bson.M{"_id": request.ServiceProviderID}).Decode(&serviceProvider); err == nil && serviceProvider.CreatedBy != serviceProvider.UserID {
+			// Get plan details
+			var plan models.SubscriptionPlan
+			err := spc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
+			if err == nil {
+				_, err := utils.ApplyCommission(ctx, spc.DB.Client(), utils.CommissionSale{
+					SubscriptionID: request.ID,
+					EntityType:     "serviceProvider",
+					EntityID:       serviceProvider.ID,
+					EntityName:     serviceProvider.BusinessName,
+					CreatedBy:      serviceProvider.CreatedBy,
+					Plan:           plan,
+					AmountPaid:     utils.PromoAmountPaid(ctx, spc.DB.Client(), models.PromoCheckoutServiceProviderSubscription, request.ID),
+				})
+				if err != nil {
+					log.Printf("Failed to apply commission: %v", err)
 				}
 			}
 		}
@@ -2018,6 +1867,8 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 	var req struct {
 		SponsorshipID primitive.ObjectID `json:"sponsorshipId" validate:"required"`
 		AdminNote     string             `json:"adminNote,omitempty"`
+		PromoCode     string             `json:"promoCode,omitempty"`
+		models.SponsorshipSlotOptions
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, models.Response{
@@ -2091,7 +1942,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 		"sponsorshipId": req.SponsorshipID,
 		"entityId":      serviceProvider.ID,
 		"entityType":    "service_provider",
-		"status":        bson.M{"$in": []string{"active", "pending"}},
+		"status":        bson.M{"$in": []string{"active", "pending", models.SponsorshipSubscriptionScheduled}},
 	}).Decode(&existingSubscription)
 	if err == nil {
 		return c.JSON(http.StatusConflict, models.Response{
@@ -2133,6 +1984,57 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 		PaymentStatus: "pending",
 	}
 
+	// Hold a sponsor place in the service provider's category and governorate while the payment is made
+	sponsorshipSubscriptionController := NewSponsorshipSubscriptionController(spc.DB)
+	slot, refusal, err := sponsorshipSubscriptionController.reserveRequestSlot(ctx, sponsorshipSlotRequest{
+		Sponsorship: sponsorship,
+		EntityType:  subscriptionRequest.EntityType,
+		EntityID:    serviceProvider.ID,
+		EntityName:  subscriptionRequest.EntityName,
+		RequestID:   subscriptionRequest.ID,
+		RequestedBy: userID,
+		Options:     req.SponsorshipSlotOptions,
+		HoldFor:     utils.SponsorshipPaymentHold(),
+	})
+	if err != nil {
+		log.Printf("Failed to reserve sponsorship place: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to reserve sponsorship place",
+		})
+	}
+	if refusal != nil {
+		return c.JSON(refusal.Status, models.Response{
+			Status:  refusal.Status,
+			Message: refusal.Message,
+			Data:    refusal.Data,
+		})
+	}
+	subscriptionRequest.SlotID = slot.ID
+	subscriptionRequest.StartDate = slot.StartDate
+
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := sponsorship.Price
+	var promo *models.PromoCodeRedemption
+	if req.PromoCode != "" {
+		promo, err = utils.ApplyPromoCode(ctx, sponsorshipSubscriptionController.DB.Client(), utils.PromoCheckout{
+			Code:       req.PromoCode,
+			Checkout:   models.PromoCheckoutSponsorship,
+			PlanType:   models.PromoPlanSponsorship,
+			EntityType: subscriptionRequest.EntityType,
+			EntityID:   serviceProvider.ID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     sponsorship.Price,
+		})
+		if err != nil {
+			sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callback URLs (backend API)
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -2162,7 +2064,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &sponsorship.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for sponsorship payments
 		Invoice:            fmt.Sprintf("Service Provider Sponsorship - %s - Sponsorship: %s", serviceProvider.BusinessName, sponsorship.Title),
 		ExternalID:         &externalID,
@@ -2176,6 +2078,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -2189,6 +2092,7 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 	_, err = existingRequestCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save sponsorship subscription request: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create sponsorship subscription request",
@@ -2219,11 +2123,14 @@ func (spc *ServiceProviderSubscriptionController) CreateServiceProviderSponsorsh
 				"businessName": serviceProvider.BusinessName,
 				"category":     serviceProvider.Category,
 			},
-			"status":      subscriptionRequest.Status,
-			"submittedAt": subscriptionRequest.RequestedAt,
-			"adminNote":   subscriptionRequest.AdminNote,
-			"paymentUrl":  collectURL,
-			"price":       sponsorship.Price,
+			"status":        subscriptionRequest.Status,
+			"submittedAt":   subscriptionRequest.RequestedAt,
+			"adminNote":     subscriptionRequest.AdminNote,
+			"paymentUrl":    collectURL,
+			"price":         sponsorship.Price,
+			"startDate":     subscriptionRequest.StartDate,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 		},
 	})
 }
@@ -2371,6 +2278,7 @@ func (spc *ServiceProviderSubscriptionController) GetServiceProviderSponsorshipR
 						"status":        "failed",
 						"processedAt":   time.Now(),
 					}})
+				NewSponsorshipSubscriptionController(spc.DB).releaseSponsorshipRequest(ctx, sponsorshipRequest)
 			}
 		}
 	}
@@ -2598,6 +2506,7 @@ func (spc *ServiceProviderSubscriptionController) HandleWhishSponsorshipPaymentS
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		NewSponsorshipSubscriptionController(spc.DB).releaseSponsorshipRequest(ctx, subscriptionRequest)
 		log.Printf("==========================================")
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
@@ -2699,6 +2608,7 @@ func (spc *ServiceProviderSubscriptionController) HandleWhishSponsorshipPaymentF
 	if err == nil {
 		log.Printf("   Request ID: %s", subscriptionRequest.ID.Hex())
 		log.Printf("   Entity: %s (%s)", subscriptionRequest.EntityName, subscriptionRequest.EntityType)
+		NewSponsorshipSubscriptionController(spc.DB).releaseSponsorshipRequest(ctx, subscriptionRequest)
 	}
 
 	_, err = requestCollection.UpdateOne(ctx,
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sponsoredUser is a user listing entry labelled with its sponsored placement
+type sponsoredUser struct {
+	models.User
+	Sponsored      bool   `json:"sponsored"`
+	SponsoredLabel string `json:"sponsoredLabel,omitempty"`
+	Placement      string `json:"placement"`
+}
+
+// sponsoredWholesaler is a wholesaler listing entry labelled with its sponsored placement
+type sponsoredWholesaler struct {
+	models.Wholesaler
+	Sponsored      bool   `json:"sponsored"`
+	SponsoredLabel string `json:"sponsoredLabel,omitempty"`
+	Placement      string `json:"placement"`
+}
+
+// sponsoredListingKey identifies a listing and its category or region filter, so
+// sponsors rotate separately in every category and region
+func sponsoredListingKey(listing string, filters ...string) string {
+	parts := []string{listing}
+	for _, f := range filters {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			parts = append(parts, f)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// matchesListingFilter reports whether value matches an optional listing filter
+func matchesListingFilter(filter, value string) bool {
+	return filter == "" || strings.EqualFold(strings.TrimSpace(filter), strings.TrimSpace(value))
+}
+
+// hasSponsoredBranch reports whether any branch of a company or wholesaler is sponsored
+func hasSponsoredBranch(branches []models.Branch) bool {
+	for _, branch := range branches {
+		if branch.Sponsorship {
+			return true
+		}
+	}
+	return false
+}
+
+// rankSponsoredEntries orders map listing entries with sponsors pinned or boosted,
+// labels every entry with its placement and records the sponsored impressions
+func rankSponsoredEntries(db *mongo.Client, listingKey string, entries []map[string]interface{}, candidates []utils.SponsoredCandidate) []map[string]interface{} {
+	ranked := utils.RankSponsored(listingKey, candidates)
+	result := make([]map[string]interface{}, 0, len(entries))
+	for _, p := range ranked {
+		entry := entries[p.Index]
+		for k, v := range utils.SponsoredLabels(candidates[p.Index].Sponsored, p.Placement) {
+			entry[k] = v
+		}
+		result = append(result, entry)
+	}
+	go utils.RecordSponsoredImpressions(db, candidates, ranked)
+	return result
+}
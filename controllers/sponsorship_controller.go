@@ -77,17 +77,18 @@ func (sc *SponsorshipController) CreateSponsorship(c echo.Context) error {
 
 	// Create sponsorship with enhanced duration handling
 	sponsorship := models.Sponsorship{
-		Title:        req.Title,
-		Price:        req.Price,
-		Duration:     req.Duration,
-		DurationInfo: durationInfo,
-		Discount:     req.Discount,
-		UsedCount:    0,
-		StartDate:    req.StartDate,
-		EndDate:      req.EndDate,
-		CreatedBy:    adminObjectID,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		Title:            req.Title,
+		Price:            req.Price,
+		Duration:         req.Duration,
+		DurationInfo:     durationInfo,
+		Discount:         req.Discount,
+		UsedCount:        0,
+		MaxSubscriptions: req.MaxSubscriptions,
+		StartDate:        req.StartDate,
+		EndDate:          req.EndDate,
+		CreatedBy:        adminObjectID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
 	}
 
 	// Insert into database
@@ -164,17 +165,18 @@ func (sc *SponsorshipController) CreateServiceProviderSponsorship(c echo.Context
 
 	// Create sponsorship with enhanced duration handling and service provider specific title
 	sponsorship := models.Sponsorship{
-		Title:        "Service Provider: " + req.Title,
-		Price:        req.Price,
-		Duration:     req.Duration,
-		DurationInfo: durationInfo,
-		Discount:     req.Discount,
-		UsedCount:    0,
-		StartDate:    req.StartDate,
-		EndDate:      req.EndDate,
-		CreatedBy:    adminObjectID,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		Title:            "Service Provider: " + req.Title,
+		Price:            req.Price,
+		Duration:         req.Duration,
+		DurationInfo:     durationInfo,
+		Discount:         req.Discount,
+		UsedCount:        0,
+		MaxSubscriptions: req.MaxSubscriptions,
+		StartDate:        req.StartDate,
+		EndDate:          req.EndDate,
+		CreatedBy:        adminObjectID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
 	}
 
 	// Insert into database
@@ -251,17 +253,18 @@ func (sc *SponsorshipController) CreateCompanyWholesalerSponsorship(c echo.Conte
 
 	// Create sponsorship with enhanced duration handling and company/wholesaler specific title
 	sponsorship := models.Sponsorship{
-		Title:        "Company/Wholesaler: " + req.Title,
-		Price:        req.Price,
-		Duration:     req.Duration,
-		DurationInfo: durationInfo,
-		Discount:     req.Discount,
-		UsedCount:    0,
-		StartDate:    req.StartDate,
-		EndDate:      req.EndDate,
-		CreatedBy:    adminObjectID,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		Title:            "Company/Wholesaler: " + req.Title,
+		Price:            req.Price,
+		Duration:         req.Duration,
+		DurationInfo:     durationInfo,
+		Discount:         req.Discount,
+		UsedCount:        0,
+		MaxSubscriptions: req.MaxSubscriptions,
+		StartDate:        req.StartDate,
+		EndDate:          req.EndDate,
+		CreatedBy:        adminObjectID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
 	}
 
 	// Insert into database
@@ -473,6 +476,9 @@ func (sc *SponsorshipController) UpdateSponsorship(c echo.Context) error {
 	if req.Discount != nil {
 		update["discount"] = *req.Discount
 	}
+	if req.MaxSubscriptions != nil {
+		update["maxSubscriptions"] = *req.MaxSubscriptions
+	}
 	if req.StartDate != nil {
 		update["startDate"] = *req.StartDate
 	}
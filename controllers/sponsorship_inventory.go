@@ -0,0 +1,561 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sponsorshipSlotRefusal is the answer to a sponsorship request that could not
+// get a sponsor place, left to the caller to render in its own response style
+type sponsorshipSlotRefusal struct {
+	Status  int
+	Message string
+	Data    map[string]interface{}
+}
+
+// sponsorshipSlotRequest describes the sponsor place a new request asks for
+type sponsorshipSlotRequest struct {
+	Sponsorship models.Sponsorship
+	EntityType  string
+	EntityID    primitive.ObjectID
+	EntityName  string
+	RequestID   primitive.ObjectID
+	RequestedBy primitive.ObjectID // Owner notified from the waiting list, if any
+	Options     models.SponsorshipSlotOptions
+	HoldFor     time.Duration
+}
+
+// reserveRequestSlot holds a sponsor place for a new sponsorship request. When
+// no place can be had it returns a refusal instead: sold out, the next start
+// the client may accept, or the request's place on the waiting list.
+func (ssc *SponsorshipSubscriptionController) reserveRequestSlot(ctx context.Context, r sponsorshipSlotRequest) (*models.SponsorshipSlot, *sponsorshipSlotRefusal, error) {
+	entityType := ssc.normalizeEntityType(r.EntityType)
+	reservation := utils.SponsorshipReservation{
+		Sponsorship:    r.Sponsorship,
+		EntityType:     entityType,
+		EntityID:       r.EntityID,
+		RequestID:      r.RequestID,
+		AcceptNextSlot: r.Options.AcceptNextSlot,
+		HoldFor:        r.HoldFor,
+	}
+	if r.Options.StartDate != nil {
+		if r.Options.StartDate.After(r.Sponsorship.EndDate) {
+			return nil, &sponsorshipSlotRefusal{
+				Status:  http.StatusBadRequest,
+				Message: "Start date is after the sponsorship ends",
+			}, nil
+		}
+		reservation.StartDate = *r.Options.StartDate
+	}
+
+	slot, err := utils.ReserveSponsorshipSlot(ctx, ssc.DB.Client(), reservation)
+	var unavailable *utils.SponsorshipUnavailableError
+	if !errors.As(err, &unavailable) {
+		return slot, nil, err
+	}
+
+	if unavailable.SoldOut {
+		return nil, &sponsorshipSlotRefusal{
+			Status:  http.StatusConflict,
+			Message: "This sponsorship is sold out",
+			Data:    map[string]interface{}{"soldOut": true},
+		}, nil
+	}
+
+	if r.Options.JoinWaitlist {
+		entry, ahead, err := utils.JoinSponsorshipWaitlist(ctx, ssc.DB.Client(), r.Sponsorship.ID, entityType, r.EntityID, r.EntityName, r.RequestedBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &sponsorshipSlotRefusal{
+			Status:  http.StatusAccepted,
+			Message: "All sponsor places in this category and governorate are taken. You have been added to the waiting list.",
+			Data: map[string]interface{}{
+				"waitlistEntry": entry,
+				"position":      ahead + 1,
+				"nextStartDate": unavailable.NextStartDate,
+			},
+		}, nil
+	}
+
+	return nil, &sponsorshipSlotRefusal{
+		Status:  http.StatusConflict,
+		Message: fmt.Sprintf("All sponsor places in this category and governorate are taken. The next one starts on %s.", unavailable.NextStartDate.Format("2006-01-02")),
+		Data: map[string]interface{}{
+			"category":      unavailable.Category,
+			"governorate":   unavailable.Governorate,
+			"maxSponsors":   unavailable.MaxSponsors,
+			"nextStartDate": unavailable.NextStartDate,
+			"hint":          "Send startDate or acceptNextSlot to book the next place, or joinWaitlist to be notified when one frees up",
+		},
+	}, nil
+}
+
+// releaseSponsorshipRequest gives back the sponsor place and promo code of a
+// request that will not go ahead
+func (ssc *SponsorshipSubscriptionController) releaseSponsorshipRequest(ctx context.Context, request models.SponsorshipSubscriptionRequest) {
+	utils.ReleasePromoCode(ctx, ssc.DB.Client(), models.PromoCheckoutSponsorship, request.ID)
+	if request.SlotID.IsZero() {
+		return
+	}
+	if err := utils.ReleaseSponsorshipSlot(ctx, ssc.DB.Client(), request.SlotID); err != nil {
+		log.Printf("Failed to release sponsorship slot %s of request %s: %v", request.SlotID.Hex(), request.ID.Hex(), err)
+	}
+}
+
+// bookRequestSlot books the place held by a paid or approved request. If the
+// hold lapsed in the meantime the next free place is booked instead, even if the
+// sponsorship has sold out since, because the entity has already paid for it.
+func (ssc *SponsorshipSubscriptionController) bookRequestSlot(ctx context.Context, request models.SponsorshipSubscriptionRequest, sponsorship models.Sponsorship) (*models.SponsorshipSlot, error) {
+	slot, err := utils.ConfirmSponsorshipSlot(ctx, ssc.DB.Client(), request.SlotID)
+	if err != utils.ErrSponsorshipSlotLost {
+		return slot, err
+	}
+
+	log.Printf("Sponsorship slot of request %s lapsed before payment, booking the next free one", request.ID.Hex())
+	slot, err = utils.ReserveSponsorshipSlot(ctx, ssc.DB.Client(), utils.SponsorshipReservation{
+		Sponsorship:    sponsorship,
+		EntityType:     ssc.normalizeEntityType(request.EntityType),
+		EntityID:       request.EntityID,
+		RequestID:      request.ID,
+		StartDate:      request.StartDate,
+		AcceptNextSlot: true,
+		Paid:           true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ssc.DB.Collection("sponsorship_subscription_requests").UpdateOne(ctx,
+		bson.M{"_id": request.ID},
+		bson.M{"$set": bson.M{"slotId": slot.ID, "startDate": slot.StartDate}},
+	); err != nil {
+		log.Printf("Failed to update sponsorship slot of request %s: %v", request.ID.Hex(), err)
+	}
+	return utils.ConfirmSponsorshipSlot(ctx, ssc.DB.Client(), slot.ID)
+}
+
+// hasRunningSponsorship reports whether an entity has an active subscription that has started
+func (ssc *SponsorshipSubscriptionController) hasRunningSponsorship(ctx context.Context, entityType string, entityID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	count, err := ssc.DB.Collection("sponsorship_subscriptions").CountDocuments(ctx, bson.M{
+		"entityType": ssc.normalizeEntityType(entityType),
+		"entityId":   entityID,
+		"status":     "active",
+		"startDate":  bson.M{"$lte": now},
+		"endDate":    bson.M{"$gt": now},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// ProcessSponsorshipSchedule releases lapsed payment holds, starts scheduled
+// subscriptions that are due, expires ended ones and tells the waiting list
+// about places that freed up. It is meant to run periodically.
+func (ssc *SponsorshipSubscriptionController) ProcessSponsorshipSchedule() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if released, err := utils.ReleaseExpiredSponsorshipHolds(ctx, ssc.DB.Client()); err != nil {
+		log.Printf("Failed to release expired sponsorship holds: %v", err)
+	} else if released > 0 {
+		log.Printf("Released %d expired sponsorship holds", released)
+	}
+
+	subscriptions := ssc.DB.Collection("sponsorship_subscriptions")
+	now := time.Now()
+
+	// Start scheduled subscriptions that are due
+	cursor, err := subscriptions.Find(ctx, bson.M{
+		"status":    models.SponsorshipSubscriptionScheduled,
+		"startDate": bson.M{"$lte": now},
+	})
+	if err != nil {
+		log.Printf("Failed to load scheduled sponsorship subscriptions: %v", err)
+		return
+	}
+	var due []models.SponsorshipSubscription
+	if err := cursor.All(ctx, &due); err != nil {
+		log.Printf("Failed to decode scheduled sponsorship subscriptions: %v", err)
+		return
+	}
+	for _, sub := range due {
+		if _, err := subscriptions.UpdateOne(ctx,
+			bson.M{"_id": sub.ID, "status": models.SponsorshipSubscriptionScheduled},
+			bson.M{"$set": bson.M{"status": "active", "updatedAt": now}},
+		); err != nil {
+			log.Printf("Failed to start sponsorship subscription %s: %v", sub.ID.Hex(), err)
+			continue
+		}
+		if err := ssc.updateEntitySponsorshipStatus(ctx, sub.EntityType, sub.EntityID, true); err != nil {
+			log.Printf("Failed to set sponsorship of %s %s: %v", sub.EntityType, sub.EntityID.Hex(), err)
+		}
+	}
+
+	// Expire subscriptions that have ended
+	cursor, err = subscriptions.Find(ctx, bson.M{
+		"status":  "active",
+		"endDate": bson.M{"$lte": now},
+	})
+	if err != nil {
+		log.Printf("Failed to load ended sponsorship subscriptions: %v", err)
+		return
+	}
+	var ended []models.SponsorshipSubscription
+	if err := cursor.All(ctx, &ended); err != nil {
+		log.Printf("Failed to decode ended sponsorship subscriptions: %v", err)
+		return
+	}
+	for _, sub := range ended {
+		if _, err := subscriptions.UpdateOne(ctx,
+			bson.M{"_id": sub.ID, "status": "active"},
+			bson.M{"$set": bson.M{"status": "expired", "updatedAt": now}},
+		); err != nil {
+			log.Printf("Failed to expire sponsorship subscription %s: %v", sub.ID.Hex(), err)
+			continue
+		}
+		if err := ssc.updateEntitySponsorshipStatus(ctx, sub.EntityType, sub.EntityID, false); err != nil {
+			log.Printf("Failed to clear sponsorship of %s %s: %v", sub.EntityType, sub.EntityID.Hex(), err)
+		}
+	}
+
+	ssc.notifySponsorshipWaitlist(ctx)
+}
+
+// notifySponsorshipWaitlist tells waiting entities, oldest first, that a sponsor
+// place is free in their category and governorate
+func (ssc *SponsorshipSubscriptionController) notifySponsorshipWaitlist(ctx context.Context) {
+	waitlist := ssc.DB.Collection("sponsorship_waitlist")
+	cursor, err := waitlist.Find(ctx,
+		bson.M{"status": models.SponsorshipWaitlistWaiting},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		log.Printf("Failed to load sponsorship waiting list: %v", err)
+		return
+	}
+	var entries []models.SponsorshipWaitlistEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		log.Printf("Failed to decode sponsorship waiting list: %v", err)
+		return
+	}
+
+	// Places freed per area, each offered to the next entries in line
+	free := map[string]int{}
+	for _, entry := range entries {
+		area := entry.Category + "|" + entry.Governorate
+		if _, seen := free[area]; !seen {
+			var sponsorship models.Sponsorship
+			if err := ssc.DB.Collection("sponsorships").FindOne(ctx, bson.M{"_id": entry.SponsorshipID}).Decode(&sponsorship); err != nil {
+				continue
+			}
+			availability, err := utils.GetSponsorshipAvailability(ctx, ssc.DB.Client(), sponsorship, entry.EntityType, entry.EntityID)
+			if err != nil {
+				log.Printf("Failed to check sponsorship availability for %s: %v", area, err)
+				continue
+			}
+			free[area] = 0
+			if availability.AvailableNow && availability.MaxSponsors > 0 {
+				// Entries told recently still have their chance to book
+				recent, err := waitlist.CountDocuments(ctx, bson.M{
+					"category":    entry.Category,
+					"governorate": entry.Governorate,
+					"status":      models.SponsorshipWaitlistNotified,
+					"notifiedAt":  bson.M{"$gt": time.Now().Add(-utils.SponsorshipPaymentHold())},
+				})
+				if err != nil {
+					log.Printf("Failed to count notified sponsorship waiting list entries for %s: %v", area, err)
+					continue
+				}
+				free[area] = availability.MaxSponsors - availability.ActiveSponsors - int(recent)
+			}
+		}
+		if free[area] <= 0 {
+			continue
+		}
+		free[area]--
+
+		now := time.Now()
+		if _, err := waitlist.UpdateOne(ctx,
+			bson.M{"_id": entry.ID, "status": models.SponsorshipWaitlistWaiting},
+			bson.M{"$set": bson.M{"status": models.SponsorshipWaitlistNotified, "notifiedAt": now}},
+		); err != nil {
+			log.Printf("Failed to update sponsorship waiting list entry %s: %v", entry.ID.Hex(), err)
+			continue
+		}
+		if entry.RequestedBy.IsZero() {
+			continue
+		}
+
+		title := "Sponsorship place available"
+		message := fmt.Sprintf("A sponsor place is now free for %s. Book it before someone else does.", entry.EntityName)
+		data := map[string]interface{}{
+			"sponsorshipId": entry.SponsorshipID.Hex(),
+			"entityType":    entry.EntityType,
+			"entityId":      entry.EntityID.Hex(),
+		}
+		if err := utils.SaveNotification(ssc.DB.Client(), entry.RequestedBy, title, message, "sponsorship_available", data); err != nil {
+			log.Printf("Failed to save sponsorship waiting list notification: %v", err)
+		}
+		if err := utils.SendFCMNotificationToUser(ssc.DB.Client(), entry.RequestedBy, title, message, data); err != nil {
+			log.Printf("Failed to send sponsorship waiting list notification: %v", err)
+		}
+	}
+}
+
+// GetSponsorshipAvailability tells an entity whether it can be sponsored now in
+// its category and governorate, or when the next place frees up
+func (ssc *SponsorshipSubscriptionController) GetSponsorshipAvailability(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sponsorshipID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Invalid sponsorship ID format",
+		})
+	}
+	entityID, err := primitive.ObjectIDFromHex(c.QueryParam("entityId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Invalid entity ID format",
+		})
+	}
+	entityType := ssc.normalizeEntityType(c.QueryParam("entityType"))
+
+	var sponsorship models.Sponsorship
+	err = ssc.DB.Collection("sponsorships").FindOne(ctx, bson.M{"_id": sponsorshipID}).Decode(&sponsorship)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"success": false,
+				"message": "Sponsorship not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to retrieve sponsorship",
+			"error":   err.Error(),
+		})
+	}
+
+	availability, err := utils.GetSponsorshipAvailability(ctx, ssc.DB.Client(), sponsorship, entityType, entityID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"success": false,
+				"message": "Entity not found",
+			})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Failed to check availability",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"message":      "Sponsorship availability retrieved successfully",
+		"availability": availability,
+	})
+}
+
+// GetSponsorshipInventoryCaps lists the sponsor place caps (admin only)
+func (ssc *SponsorshipSubscriptionController) GetSponsorshipInventoryCaps(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "category", Value: 1}, {Key: "governorate", Value: 1}})
+	cursor, err := ssc.DB.Collection("sponsorship_inventory_caps").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to retrieve sponsorship caps",
+			"error":   err.Error(),
+		})
+	}
+	caps := []models.SponsorshipInventoryCap{}
+	if err := cursor.All(ctx, &caps); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to decode sponsorship caps",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Sponsorship caps retrieved successfully",
+		"caps":    caps,
+	})
+}
+
+// SetSponsorshipInventoryCap creates or replaces the cap of a category and governorate (admin only)
+func (ssc *SponsorshipSubscriptionController) SetSponsorshipInventoryCap(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.SponsorshipInventoryCapRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	req.Governorate = strings.ToLower(strings.TrimSpace(req.Governorate))
+	if req.Category == "" && req.Governorate == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Category or governorate is required",
+		})
+	}
+	if req.MaxSponsors < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "maxSponsors cannot be negative",
+		})
+	}
+
+	now := time.Now()
+	var inventoryCap models.SponsorshipInventoryCap
+	err := ssc.DB.Collection("sponsorship_inventory_caps").FindOneAndUpdate(ctx,
+		bson.M{"category": req.Category, "governorate": req.Governorate},
+		bson.M{
+			"$set":         bson.M{"maxSponsors": req.MaxSponsors, "updatedAt": now},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&inventoryCap)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to save sponsorship cap",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Sponsorship cap saved successfully",
+		"cap":     inventoryCap,
+	})
+}
+
+// DeleteSponsorshipInventoryCap removes a cap (admin only)
+func (ssc *SponsorshipSubscriptionController) DeleteSponsorshipInventoryCap(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	capID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "Invalid cap ID format",
+		})
+	}
+
+	result, err := ssc.DB.Collection("sponsorship_inventory_caps").DeleteOne(ctx, bson.M{"_id": capID})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to delete sponsorship cap",
+			"error":   err.Error(),
+		})
+	}
+	if result.DeletedCount == 0 {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"success": false,
+			"message": "Sponsorship cap not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Sponsorship cap deleted successfully",
+	})
+}
+
+// GetSponsorshipWaitlist lists the sponsorship waiting list, oldest first (admin only)
+func (ssc *SponsorshipSubscriptionController) GetSponsorshipWaitlist(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if status := c.QueryParam("status"); status != "" {
+		filter["status"] = status
+	}
+	if category := c.QueryParam("category"); category != "" {
+		filter["category"] = strings.ToLower(strings.TrimSpace(category))
+	}
+	if governorate := c.QueryParam("governorate"); governorate != "" {
+		filter["governorate"] = strings.ToLower(strings.TrimSpace(governorate))
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	collection := ssc.DB.Collection("sponsorship_waitlist")
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to count waiting list",
+			"error":   err.Error(),
+		})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to retrieve waiting list",
+			"error":   err.Error(),
+		})
+	}
+	entries := []models.SponsorshipWaitlistEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to decode waiting list",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Sponsorship waiting list retrieved successfully",
+		"entries": entries,
+		"pagination": map[string]interface{}{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		},
+	})
+}
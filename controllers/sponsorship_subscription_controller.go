@@ -10,6 +10,7 @@ import (
 
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/services"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -88,7 +89,7 @@ func (ssc *SponsorshipSubscriptionController) CreateSponsorshipSubscriptionReque
 		"sponsorshipId": req.SponsorshipID,
 		"entityId":      req.EntityID,
 		"entityType":    req.EntityType,
-		"status":        bson.M{"$in": []string{"active", "pending"}},
+		"status":        bson.M{"$in": []string{"active", "pending", models.SponsorshipSubscriptionScheduled}},
 	}).Decode(&existingSubscription)
 	if err == nil {
 		return c.JSON(http.StatusConflict, map[string]interface{}{
@@ -115,6 +116,7 @@ func (ssc *SponsorshipSubscriptionController) CreateSponsorshipSubscriptionReque
 
 	// Create subscription request
 	subscriptionRequest := models.SponsorshipSubscriptionRequest{
+		ID:              primitive.NewObjectID(),
 		SponsorshipID:   req.SponsorshipID,
 		EntityType:      req.EntityType,
 		EntityID:        req.EntityID,
@@ -125,10 +127,37 @@ func (ssc *SponsorshipSubscriptionController) CreateSponsorshipSubscriptionReque
 		ManagerApproved: nil,
 	}
 
+	// Hold a sponsor place until an admin processes the request
+	slot, refusal, err := ssc.reserveRequestSlot(context.Background(), sponsorshipSlotRequest{
+		Sponsorship: sponsorship,
+		EntityType:  req.EntityType,
+		EntityID:    req.EntityID,
+		EntityName:  entityName,
+		RequestID:   subscriptionRequest.ID,
+		Options:     req.SponsorshipSlotOptions,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": "Failed to reserve sponsorship place",
+			"error":   err.Error(),
+		})
+	}
+	if refusal != nil {
+		return c.JSON(refusal.Status, map[string]interface{}{
+			"success": refusal.Status == http.StatusAccepted,
+			"message": refusal.Message,
+			"data":    refusal.Data,
+		})
+	}
+	subscriptionRequest.SlotID = slot.ID
+	subscriptionRequest.StartDate = slot.StartDate
+
 	// Insert into database
 	requestCollection := ssc.DB.Collection("sponsorship_subscription_requests")
-	result, err := requestCollection.InsertOne(context.Background(), subscriptionRequest)
+	_, err = requestCollection.InsertOne(context.Background(), subscriptionRequest)
 	if err != nil {
+		ssc.releaseSponsorshipRequest(context.Background(), subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"success": false,
 			"message": "Failed to create subscription request",
@@ -136,8 +165,6 @@ func (ssc *SponsorshipSubscriptionController) CreateSponsorshipSubscriptionReque
 		})
 	}
 
-	subscriptionRequest.ID = result.InsertedID.(primitive.ObjectID)
-
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"success": true,
 		"message": "Sponsorship subscription request created successfully",
@@ -384,6 +411,9 @@ func (ssc *SponsorshipSubscriptionController) ProcessSponsorshipSubscriptionRequ
 			log.Printf("Skipping admin wallet addition - payment already processed and added to wallet")
 		}
 	} else {
+		// Give the held sponsor place back to the category and governorate
+		ssc.releaseSponsorshipRequest(context.Background(), subscriptionRequest)
+
 		// If rejected, update entity sponsorship status to false
 		err = ssc.updateEntitySponsorshipStatus(context.Background(), subscriptionRequest.EntityType, subscriptionRequest.EntityID, false)
 		if err != nil {
@@ -1288,6 +1318,22 @@ func (ssc *SponsorshipSubscriptionController) createActiveSubscription(ctx conte
 	// Calculate start and end dates
 	startDate := time.Now()
 	endDate := startDate.AddDate(0, 0, sponsorship.Duration)
+	status := "active"
+
+	// Requests that hold a sponsor place run for the dates of their place
+	if !request.SlotID.IsZero() {
+		slot, err := ssc.bookRequestSlot(ctx, request, sponsorship)
+		if err != nil {
+			return err
+		}
+		startDate, endDate = slot.StartDate, slot.EndDate
+		if startDate.After(time.Now()) {
+			status = models.SponsorshipSubscriptionScheduled
+		}
+	}
+
+	// The request was paid or approved and has its place, so its promo code is used
+	utils.RedeemPromoCode(ctx, ssc.DB.Client(), models.PromoCheckoutSponsorship, request.ID)
 
 	// Create active subscription
 	subscription := models.SponsorshipSubscription{
@@ -1296,7 +1342,7 @@ func (ssc *SponsorshipSubscriptionController) createActiveSubscription(ctx conte
 		EntityID:        request.EntityID,
 		StartDate:       startDate,
 		EndDate:         endDate,
-		Status:          "active",
+		Status:          status,
 		AutoRenew:       false,
 		DiscountApplied: sponsorship.Discount,
 		CreatedAt:       time.Now(),
@@ -1310,6 +1356,11 @@ func (ssc *SponsorshipSubscriptionController) createActiveSubscription(ctx conte
 		return err
 	}
 
+	// The sale was already counted when the place was reserved
+	if !request.SlotID.IsZero() {
+		return nil
+	}
+
 	// Update sponsorship used count
 	_, err = sponsorshipCollection.UpdateOne(
 		ctx,
@@ -1339,6 +1390,16 @@ func (ssc *SponsorshipSubscriptionController) updateEntitySponsorshipStatus(ctx
 	// Normalize entity type to handle both camelCase and snake_case formats
 	normalizedEntityType := ssc.normalizeEntityType(entityType)
 
+	// The flag follows the running subscriptions: one scheduled for later does not
+	// set it yet, and ending one does not clear it while another still runs
+	running, err := ssc.hasRunningSponsorship(ctx, normalizedEntityType, entityID)
+	if err != nil {
+		return err
+	}
+	if running != hasSponsorship {
+		return nil
+	}
+
 	switch normalizedEntityType {
 	case "service_provider":
 		// Update service provider sponsorship status
@@ -16,6 +16,7 @@ import (
 
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -560,57 +561,18 @@ func (sc *SubscriptionController) ProcessSubscriptionRequest(c echo.Context) err
 				log.Printf("Failed to update user status: %v", err)
 			}
 			// Commission calculation logic
-			// Check if company was created by a salesperson or by itself (user signup)
-			if company.CreatedBy == company.UserID {
-				log.Printf("DEBUG: Company was created by user signup, adding subscription price to admin wallet")
-				// Add subscription price directly to admin wallet (no commission calculation needed)
-				log.Printf("Subscription income added to admin wallet: $%.2f from company '%s' (ID: %s) - User signup subscription",
-					plan.Price, company.BusinessName, company.ID.Hex())
-			} else if !company.CreatedBy.IsZero() {
-				// Company was created by a salesperson, proceed with commission calculation
-				salespersonID := company.CreatedBy
-				var salesperson models.Salesperson
-				err = sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": salespersonID}).Decode(&salesperson)
-				if err == nil {
-					salesManagerID := salesperson.SalesManagerID
-					var salesManager models.SalesManager
-					err = sc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesManagerID}).Decode(&salesManager)
-					if err == nil {
-						planPrice := plan.Price
-						salespersonPercent := salesperson.CommissionPercent
-						salesManagerPercent := salesManager.CommissionPercent
-
-						// Calculate commissions correctly
-						// Salesperson gets their percentage directly from the plan price
-						salespersonCommission := planPrice * salespersonPercent / 100.0
-						// Sales manager gets their percentage directly from the plan price
-						salesManagerCommission := planPrice * salesManagerPercent / 100.0
-
-						// Insert commission document using Commission model
-						commission := models.Commission{
-							ID:                            primitive.NewObjectID(),
-							SubscriptionID:                subscription.ID,
-							CompanyID:                     company.ID,
-							PlanID:                        plan.ID,
-							PlanPrice:                     planPrice,
-							SalespersonID:                 salesperson.ID,
-							SalespersonCommission:         salespersonCommission,
-							SalespersonCommissionPercent:  salespersonPercent,
-							SalesManagerID:                salesManager.ID,
-							SalesManagerCommission:        salesManagerCommission,
-							SalesManagerCommissionPercent: salesManagerPercent,
-							CreatedAt:                     time.Now(),
-							Paid:                          false,
-							PaidAt:                        nil,
-						}
-						_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-						if err != nil {
-							log.Printf("Failed to insert commission: %v", err)
-						} else {
-							log.Printf("Commission inserted successfully - Plan Price: $%.2f, Sales Manager Commission: $%.2f, Salesperson Commission: $%.2f",
-								planPrice, salesManagerCommission, salespersonCommission)
-						}
-					}
+			// Only proceed if company was created by a salesperson
+			if company.CreatedBy != company.UserID {
+				_, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+					SubscriptionID: subscription.ID,
+					EntityType:     "company",
+					EntityID:       company.ID,
+					EntityName:     company.BusinessName,
+					CreatedBy:      company.CreatedBy,
+					Plan:           plan,
+				})
+				if err != nil {
+					log.Printf("Failed to apply commission: %v", err)
 				}
 			}
 			// Send email notification to company
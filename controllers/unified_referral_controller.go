@@ -2,11 +2,7 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"fmt"
-	"image/png"
 	"log"
 	"net/http"
 	"time"
@@ -14,8 +10,6 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/utils"
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/qr"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -217,16 +211,35 @@ func (rc *UnifiedReferralController) findReferrerByCode(ctx context.Context, ref
 
 // processReferral handles the actual referral processing
 func (rc *UnifiedReferralController) processReferral(c echo.Context, ctx context.Context, currentUser models.User, referrerEntity *ReferralEntity, userObjID primitive.ObjectID) error {
-	// All user types get 5 points for any referral
-	pointsToAdd := rc.calculateReferralPoints(referrerEntity.Type, currentUser.UserType)
+	log.Printf("Processing referral - Referrer: %s, Referee: %s",
+		referrerEntity.Type, currentUser.UserType)
 
-	log.Printf("Processing referral - Referrer: %s, Referee: %s, Points: %d",
-		referrerEntity.Type, currentUser.UserType, pointsToAdd)
+	// The referee is rewarded on the account it manages, if any
+	refereeType, refereeID, err := utils.PointsAccountForUser(ctx, rc.DB, currentUser.UserType, userObjID)
+	if err != nil {
+		refereeType, refereeID = models.PointsAccountUser, userObjID
+	}
 
-	// Update the referrer's points and add to referrals list
-	err := rc.updateReferrerPoints(ctx, referrerEntity, userObjID, pointsToAdd)
+	// Reward by the referral rules and add to the referrer's referrals list
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.DB, utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  referrerEntity.Type,
+		ReferrerID:    referrerEntity.ID,
+		RefereeType:   refereeType,
+		RefereeID:     refereeID,
+		RefereeUserID: &userObjID,
+		Fingerprint:   referralFingerprint(c, ""),
+		Code:          referrerEntity.ReferralCode,
+		Channel:       models.ReferralChannelCode,
+	})
+	if err == utils.ErrReferralEventRecorded {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "This referral code has already been used",
+		})
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to update referrer points: %v", err)
+		log.Printf("ERROR: Failed to apply referral: %v", err)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to update referrer",
@@ -265,52 +278,12 @@ func (rc *UnifiedReferralController) processReferral(c echo.Context, ctx context
 		Data: map[string]interface{}{
 			"referrerID":      referrerEntity.ID,
 			"referrerType":    referrerEntity.Type,
-			"pointsAdded":     pointsToAdd,
+			"pointsAdded":     outcome.ReferrerPoints(),
 			"newReferralCode": currentUser.ReferralCode,
 		},
 	})
 }
 
-// calculateReferralPoints determines points based on referrer and referee types
-func (rc *UnifiedReferralController) calculateReferralPoints(referrerType, refereeType string) int {
-	// All user types get 5 points for any referral
-	return 5
-}
-
-// updateReferrerPoints updates the referrer's points in the appropriate collection
-func (rc *UnifiedReferralController) updateReferrerPoints(ctx context.Context, referrerEntity *ReferralEntity, refereeID primitive.ObjectID, pointsToAdd int) error {
-	update := bson.M{
-		"$inc":  bson.M{"points": pointsToAdd},
-		"$push": bson.M{"referrals": refereeID},
-		"$set":  bson.M{"updatedAt": time.Now()},
-	}
-
-	switch referrerEntity.Type {
-	case "user":
-		userCollection := rc.DB.Database("barrim").Collection("users")
-		_, err := userCollection.UpdateByID(ctx, referrerEntity.ID, update)
-		return err
-
-	case "company":
-		companyCollection := rc.DB.Database("barrim").Collection("companies")
-		_, err := companyCollection.UpdateByID(ctx, referrerEntity.ID, update)
-		return err
-
-	case "wholesaler":
-		wholesalerCollection := rc.DB.Database("barrim").Collection("wholesalers")
-		_, err := wholesalerCollection.UpdateByID(ctx, referrerEntity.ID, update)
-		return err
-
-	case "serviceProvider":
-		serviceProviderCollection := rc.DB.Database("barrim").Collection("serviceProviders")
-		_, err := serviceProviderCollection.UpdateByID(ctx, referrerEntity.ID, update)
-		return err
-
-	default:
-		return fmt.Errorf("unknown referrer type: %s", referrerEntity.Type)
-	}
-}
-
 // GetReferralData fetches referral statistics for the current user
 func (rc *UnifiedReferralController) GetReferralData(c echo.Context) error {
 	log.Printf("=== GETTING UNIFIED REFERRAL DATA ===")
@@ -429,7 +402,7 @@ func (rc *UnifiedReferralController) getReferralDataByType(c echo.Context, ctx c
 		"referralCode":  referralCode,
 		"referralCount": referralCount,
 		"points":        points,
-		"referralLink":  fmt.Sprintf("https://barrim.com/referral?code=%s", referralCode),
+		"referralLink":  utils.ReferralLinkURL(referralCode, models.ReferralSourceLink),
 		"qrCode":        qrCode,
 		"userType":      user.UserType,
 	}
@@ -443,33 +416,9 @@ func (rc *UnifiedReferralController) getReferralDataByType(c echo.Context, ctx c
 	})
 }
 
-// GenerateReferralQRCode creates a QR code image for a referral code
+// GenerateReferralQRCode creates a QR code image of the tracked link of a referral code
 func (rc *UnifiedReferralController) GenerateReferralQRCode(referralCode string) (string, error) {
-	// Create the QR code content
-	content := fmt.Sprintf("https://barrim.com/referral?code=%s", referralCode)
-
-	// Generate the QR code
-	qrCode, err := qr.Encode(content, qr.M, qr.Auto)
-	if err != nil {
-		return "", err
-	}
-
-	// Scale the QR code to a reasonable size (300x300 pixels)
-	qrCode, err = barcode.Scale(qrCode, 300, 300)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert the QR code to a PNG image
-	var buf bytes.Buffer
-	err = png.Encode(&buf, qrCode)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert to base64 for embedding in responses
-	base64QR := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return "data:image/png;base64," + base64QR, nil
+	return utils.ReferralQRCode(referralCode)
 }
 
 // GetReferralQRCode endpoint to get QR code for a referral code
@@ -977,6 +977,12 @@ func (uc *UserController) SearchServiceProviders(c echo.Context) error {
 		})
 	}
 
+	listing, err := uc.rankSponsoredProviders(ctx, collection, filter, providers, page == 1, limit,
+		sponsoredListingKey("service_providers", serviceType, city, country))
+	if err != nil {
+		log.Printf("Failed to rank sponsored service providers: %v", err)
+	}
+
 	// Get total count for pagination info
 	totalCount, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -994,7 +1000,7 @@ func (uc *UserController) SearchServiceProviders(c echo.Context) error {
 		Status:  http.StatusOK,
 		Message: "Service providers retrieved successfully",
 		Data: map[string]interface{}{
-			"serviceProviders": providers,
+			"serviceProviders": listing,
 			"pagination": map[string]interface{}{
 				"totalCount": totalCount,
 				"page":       page,
@@ -1005,6 +1011,85 @@ func (uc *UserController) SearchServiceProviders(c echo.Context) error {
 	})
 }
 
+// rankSponsoredProviders labels a page of service providers with their sponsored
+// placement. On the first page the sponsors matching the search are pinned on top
+// in rotation, whichever page they would organically fall on, and take the place
+// of the last organic entries so the page keeps its size; later pages only boost
+// the sponsors they already hold. If sponsorships cannot be loaded the page is
+// returned unranked along with the error.
+func (uc *UserController) rankSponsoredProviders(ctx context.Context, collection *mongo.Collection, filter bson.M, page []models.User, firstPage bool, limit int, listingKey string) ([]sponsoredUser, error) {
+	unranked := func() []sponsoredUser {
+		listing := make([]sponsoredUser, 0, len(page))
+		for _, u := range page {
+			listing = append(listing, sponsoredUser{User: u, Placement: utils.PlacementOrganic})
+		}
+		return listing
+	}
+
+	sponsors, err := utils.LoadSponsoredProviders(ctx, uc.DB)
+	if err != nil {
+		return unranked(), err
+	}
+
+	entries := page
+	if firstPage {
+		// Pull in every sponsor matching the search so pinned slots rotate among all of them
+		sponsorFilter := bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{"_id": bson.M{"$in": sponsors.UserIDs()}},
+			bson.M{"serviceProviderId": bson.M{"$in": sponsors.ProviderIDs()}},
+		}}}}
+		cursor, err := collection.Find(ctx, sponsorFilter, options.Find().SetProjection(bson.M{"password": 0}))
+		if err != nil {
+			return unranked(), err
+		}
+		var sponsored []models.User
+		err = cursor.All(ctx, &sponsored)
+		cursor.Close(ctx)
+		if err != nil {
+			return unranked(), err
+		}
+
+		onPage := make(map[primitive.ObjectID]bool, len(page))
+		for _, u := range page {
+			onPage[u.ID] = true
+		}
+		entries = append([]models.User{}, page...)
+		for _, u := range sponsored {
+			if !onPage[u.ID] {
+				entries = append(entries, u)
+			}
+		}
+	}
+
+	candidates := make([]utils.SponsoredCandidate, len(entries))
+	for i, u := range entries {
+		providerID, ok := sponsors.Lookup(u)
+		candidates[i] = utils.SponsoredCandidate{EntityType: utils.SponsoredEntityServiceProvider, EntityID: providerID, Sponsored: ok}
+	}
+
+	ranked := utils.RankSponsored(listingKey, candidates)
+	var shown []utils.SponsoredPlacement
+	listing := make([]sponsoredUser, 0, len(page))
+	for _, p := range ranked {
+		if len(listing) == limit {
+			break
+		}
+		// Sponsors fetched for the first page only appear when they win a pinned slot
+		if p.Index >= len(page) && p.Placement != utils.PlacementPinned {
+			continue
+		}
+		entry := sponsoredUser{User: entries[p.Index], Sponsored: candidates[p.Index].Sponsored, Placement: p.Placement}
+		if entry.Sponsored {
+			entry.SponsoredLabel = utils.SponsoredLabel
+		}
+		listing = append(listing, entry)
+		shown = append(shown, p)
+	}
+	go utils.RecordSponsoredImpressions(uc.DB, candidates, shown)
+
+	return listing, nil
+}
+
 // GetCompaniesWithLocations handler returns all companies with location data and branch status
 func (uc *UserController) GetCompaniesWithLocations(c echo.Context) error {
 	// Create a context with timeout
@@ -1542,6 +1627,8 @@ func (uc *UserController) AddBranchToFavorites(c echo.Context) error {
 		})
 	}
 
+	go utils.RecordEntityEvent(uc.DB, utils.SponsoredEntityCompanyBranch, branchID, models.EntityEventFavorite)
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Branch added to favorites successfully",
@@ -1810,6 +1897,8 @@ func (uc *UserController) AddServiceProviderToFavorites(c echo.Context) error {
 		})
 	}
 
+	go utils.RecordEntityEvent(uc.DB, utils.SponsoredEntityServiceProvider, serviceProviderID, models.EntityEventFavorite)
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Service provider added to favorites successfully",
@@ -2270,7 +2359,7 @@ func (uc *UserController) FilterCompaniesAndWholesalers(c echo.Context) error {
 	// Build filter
 	filter := bson.M{
 		"userType": bson.M{"$in": []string{"company", "wholesaler"}},
-		"location": bson.M{
+		"location.point": bson.M{
 			"$near": bson.M{
 				"$geometry": bson.M{
 					"type":        "Point",
@@ -2306,9 +2395,62 @@ func (uc *UserController) FilterCompaniesAndWholesalers(c echo.Context) error {
 		})
 	}
 
+	// Sponsorships are held by the company and wholesaler profiles, not the users
+	userIDs := make([]primitive.ObjectID, 0, len(results))
+	for _, u := range results {
+		userIDs = append(userIDs, u.ID)
+	}
+	type businessRef struct {
+		entityType string
+		id         primitive.ObjectID
+		sponsored  bool
+	}
+	businesses := make(map[primitive.ObjectID]businessRef)
+	for _, owner := range []struct {
+		collection string
+		entityType string
+	}{{"companies", utils.SponsoredEntityCompany}, {"wholesalers", utils.SponsoredEntityWholesaler}} {
+		ownerCursor, err := config.GetCollection(uc.DB, owner.collection).Find(ctx,
+			bson.M{"userId": bson.M{"$in": userIDs}},
+			options.Find().SetProjection(bson.M{"userId": 1, "sponsorship": 1, "branches.sponsorship": 1}),
+		)
+		if err != nil {
+			log.Printf("Failed to load %s sponsorships: %v", owner.collection, err)
+			continue
+		}
+		var owners []models.Company
+		if err := ownerCursor.All(ctx, &owners); err != nil {
+			log.Printf("Failed to decode %s sponsorships: %v", owner.collection, err)
+		}
+		ownerCursor.Close(ctx)
+		for _, o := range owners {
+			businesses[o.UserID] = businessRef{
+				entityType: owner.entityType,
+				id:         o.ID,
+				sponsored:  o.Sponsorship || hasSponsoredBranch(o.Branches),
+			}
+		}
+	}
+
+	candidates := make([]utils.SponsoredCandidate, len(results))
+	for i, u := range results {
+		ref := businesses[u.ID]
+		candidates[i] = utils.SponsoredCandidate{EntityType: ref.entityType, EntityID: ref.id, Sponsored: ref.sponsored}
+	}
+	ranked := utils.RankSponsored(sponsoredListingKey("nearby_businesses", category), candidates)
+	listing := make([]sponsoredUser, 0, len(ranked))
+	for _, p := range ranked {
+		entry := sponsoredUser{User: results[p.Index], Sponsored: candidates[p.Index].Sponsored, Placement: p.Placement}
+		if entry.Sponsored {
+			entry.SponsoredLabel = utils.SponsoredLabel
+		}
+		listing = append(listing, entry)
+	}
+	go utils.RecordSponsoredImpressions(uc.DB, candidates, ranked)
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Filtered results retrieved successfully",
-		Data:    results,
+		Data:    listing,
 	})
 }
@@ -20,6 +20,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type VoucherController struct {
@@ -30,6 +31,99 @@ func NewVoucherController(db *mongo.Database) *VoucherController {
 	return &VoucherController{DB: db}
 }
 
+// parseVoucherDate reads a voucher validity date given as RFC 3339 or as a
+// day; a day given as the end of the validity runs to the end of that day
+func parseVoucherDate(value string, endOfDay bool) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
+
+// validateVoucherLimits checks the stock, per-account and validity limits of a voucher
+func validateVoucherLimits(limits models.VoucherLimits) string {
+	switch {
+	case limits.Stock < 0 || limits.PerAccountLimit < 0 || limits.ValidityDays < 0:
+		return "stock, perAccountLimit and validityDays cannot be negative"
+	case limits.ValidFrom != nil && limits.ValidUntil != nil && limits.ValidUntil.Before(*limits.ValidFrom):
+		return "validUntil must be after validFrom"
+	case limits.MinTier != "" && models.LoyaltyTierRank(limits.MinTier) < 0:
+		return "minTier must be 'bronze', 'silver' or 'gold'"
+	}
+	return ""
+}
+
+// voucherLimitsFromForm reads the optional limits of a voucher form. It also
+// returns the fields that were sent, so that an update only changes those;
+// an empty date clears it.
+func voucherLimitsFromForm(c echo.Context) (models.VoucherLimits, bson.M, string) {
+	var limits models.VoucherLimits
+	fields := bson.M{}
+	form, _ := c.FormParams()
+
+	ints := []struct {
+		key    string
+		target *int
+	}{
+		{"stock", &limits.Stock},
+		{"perAccountLimit", &limits.PerAccountLimit},
+		{"validityDays", &limits.ValidityDays},
+	}
+	for _, field := range ints {
+		if _, ok := form[field.key]; !ok {
+			continue
+		}
+		n, err := strconv.Atoi(form.Get(field.key))
+		if err != nil {
+			return limits, nil, field.key + " must be an integer"
+		}
+		*field.target = n
+		fields[field.key] = n
+	}
+
+	dates := []struct {
+		key      string
+		target   **time.Time
+		endOfDay bool
+	}{
+		{"validFrom", &limits.ValidFrom, false},
+		{"validUntil", &limits.ValidUntil, true},
+	}
+	for _, field := range dates {
+		if _, ok := form[field.key]; !ok {
+			continue
+		}
+		value := form.Get(field.key)
+		if value == "" {
+			fields[field.key] = nil
+			continue
+		}
+		t, err := parseVoucherDate(value, field.endOfDay)
+		if err != nil {
+			return limits, nil, field.key + " must be a date (YYYY-MM-DD) or an RFC 3339 time"
+		}
+		*field.target = t
+		fields[field.key] = *t
+	}
+
+	if _, ok := form["minTier"]; ok {
+		limits.MinTier = form.Get("minTier")
+		fields["minTier"] = limits.MinTier
+	}
+
+	if msg := validateVoucherLimits(limits); msg != "" {
+		return limits, nil, msg
+	}
+	return limits, fields, ""
+}
+
 // CreateVoucher creates a new voucher with image upload (Admin only)
 func (vc *VoucherController) CreateVoucher(c echo.Context) error {
 	// Check if user is admin
@@ -80,6 +174,14 @@ func (vc *VoucherController) CreateVoucher(c echo.Context) error {
 		})
 	}
 
+	limits, _, msg := voucherLimitsFromForm(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
 	// Handle image upload
 	var imagePath string
 	if files := form.File["image"]; len(files) > 0 {
@@ -118,6 +220,8 @@ func (vc *VoucherController) CreateVoucher(c echo.Context) error {
 		CreatedBy:   createdByID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+
+		VoucherLimits: limits,
 	}
 
 	// Insert into database
@@ -175,6 +279,13 @@ func (vc *VoucherController) CreateVoucherJSON(c echo.Context) error {
 		})
 	}
 
+	if msg := validateVoucherLimits(req.VoucherLimits); msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
 	// Convert UserID to ObjectID
 	createdByID, err := primitive.ObjectIDFromHex(claims.UserID)
 	if err != nil {
@@ -213,6 +324,8 @@ func (vc *VoucherController) CreateVoucherJSON(c echo.Context) error {
 		CreatedBy:   createdByID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+
+		VoucherLimits: req.VoucherLimits,
 	}
 
 	// Insert into database
@@ -344,6 +457,14 @@ func (vc *VoucherController) UpdateVoucher(c echo.Context) error {
 		})
 	}
 
+	_, limitFields, msg := voucherLimitsFromForm(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
 	// Get current voucher to check if we need to delete old image
 	collection := vc.DB.Collection("vouchers")
 	ctx := context.Background()
@@ -384,15 +505,17 @@ func (vc *VoucherController) UpdateVoucher(c echo.Context) error {
 	}
 
 	// Update voucher
-	update := bson.M{
-		"$set": bson.M{
-			"name":        name,
-			"description": description,
-			"image":       imagePath,
-			"points":      points,
-			"updatedAt":   time.Now(),
-		},
+	fields := bson.M{
+		"name":        name,
+		"description": description,
+		"image":       imagePath,
+		"points":      points,
+		"updatedAt":   time.Now(),
 	}
+	for field, value := range limitFields {
+		fields[field] = value
+	}
+	update := bson.M{"$set": fields}
 
 	result, err := collection.UpdateByID(ctx, objID, update)
 	if err != nil {
@@ -567,67 +690,20 @@ func (vc *VoucherController) ToggleVoucherStatus(c echo.Context) error {
 	})
 }
 
-// GetAvailableVouchers retrieves all active vouchers for users
+// GetAvailableVouchers retrieves the vouchers users can buy now
 func (vc *VoucherController) GetAvailableVouchers(c echo.Context) error {
-	collection := vc.DB.Collection("vouchers")
-	ctx := context.Background()
-
-	// Get user info to check their points
-	claims := middleware.GetUserFromToken(c)
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid user ID",
-		})
-	}
-
-	// Get user's current points
-	usersCollection := vc.DB.Collection("users")
-	var user models.User
-	err = usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil {
-		log.Printf("Error retrieving user: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve user information",
-			Data:    err.Error(),
-		})
-	}
-
-	// Get vouchers available for users
-	cursor, err := collection.Find(ctx, bson.M{
-		"isActive":       true,
-		"targetUserType": "user",
-	})
+	vouchers, points, err := availableVouchersFor(context.Background(), c, vc.DB.Client(), models.VoucherOwnerUser)
 	if err != nil {
-		log.Printf("Error retrieving vouchers: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve vouchers",
-			Data:    err.Error(),
-		})
-	}
-	defer cursor.Close(ctx)
-
-	var vouchers []models.Voucher
-	if err = cursor.All(ctx, &vouchers); err != nil {
-		log.Printf("Error decoding vouchers: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to decode vouchers",
-			Data:    err.Error(),
-		})
+		return voucherErrorResponse(c, err)
 	}
 
 	// Create user vouchers with purchase capability info
-	var userVouchers []models.UserVoucher
+	userVouchers := []models.UserVoucher{}
 	for _, voucher := range vouchers {
-		canPurchase := user.Points >= voucher.Points
 		userVouchers = append(userVouchers, models.UserVoucher{
 			Voucher:     voucher,
-			CanPurchase: canPurchase,
-			UserPoints:  user.Points,
+			CanPurchase: points >= voucher.Points,
+			UserPoints:  points,
 		})
 	}
 
@@ -637,196 +713,28 @@ func (vc *VoucherController) GetAvailableVouchers(c echo.Context) error {
 		Data: map[string]interface{}{
 			"count":      len(userVouchers),
 			"vouchers":   userVouchers,
-			"userPoints": user.Points,
+			"userPoints": points,
 		},
 	})
 }
 
-// PurchaseVoucher allows a user to purchase a voucher with points and automatically marks it as used
+// PurchaseVoucher allows a user to purchase a voucher with points
 func (vc *VoucherController) PurchaseVoucher(c echo.Context) error {
-	claims := middleware.GetUserFromToken(c)
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid user ID",
-		})
-	}
-
-	var req models.VoucherPurchaseRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid request body",
-			Data:    err.Error(),
-		})
-	}
-
-	// Validate request
-	validate := validator.New()
-	if err := validate.Struct(req); err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Validation failed",
-			Data:    err.Error(),
-		})
-	}
-
-	voucherID, err := primitive.ObjectIDFromHex(req.VoucherID)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid voucher ID",
-		})
-	}
-
-	ctx := context.Background()
-
-	// Get the voucher
-	vouchersCollection := vc.DB.Collection("vouchers")
-	var voucher models.Voucher
-	err = vouchersCollection.FindOne(ctx, bson.M{"_id": voucherID, "isActive": true}).Decode(&voucher)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.JSON(http.StatusNotFound, models.Response{
-				Status:  http.StatusNotFound,
-				Message: "Voucher not found or inactive",
-			})
-		}
-		log.Printf("Error retrieving voucher: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve voucher",
-			Data:    err.Error(),
-		})
-	}
-
-	// Get user's current points
-	usersCollection := vc.DB.Collection("users")
-	var user models.User
-	err = usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil {
-		log.Printf("Error retrieving user: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve user information",
-			Data:    err.Error(),
-		})
-	}
-
-	// Check if user has enough points
-	if user.Points < voucher.Points {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Insufficient points",
-		})
-	}
-
-	// Check if user already purchased this voucher
-	purchasesCollection := vc.DB.Collection("voucher_purchases")
-	var existingPurchase models.VoucherPurchase
-	err = purchasesCollection.FindOne(ctx, bson.M{
-		"userId":    userID,
-		"voucherId": voucherID,
-	}).Decode(&existingPurchase)
-	if err == nil {
-		return c.JSON(http.StatusConflict, models.Response{
-			Status:  http.StatusConflict,
-			Message: "You have already purchased this voucher",
-		})
-	}
-
-	// Create purchase record (automatically marked as used)
-	purchase := models.VoucherPurchase{
-		ID:          primitive.NewObjectID(),
-		UserID:      userID,
-		VoucherID:   voucherID,
-		PointsUsed:  voucher.Points,
-		PurchasedAt: time.Now(),
-		IsUsed:      true,       // Automatically mark as used
-		UsedAt:      time.Now(), // Record usage timestamp
-	}
-
-	_, err = purchasesCollection.InsertOne(ctx, purchase)
-	if err != nil {
-		log.Printf("Error creating purchase record: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to create purchase record",
-			Data:    err.Error(),
-		})
-	}
-
-	// Deduct points from user
-	_, err = usersCollection.UpdateByID(ctx, userID, bson.M{
-		"$inc": bson.M{"points": -voucher.Points},
-	})
-	if err != nil {
-		log.Printf("Error deducting points: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to deduct points",
-			Data:    err.Error(),
-		})
-	}
-
-	return c.JSON(http.StatusOK, models.Response{
-		Status:  http.StatusOK,
-		Message: "Voucher purchased and used successfully",
-	})
+	return purchaseVoucherFor(c, vc.DB.Client(), models.VoucherOwnerUser)
 }
 
 // GetUserVouchers retrieves all vouchers purchased by the current user
 func (vc *VoucherController) GetUserVouchers(c echo.Context) error {
-	claims := middleware.GetUserFromToken(c)
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	owned, err := ownedVouchersFor(context.Background(), c, vc.DB.Client(), models.VoucherOwnerUser)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid user ID",
-		})
-	}
-
-	ctx := context.Background()
-
-	// Get user's purchased vouchers
-	purchasesCollection := vc.DB.Collection("voucher_purchases")
-	cursor, err := purchasesCollection.Find(ctx, bson.M{"userId": userID})
-	if err != nil {
-		log.Printf("Error retrieving user vouchers: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve user vouchers",
-			Data:    err.Error(),
-		})
-	}
-	defer cursor.Close(ctx)
-
-	var purchases []models.VoucherPurchase
-	if err = cursor.All(ctx, &purchases); err != nil {
-		log.Printf("Error decoding purchases: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to decode purchases",
-			Data:    err.Error(),
-		})
+		return voucherErrorResponse(c, err)
 	}
 
-	// Get voucher details for each purchase
-	var userVouchers []models.UserVoucher
-	vouchersCollection := vc.DB.Collection("vouchers")
-
-	for _, purchase := range purchases {
-		var voucher models.Voucher
-		err := vouchersCollection.FindOne(ctx, bson.M{"_id": purchase.VoucherID}).Decode(&voucher)
-		if err != nil {
-			log.Printf("Error retrieving voucher %s: %v", purchase.VoucherID.Hex(), err)
-			continue
-		}
-
+	userVouchers := make([]models.UserVoucher, 0, len(owned))
+	for _, o := range owned {
 		userVouchers = append(userVouchers, models.UserVoucher{
-			Voucher:  voucher,
-			Purchase: purchase,
+			Voucher:  o.Voucher,
+			Purchase: o.Purchase,
 		})
 	}
 
@@ -840,79 +748,15 @@ func (vc *VoucherController) GetUserVouchers(c echo.Context) error {
 	})
 }
 
-// UseVoucher marks a voucher as used
+// UseVoucher no longer lets a user mark its own voucher as used; a partner redeems
+// it by scanning its QR code
 func (vc *VoucherController) UseVoucher(c echo.Context) error {
-	claims := middleware.GetUserFromToken(c)
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid user ID",
-		})
-	}
-
-	purchaseID := c.Param("id")
-	objID, err := primitive.ObjectIDFromHex(purchaseID)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Invalid purchase ID",
-		})
-	}
-
-	ctx := context.Background()
-	purchasesCollection := vc.DB.Collection("voucher_purchases")
-
-	// Check if the purchase exists and belongs to the user
-	var purchase models.VoucherPurchase
-	err = purchasesCollection.FindOne(ctx, bson.M{
-		"_id":    objID,
-		"userId": userID,
-	}).Decode(&purchase)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.JSON(http.StatusNotFound, models.Response{
-				Status:  http.StatusNotFound,
-				Message: "Voucher purchase not found",
-			})
-		}
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to retrieve voucher purchase",
-			Data:    err.Error(),
-		})
-	}
-
-	// Check if already used
-	if purchase.IsUsed {
-		return c.JSON(http.StatusBadRequest, models.Response{
-			Status:  http.StatusBadRequest,
-			Message: "Voucher has already been used",
-		})
-	}
-
-	// Mark as used
-	update := bson.M{
-		"$set": bson.M{
-			"isUsed": true,
-			"usedAt": time.Now(),
-		},
-	}
-
-	_, err = purchasesCollection.UpdateByID(ctx, objID, update)
-	if err != nil {
-		log.Printf("Error using voucher: %v", err)
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Status:  http.StatusInternalServerError,
-			Message: "Failed to use voucher",
-			Data:    err.Error(),
-		})
-	}
+	return useVoucherFor(c)
+}
 
-	return c.JSON(http.StatusOK, models.Response{
-		Status:  http.StatusOK,
-		Message: "Voucher used successfully",
-	})
+// GetVoucherQRCode returns the redemption QR code of a voucher bought by the current user
+func (vc *VoucherController) GetVoucherQRCode(c echo.Context) error {
+	return voucherQRCodeFor(c, vc.DB.Client(), models.VoucherOwnerUser)
 }
 
 // CreateUserTypeVoucher creates a voucher for a specific user type with image upload (Admin only)
@@ -980,6 +824,14 @@ func (vc *VoucherController) CreateUserTypeVoucher(c echo.Context) error {
 		})
 	}
 
+	limits, _, msg := voucherLimitsFromForm(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: msg,
+		})
+	}
+
 	// Convert UserID to ObjectID
 	createdByID, err := primitive.ObjectIDFromHex(claims.UserID)
 	if err != nil {
@@ -1019,6 +871,8 @@ func (vc *VoucherController) CreateUserTypeVoucher(c echo.Context) error {
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		TargetUserType: targetUserType,
+
+		VoucherLimits: limits,
 	}
 
 	// Insert into database
@@ -1182,3 +1036,180 @@ func (vc *VoucherController) downloadAndSaveImage(imageURL string) (string, erro
 	// The nginx configuration will serve it from /uploads/vouchers/ directory
 	return uniqueFilename, nil
 }
+
+// GetVoucherRedemptions lists voucher redemption attempts, optionally by company, wholesaler, purchase or result (Admin only)
+func (vc *VoucherController) GetVoucherRedemptions(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	for _, field := range []string{"companyId", "wholesalerId", "purchaseId"} {
+		value := c.QueryParam(field)
+		if value == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid " + field,
+			})
+		}
+		filter[field] = id
+	}
+	if result := c.QueryParam("result"); result != "" {
+		filter["result"] = result
+	}
+	return voucherRedemptionsResponse(c, ctx, vc.DB.Client(), filter)
+}
+
+// GetPendingVoucherRequests lists the partner vouchers awaiting approval (Admin only)
+func (vc *VoucherController) GetPendingVoucherRequests(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+
+	collection := vc.DB.Collection("voucher_requests")
+	filter := bson.M{"status": "pending"}
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "submittedAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve voucher requests",
+		})
+	}
+	requests := []models.VoucherApprovalRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to decode voucher requests",
+		})
+	}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to count voucher requests",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher requests retrieved successfully",
+		Data: map[string]interface{}{
+			"requests": requests,
+			"total":    total,
+			"page":     page,
+			"limit":    limit,
+		},
+	})
+}
+
+// ProcessVoucherRequest approves or rejects a partner voucher; approving it
+// puts the voucher on sale (Admin only)
+func (vc *VoucherController) ProcessVoucherRequest(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	adminID, err := primitive.ObjectIDFromHex(c.Get("userId").(string))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid admin ID",
+		})
+	}
+	requestID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request ID format",
+		})
+	}
+
+	var decision models.BranchApprovalRequest
+	if err := c.Bind(&decision); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request data",
+		})
+	}
+	if decision.Status != "approved" && decision.Status != "rejected" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid status. Must be 'approved' or 'rejected'",
+		})
+	}
+
+	// Only a pending request is processed, so that two admins cannot both approve it
+	now := time.Now()
+	var request models.VoucherApprovalRequest
+	err = vc.DB.Collection("voucher_requests").FindOneAndUpdate(ctx,
+		bson.M{"_id": requestID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":      decision.Status,
+			"adminId":     adminID,
+			"adminNote":   decision.AdminNote,
+			"processedAt": now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		count, _ := vc.DB.Collection("voucher_requests").CountDocuments(ctx, bson.M{"_id": requestID})
+		if count > 0 {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "Voucher request is already processed",
+			})
+		}
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Voucher request not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to update voucher request",
+		})
+	}
+
+	if decision.Status == "rejected" {
+		if request.VoucherData.Image != "" {
+			os.Remove(filepath.Join("uploads/vouchers", request.VoucherData.Image))
+		}
+	} else {
+		voucher := request.VoucherData
+		voucher.IsActive = true
+		voucher.CreatedAt = now
+		voucher.UpdatedAt = now
+		if _, err := vc.DB.Collection("vouchers").InsertOne(ctx, voucher); err != nil {
+			log.Printf("Error creating approved voucher: %v", err)
+			// Leave the request pending so that it can be approved again
+			vc.DB.Collection("voucher_requests").UpdateByID(ctx, requestID, bson.M{
+				"$set":   bson.M{"status": "pending"},
+				"$unset": bson.M{"adminId": "", "adminNote": "", "processedAt": ""},
+			})
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Status:  http.StatusInternalServerError,
+				Message: "Failed to create voucher",
+			})
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher request processed successfully",
+		Data:    request,
+	})
+}
@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/middleware"
+	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Reasons a voucher request is refused before reaching the voucher engine
+var (
+	errInvalidVoucherUser = errors.New("invalid user ID")
+	errInvalidBranch      = errors.New("invalid branch")
+)
+
+// voucherOwner returns the user, company, wholesaler or service provider the
+// logged-in user buys vouchers as
+func voucherOwner(ctx context.Context, c echo.Context, db *mongo.Client, ownerType string) (primitive.ObjectID, error) {
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return primitive.NilObjectID, errInvalidVoucherUser
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return primitive.NilObjectID, errInvalidVoucherUser
+	}
+	_, ownerID, err := utils.PointsAccountForUser(ctx, db, ownerType, userID)
+	return ownerID, err
+}
+
+// voucherErrorResponse answers a voucher request that could not be served
+func voucherErrorResponse(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch err {
+	case errInvalidVoucherUser, utils.ErrInsufficientPoints, utils.ErrVoucherNotYetValid, utils.ErrVoucherEnded,
+		utils.ErrVoucherOutOfStock, utils.ErrVoucherExpired, utils.ErrVoucherCodeInvalid:
+	case utils.ErrVoucherWrongMerchant, utils.ErrVoucherTierRequired:
+		status = http.StatusForbidden
+	case utils.ErrVoucherLimitReached, utils.ErrVoucherAlreadyUsed:
+		status = http.StatusConflict
+	case utils.ErrVoucherNotFound, utils.ErrVoucherPurchaseNotFound:
+		status = http.StatusNotFound
+	case utils.ErrVoucherCodesDisabled:
+		status = http.StatusServiceUnavailable
+	case utils.ErrPointsAccountNotFound:
+		return c.JSON(http.StatusNotFound, models.Response{
+			Status:  http.StatusNotFound,
+			Message: "Account not found",
+		})
+	default:
+		log.Printf("Voucher request failed: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to process voucher request",
+		})
+	}
+	message := err.Error()
+	if err == utils.ErrInsufficientPoints {
+		message = "Insufficient points"
+	}
+	return c.JSON(status, models.Response{
+		Status:  status,
+		Message: message,
+	})
+}
+
+// availableVouchersFor returns the vouchers the logged-in owner can buy and its
+// points balance; users only see partner vouchers aimed at them
+func availableVouchersFor(ctx context.Context, c echo.Context, db *mongo.Client, ownerType string) ([]models.Voucher, int, error) {
+	ownerID, err := voucherOwner(ctx, c, db, ownerType)
+	if err != nil {
+		return nil, 0, err
+	}
+	points, err := utils.PointsBalance(ctx, db, ownerType, ownerID)
+	if err != nil {
+		return nil, 0, err
+	}
+	tier, err := utils.LoyaltyTier(ctx, db, ownerType, ownerID)
+	if err != nil {
+		return nil, 0, err
+	}
+	viewer, err := utils.VoucherViewerFor(ctx, db, ownerType, ownerID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if viewer != nil {
+		viewer.Category = c.QueryParam("category")
+	}
+	vouchers, err := utils.AvailableVouchers(ctx, db, ownerType, tier, viewer)
+	return vouchers, points, err
+}
+
+// purchaseVoucherFor buys the voucher in the request body for the logged-in owner
+func purchaseVoucherFor(c echo.Context, db *mongo.Client, ownerType string) error {
+	var req models.VoucherPurchaseRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid request body",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Validation failed",
+			Data:    err.Error(),
+		})
+	}
+	voucherID, err := primitive.ObjectIDFromHex(req.VoucherID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid voucher ID",
+		})
+	}
+
+	ctx := context.Background()
+	ownerID, err := voucherOwner(ctx, c, db, ownerType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	purchase, entry, err := utils.PurchaseVoucher(ctx, db, ownerType, ownerID, voucherID)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	remaining, err := utils.PointsBalance(ctx, db, ownerType, ownerID)
+	if entry != nil {
+		remaining, err = entry.BalanceAfter, nil
+	}
+	if err != nil {
+		log.Printf("Error reading points balance after voucher purchase: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher purchased successfully",
+		Data: map[string]interface{}{
+			"purchaseId":      purchase.ID.Hex(),
+			"pointsUsed":      purchase.PointsUsed,
+			"remainingPoints": remaining,
+			"purchase":        purchase,
+		},
+	})
+}
+
+// ownedVouchersFor returns the vouchers the logged-in owner bought
+func ownedVouchersFor(ctx context.Context, c echo.Context, db *mongo.Client, ownerType string) ([]utils.OwnedVoucher, error) {
+	ownerID, err := voucherOwner(ctx, c, db, ownerType)
+	if err != nil {
+		return nil, err
+	}
+	return utils.OwnerVouchers(ctx, db, ownerType, ownerID)
+}
+
+// useVoucherFor refuses an owner marking its own voucher as used: vouchers
+// are redeemed by a partner scanning their QR code, so that use is verified
+func useVoucherFor(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, models.Response{
+		Status:  http.StatusForbidden,
+		Message: "Vouchers are redeemed by a partner scanning their QR code",
+	})
+}
+
+// generateVoucherQRCode renders a redemption code as a base64 PNG QR code
+func generateVoucherQRCode(code string) (string, error) {
+	qrCode, err := qr.Encode(code, qr.M, qr.Auto)
+	if err != nil {
+		return "", err
+	}
+	qrCode, err = barcode.Scale(qrCode, 300, 300)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qrCode); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// voucherQRCodeFor returns the redemption QR code of a voucher the logged-in owner bought
+func voucherQRCodeFor(c echo.Context, db *mongo.Client, ownerType string) error {
+	purchaseID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid purchase ID",
+		})
+	}
+
+	ctx := context.Background()
+	ownerID, err := voucherOwner(ctx, c, db, ownerType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	purchase, err := utils.VoucherRedemptionCode(ctx, db, ownerType, ownerID, purchaseID)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	qrCode, err := generateVoucherQRCode(purchase.RedemptionCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to generate QR code",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher QR code generated successfully",
+		Data: map[string]interface{}{
+			"purchaseId":     purchase.ID.Hex(),
+			"redemptionCode": purchase.RedemptionCode,
+			"qrCode":         qrCode,
+			"isUsed":         purchase.IsUsed,
+			"expiresAt":      purchase.ExpiresAt,
+		},
+	})
+}
+
+// voucherRedemptionsResponse answers with a page of voucher redemption attempts matching a filter
+func voucherRedemptionsResponse(c echo.Context, ctx context.Context, db *mongo.Client, filter bson.M) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	attempts, total, err := utils.VoucherRedemptionAttempts(ctx, db, filter, page, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to retrieve voucher redemptions",
+		})
+	}
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher redemptions retrieved successfully",
+		Data: map[string]interface{}{
+			"redemptions": attempts,
+			"pagination": map[string]interface{}{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// voucherMerchant returns the company or wholesaler of the logged-in owner or
+// staff member and the user's ID, for redeeming vouchers there
+func voucherMerchant(ctx context.Context, c echo.Context, db *mongo.Client, merchantType string) (primitive.ObjectID, primitive.ObjectID, error) {
+	claims := middleware.GetUserFromToken(c)
+	if claims == nil {
+		return primitive.NilObjectID, primitive.NilObjectID, errInvalidVoucherUser
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, errInvalidVoucherUser
+	}
+
+	var user models.User
+	err = db.Database("barrim").Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, primitive.NilObjectID, err
+	}
+	if merchantType == models.VoucherIssuerCompany && user.CompanyID != nil {
+		return *user.CompanyID, userID, nil
+	}
+	if merchantType == models.VoucherIssuerWholesaler && user.WholesalerID != nil {
+		return *user.WholesalerID, userID, nil
+	}
+	_, merchantID, err := utils.PointsAccountForUser(ctx, db, merchantType, userID)
+	return merchantID, userID, err
+}
+
+// merchantBranch checks that a branch given by ID belongs to a company or wholesaler
+func merchantBranch(ctx context.Context, db *mongo.Client, merchantType string, merchantID primitive.ObjectID, branchID string) (*primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(branchID)
+	if err != nil {
+		return nil, errInvalidBranch
+	}
+	collection, _ := utils.PointsCollection(merchantType)
+	n, err := db.Database("barrim").Collection(collection).CountDocuments(ctx, bson.M{"_id": merchantID, "branches._id": id})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errInvalidBranch
+	}
+	return &id, nil
+}
+
+// redeemVoucherAt redeems a voucher whose QR code was scanned at the logged-in
+// company or wholesaler, or at one of its branches
+func redeemVoucherAt(c echo.Context, db *mongo.Client, merchantType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.VoucherRedemptionRequest
+	if err := c.Bind(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Status:  http.StatusBadRequest,
+			Message: "A voucher code is required",
+		})
+	}
+
+	merchantID, userID, err := voucherMerchant(ctx, c, db, merchantType)
+	if err == utils.ErrPointsAccountNotFound {
+		return c.JSON(http.StatusForbidden, models.Response{
+			Status:  http.StatusForbidden,
+			Message: "Only partner businesses can redeem vouchers",
+		})
+	}
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	redeemer := utils.VoucherRedeemer{MerchantType: merchantType, MerchantID: merchantID, UserID: userID}
+
+	if req.BranchID != "" {
+		redeemer.BranchID, err = merchantBranch(ctx, db, merchantType, merchantID, req.BranchID)
+		if err == errInvalidBranch {
+			return c.JSON(http.StatusForbidden, models.Response{
+				Status:  http.StatusForbidden,
+				Message: "The branch does not belong to your business",
+			})
+		}
+		if err != nil {
+			return voucherErrorResponse(c, err)
+		}
+	}
+
+	purchase, err := utils.RedeemVoucher(ctx, db, strings.TrimSpace(req.Code), redeemer)
+	if err == utils.ErrVoucherAlreadyUsed {
+		return c.JSON(http.StatusConflict, models.Response{
+			Status:  http.StatusConflict,
+			Message: "Voucher has already been redeemed",
+			Data: map[string]interface{}{
+				"purchaseId": purchase.ID.Hex(),
+				"redeemedAt": purchase.UsedAt,
+			},
+		})
+	}
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+
+	var voucher models.Voucher
+	if err := db.Database("barrim").Collection("vouchers").FindOne(ctx, bson.M{"_id": purchase.VoucherID}).Decode(&voucher); err != nil {
+		log.Printf("Error loading redeemed voucher %s: %v", purchase.VoucherID.Hex(), err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Status:  http.StatusOK,
+		Message: "Voucher redeemed successfully",
+		Data: map[string]interface{}{
+			"purchase": purchase,
+			"voucher":  voucher,
+		},
+	})
+}
+
+// voucherRedemptionsAt lists the voucher redemption attempts made at the logged-in company or wholesaler
+func voucherRedemptionsAt(c echo.Context, db *mongo.Client, merchantType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	merchantID, _, err := voucherMerchant(ctx, c, db, merchantType)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	filter := bson.M{"companyId": merchantID}
+	if merchantType == models.VoucherIssuerWholesaler {
+		filter = bson.M{"wholesalerId": merchantID}
+	}
+	if result := c.QueryParam("result"); result != "" {
+		filter["result"] = result
+	}
+	return voucherRedemptionsResponse(c, ctx, db, filter)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/HSouheill/barrim_backend/config"
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
@@ -1369,7 +1370,9 @@ func (wc *WholesalerController) UpdateBranch(c echo.Context) error {
 	})
 }
 
-// GetAllWholesalers retrieves all wholesalers from the database with branch status
+// GetAllWholesalers retrieves all wholesalers from the database with branch status.
+// Optional category and governorate query params narrow the listing; sponsored
+// wholesalers are pinned or boosted within it and labelled.
 func (wc *WholesalerController) GetAllWholesalers(c echo.Context) error {
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -1419,10 +1422,42 @@ func (wc *WholesalerController) GetAllWholesalers(c echo.Context) error {
 		})
 	}
 
+	// Narrow to the requested category and region, then rank sponsors within it
+	category := c.QueryParam("category")
+	governorate := c.QueryParam("governorate")
+	var listed []models.Wholesaler
+	var candidates []utils.SponsoredCandidate
+	for _, wholesaler := range wholesalers {
+		if !matchesListingFilter(category, wholesaler.Category) || !matchesListingFilter(governorate, wholesaler.ContactInfo.Address.Governorate) {
+			continue
+		}
+		listed = append(listed, wholesaler)
+		candidates = append(candidates, utils.SponsoredCandidate{
+			EntityType: utils.SponsoredEntityWholesaler,
+			EntityID:   wholesaler.ID,
+			Sponsored:  wholesaler.Sponsorship || hasSponsoredBranch(wholesaler.Branches),
+		})
+	}
+
+	ranked := utils.RankSponsored(sponsoredListingKey("wholesalers", category, governorate), candidates)
+	result := make([]sponsoredWholesaler, 0, len(ranked))
+	for _, p := range ranked {
+		entry := sponsoredWholesaler{
+			Wholesaler: listed[p.Index],
+			Sponsored:  candidates[p.Index].Sponsored,
+			Placement:  p.Placement,
+		}
+		if entry.Sponsored {
+			entry.SponsoredLabel = utils.SponsoredLabel
+		}
+		result = append(result, entry)
+	}
+	go utils.RecordSponsoredImpressions(wc.DB, candidates, ranked)
+
 	// Return success response with wholesalers including branch status
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "Wholesalers retrieved successfully",
-		Data:    wholesalers,
+		Data:    result,
 	})
 }
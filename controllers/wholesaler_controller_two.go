@@ -330,6 +330,15 @@ func (wc *WholesalerController) UpdateWholesalerData(c echo.Context) error {
 		})
 	}
 
+	// Keep the GeoJSON point in step with coordinates set field by field
+	_, latSet := updateFields["contactInfo.address.lat"]
+	_, lngSet := updateFields["contactInfo.address.lng"]
+	if latSet || lngSet {
+		if err := utils.SyncGeoPoint(ctx, wholesalerCollection, bson.M{"_id": wholesaler.ID}, "contactInfo.address"); err != nil {
+			log.Printf("Failed to sync wholesaler geo point: %v", err)
+		}
+	}
+
 	// Get updated wholesaler data to return
 	var updatedWholesaler models.Wholesaler
 	err = wholesalerCollection.FindOne(ctx, bson.M{"_id": wholesaler.ID}).Decode(&updatedWholesaler)
@@ -622,12 +631,17 @@ func (wc *WholesalerController) ChangeWholesalerDetails(c echo.Context) error {
 	})
 }
 
-// GetAllBranches retrieves all branches from all wholesalers with branch status
+// GetAllBranches retrieves all branches from all wholesalers with branch status.
+// Optional category and governorate query params narrow the listing; sponsored
+// branches are pinned or boosted within it and labelled.
 func (wc *WholesalerController) GetAllBranches(c echo.Context) error {
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	category := c.QueryParam("category")
+	governorate := c.QueryParam("governorate")
+
 	// Get wholesaler collection
 	wholesalerCollection := config.GetCollection(wc.DB, "wholesalers")
 
@@ -653,8 +667,12 @@ func (wc *WholesalerController) GetAllBranches(c echo.Context) error {
 
 	// Prepare response data - flatten all branches with wholesaler reference and status
 	var allBranches []map[string]interface{}
+	var candidates []utils.SponsoredCandidate
 	for _, wholesaler := range wholesalers {
 		for _, branch := range wholesaler.Branches {
+			if !matchesListingFilter(category, branch.Category) || !matchesListingFilter(governorate, branch.Location.Governorate) {
+				continue
+			}
 			branchData := map[string]interface{}{
 				"id":          branch.ID.Hex(),
 				"name":        branch.Name,
@@ -677,9 +695,17 @@ func (wc *WholesalerController) GetAllBranches(c echo.Context) error {
 				},
 			}
 			allBranches = append(allBranches, branchData)
+			candidates = append(candidates, utils.SponsoredCandidate{
+				EntityType: utils.SponsoredEntityWholesalerBranch,
+				EntityID:   branch.ID,
+				Sponsored:  branch.Sponsorship,
+			})
 		}
 	}
 
+	listingKey := sponsoredListingKey("wholesaler_branches", category, governorate)
+	allBranches = rankSponsoredEntries(wc.DB, listingKey, allBranches, candidates)
+
 	return c.JSON(http.StatusOK, models.Response{
 		Status:  http.StatusOK,
 		Message: "All branches retrieved successfully",
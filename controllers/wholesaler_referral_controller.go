@@ -2,19 +2,13 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"fmt"
-	"image/png"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/qr"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -173,18 +167,27 @@ func (rc *WholesalerReferralController) handleWholesalerReferral(c echo.Context,
 		}
 	}
 
-	// Update the referrer wholesaler - add points and add to referrals list
-	const pointsToAdd = 5
-	update := bson.M{
-		"$inc":  bson.M{"points": pointsToAdd},
-		"$push": bson.M{"referrals": currentWholesaler.ID},
-		"$set":  bson.M{"updatedAt": time.Now()},
-	}
-
-	log.Printf("Updating wholesaler %s with points increment: %d", referrerWholesaler.ID.Hex(), pointsToAdd)
-
-	_, err = wholesalerCollection.UpdateByID(ctx, referrerWholesaler.ID, update)
+	// Reward the referrer wholesaler by the referral rules and add to referrals list
+	log.Printf("Applying referral rules for wholesaler %s", referrerWholesaler.ID.Hex())
+
+	outcome, err := utils.ApplyReferralEvent(ctx, rc.DB, utils.ReferralEvent{
+		Event:         models.ReferralEventSignup,
+		ReferrerType:  models.PointsAccountWholesaler,
+		ReferrerID:    referrerWholesaler.ID,
+		RefereeType:   models.PointsAccountWholesaler,
+		RefereeID:     currentWholesaler.ID,
+		RefereeUserID: &currentWholesaler.UserID,
+		Fingerprint:   referralFingerprint(c, ""),
+		Code:          referralCode,
+		Channel:       models.ReferralChannelCode,
+	})
 	if err != nil {
+		if err == utils.ErrReferralEventRecorded {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Status:  http.StatusBadRequest,
+				Message: "This referral code has already been used",
+			})
+		}
 		log.Printf("ERROR: Failed to update wholesaler points: %v", err)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
@@ -211,7 +214,7 @@ func (rc *WholesalerReferralController) handleWholesalerReferral(c echo.Context,
 		ReferrerID:      updatedReferrerWholesaler.ID,
 		Referrer:        updatedReferrerWholesaler,
 		NewWholesaler:   currentWholesaler,
-		PointsAdded:     pointsToAdd,
+		PointsAdded:     outcome.ReferrerPoints(),
 		NewReferralCode: currentWholesaler.ReferralCode,
 	}
 
@@ -310,7 +313,7 @@ func (rc *WholesalerReferralController) getWholesalerReferralData(c echo.Context
 		ReferralCode:  wholesaler.ReferralCode,
 		ReferralCount: len(wholesaler.Referrals),
 		Points:        wholesaler.Points,
-		ReferralLink:  fmt.Sprintf("https://barrim.com/referral?code=%s", wholesaler.ReferralCode),
+		ReferralLink:  utils.ReferralLinkURL(wholesaler.ReferralCode, models.ReferralSourceLink),
 	}
 
 	responseData := map[string]interface{}{
@@ -327,33 +330,9 @@ func (rc *WholesalerReferralController) getWholesalerReferralData(c echo.Context
 	})
 }
 
-// GenerateReferralQRCode creates a QR code image for a referral code
+// GenerateReferralQRCode creates a QR code image of the tracked link of a referral code
 func (rc *WholesalerReferralController) GenerateReferralQRCode(referralCode string) (string, error) {
-	// Create the QR code content - usually a URL or the code itself
-	content := fmt.Sprintf("https://barrim.com/referral?code=%s", referralCode)
-
-	// Generate the QR code
-	qrCode, err := qr.Encode(content, qr.M, qr.Auto)
-	if err != nil {
-		return "", err
-	}
-
-	// Scale the QR code to a reasonable size (300x300 pixels)
-	qrCode, err = barcode.Scale(qrCode, 300, 300)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert the QR code to a PNG image
-	var buf bytes.Buffer
-	err = png.Encode(&buf, qrCode)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert to base64 for embedding in responses
-	base64QR := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return "data:image/png;base64," + base64QR, nil
+	return utils.ReferralQRCode(referralCode)
 }
 
 // GetWholesalerReferralQRCode endpoint to get QR code for a wholesaler referral code
@@ -18,6 +18,7 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/models"
 	"github.com/HSouheill/barrim_backend/services"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -550,61 +551,21 @@ func (sc *SubscriptionController) ApproveWholesalerSubscriptionRequest(c echo.Co
 	wholesalerCollection := sc.DB.Collection("wholesalers")
 	// Get wholesaler details
 	var wholesaler models.Wholesaler
-	if err := wholesalerCollection.FindOne(ctx, bson.M{"_id": request.WholesalerID}).Decode(&wholesaler); err == nil {
-		if !wholesaler.CreatedBy.IsZero() {
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": wholesaler.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				// Get admin who created the salesperson
-				var admin models.Admin
-				err := sc.DB.Collection("admins").FindOne(ctx, bson.M{"_id": salesperson.CreatedBy}).Decode(&admin)
-				if err == nil {
-					// Get plan details
-					var plan models.SubscriptionPlan
-					err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-					if err == nil {
-						planPrice := plan.Price
-						salespersonPercent := salesperson.CommissionPercent
-
-						// Calculate admin commission (remaining percentage after salesperson commission)
-						adminPercent := 100.0 - salespersonPercent
-
-						// Calculate commissions correctly
-						// Salesperson gets their percentage directly from the plan price
-						salespersonCommission := planPrice * salespersonPercent / 100.0
-						// Admin gets the remaining percentage
-						adminCommission := planPrice * adminPercent / 100.0
-
-						// Insert commission documents
-						commissionCollection := sc.DB.Collection("commissions")
-						commissionDocs := []interface{}{
-							models.Commission{
-								ID:                           primitive.NewObjectID(),
-								SubscriptionID:               request.ID,
-								CompanyID:                    primitive.NilObjectID,
-								PlanID:                       plan.ID,
-								PlanPrice:                    planPrice,
-								AdminID:                      admin.ID,
-								AdminCommission:              adminCommission,
-								AdminCommissionPercent:       adminPercent,
-								SalespersonID:                salesperson.ID,
-								SalespersonCommission:        salespersonCommission,
-								SalespersonCommissionPercent: salespersonPercent,
-								CreatedAt:                    time.Now(),
-								Paid:                         false,
-								PaidAt:                       nil,
-							},
-						}
-						_, err := commissionCollection.InsertMany(ctx, commissionDocs)
-						if err != nil {
-							log.Printf("Failed to insert commission docs: %v", err)
-						} else {
-							log.Printf("Commission inserted successfully - Plan Price: $%.2f, Admin Commission: $%.2f (%.1f%%), Salesperson Commission: $%.2f (%.1f%%)\n",
-								planPrice, adminCommission, adminPercent, salespersonCommission, salespersonPercent)
-						}
-					}
-				}
+	if err := wholesalerCollection.FindOne(ctx, bson.M{"_id": request.WholesalerID}).Decode(&wholesaler); err == nil && wholesaler.CreatedBy != wholesaler.UserID {
+		// Get plan details
+		var plan models.SubscriptionPlan
+		err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
+		if err == nil {
+			_, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: request.ID,
+				EntityType:     "wholesaler",
+				EntityID:       wholesaler.ID,
+				EntityName:     wholesaler.BusinessName,
+				CreatedBy:      wholesaler.CreatedBy,
+				Plan:           plan,
+			})
+			if err != nil {
+				log.Printf("Failed to apply commission: %v", err)
 			}
 		}
 	}
@@ -748,61 +709,21 @@ func (sc *SubscriptionController) ApproveWholesalerSubscriptionRequestByManager(
 	wholesalerCollection := sc.DB.Collection("wholesalers")
 	// Get wholesaler details
 	var wholesaler models.Wholesaler
-	if err := wholesalerCollection.FindOne(ctx, bson.M{"_id": request.WholesalerID}).Decode(&wholesaler); err == nil {
-		if !wholesaler.CreatedBy.IsZero() {
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": wholesaler.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				// Get admin who created the salesperson
-				var admin models.Admin
-				err := sc.DB.Collection("admins").FindOne(ctx, bson.M{"_id": salesperson.CreatedBy}).Decode(&admin)
-				if err == nil {
-					// Get plan details
-					var plan models.SubscriptionPlan
-					err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
-					if err == nil {
-						planPrice := plan.Price
-						salespersonPercent := salesperson.CommissionPercent
-
-						// Calculate admin commission (remaining percentage after salesperson commission)
-						adminPercent := 100.0 - salespersonPercent
-
-						// Calculate commissions correctly
-						// Salesperson gets their percentage directly from the plan price
-						salespersonCommission := planPrice * salespersonPercent / 100.0
-						// Admin gets the remaining percentage
-						adminCommission := planPrice * adminPercent / 100.0
-
-						// Insert commission documents
-						commissionCollection := sc.DB.Collection("commissions")
-						commissionDocs := []interface{}{
-							models.Commission{
-								ID:                           primitive.NewObjectID(),
-								SubscriptionID:               request.ID,
-								CompanyID:                    primitive.NilObjectID,
-								PlanID:                       plan.ID,
-								PlanPrice:                    planPrice,
-								AdminID:                      admin.ID,
-								AdminCommission:              adminCommission,
-								AdminCommissionPercent:       adminPercent,
-								SalespersonID:                salesperson.ID,
-								SalespersonCommission:        salespersonCommission,
-								SalespersonCommissionPercent: salespersonPercent,
-								CreatedAt:                    time.Now(),
-								Paid:                         false,
-								PaidAt:                       nil,
-							},
-						}
-						_, err := commissionCollection.InsertMany(ctx, commissionDocs)
-						if err != nil {
-							log.Printf("Failed to insert commission docs: %v", err)
-						} else {
-							log.Printf("Commission inserted successfully - Plan Price: $%.2f, Admin Commission: $%.2f (%.1f%%), Salesperson Commission: $%.2f (%.1f%%)\n",
-								planPrice, adminCommission, adminPercent, salespersonCommission, salespersonPercent)
-						}
-					}
-				}
+	if err := wholesalerCollection.FindOne(ctx, bson.M{"_id": request.WholesalerID}).Decode(&wholesaler); err == nil && wholesaler.CreatedBy != wholesaler.UserID {
+		// Get plan details
+		var plan models.SubscriptionPlan
+		err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": request.PlanID}).Decode(&plan)
+		if err == nil {
+			_, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: request.ID,
+				EntityType:     "wholesaler",
+				EntityID:       wholesaler.ID,
+				EntityName:     wholesaler.BusinessName,
+				CreatedBy:      wholesaler.CreatedBy,
+				Plan:           plan,
+			})
+			if err != nil {
+				log.Printf("Failed to apply commission: %v", err)
 			}
 		}
 	}
@@ -1042,6 +963,27 @@ func (sc *WholesalerBranchSubscriptionController) CreateBranchSubscriptionReques
 	subscriptionRequest.Status = "pending_payment"
 	subscriptionRequest.PaymentStatus = "pending"
 
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := plan.Price
+	var promo *models.PromoCodeRedemption
+	if code := c.FormValue("promoCode"); code != "" {
+		promo, err = utils.ApplyPromoCode(ctx, sc.DB.Client(), utils.PromoCheckout{
+			Code:       code,
+			Checkout:   models.PromoCheckoutWholesalerBranchSubscription,
+			PlanType:   plan.Type,
+			EntityType: utils.SponsoredEntityWholesalerBranch,
+			EntityID:   branch.ID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     plan.Price,
+		})
+		if err != nil {
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callbacks
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -1072,7 +1014,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateBranchSubscriptionReques
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &plan.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for subscription payments
 		Invoice:            fmt.Sprintf("Wholesaler Branch Subscription - %s - Plan: %s", branch.Name, plan.Title),
 		ExternalID:         &externalID,
@@ -1086,6 +1028,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateBranchSubscriptionReques
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -1099,6 +1042,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateBranchSubscriptionReques
 	_, err = subscriptionRequestsCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save subscription request: %v", err)
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create subscription request",
@@ -1115,7 +1059,8 @@ func (sc *WholesalerBranchSubscriptionController) CreateBranchSubscriptionReques
 			"plan":          plan,
 			"status":        subscriptionRequest.Status,
 			"submittedAt":   subscriptionRequest.RequestedAt,
-			"paymentAmount": plan.Price,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 			"collectUrl":    collectURL,
 			"externalId":    externalID,
 		},
@@ -1177,6 +1122,7 @@ func (sc *WholesalerBranchSubscriptionController) HandleWhishPaymentSuccess(c ec
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		utils.ReleasePromoCode(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID)
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
 
@@ -1221,12 +1167,16 @@ func (sc *WholesalerBranchSubscriptionController) HandleWhishPaymentFailure(c ec
 		return c.String(http.StatusInternalServerError, "Failed to update status")
 	}
 
+	utils.ReleasePromoCodeByExternalID(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, externalID)
 	log.Printf("Payment failed for externalId: %d", externalID)
 	return c.String(http.StatusOK, "Payment failure recorded")
 }
 
 // activateWholesalerBranchSubscription activates the subscription after successful payment
 func (sc *WholesalerBranchSubscriptionController) activateWholesalerBranchSubscription(ctx context.Context, subscriptionRequest models.WholesalerBranchSubscriptionRequest, payerPhone string) error {
+	// The payment went through, so its promo code is used
+	utils.RedeemPromoCode(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID)
+
 	// Get plan details
 	var plan models.SubscriptionPlan
 	err := sc.DB.Collection("subscription_plans").FindOne(ctx, bson.M{"_id": subscriptionRequest.PlanID}).Decode(&plan)
@@ -1338,50 +1288,30 @@ func (sc *WholesalerBranchSubscriptionController) activateWholesalerBranchSubscr
 		log.Printf("Failed to update user status to active: %v", err)
 	}
 
-	// Handle commission and admin wallet (30% salesperson, 70% admin)
-	planPrice := plan.Price
-	if wholesaler.CreatedBy != wholesaler.UserID && !wholesaler.CreatedBy.IsZero() {
-		// Wholesaler was created by a salesperson - split commission
-		var salesperson models.Salesperson
-		err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": wholesaler.CreatedBy}).Decode(&salesperson)
-		if err == nil {
-			// Calculate commissions: 30% salesperson, 70% admin
-			salespersonPercent := 30.0
-			adminPercent := 70.0
-
-			salespersonCommission := planPrice * salespersonPercent / 100.0
-			adminCommission := planPrice * adminPercent / 100.0
-
-			// Add salesperson commission
-			err = sc.addCommissionToSalespersonWallet(ctx, salesperson.ID, salespersonCommission, newSubscription.ID, "wholesaler_branch_subscription", wholesaler.BusinessName)
-			if err != nil {
-				log.Printf("Failed to add salesperson commission: %v", err)
-			} else {
-				log.Printf("Added salesperson commission: $%.2f (30%% of $%.2f)", salespersonCommission, planPrice)
-			}
-
-			// Add admin commission
-			err = sc.addSubscriptionIncomeToAdminWallet(ctx, adminCommission, newSubscription.ID, "wholesaler_branch_subscription_commission", wholesaler.BusinessName, branch.Name)
-			if err != nil {
-				log.Printf("Failed to add admin commission: %v", err)
-			} else {
-				log.Printf("Added admin commission: $%.2f (70%% of $%.2f)", adminCommission, planPrice)
-			}
-		} else {
-			log.Printf("Salesperson not found, adding full amount to admin wallet")
-			// Salesperson not found, add full amount to admin
-			err = sc.addSubscriptionIncomeToAdminWallet(ctx, planPrice, newSubscription.ID, "wholesaler_branch_subscription", wholesaler.BusinessName, branch.Name)
-			if err != nil {
-				log.Printf("Failed to add subscription income to admin wallet: %v", err)
-			}
-		}
-	} else {
-		// Wholesaler created by itself - full amount to admin
-		err = sc.addSubscriptionIncomeToAdminWallet(ctx, planPrice, newSubscription.ID, "wholesaler_branch_subscription", wholesaler.BusinessName, branch.Name)
+	// Pay the salesperson who created the wholesaler, if any, and the admin the rest
+	adminAmount, adminEntityType := plan.Price, "wholesaler_branch_subscription"
+	if wholesaler.CreatedBy != wholesaler.UserID {
+		commission, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+			SubscriptionID: newSubscription.ID,
+			EntityType:     "wholesaler",
+			EntityID:       wholesaler.ID,
+			EntityName:     wholesaler.BusinessName,
+			BranchID:       &subscriptionRequest.BranchID,
+			CreatedBy:      wholesaler.CreatedBy,
+			Plan:           plan,
+			AmountPaid:     utils.PromoAmountPaid(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID),
+		})
 		if err != nil {
-			log.Printf("Failed to add subscription income to admin wallet: %v", err)
+			log.Printf("Failed to apply commission: %v", err)
+		}
+		if commission != nil {
+			adminAmount, adminEntityType = utils.AdminWalletShare(commission), "wholesaler_branch_subscription_commission"
 		}
 	}
+	err = sc.addSubscriptionIncomeToAdminWallet(ctx, adminAmount, newSubscription.ID, adminEntityType, wholesaler.BusinessName, branch.Name)
+	if err != nil {
+		log.Printf("Failed to add subscription income to admin wallet: %v", err)
+	}
 
 	// Update subscription request status
 	subscriptionRequestsCollection := sc.DB.Collection("wholesaler_branch_subscription_requests")
@@ -1398,52 +1328,15 @@ func (sc *WholesalerBranchSubscriptionController) activateWholesalerBranchSubscr
 		log.Printf("Failed to update subscription request status: %v", err)
 	}
 
-	log.Printf("Wholesaler branch subscription activated successfully: Branch=%s, Plan=%s, Amount=$%.2f", branch.Name, plan.Title, planPrice)
-	return nil
-}
-
-// addCommissionToSalespersonWallet adds commission amount to salesperson's wallet
-func (sc *WholesalerBranchSubscriptionController) addCommissionToSalespersonWallet(ctx context.Context, salespersonID primitive.ObjectID, amount float64, subscriptionID primitive.ObjectID, entityType, wholesalerName string) error {
-	// CommissionRecord structure
-	type CommissionRecord struct {
-		ID             primitive.ObjectID `bson:"_id,omitempty"`
-		SubscriptionID primitive.ObjectID `bson:"subscriptionId"`
-		SalespersonID  primitive.ObjectID `bson:"salespersonId"`
-		Amount         float64            `bson:"amount"`
-		Role           string             `bson:"role"`
-		Status         string             `bson:"status"`
-		CreatedAt      time.Time          `bson:"createdAt"`
-	}
-
-	commissionRecord := CommissionRecord{
-		ID:             primitive.NewObjectID(),
-		SubscriptionID: subscriptionID,
-		SalespersonID:  salespersonID,
-		Amount:         amount,
-		Role:           "salesperson",
-		Status:         "pending",
-		CreatedAt:      time.Now(),
-	}
-
-	_, err := sc.DB.Collection("commission_records").InsertOne(ctx, commissionRecord)
-	if err != nil {
-		return fmt.Errorf("failed to insert salesperson commission record: %w", err)
-	}
-
-	_, err = sc.DB.Collection("salespersons").UpdateOne(
-		ctx,
-		bson.M{"_id": salespersonID},
-		bson.M{
-			"$inc": bson.M{"commissionBalance": amount},
-			"$set": bson.M{"updatedAt": time.Now()},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update salesperson commission balance: %w", err)
+	log.Printf("Wholesaler branch subscription activated successfully: Branch=%s, Plan=%s, Amount=$%.2f", branch.Name, plan.Title, plan.Price)
+	if plan.Price > 0 {
+		go utils.TriggerReferralEvent(sc.DB.Client(), utils.ReferralEvent{
+			Event:         models.ReferralEventFirstPaidSubscription,
+			RefereeType:   models.PointsAccountWholesaler,
+			RefereeID:     wholesaler.ID,
+			RefereeUserID: &wholesaler.UserID,
+		})
 	}
-
-	log.Printf("Commission $%.2f added to salesperson wallet (ID: %s) from %s - %s",
-		amount, salespersonID.Hex(), entityType, wholesalerName)
 	return nil
 }
 
@@ -2287,85 +2180,30 @@ func (sc *WholesalerBranchSubscriptionController) ProcessWholesalerBranchSubscri
 		subscription = &newSubscription
 
 		// --- Commission logic start ---
-		// Check if wholesaler was created by a salesperson or by itself (user signup)
-		log.Printf("DEBUG: Wholesaler CreatedBy field: %v (IsZero: %v)", wholesaler.CreatedBy, wholesaler.CreatedBy.IsZero())
-
-		// Check if wholesaler was created by itself (user signup) - CreatedBy equals UserID
-		if wholesaler.CreatedBy == wholesaler.UserID {
-			log.Printf("DEBUG: Wholesaler was created by user signup, adding subscription price to admin wallet")
-			// Add subscription price directly to admin wallet (no commission calculation needed)
-			err := sc.addSubscriptionIncomeToAdminWallet(ctx, plan.Price, newSubscription.ID, "wholesaler_branch_subscription", wholesaler.BusinessName, branch.Name)
+		// Pay the salesperson who created the wholesaler, if any, and the admin the rest
+		adminAmount, adminEntityType := plan.Price, "wholesaler_branch_subscription"
+		if wholesaler.CreatedBy != wholesaler.UserID {
+			commission, err := utils.ApplyCommission(ctx, sc.DB.Client(), utils.CommissionSale{
+				SubscriptionID: newSubscription.ID,
+				EntityType:     "wholesaler",
+				EntityID:       wholesaler.ID,
+				EntityName:     wholesaler.BusinessName,
+				BranchID:       &subscriptionRequest.BranchID,
+				CreatedBy:      wholesaler.CreatedBy,
+				Plan:           plan,
+				AmountPaid:     utils.PromoAmountPaid(ctx, sc.DB.Client(), models.PromoCheckoutWholesalerBranchSubscription, subscriptionRequest.ID),
+			})
 			if err != nil {
-				log.Printf("Failed to add subscription income to admin wallet: %v", err)
-			} else {
-				log.Printf("Subscription income added to admin wallet: $%.2f from wholesaler '%s' (ID: %s) - User signup subscription",
-					plan.Price, wholesaler.BusinessName, wholesaler.ID.Hex())
+				log.Printf("Failed to apply commission: %v", err)
 			}
-		} else if !wholesaler.CreatedBy.IsZero() {
-			log.Printf("DEBUG: Wholesaler was created by salesperson, proceeding with commission calculation")
-			// Get salesperson
-			var salesperson models.Salesperson
-			err := sc.DB.Collection("salespersons").FindOne(ctx, bson.M{"_id": wholesaler.CreatedBy}).Decode(&salesperson)
-			if err == nil {
-				log.Printf("DEBUG: Found salesperson: %s (ID: %v)", salesperson.FullName, salesperson.ID)
-				// Get sales manager - try both collection names due to inconsistency
-				var salesManager models.SalesManager
-				err := sc.DB.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-				if err != nil {
-					// Try the alternative collection name
-					log.Printf("DEBUG: Sales manager not found in sales_managers collection, trying salesManagers collection")
-					err = sc.DB.Collection("salesManagers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&salesManager)
-				}
-				if err == nil {
-					log.Printf("DEBUG: Found sales manager: %s (ID: %v)", salesManager.FullName, salesManager.ID)
-					planPrice := plan.Price
-					salespersonPercent := salesperson.CommissionPercent
-					salesManagerPercent := salesManager.CommissionPercent
-
-					log.Printf("DEBUG: Plan price: $%.2f, Salesperson commission percent: %.2f%%, Sales Manager commission percent: %.2f%%",
-						planPrice, salespersonPercent, salesManagerPercent)
-
-					// Calculate commissions correctly
-					// Salesperson gets their percentage directly from the plan price
-					salespersonCommission := planPrice * salespersonPercent / 100.0
-					// Sales manager gets their percentage directly from the plan price
-					salesManagerCommission := planPrice * salesManagerPercent / 100.0
-
-					log.Printf("DEBUG: Calculated commissions - Sales Manager: $%.2f, Salesperson: $%.2f",
-						salesManagerCommission, salespersonCommission)
-
-					// Insert commission document using Commission model
-					commission := models.Commission{
-						ID:                            primitive.NewObjectID(),
-						SubscriptionID:                newSubscription.ID,
-						CompanyID:                     wholesaler.ID, // Using wholesaler ID as the entity ID
-						PlanID:                        plan.ID,
-						PlanPrice:                     planPrice,
-						SalespersonID:                 salesperson.ID,
-						SalespersonCommission:         salespersonCommission,
-						SalespersonCommissionPercent:  salespersonPercent,
-						SalesManagerID:                salesManager.ID,
-						SalesManagerCommission:        salesManagerCommission,
-						SalesManagerCommissionPercent: salesManagerPercent,
-						CreatedAt:                     time.Now(),
-						Paid:                          false,
-						PaidAt:                        nil,
-					}
-					_, err := sc.DB.Collection("commissions").InsertOne(ctx, commission)
-					if err != nil {
-						log.Printf("Failed to insert commission: %v", err)
-					} else {
-						log.Printf("Commission inserted successfully for wholesaler branch subscription - Plan Price: $%.2f, Sales Manager Commission: $%.2f, Salesperson Commission: $%.2f",
-							planPrice, salesManagerCommission, salespersonCommission)
-					}
-				} else {
-					log.Printf("DEBUG: Failed to find sales manager for ID: %v, Error: %v", salesperson.SalesManagerID, err)
-				}
-			} else {
-				log.Printf("DEBUG: Failed to find salesperson for ID: %v, Error: %v", wholesaler.CreatedBy, err)
+			if commission != nil {
+				adminAmount, adminEntityType = utils.AdminWalletShare(commission), "wholesaler_branch_subscription_commission"
+			}
+		}
+		if adminAmount > 0 {
+			if err := sc.addSubscriptionIncomeToAdminWallet(ctx, adminAmount, newSubscription.ID, adminEntityType, wholesaler.BusinessName, branch.Name); err != nil {
+				log.Printf("Failed to add subscription income to admin wallet: %v", err)
 			}
-		} else {
-			log.Printf("DEBUG: Wholesaler was not created by a salesperson (CreatedBy is zero or not set)")
 		}
 		// --- Commission logic end ---
 
@@ -2496,6 +2334,8 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 	var req struct {
 		SponsorshipID primitive.ObjectID `json:"sponsorshipId" validate:"required"`
 		AdminNote     string             `json:"adminNote,omitempty"`
+		PromoCode     string             `json:"promoCode,omitempty"`
+		models.SponsorshipSlotOptions
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, models.Response{
@@ -2595,7 +2435,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 		"sponsorshipId": req.SponsorshipID,
 		"entityId":      branchObjectID,
 		"entityType":    "wholesaler_branch",
-		"status":        bson.M{"$in": []string{"active", "pending"}},
+		"status":        bson.M{"$in": []string{"active", "pending", models.SponsorshipSubscriptionScheduled}},
 	}).Decode(&existingSubscription)
 	if err == nil {
 		return c.JSON(http.StatusConflict, models.Response{
@@ -2637,6 +2477,57 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 		PaymentStatus: "pending",
 	}
 
+	// Hold a sponsor place in the branch's category and governorate while the payment is made
+	sponsorshipSubscriptionController := NewSponsorshipSubscriptionController(sc.DB)
+	slot, refusal, err := sponsorshipSubscriptionController.reserveRequestSlot(ctx, sponsorshipSlotRequest{
+		Sponsorship: sponsorship,
+		EntityType:  subscriptionRequest.EntityType,
+		EntityID:    branchObjectID,
+		EntityName:  subscriptionRequest.EntityName,
+		RequestID:   subscriptionRequest.ID,
+		RequestedBy: userID,
+		Options:     req.SponsorshipSlotOptions,
+		HoldFor:     utils.SponsorshipPaymentHold(),
+	})
+	if err != nil {
+		log.Printf("Failed to reserve sponsorship place: %v", err)
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to reserve sponsorship place",
+		})
+	}
+	if refusal != nil {
+		return c.JSON(refusal.Status, models.Response{
+			Status:  refusal.Status,
+			Message: refusal.Message,
+			Data:    refusal.Data,
+		})
+	}
+	subscriptionRequest.SlotID = slot.ID
+	subscriptionRequest.StartDate = slot.StartDate
+
+	// Apply the promo code, if any, to the amount charged
+	paymentAmount := sponsorship.Price
+	var promo *models.PromoCodeRedemption
+	if req.PromoCode != "" {
+		promo, err = utils.ApplyPromoCode(ctx, sponsorshipSubscriptionController.DB.Client(), utils.PromoCheckout{
+			Code:       req.PromoCode,
+			Checkout:   models.PromoCheckoutSponsorship,
+			PlanType:   models.PromoPlanSponsorship,
+			EntityType: subscriptionRequest.EntityType,
+			EntityID:   branchObjectID,
+			UserID:     userID,
+			RequestID:  subscriptionRequest.ID,
+			ExternalID: externalID,
+			Amount:     sponsorship.Price,
+		})
+		if err != nil {
+			sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
+			return promoCodeErrorResponse(c, err)
+		}
+		paymentAmount = promo.FinalAmount
+	}
+
 	// Get base URL for callback URLs (backend API)
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
@@ -2666,7 +2557,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 
 	// Create Whish payment request
 	whishReq := models.WhishRequest{
-		Amount:             &sponsorship.Price,
+		Amount:             &paymentAmount,
 		Currency:           "USD", // Use USD for sponsorship payments
 		Invoice:            fmt.Sprintf("Wholesaler Branch Sponsorship - %s - %s - Sponsorship: %s", wholesaler.BusinessName, branch.Name, sponsorship.Title),
 		ExternalID:         &externalID,
@@ -2680,6 +2571,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 	collectURL, err := whishService.PostPayment(whishReq)
 	if err != nil {
 		log.Printf("Failed to create Whish payment: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: fmt.Sprintf("Failed to initiate payment: %v", err),
@@ -2693,6 +2585,7 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 	_, err = existingRequestCollection.InsertOne(ctx, subscriptionRequest)
 	if err != nil {
 		log.Printf("Failed to save sponsorship subscription request: %v", err)
+		sponsorshipSubscriptionController.releaseSponsorshipRequest(ctx, subscriptionRequest)
 		return c.JSON(http.StatusInternalServerError, models.Response{
 			Status:  http.StatusInternalServerError,
 			Message: "Failed to create sponsorship subscription request",
@@ -2717,15 +2610,18 @@ func (sc *WholesalerBranchSubscriptionController) CreateWholesalerBranchSponsors
 		Status:  http.StatusCreated,
 		Message: "Sponsorship subscription request created successfully. Please complete the payment.",
 		Data: map[string]interface{}{
-			"requestId":   subscriptionRequest.ID,
-			"sponsorship": sponsorship,
-			"branch":      branch,
-			"wholesaler":  wholesaler.BusinessName,
-			"status":      subscriptionRequest.Status,
-			"submittedAt": subscriptionRequest.RequestedAt,
-			"adminNote":   subscriptionRequest.AdminNote,
-			"paymentUrl":  collectURL,
-			"price":       sponsorship.Price,
+			"requestId":     subscriptionRequest.ID,
+			"sponsorship":   sponsorship,
+			"branch":        branch,
+			"wholesaler":    wholesaler.BusinessName,
+			"status":        subscriptionRequest.Status,
+			"submittedAt":   subscriptionRequest.RequestedAt,
+			"adminNote":     subscriptionRequest.AdminNote,
+			"paymentUrl":    collectURL,
+			"price":         sponsorship.Price,
+			"startDate":     subscriptionRequest.StartDate,
+			"paymentAmount": paymentAmount,
+			"promo":         promo,
 		},
 	})
 }
@@ -2856,6 +2752,7 @@ func (sc *WholesalerBranchSubscriptionController) HandleWhishSponsorshipPaymentS
 				"status":        "failed",
 				"processedAt":   time.Now(),
 			}})
+		NewSponsorshipSubscriptionController(sc.DB).releaseSponsorshipRequest(ctx, subscriptionRequest)
 		log.Printf("==========================================")
 		return c.String(http.StatusBadRequest, "Payment not successful")
 	}
@@ -2957,6 +2854,7 @@ func (sc *WholesalerBranchSubscriptionController) HandleWhishSponsorshipPaymentF
 	if err == nil {
 		log.Printf("   Request ID: %s", subscriptionRequest.ID.Hex())
 		log.Printf("   Entity: %s (%s)", subscriptionRequest.EntityName, subscriptionRequest.EntityType)
+		NewSponsorshipSubscriptionController(sc.DB).releaseSponsorshipRequest(ctx, subscriptionRequest)
 	}
 
 	_, err = requestCollection.UpdateOne(ctx,
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/HSouheill/barrim_backend/middleware"
 	"github.com/HSouheill/barrim_backend/repositories"
 	"github.com/HSouheill/barrim_backend/routes"
+	"github.com/HSouheill/barrim_backend/utils"
 	"github.com/HSouheill/barrim_backend/websocket"
 )
 
@@ -52,6 +54,38 @@ func main() {
 	client := config.ConnectDB()
 	barrimDB := client.Database("barrim") // Ensure consistent database reference
 
+	utils.CheckVoucherCodeSecret()
+
+	// Add GeoJSON points to documents saved before they were stored
+	go utils.BackfillGeoPoints(client)
+
+	// Record the entity of commissions from before it was, so renewals are recognised
+	go func() {
+		if updated, err := utils.BackfillCommissionEntities(context.Background(), client); err != nil {
+			log.Printf("Error backfilling commission entities: %v", err)
+		} else if updated > 0 {
+			log.Printf("Backfilled the entity of %d commissions", updated)
+		}
+	}()
+
+	// Move voucher purchases from the per-owner collections into the unified one
+	go func() {
+		if moved, err := utils.MigrateVoucherPurchases(context.Background(), client); err != nil {
+			log.Printf("Error migrating voucher purchases: %v", err)
+		} else if moved > 0 {
+			log.Printf("Migrated %d voucher purchases", moved)
+		}
+	}()
+
+	// Add the referrals recorded before the referral graph to it
+	go func() {
+		if added, err := utils.BackfillReferralGraph(context.Background(), client); err != nil {
+			log.Printf("Error backfilling referral graph: %v", err)
+		} else if added > 0 {
+			log.Printf("Added %d referrals to the referral graph", added)
+		}
+	}()
+
 	// Create WebSocket hub
 	wsHub := websocket.NewHub()
 	go wsHub.Run()
@@ -148,6 +182,103 @@ func main() {
 		}
 	}()
 
+	// Expire pending bookings whose slot hold ran out before the provider responded,
+	// send upcoming booking reminders and ask providers for appointment outcomes
+	bookingController := controllers.NewBookingController(client, wsHub)
+	go func() {
+		for {
+			bookingController.ExpireStaleBookings()
+			bookingController.SendBookingReminders()
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	// Escalate emergency requests nobody answered before their round timed out
+	go func() {
+		for {
+			bookingController.ProcessEmergencyDispatches()
+			time.Sleep(15 * time.Second)
+		}
+	}()
+
+	// Release lapsed sponsorship payment holds, start and end scheduled
+	// sponsorships and notify the sponsorship waiting list
+	sponsorshipSubscriptionController := controllers.NewSponsorshipSubscriptionController(barrimDB)
+	go func() {
+		for {
+			sponsorshipSubscriptionController.ProcessSponsorshipSchedule()
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	// Release promo codes held by checkouts that were never paid
+	go func() {
+		for {
+			if released, err := utils.ReleaseStalePromoRedemptions(context.Background(), client); err != nil {
+				log.Printf("Failed to release stale promo codes: %v", err)
+			} else if released > 0 {
+				log.Printf("Released %d stale promo code redemptions", released)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Give the held referral signup rewards whose referee came back
+	go func() {
+		for {
+			if vested, err := utils.VestReferralRewards(context.Background(), client); err != nil {
+				log.Printf("Failed to vest referral rewards: %v", err)
+			} else if vested > 0 {
+				log.Printf("Vested %d referral rewards", vested)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Expire old points, remind accounts of points about to expire and keep loyalty tiers current
+	go func() {
+		for {
+			if expired, err := utils.ExpirePoints(context.Background(), client); err != nil {
+				log.Printf("Failed to expire points: %v", err)
+			} else if expired > 0 {
+				log.Printf("Expired points of %d accounts", expired)
+			}
+			if reminded, err := utils.NotifyExpiringPoints(context.Background(), client); err != nil {
+				log.Printf("Failed to notify expiring points: %v", err)
+			} else if reminded > 0 {
+				log.Printf("Told %d accounts about expiring points", reminded)
+			}
+			if changed, err := utils.RefreshLoyaltyTiers(context.Background(), client); err != nil {
+				log.Printf("Failed to refresh loyalty tiers: %v", err)
+			} else if changed > 0 {
+				log.Printf("Changed %d loyalty tiers", changed)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Rebuild rating aggregates to correct drift of the incremental updates
+	go func() {
+		for {
+			if count, err := utils.RecomputeRatings(context.Background(), client); err != nil {
+				log.Printf("Failed to recompute ratings: %v", err)
+			} else {
+				log.Printf("Recomputed %d rating aggregates", count)
+			}
+			time.Sleep(utils.RatingRecomputeInterval())
+		}
+	}()
+
+	// Keep the in-memory search index fresh
+	go func() {
+		for {
+			if err := utils.RefreshSearchIndex(client); err != nil {
+				log.Printf("Failed to refresh search index: %v", err)
+			}
+			time.Sleep(utils.SearchIndexRefreshInterval())
+		}
+	}()
+
 	// Ensure uploads directory exists
 	os.MkdirAll("uploads", 0755)
 	os.MkdirAll("uploads/vouchers", 0755)
@@ -45,7 +45,7 @@ func NewCORSConfig() *CORSConfig {
 	return &CORSConfig{
 		AllowOrigins:     origins,
 		AllowMethods:     []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "X-API-Key"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "X-API-Key", "X-Device-ID"},
 		AllowCredentials: true,
 		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
 		MaxAge:           86400, // 24 hours
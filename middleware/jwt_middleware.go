@@ -337,6 +337,9 @@ func ActivityTracker(db *mongo.Client) echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			// Remember where the user connects from, for referral fraud checks
+			ip, deviceID := c.RealIP(), c.Request().Header.Get("X-Device-ID")
+
 			// Update lastActivityAt and isActive in background
 			go func() {
 				collection := config.GetCollection(db, "users")
@@ -345,11 +348,16 @@ func ActivityTracker(db *mongo.Client) echo.MiddlewareFunc {
 
 				now := time.Now()
 				filter := bson.M{"_id": objID}
-				update := bson.M{"$set": bson.M{
+				set := bson.M{
 					"lastActivityAt": now,
 					"isActive":       true,
 					"updatedAt":      now,
-				}}
+					"lastIp":         ip,
+				}
+				if deviceID != "" {
+					set["lastDeviceId"] = deviceID
+				}
+				update := bson.M{"$set": set}
 
 				_, err := collection.UpdateOne(ctx, filter, update)
 				if err != nil {
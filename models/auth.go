@@ -3,15 +3,17 @@
 package models
 
 type SignupRequest struct {
-	Email           string    `json:"email"`
-	Password        string    `json:"password"`
-	FullName        string    `json:"fullName"`
-	UserType        string    `json:"userType"` // "user", "company", "wholesaler", "serviceProvider"
-	DateOfBirth     string    `json:"dateOfBirth"`
-	Gender          string    `json:"gender"`
-	Phone           string    `json:"phone,omitempty" bson:"phone,omitempty"`
-	ProfilePic      string    `json:"profilePic,omitempty" bson:"profilePic,omitempty"`
-	ReferralCode    string    `json:"referralCode,omitempty"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	FullName     string `json:"fullName"`
+	UserType     string `json:"userType"` // "user", "company", "wholesaler", "serviceProvider"
+	DateOfBirth  string `json:"dateOfBirth"`
+	Gender       string `json:"gender"`
+	Phone        string `json:"phone,omitempty" bson:"phone,omitempty"`
+	ProfilePic   string `json:"profilePic,omitempty" bson:"profilePic,omitempty"`
+	ReferralCode string `json:"referralCode,omitempty"`
+	// Click of the tracked referral link the app was installed from, if any
+	ReferralClickID string    `json:"referralClickId,omitempty"`
 	InterestedDeals []string  `json:"interestedDeals" bson:"interestedDeals"`
 	Location        *Location `json:"location" bson:"location"`
 	LogoPath        string    `json:"logoPath,omitempty" bson:"logoPath,omitempty"`
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// ProviderAvailability is a service provider's booking calendar. It replaces the
+// legacy AvailableDays/AvailableWeekdays/AvailableHours string arrays when set.
+type ProviderAvailability struct {
+	TimeZone            string                   `json:"timeZone,omitempty" bson:"timeZone,omitempty"` // IANA name, e.g. "Asia/Beirut"
+	SlotDurationMinutes int                      `json:"slotDurationMinutes" bson:"slotDurationMinutes"`
+	BufferMinutes       int                      `json:"bufferMinutes" bson:"bufferMinutes"` // Gap kept free after every booking
+	DailyCapacity       int                      `json:"dailyCapacity" bson:"dailyCapacity"` // Max active bookings per day, 0 = unlimited
+	WeeklyRules         []WeeklyAvailabilityRule `json:"weeklyRules" bson:"weeklyRules"`
+	ServiceDurations    []ServiceDuration        `json:"serviceDurations,omitempty" bson:"serviceDurations,omitempty"`
+	Holidays            []string                 `json:"holidays,omitempty" bson:"holidays,omitempty"`     // "2006-01-02" dates with no availability
+	Exceptions          []AvailabilityException  `json:"exceptions,omitempty" bson:"exceptions,omitempty"` // Per-date overrides of the weekly rules
+	UpdatedAt           time.Time                `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// TimeInterval is a working interval within a day in "15:04" format
+type TimeInterval struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// WeeklyAvailabilityRule lists the working intervals for one weekday
+type WeeklyAvailabilityRule struct {
+	Weekday   string         `json:"weekday" bson:"weekday"` // "Monday" ... "Sunday"
+	Intervals []TimeInterval `json:"intervals" bson:"intervals"`
+}
+
+// ServiceDuration overrides the default slot duration for a service type
+type ServiceDuration struct {
+	ServiceType     string `json:"serviceType" bson:"serviceType"`
+	DurationMinutes int    `json:"durationMinutes" bson:"durationMinutes"`
+}
+
+// AvailabilityException overrides the weekly rules on a single date
+type AvailabilityException struct {
+	Date      string         `json:"date" bson:"date"` // "2006-01-02"
+	Closed    bool           `json:"closed" bson:"closed"`
+	Intervals []TimeInterval `json:"intervals,omitempty" bson:"intervals,omitempty"`
+	Note      string         `json:"note,omitempty" bson:"note,omitempty"`
+}
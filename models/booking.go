@@ -8,28 +8,59 @@ import (
 
 // Booking model
 type Booking struct {
+	ID                primitive.ObjectID    `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID            primitive.ObjectID    `json:"userId" bson:"userId"`
+	ServiceProviderID primitive.ObjectID    `json:"serviceProviderId" bson:"serviceProviderId"`
+	BookingDate       time.Time             `json:"bookingDate" bson:"bookingDate"`
+	TimeSlot          string                `json:"timeSlot" bson:"timeSlot"`
+	DurationMinutes   int                   `json:"durationMinutes,omitempty" bson:"durationMinutes,omitempty"` // Length of the booked service, used to block overlapping slots
+	PhoneNumber       string                `json:"phoneNumber" bson:"phoneNumber"`
+	Details           string                `json:"details" bson:"details"`
+	IsEmergency       bool                  `json:"isEmergency" bson:"isEmergency"`
+	Status            string                `json:"status" bson:"status"`                                         // "pending", "accepted", "rejected", "confirmed", "completed", "cancelled", "expired", "no_show"; see booking_state.go
+	ProviderResponse  string                `json:"providerResponse,omitempty" bson:"providerResponse,omitempty"` // Optional message from service provider
+	MediaTypes        []string              `json:"mediaTypes,omitempty" bson:"mediaTypes,omitempty"`             // Array of "image" or "video"
+	MediaURLs         []string              `json:"mediaUrls,omitempty" bson:"mediaUrls,omitempty"`               // Array of URLs to the uploaded media
+	ThumbnailURLs     []string              `json:"thumbnailUrls,omitempty" bson:"thumbnailUrls,omitempty"`       // Array of URLs to the thumbnails (for videos)
+	StatusHistory     []BookingStatusChange `json:"statusHistory,omitempty" bson:"statusHistory,omitempty"`
+	HoldExpiresAt     *time.Time            `json:"holdExpiresAt,omitempty" bson:"holdExpiresAt,omitempty"` // When a pending booking releases its slot if the provider hasn't responded
+	RemindersSent     []string              `json:"remindersSent,omitempty" bson:"remindersSent,omitempty"` // Reminder keys already sent, e.g. "24h", "1h"
+	RescheduleCount   int                   `json:"rescheduleCount,omitempty" bson:"rescheduleCount,omitempty"`
+	PendingReschedule *BookingReschedule    `json:"pendingReschedule,omitempty" bson:"pendingReschedule,omitempty"`
+	RescheduleHistory []BookingReschedule   `json:"rescheduleHistory,omitempty" bson:"rescheduleHistory,omitempty"`
+	OutcomePromptedAt *time.Time            `json:"outcomePromptedAt,omitempty" bson:"outcomePromptedAt,omitempty"` // When the provider was asked to mark completed or no-show
+	CreatedAt         time.Time             `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time             `json:"updatedAt" bson:"updatedAt"`
+}
+
+// BookingSlotHold reserves a provider's time slot for a booking. The unique index on
+// (serviceProviderId, date, timeSlot) makes reservation atomic.
+type BookingSlotHold struct {
 	ID                primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID            primitive.ObjectID `json:"userId" bson:"userId"`
 	ServiceProviderID primitive.ObjectID `json:"serviceProviderId" bson:"serviceProviderId"`
-	BookingDate       time.Time          `json:"bookingDate" bson:"bookingDate"`
-	TimeSlot          string             `json:"timeSlot" bson:"timeSlot"`
-	PhoneNumber       string             `json:"phoneNumber" bson:"phoneNumber"`
-	Details           string             `json:"details" bson:"details"`
-	IsEmergency       bool               `json:"isEmergency" bson:"isEmergency"`
-	Status            string             `json:"status" bson:"status"`                                         // "pending", "accepted", "rejected", "confirmed", "completed", "cancelled"
-	ProviderResponse  string             `json:"providerResponse,omitempty" bson:"providerResponse,omitempty"` // Optional message from service provider
-	MediaTypes        []string           `json:"mediaTypes,omitempty" bson:"mediaTypes,omitempty"`             // Array of "image" or "video"
-	MediaURLs         []string           `json:"mediaUrls,omitempty" bson:"mediaUrls,omitempty"`               // Array of URLs to the uploaded media
-	ThumbnailURLs     []string           `json:"thumbnailUrls,omitempty" bson:"thumbnailUrls,omitempty"`       // Array of URLs to the thumbnails (for videos)
+	Date              string             `json:"date" bson:"date"`         // "2006-01-02"
+	TimeSlot          string             `json:"timeSlot" bson:"timeSlot"` // "15:04" start of a calendar cell
+	BookingID         primitive.ObjectID `json:"bookingId" bson:"bookingId"`
+	ReservationID     primitive.ObjectID `json:"reservationId,omitempty" bson:"reservationId,omitempty"` // Shared by the cells reserved together
+	ExpiresAt         *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`         // Cleared once the provider accepts
 	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
+// AlternativeSlots lists free slots offered when a requested slot is taken
+type AlternativeSlots struct {
+	Date  string   `json:"date"`
+	Slots []string `json:"slots"`
+}
+
+// ActiveBookingStatuses are the booking statuses that hold a provider's time slot
+var ActiveBookingStatuses = []string{BookingStatusPending, BookingStatusAccepted, BookingStatusConfirmed}
+
 // BookingRequest model
 type BookingRequest struct {
 	ServiceProviderID string    `json:"serviceProviderId"`
 	BookingDate       time.Time `json:"bookingDate"`
 	TimeSlot          string    `json:"timeSlot"`
+	ServiceType       string    `json:"serviceType,omitempty"` // Selects a per-service duration from the provider's calendar
 	PhoneNumber       string    `json:"phoneNumber"`
 	Details           string    `json:"details"`
 	IsEmergency       bool      `json:"isEmergency"`
@@ -42,6 +73,37 @@ type BookingRequest struct {
 type BookingStatusUpdateRequest struct {
 	Status           string `json:"status"`
 	ProviderResponse string `json:"providerResponse,omitempty"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// BookingReschedule is a proposal by one party to move a booking to a new date and slot
+type BookingReschedule struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	ProposedBy   string             `json:"proposedBy" bson:"proposedBy"` // "user" or "serviceProvider"
+	ProposedByID primitive.ObjectID `json:"proposedById" bson:"proposedById"`
+	FromDate     time.Time          `json:"fromDate" bson:"fromDate"`
+	FromTimeSlot string             `json:"fromTimeSlot" bson:"fromTimeSlot"`
+	BookingDate  time.Time          `json:"bookingDate" bson:"bookingDate"`
+	TimeSlot     string             `json:"timeSlot" bson:"timeSlot"`
+	Reason       string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status       string             `json:"status" bson:"status"` // "pending", "accepted", "declined", "countered"
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
+	RespondedAt  *time.Time         `json:"respondedAt,omitempty" bson:"respondedAt,omitempty"`
+}
+
+// RescheduleRequest proposes a new date and slot for a booking
+type RescheduleRequest struct {
+	BookingDate time.Time `json:"bookingDate"`
+	TimeSlot    string    `json:"timeSlot"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// RescheduleResponseRequest answers a pending reschedule proposal
+type RescheduleResponseRequest struct {
+	Action      string    `json:"action"` // "accept", "decline" or "counter"
+	BookingDate time.Time `json:"bookingDate,omitempty"`
+	TimeSlot    string    `json:"timeSlot,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
 }
 
 // BookingResponse model
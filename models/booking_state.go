@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Booking statuses
+const (
+	BookingStatusPending   = "pending"
+	BookingStatusAccepted  = "accepted"
+	BookingStatusRejected  = "rejected"
+	BookingStatusConfirmed = "confirmed"
+	BookingStatusCompleted = "completed"
+	BookingStatusCancelled = "cancelled"
+	BookingStatusExpired   = "expired"
+	BookingStatusNoShow    = "no_show"
+)
+
+// Actors allowed to change a booking's status
+const (
+	BookingActorUser     = "user"
+	BookingActorProvider = "serviceProvider"
+	BookingActorAdmin    = "admin"
+	BookingActorSystem   = "system"
+)
+
+// BookingStatusChange records one status transition of a booking
+type BookingStatusChange struct {
+	From      string             `json:"from" bson:"from"`
+	To        string             `json:"to" bson:"to"`
+	Actor     string             `json:"actor" bson:"actor"` // "user", "serviceProvider", "admin" or "system"
+	ActorID   primitive.ObjectID `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ChangedAt time.Time          `json:"changedAt" bson:"changedAt"`
+}
+
+// ErrInvalidBookingTransition is returned for moves the booking state machine doesn't allow
+var ErrInvalidBookingTransition = errors.New("invalid booking status change")
+
+// bookingTransitions maps from-status -> to-status -> actors allowed to make that move
+var bookingTransitions = map[string]map[string][]string{
+	BookingStatusPending: {
+		BookingStatusAccepted:  {BookingActorProvider},
+		BookingStatusRejected:  {BookingActorProvider},
+		BookingStatusCancelled: {BookingActorUser, BookingActorProvider, BookingActorAdmin},
+		BookingStatusExpired:   {BookingActorSystem},
+	},
+	BookingStatusAccepted: {
+		BookingStatusConfirmed: {BookingActorUser, BookingActorProvider},
+		BookingStatusCompleted: {BookingActorProvider, BookingActorAdmin},
+		BookingStatusNoShow:    {BookingActorProvider, BookingActorAdmin},
+		BookingStatusCancelled: {BookingActorUser, BookingActorProvider, BookingActorAdmin},
+	},
+	BookingStatusConfirmed: {
+		BookingStatusCompleted: {BookingActorProvider, BookingActorAdmin},
+		BookingStatusNoShow:    {BookingActorProvider, BookingActorAdmin},
+		BookingStatusCancelled: {BookingActorUser, BookingActorProvider, BookingActorAdmin},
+	},
+}
+
+// IsBookingOutcome reports whether a status records how an appointment went,
+// which can only be known once the appointment has started
+func IsBookingOutcome(status string) bool {
+	return status == BookingStatusCompleted || status == BookingStatusNoShow
+}
+
+// IsTerminalBookingStatus reports whether no further transitions are possible from a status
+func IsTerminalBookingStatus(status string) bool {
+	return len(bookingTransitions[status]) == 0
+}
+
+// CheckBookingTransition returns an error unless actor may move a booking from one status to another
+func CheckBookingTransition(from, to, actor string) error {
+	targets, ok := bookingTransitions[from]
+	if !ok || len(targets) == 0 {
+		return fmt.Errorf("%w: booking is already %s", ErrInvalidBookingTransition, from)
+	}
+	actors, ok := targets[to]
+	if !ok {
+		return fmt.Errorf("%w: cannot change booking from %s to %s", ErrInvalidBookingTransition, from, to)
+	}
+	for _, a := range actors {
+		if a == actor {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s cannot change booking from %s to %s", ErrInvalidBookingTransition, actor, from, to)
+}
@@ -6,12 +6,43 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Kinds of commissioned sales
+const (
+	CommissionSaleFirst   = "first"   // The first commissioned subscription of an entity or branch
+	CommissionSaleRenewal = "renewal" // Any later one
+)
+
+// CommissionAny matches every plan type, entity type, salesperson tier or
+// sale kind in a commission rule
+const CommissionAny = "any"
+
+// Roles a commission record pays
+const (
+	CommissionRoleSalesperson  = "salesperson"
+	CommissionRoleSalesManager = "sales_manager"
+)
+
+// CommissionPersonalRates names the built-in rule paying the salesperson's
+// and sales manager's own commission percent when no configured rule matches
+const CommissionPersonalRates = "Personal rates"
+
 type Commission struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	SubscriptionID primitive.ObjectID `bson:"subscriptionId" json:"subscriptionId"`
 	CompanyID      primitive.ObjectID `bson:"companyId" json:"companyId"`
 	PlanID         primitive.ObjectID `bson:"planId" json:"planId"`
 	PlanPrice      float64            `bson:"planPrice" json:"planPrice"`
+	BaseAmount     float64            `bson:"baseAmount,omitempty" json:"baseAmount,omitempty"` // What was paid, after any promo code
+
+	// What was sold and the rule that set the rates
+	EntityType      string              `bson:"entityType,omitempty" json:"entityType,omitempty"` // "company", "wholesaler" or "serviceProvider"
+	EntityID        primitive.ObjectID  `bson:"entityId,omitempty" json:"entityId,omitempty"`
+	BranchID        *primitive.ObjectID `bson:"branchId,omitempty" json:"branchId,omitempty"`
+	PlanType        string              `bson:"planType,omitempty" json:"planType,omitempty"`
+	SaleKind        string              `bson:"saleKind,omitempty" json:"saleKind,omitempty"` // "first" or "renewal"
+	SalespersonTier string              `bson:"salespersonTier,omitempty" json:"salespersonTier,omitempty"`
+	RuleID          *primitive.ObjectID `bson:"ruleId,omitempty" json:"ruleId,omitempty"` // Unset when the personal rates applied
+	RuleName        string              `bson:"ruleName,omitempty" json:"ruleName,omitempty"`
 
 	// Admin commission fields
 	AdminID                primitive.ObjectID `bson:"adminID" json:"adminId"`
@@ -32,3 +63,26 @@ type Commission struct {
 	Paid      bool       `bson:"paid" json:"paid"`
 	PaidAt    *time.Time `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
 }
+
+// CommissionRule sets the share of a subscription sale paid to the
+// salesperson who made it and, as an override, to their sales manager. Both
+// are percents of the plan price; the admin keeps the rest. Among the rules
+// matching a sale the one with the highest priority applies, and the one
+// naming more criteria beats "any" at equal priority.
+type CommissionRule struct {
+	ID                     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name                   string             `json:"name" bson:"name"`
+	PlanType               string             `json:"planType" bson:"planType"`               // "company", "wholesaler", "serviceProvider" or "any"
+	EntityType             string             `json:"entityType" bson:"entityType"`           // "company", "wholesaler", "serviceProvider" or "any"
+	SalespersonTier        string             `json:"salespersonTier" bson:"salespersonTier"` // A tier admins gave salespersons, or "any"
+	SaleKind               string             `json:"saleKind" bson:"saleKind"`               // "first", "renewal" or "any"
+	SalespersonPercent     float64            `json:"salespersonPercent" bson:"salespersonPercent"`
+	ManagerOverridePercent float64            `json:"managerOverridePercent" bson:"managerOverridePercent"` // Paid only when the salesperson has a sales manager
+	Priority               int                `json:"priority" bson:"priority"`
+	StartsAt               *time.Time         `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
+	EndsAt                 *time.Time         `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
+	IsActive               bool               `json:"isActive" bson:"isActive"`
+	CreatedBy              primitive.ObjectID `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt              time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt              time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
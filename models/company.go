@@ -17,6 +17,7 @@ type Company struct {
 	ReferralCode     string               `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
 	Referrals        []primitive.ObjectID `json:"referrals,omitempty" bson:"referrals,omitempty"` // Added: List of referred companies
 	Points           int                  `json:"points" bson:"points"`
+	LoyaltyTier      string               `json:"loyaltyTier,omitempty" bson:"loyaltyTier,omitempty"` // Kept up to date by RefreshLoyaltyTiers
 	ContactInfo      ContactInfo          `json:"contactInfo" bson:"contactInfo"`
 	ContactPerson    string               `json:"contactPerson,omitempty" bson:"contactPerson,omitempty"`
 	AdditionalPhones []string             `json:"additionalPhones,omitempty" bson:"additionalPhones,omitempty"`
@@ -84,6 +85,8 @@ type BranchComment struct {
 	Replies    []CommentReply     `json:"replies,omitempty" bson:"replies,omitempty"`
 	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	ModerationInfo `bson:",inline"`
 }
 
 // CommentReply represents a company's reply to a user comment
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Emergency dispatch statuses
+const (
+	EmergencyDispatchStatusDispatching = "dispatching" // Waiting for one of the current candidates to accept
+	EmergencyDispatchStatusAccepted    = "accepted"    // A provider accepted and a booking was created
+	EmergencyDispatchStatusUnanswered  = "unanswered"  // Every escalation round timed out
+	EmergencyDispatchStatusCancelled   = "cancelled"   // The user withdrew the request
+)
+
+// GeoPoint is a latitude/longitude pair
+type GeoPoint struct {
+	Lat float64 `json:"lat" bson:"lat"`
+	Lng float64 `json:"lng" bson:"lng"`
+}
+
+// EmergencyDispatch fans an emergency request out to the nearest available providers
+// for a service type. The first provider to accept wins; if nobody answers before the
+// round expires the request escalates to a wider radius.
+type EmergencyDispatch struct {
+	ID             primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID         primitive.ObjectID   `json:"userId" bson:"userId"`
+	ServiceType    string               `json:"serviceType" bson:"serviceType"`
+	Location       GeoPoint             `json:"location" bson:"location"`
+	PhoneNumber    string               `json:"phoneNumber" bson:"phoneNumber"`
+	Details        string               `json:"details" bson:"details"`
+	Status         string               `json:"status" bson:"status"` // "dispatching", "accepted", "unanswered", "cancelled"
+	Round          int                  `json:"round" bson:"round"`   // Starts at 1, incremented on every escalation
+	RadiusKm       float64              `json:"radiusKm" bson:"radiusKm"`
+	CandidateIDs   []primitive.ObjectID `json:"candidateIds" bson:"candidateIds"`                   // Providers offered the request in the current round
+	NotifiedIDs    []primitive.ObjectID `json:"notifiedIds" bson:"notifiedIds"`                     // Every provider offered the request so far
+	DeclinedIDs    []primitive.ObjectID `json:"declinedIds,omitempty" bson:"declinedIds,omitempty"` // Providers that turned it down
+	AcceptedBy     *primitive.ObjectID  `json:"acceptedBy,omitempty" bson:"acceptedBy,omitempty"`
+	BookingID      *primitive.ObjectID  `json:"bookingId,omitempty" bson:"bookingId,omitempty"`
+	RoundExpiresAt time.Time            `json:"roundExpiresAt" bson:"roundExpiresAt"`
+	CreatedAt      time.Time            `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time            `json:"updatedAt" bson:"updatedAt"`
+}
+
+// EmergencyBookingRequest asks for the nearest available provider of a service type
+type EmergencyBookingRequest struct {
+	ServiceType string  `json:"serviceType"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	PhoneNumber string  `json:"phoneNumber"`
+	Details     string  `json:"details"`
+}
+
+// EmergencyDispatchResponseRequest is a provider's answer to an emergency request
+type EmergencyDispatchResponseRequest struct {
+	Action string `json:"action"` // "accept" or "decline"
+}
+
+// EmergencyCandidate is a provider offered an emergency request, with its distance from the user
+type EmergencyCandidate struct {
+	ServiceProviderID primitive.ObjectID `json:"serviceProviderId"`
+	DistanceKm        float64            `json:"distanceKm"`
+}
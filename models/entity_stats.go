@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Entity events counted in the daily stats
+const (
+	EntityEventImpression  = "impression"
+	EntityEventProfileView = "profile_view"
+	EntityEventBranchView  = "branch_view"
+	EntityEventPhoneTap    = "phone_tap"
+	EntityEventWhatsAppTap = "whatsapp_tap"
+	EntityEventFavorite    = "favorite"
+	EntityEventBooking     = "booking"
+)
+
+// EntityDailyStats holds the counters of one sponsorable entity for one day
+type EntityDailyStats struct {
+	ID                primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	EntityType        string             `json:"entityType" bson:"entityType"` // "service_provider", "company_branch", "wholesaler_branch", "wholesaler" or "company"
+	EntityID          primitive.ObjectID `json:"entityId" bson:"entityId"`
+	Date              string             `json:"date" bson:"date"` // UTC day, "2006-01-02"
+	Impressions       int64              `json:"impressions" bson:"impressions"`
+	PinnedImpressions int64              `json:"pinnedImpressions" bson:"pinnedImpressions"`
+	ProfileViews      int64              `json:"profileViews" bson:"profileViews"`
+	BranchViews       int64              `json:"branchViews" bson:"branchViews"`
+	PhoneTaps         int64              `json:"phoneTaps" bson:"phoneTaps"`
+	WhatsAppTaps      int64              `json:"whatsappTaps" bson:"whatsappTaps"`
+	Favorites         int64              `json:"favorites" bson:"favorites"`
+	Bookings          int64              `json:"bookings" bson:"bookings"`
+	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// EntityStatsTotals sums the daily stats of one or more entities over a period
+type EntityStatsTotals struct {
+	Impressions       int64 `json:"impressions" bson:"impressions"`
+	PinnedImpressions int64 `json:"pinnedImpressions" bson:"pinnedImpressions"`
+	ProfileViews      int64 `json:"profileViews" bson:"profileViews"`
+	BranchViews       int64 `json:"branchViews" bson:"branchViews"`
+	PhoneTaps         int64 `json:"phoneTaps" bson:"phoneTaps"`
+	WhatsAppTaps      int64 `json:"whatsappTaps" bson:"whatsappTaps"`
+	Favorites         int64 `json:"favorites" bson:"favorites"`
+	Bookings          int64 `json:"bookings" bson:"bookings"`
+}
+
+// TrackEntityEventRequest is sent by the apps for events only they can see
+type TrackEntityEventRequest struct {
+	EntityType string `json:"entityType"`
+	EntityID   string `json:"entityId"`
+	Event      string `json:"event"` // "profile_view", "branch_view", "phone_tap" or "whatsapp_tap"
+}
+
+// MetricComparison compares the daily average of one metric before and during a sponsorship
+type MetricComparison struct {
+	BeforeDailyAvg    float64  `json:"beforeDailyAvg"`
+	SponsoredDailyAvg float64  `json:"sponsoredDailyAvg"`
+	ChangePercent     *float64 `json:"changePercent"` // Nil when there was no activity before
+}
+
+// SponsorshipPeriodComparison compares a sponsored period with the period of the
+// same length just before it
+type SponsorshipPeriodComparison struct {
+	SubscriptionID primitive.ObjectID          `json:"subscriptionId"`
+	Status         string                      `json:"status"`
+	StartDate      time.Time                   `json:"startDate"`
+	EndDate        time.Time                   `json:"endDate"`
+	Days           int                         `json:"days"` // Days of the sponsored period elapsed so far
+	Before         EntityStatsTotals           `json:"before"`
+	Sponsored      EntityStatsTotals           `json:"sponsored"`
+	Metrics        map[string]MetricComparison `json:"metrics"`
+}
+
+// EntityAnalytics is the analytics dashboard of one sponsorable entity
+type EntityAnalytics struct {
+	EntityType  string                        `json:"entityType"`
+	EntityID    primitive.ObjectID            `json:"entityId"`
+	Name        string                        `json:"name"`
+	Sponsored   bool                          `json:"sponsored"`
+	Totals      EntityStatsTotals             `json:"totals"`
+	Daily       []EntityDailyStats            `json:"daily"`
+	Comparisons []SponsorshipPeriodComparison `json:"comparisons"`
+}
+
+// EntityStatsSummary is one entity's totals in the admin sponsorship report
+type EntityStatsSummary struct {
+	EntityType string             `json:"entityType" bson:"entityType"`
+	EntityID   primitive.ObjectID `json:"entityId" bson:"entityId"`
+	Sponsored  bool               `json:"sponsored" bson:"-"`
+	Totals     EntityStatsTotals  `json:"totals" bson:"totals"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// GeoJSONPoint is a GeoJSON point as indexed by MongoDB 2dsphere indexes.
+// Coordinates are [longitude, latitude].
+type GeoJSONPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// NewGeoJSONPoint returns the GeoJSON point for a latitude/longitude pair, or nil
+// when the coordinates are unset (0, 0) or out of range so nothing invalid is indexed
+func NewGeoJSONPoint(lat, lng float64) *GeoJSONPoint {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil
+	}
+	return &GeoJSONPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// MarshalBSON stores a "point" GeoJSON field next to lat/lng so every write of an
+// Address (company contact info, branches, service providers) stays geo-indexed
+func (a Address) MarshalBSON() ([]byte, error) {
+	type AddressFields Address
+	return bson.Marshal(struct {
+		AddressFields `bson:",inline"`
+		Point         *GeoJSONPoint `bson:"point,omitempty"`
+	}{AddressFields(a), NewGeoJSONPoint(a.Lat, a.Lng)})
+}
+
+// MarshalBSON stores a "point" GeoJSON field next to lat/lng so user locations stay geo-indexed
+func (l Location) MarshalBSON() ([]byte, error) {
+	type LocationFields Location
+	return bson.Marshal(struct {
+		LocationFields `bson:",inline"`
+		Point          *GeoJSONPoint `bson:"point,omitempty"`
+	}{LocationFields(l), NewGeoJSONPoint(l.Lat, l.Lng)})
+}
+
+// GeoSearchResult is a branch or service provider found by a geospatial search
+type GeoSearchResult struct {
+	Type        string      `json:"type"` // "branch" or "serviceProvider"
+	ID          string      `json:"id"`
+	OwnerID     string      `json:"ownerId,omitempty"`   // Company or wholesaler owning a branch
+	OwnerType   string      `json:"ownerType,omitempty"` // "company" or "wholesaler"
+	Name        string      `json:"name"`
+	Category    string      `json:"category,omitempty"`
+	SubCategory string      `json:"subCategory,omitempty"`
+	Location    Address     `json:"location"`
+	DistanceKm  float64     `json:"distanceKm"`
+	Sponsored   bool        `json:"sponsored"`
+	Details     interface{} `json:"details,omitempty"`
+}
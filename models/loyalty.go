@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Loyalty tiers, lowest first
+const (
+	LoyaltyTierBronze = "bronze"
+	LoyaltyTierSilver = "silver"
+	LoyaltyTierGold   = "gold"
+)
+
+// LoyaltyTiers lists the loyalty tiers from lowest to highest
+var LoyaltyTiers = []string{LoyaltyTierBronze, LoyaltyTierSilver, LoyaltyTierGold}
+
+// What loyalty tiers are computed from
+const (
+	LoyaltyBasisPoints   = "points"   // Points earned over the rolling window
+	LoyaltyBasisBookings = "bookings" // Bookings completed over the rolling window
+)
+
+// LoyaltySettingsID is the key of the single loyalty settings document
+const LoyaltySettingsID = "loyalty"
+
+// LoyaltyTierRank returns the position of a tier from the lowest, or -1 for an unknown tier
+func LoyaltyTierRank(tier string) int {
+	for i, t := range LoyaltyTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// LoyaltyTierThreshold is the score an account needs over the rolling window to reach a tier
+type LoyaltyTierThreshold struct {
+	Tier      string `json:"tier" bson:"tier"`
+	Threshold int    `json:"threshold" bson:"threshold"`
+}
+
+// LoyaltySettings configure points expiry and loyalty tiers (admin only)
+type LoyaltySettings struct {
+	ID                 string                 `json:"-" bson:"_id"`
+	PointsExpiryMonths int                    `json:"pointsExpiryMonths" bson:"pointsExpiryMonths"` // Points expire this long after they were earned, oldest first; never when 0
+	ExpiryReminderDays int                    `json:"expiryReminderDays" bson:"expiryReminderDays"` // Accounts are told this long before their points expire
+	TierBasis          string                 `json:"tierBasis" bson:"tierBasis"`                   // "points" or "bookings"
+	TierWindowDays     int                    `json:"tierWindowDays" bson:"tierWindowDays"`         // Rolling window the tier score is counted over
+	Tiers              []LoyaltyTierThreshold `json:"tiers" bson:"tiers"`                           // Silver and gold thresholds; bronze needs nothing
+	UpdatedBy          *primitive.ObjectID    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt          time.Time              `json:"updatedAt" bson:"updatedAt"`
+}
+
+// LoyaltyStatus is an account's standing in the loyalty program
+type LoyaltyStatus struct {
+	Tier            string     `json:"tier"`
+	Score           int        `json:"score"` // Points earned or bookings completed over the window
+	TierBasis       string     `json:"tierBasis"`
+	TierWindowDays  int        `json:"tierWindowDays"`
+	NextTier        string     `json:"nextTier,omitempty"`
+	ToNextTier      int        `json:"toNextTier,omitempty"` // Score still needed for the next tier
+	Points          int        `json:"points"`
+	ExpiringPoints  int        `json:"expiringPoints"` // Points expiring within the reminder period
+	ExpiringBy      *time.Time `json:"expiringBy,omitempty"`
+	ExpiryMonths    int        `json:"pointsExpiryMonths"`
+	TierRefreshedAt *time.Time `json:"tierRefreshedAt,omitempty"`
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Content that can be moderated
+const (
+	ModerationContentReview        = "review"
+	ModerationContentBranchComment = "branch_comment"
+)
+
+// Moderation statuses of reviews and comments. Content without a status was
+// published before moderation existed and counts as approved.
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationRejected = "rejected"
+)
+
+// Moderation decisions an admin can take on held content
+const (
+	ModerationActionApprove = "approve"
+	ModerationActionReject  = "reject"
+	ModerationActionEdit    = "edit" // Approve with edited text
+)
+
+// Content report statuses
+const (
+	ContentReportOpen      = "open"
+	ContentReportResolved  = "resolved"
+	ContentReportDismissed = "dismissed"
+)
+
+// ModerationInfo is embedded in moderated content
+type ModerationInfo struct {
+	ModerationStatus string              `json:"moderationStatus,omitempty" bson:"moderationStatus,omitempty"`
+	FlagReasons      []string            `json:"flagReasons,omitempty" bson:"flagReasons,omitempty"` // Why the content was held
+	ReportCount      int                 `json:"reportCount,omitempty" bson:"reportCount,omitempty"`
+	ModerationReason string              `json:"moderationReason,omitempty" bson:"moderationReason,omitempty"`
+	ModeratedBy      *primitive.ObjectID `json:"moderatedBy,omitempty" bson:"moderatedBy,omitempty"`
+	ModeratedAt      *time.Time          `json:"moderatedAt,omitempty" bson:"moderatedAt,omitempty"`
+}
+
+// ContentReport is a user's report of a review or comment
+type ContentReport struct {
+	ID          primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	ContentType string              `json:"contentType" bson:"contentType"`
+	ContentID   primitive.ObjectID  `json:"contentId" bson:"contentId"`
+	ReporterID  primitive.ObjectID  `json:"reporterId" bson:"reporterId"`
+	Reason      string              `json:"reason" bson:"reason"`
+	Details     string              `json:"details,omitempty" bson:"details,omitempty"`
+	Status      string              `json:"status" bson:"status"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	ResolvedAt  *time.Time          `json:"resolvedAt,omitempty" bson:"resolvedAt,omitempty"`
+	ResolvedBy  *primitive.ObjectID `json:"resolvedBy,omitempty" bson:"resolvedBy,omitempty"`
+}
+
+// ContentReportRequest is the body for reporting a review or comment
+type ContentReportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// ModerationWord is an entry of the blocked word list
+type ModerationWord struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Word      string             `json:"word" bson:"word"`         // Normalized form
+	Language  string             `json:"language" bson:"language"` // "ar" or "en"
+	CreatedBy primitive.ObjectID `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// ModerationWordRequest is the body for adding blocked words
+type ModerationWordRequest struct {
+	Words    []string `json:"words"`
+	Language string   `json:"language"`
+}
+
+// ModerationDecisionRequest is the body of an admin moderation decision
+type ModerationDecisionRequest struct {
+	Action string `json:"action"` // "approve", "reject" or "edit"
+	Reason string `json:"reason"`
+	Text   string `json:"text,omitempty"` // Replacement text for "edit"
+}
+
+// ModerationQueueItem is held or reported content awaiting an admin
+type ModerationQueueItem struct {
+	ContentType string             `json:"contentType"`
+	ContentID   primitive.ObjectID `json:"contentId"`
+	AuthorID    primitive.ObjectID `json:"authorId"`
+	AuthorName  string             `json:"authorName"`
+	TargetID    primitive.ObjectID `json:"targetId"` // Service provider or branch
+	Text        string             `json:"text"`
+	Rating      int                `json:"rating,omitempty"`
+	ModerationInfo
+	OpenReports int       `json:"openReports"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
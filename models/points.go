@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Accounts that hold a points balance, named after the user types
+const (
+	PointsAccountUser            = "user"
+	PointsAccountCompany         = "company"
+	PointsAccountWholesaler      = "wholesaler"
+	PointsAccountServiceProvider = "serviceProvider"
+)
+
+// Points ledger entry types
+const (
+	PointsEarn   = "earn"
+	PointsSpend  = "spend"
+	PointsExpire = "expire"
+	PointsAdjust = "adjust"
+)
+
+// What a points ledger entry was recorded for
+const (
+	PointsSourceReferral        = "referral"         // Bonus for referring another account
+	PointsSourceReferralSignup  = "referral_signup"  // Bonus for signing up with a referral code
+	PointsSourceVoucherPurchase = "voucher_purchase" // Source ID is the purchase record
+	PointsSourceAdmin           = "admin"            // Manual adjustment by an admin
+	PointsSourceOpeningBalance  = "opening_balance"  // Balance held before the ledger was introduced
+	PointsSourceExpiry          = "expiry"           // Points that expired unused
+)
+
+// PointsLedgerEntry records one change of an account's points balance
+type PointsLedgerEntry struct {
+	ID           primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	AccountType  string              `json:"accountType" bson:"accountType"` // "user", "company", "wholesaler" or "serviceProvider"
+	AccountID    primitive.ObjectID  `json:"accountId" bson:"accountId"`
+	Type         string              `json:"type" bson:"type"`                 // "earn", "spend", "expire" or "adjust"
+	Points       int                 `json:"points" bson:"points"`             // Signed change of the balance
+	BalanceAfter int                 `json:"balanceAfter" bson:"balanceAfter"` // Balance once the change was applied
+	SourceType   string              `json:"sourceType" bson:"sourceType"`
+	SourceID     *primitive.ObjectID `json:"sourceId,omitempty" bson:"sourceId,omitempty"`
+	Reason       string              `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedBy    *primitive.ObjectID `json:"createdBy,omitempty" bson:"createdBy,omitempty"` // Admin of a manual adjustment
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// PointsAdjustmentRequest is the body of an admin's manual points adjustment
+type PointsAdjustmentRequest struct {
+	AccountType string `json:"accountType"`
+	AccountID   string `json:"accountId"`
+	Points      int    `json:"points"` // Positive to credit, negative to debit
+	Reason      string `json:"reason"`
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Promo code discount types
+const (
+	PromoDiscountPercentage = "percentage"
+	PromoDiscountFixed      = "fixed"
+)
+
+// Checkouts a promo code can be applied to
+const (
+	PromoCheckoutBranchSubscription           = "branch_subscription"
+	PromoCheckoutWholesalerBranchSubscription = "wholesaler_branch_subscription"
+	PromoCheckoutServiceProviderSubscription  = "service_provider_subscription"
+	PromoCheckoutSponsorship                  = "sponsorship"
+)
+
+// PromoPlanSponsorship is the plan type of sponsorship checkouts, next to the
+// subscription plan types "company", "wholesaler" and "serviceProvider"
+const PromoPlanSponsorship = "sponsorship"
+
+// Promo code redemption statuses
+const (
+	PromoRedemptionPending  = "pending"  // Applied to a checkout waiting for payment
+	PromoRedemptionRedeemed = "redeemed" // Payment went through
+	PromoRedemptionReleased = "released" // Payment failed or was abandoned
+)
+
+// PromoCode is an admin-managed discount applied at subscription and sponsorship checkout
+type PromoCode struct {
+	ID              primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Code            string             `json:"code" bson:"code"` // Upper-cased
+	Description     string             `json:"description,omitempty" bson:"description,omitempty"`
+	DiscountType    string             `json:"discountType" bson:"discountType"`                   // "percentage" or "fixed"
+	Value           float64            `json:"value" bson:"value"`                                 // Percent off, or amount off in USD
+	MaxDiscount     float64            `json:"maxDiscount,omitempty" bson:"maxDiscount,omitempty"` // Cap of a percentage discount, 0 for none
+	MaxRedemptions  int                `json:"maxRedemptions" bson:"maxRedemptions"`               // 0 for unlimited
+	RedemptionCount int                `json:"redemptionCount" bson:"redemptionCount"`             // Pending and redeemed uses
+	PerAccountLimit int                `json:"perAccountLimit" bson:"perAccountLimit"`             // 0 for unlimited
+	ValidFrom       *time.Time         `json:"validFrom,omitempty" bson:"validFrom,omitempty"`
+	ValidUntil      *time.Time         `json:"validUntil,omitempty" bson:"validUntil,omitempty"`
+	PlanTypes       []string           `json:"planTypes,omitempty" bson:"planTypes,omitempty"`     // Empty for any
+	EntityTypes     []string           `json:"entityTypes,omitempty" bson:"entityTypes,omitempty"` // Empty for any
+	IsActive        bool               `json:"isActive" bson:"isActive"`
+	CreatedBy       primitive.ObjectID `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt       time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PromoCodeRequest is the body for creating or updating a promo code
+type PromoCodeRequest struct {
+	Code            string     `json:"code"`
+	Description     string     `json:"description"`
+	DiscountType    string     `json:"discountType"`
+	Value           float64    `json:"value"`
+	MaxDiscount     float64    `json:"maxDiscount"`
+	MaxRedemptions  int        `json:"maxRedemptions"`
+	PerAccountLimit int        `json:"perAccountLimit"`
+	ValidFrom       *time.Time `json:"validFrom"`
+	ValidUntil      *time.Time `json:"validUntil"`
+	PlanTypes       []string   `json:"planTypes"`
+	EntityTypes     []string   `json:"entityTypes"`
+	IsActive        *bool      `json:"isActive"`
+}
+
+// PromoCodeRedemption records a promo code applied to a checkout
+type PromoCodeRedemption struct {
+	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	PromoCodeID    primitive.ObjectID `json:"promoCodeId" bson:"promoCodeId"`
+	Code           string             `json:"code" bson:"code"`
+	UserID         primitive.ObjectID `json:"userId" bson:"userId"`
+	Checkout       string             `json:"checkout" bson:"checkout"`
+	RequestID      primitive.ObjectID `json:"requestId" bson:"requestId"`
+	ExternalID     int64              `json:"externalId,omitempty" bson:"externalId,omitempty"`
+	PlanType       string             `json:"planType,omitempty" bson:"planType,omitempty"`
+	EntityType     string             `json:"entityType,omitempty" bson:"entityType,omitempty"`
+	EntityID       primitive.ObjectID `json:"entityId,omitempty" bson:"entityId,omitempty"`
+	OriginalAmount float64            `json:"originalAmount" bson:"originalAmount"`
+	DiscountAmount float64            `json:"discountAmount" bson:"discountAmount"`
+	FinalAmount    float64            `json:"finalAmount" bson:"finalAmount"`
+	Currency       string             `json:"currency" bson:"currency"`
+	Status         string             `json:"status" bson:"status"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	RedeemedAt     *time.Time         `json:"redeemedAt,omitempty" bson:"redeemedAt,omitempty"`
+	ReleasedAt     *time.Time         `json:"releasedAt,omitempty" bson:"releasedAt,omitempty"`
+}
+
+// PromoCodeValidateRequest is the body for previewing a promo code at checkout
+type PromoCodeValidateRequest struct {
+	Code       string  `json:"code"`
+	PlanType   string  `json:"planType"`
+	EntityType string  `json:"entityType"`
+	Amount     float64 `json:"amount"`
+}
+
+// PromoCodeReportRow sums the redeemed discounts of a promo code and checkout
+type PromoCodeReportRow struct {
+	Code          string  `json:"code" bson:"code"`
+	Checkout      string  `json:"checkout" bson:"checkout"`
+	Redemptions   int     `json:"redemptions" bson:"redemptions"`
+	GrossAmount   float64 `json:"grossAmount" bson:"grossAmount"`
+	DiscountTotal float64 `json:"discountTotal" bson:"discountTotal"`
+	NetAmount     float64 `json:"netAmount" bson:"netAmount"`
+}
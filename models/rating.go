@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RatingAggregate is the incrementally maintained rating of a reviewable entity:
+// a service provider (rated by reviews) or a company or wholesaler branch (rated
+// by branch comments)
+type RatingAggregate struct {
+	ID              primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	EntityType      string             `json:"entityType" bson:"entityType"` // "service_provider", "company_branch" or "wholesaler_branch"
+	EntityID        primitive.ObjectID `json:"entityId" bson:"entityId"`
+	Count           int                `json:"count" bson:"count"`
+	Sum             int                `json:"sum" bson:"sum"`             // Sum of the star values
+	Histogram       map[string]int     `json:"histogram" bson:"histogram"` // Ratings per star, keyed "1" to "5"
+	WeightedSum     float64            `json:"weightedSum" bson:"weightedSum"`
+	TotalWeight     float64            `json:"totalWeight" bson:"totalWeight"`         // Unverified reviews weigh less than verified ones
+	Average         float64            `json:"average" bson:"average"`                 // Weighted mean
+	BayesianAverage float64            `json:"bayesianAverage" bson:"bayesianAverage"` // Weighted mean pulled towards the mean of the entity type
+	UpdatedAt       time.Time          `json:"updatedAt" bson:"updatedAt"`
+	RecomputedAt    *time.Time         `json:"recomputedAt,omitempty" bson:"recomputedAt,omitempty"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Where a tracked referral link was opened from
+const (
+	ReferralSourceQR   = "qr"   // Scanned from a referral QR code
+	ReferralSourceLink = "link" // Opened from a shared referral link
+)
+
+// Platforms a referral click is routed to
+const (
+	ReferralPlatformIOS     = "ios"
+	ReferralPlatformAndroid = "android"
+	ReferralPlatformWeb     = "web"
+)
+
+// ReferralClick is a scan or click of a tracked referral link, kept so the
+// signup that follows it can be attributed to the referral code
+type ReferralClick struct {
+	ID           primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	Code         string              `json:"code" bson:"code"`
+	ReferrerType string              `json:"referrerType" bson:"referrerType"`
+	ReferrerID   primitive.ObjectID  `json:"referrerId" bson:"referrerId"`
+	Source       string              `json:"source" bson:"source"`
+	Platform     string              `json:"platform" bson:"platform"`
+	IP           string              `json:"-" bson:"ip"`
+	DeviceID     string              `json:"-" bson:"deviceId,omitempty"`
+	UserAgent    string              `json:"-" bson:"userAgent"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+	ConvertedAt  *time.Time          `json:"convertedAt,omitempty" bson:"convertedAt,omitempty"`
+	RefereeType  string              `json:"refereeType,omitempty" bson:"refereeType,omitempty"`
+	RefereeID    *primitive.ObjectID `json:"refereeId,omitempty" bson:"refereeId,omitempty"`
+}
+
+// ReferralClickStats counts the clicks of one source and platform over a
+// period and the signups attributed to them
+type ReferralClickStats struct {
+	Source         string  `json:"source" bson:"source"`
+	Platform       string  `json:"platform" bson:"platform"`
+	Clicks         int     `json:"clicks" bson:"clicks"`
+	Signups        int     `json:"signups" bson:"signups"`
+	ConversionRate float64 `json:"conversionRate" bson:"-"`
+}
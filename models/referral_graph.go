@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReferrerSalesperson is the referrer type of salespersons, who refer
+// accounts but earn commissions rather than referral rewards
+const ReferrerSalesperson = "salesperson"
+
+// How a referee came to use a referral code
+const (
+	ReferralChannelSignup   = "signup"   // Entered the code while signing up
+	ReferralChannelCode     = "code"     // Applied the code after signing up
+	ReferralChannelLink     = "link"     // Signed up after opening a tracked referral link
+	ReferralChannelBackfill = "backfill" // Recovered from the referrals lists kept before the graph
+)
+
+// ReferralLink is an edge of the referral graph: who brought whom, across
+// users, companies, wholesalers, service providers and salespersons
+type ReferralLink struct {
+	ID            primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	ReferrerType  string              `json:"referrerType" bson:"referrerType"`
+	ReferrerID    primitive.ObjectID  `json:"referrerId" bson:"referrerId"`
+	RefereeType   string              `json:"refereeType" bson:"refereeType"`
+	RefereeID     primitive.ObjectID  `json:"refereeId" bson:"refereeId"`
+	RefereeUserID *primitive.ObjectID `json:"refereeUserId,omitempty" bson:"refereeUserId,omitempty"`
+	// The referee and the user behind it, either of which its own referrals
+	// may be recorded under
+	RefereeAccounts []primitive.ObjectID `json:"-" bson:"refereeAccounts"`
+	Code            string               `json:"code,omitempty" bson:"code,omitempty"`
+	Channel         string               `json:"channel" bson:"channel"`
+	CreatedAt       time.Time            `json:"createdAt" bson:"createdAt"`
+}
+
+// ReferralTreeNode is a referee in a referral subtree with the referees it brought
+type ReferralTreeNode struct {
+	ReferralLink `bson:",inline"`
+	Depth        int                 `json:"depth"`
+	Children     []*ReferralTreeNode `json:"children"`
+}
+
+// ReferralFunnelStages counts referees reaching each step after signup
+type ReferralFunnelStages struct {
+	Signups          int `json:"signups"`
+	Verified         int `json:"verified"`         // Verified their phone
+	PaidSubscription int `json:"paidSubscription"` // Took a first paid subscription
+}
+
+// ReferralFunnel is the conversion of referred signups over a period
+type ReferralFunnel struct {
+	ReferralFunnelStages
+	VerifiedRate  float64                         `json:"verifiedRate"`
+	PaidRate      float64                         `json:"paidRate"`
+	ByRefereeType map[string]ReferralFunnelStages `json:"byRefereeType"`
+}
+
+// TopReferrer is a referrer ranked by the referees it brought over a period
+type TopReferrer struct {
+	ReferrerType     string             `json:"referrerType" bson:"referrerType"`
+	ReferrerID       primitive.ObjectID `json:"referrerId" bson:"referrerId"`
+	Name             string             `json:"name"`
+	Referrals        int                `json:"referrals" bson:"referrals"`
+	PaidSubscription int                `json:"paidSubscription"`
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Referral events a rule can reward
+const (
+	ReferralEventSignup                = "signup"
+	ReferralEventFirstBooking          = "first_booking"
+	ReferralEventFirstPaidSubscription = "first_paid_subscription"
+)
+
+// ReferralAnyType matches every referrer or referee type in a referral rule
+const ReferralAnyType = "any"
+
+// Kinds of referral rewards
+const (
+	ReferralRewardPoints = "points" // Credited to the points ledger straight away
+	ReferralRewardCash   = "cash"   // Recorded for an admin to pay out
+)
+
+// Periods a referral rule caps its rewards over, rolling back from now
+const (
+	ReferralCapDay   = "day"
+	ReferralCapWeek  = "week"
+	ReferralCapMonth = "month"
+	ReferralCapTotal = "total"
+)
+
+// Who a referral reward goes to and what became of it
+const (
+	ReferralBeneficiaryReferrer = "referrer"
+	ReferralBeneficiaryReferee  = "referee"
+
+	ReferralRewardGranted  = "granted"  // Points credited
+	ReferralRewardPending  = "pending"  // Cash awaiting payout
+	ReferralRewardPaid     = "paid"     // Cash paid out
+	ReferralRewardCapped   = "capped"   // Not given, the referrer reached the rule's cap
+	ReferralRewardHeld     = "held"     // Not given yet, see the hold reason
+	ReferralRewardRejected = "rejected" // Not given, the referral was found fraudulent
+)
+
+// Why a referral reward is held
+const (
+	ReferralHoldVesting = "vesting" // Until the referee shows activity
+	ReferralHoldReview  = "review"  // Until an admin reviews the referral
+)
+
+// Review states of a suspicious referral
+const (
+	ReferralReviewPending  = "pending"
+	ReferralReviewApproved = "approved"
+	ReferralReviewRejected = "rejected"
+)
+
+// Signals that make a referral suspicious
+const (
+	ReferralSignalSelfReferral    = "self_referral"            // The referee is the referrer's own account
+	ReferralSignalSameIP          = "same_ip_as_referrer"      // The referee signed up from the referrer's IP
+	ReferralSignalSameDevice      = "same_device_as_referrer"  // The referee signed up from the referrer's device
+	ReferralSignalSamePhonePrefix = "same_phone_prefix"        // The phones differ only in their last digits
+	ReferralSignalSharedIP        = "shared_ip"                // Many signups came from the IP lately
+	ReferralSignalSharedDevice    = "shared_device"            // Many signups came from the device lately
+	ReferralSignalVelocity        = "referrer_velocity"        // The referrer referred too many accounts today
+	ReferralSignalRing            = "referral_ring"            // The referee is up the referrer's referral chain
+	ReferralSignalRefereeInReview = "referee_signup_in_review" // The referee's signup is itself under review
+)
+
+// ReferralFingerprint identifies where a referee signed up from
+type ReferralFingerprint struct {
+	IP       string `json:"ip,omitempty" bson:"ip,omitempty"`
+	DeviceID string `json:"deviceId,omitempty" bson:"deviceId,omitempty"`
+	Phone    string `json:"phone,omitempty" bson:"phone,omitempty"`
+}
+
+// ReferralRule sets what a referrer and the referee get when a referee of one
+// type, referred by a referrer of another, reaches an event. Among the rules
+// matching an event the one with the highest priority applies, and an exact
+// type beats "any" at equal priority.
+type ReferralRule struct {
+	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name           string             `json:"name" bson:"name"`
+	ReferrerType   string             `json:"referrerType" bson:"referrerType"` // "user", "company", "wholesaler", "serviceProvider" or "any"
+	RefereeType    string             `json:"refereeType" bson:"refereeType"`
+	Event          string             `json:"event" bson:"event"`           // "signup", "first_booking" or "first_paid_subscription"
+	RewardType     string             `json:"rewardType" bson:"rewardType"` // "points" or "cash"
+	ReferrerReward float64            `json:"referrerReward" bson:"referrerReward"`
+	RefereeReward  float64            `json:"refereeReward" bson:"refereeReward"`
+	CapPerPeriod   int                `json:"capPerPeriod" bson:"capPerPeriod"`               // Rewards per referrer per period, 0 for no cap
+	CapPeriod      string             `json:"capPeriod,omitempty" bson:"capPeriod,omitempty"` // "day", "week", "month" or "total"
+	Priority       int                `json:"priority" bson:"priority"`                       // Campaigns take a higher priority than the base rules
+	StartsAt       *time.Time         `json:"startsAt,omitempty" bson:"startsAt,omitempty"`   // Campaign start
+	EndsAt         *time.Time         `json:"endsAt,omitempty" bson:"endsAt,omitempty"`       // Campaign end
+	IsActive       bool               `json:"isActive" bson:"isActive"`
+	CreatedBy      primitive.ObjectID `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ReferralEventRecord records that a referee reached an event, so that each
+// event is rewarded once and later events find the referrer
+type ReferralEventRecord struct {
+	ID            primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	Event         string              `json:"event" bson:"event"`
+	ReferrerType  string              `json:"referrerType" bson:"referrerType"`
+	ReferrerID    primitive.ObjectID  `json:"referrerId" bson:"referrerId"`
+	RefereeType   string              `json:"refereeType" bson:"refereeType"`
+	RefereeID     primitive.ObjectID  `json:"refereeId" bson:"refereeId"`
+	RefereeUserID *primitive.ObjectID `json:"refereeUserId,omitempty" bson:"refereeUserId,omitempty"` // User account behind a business referee
+	RuleID        *primitive.ObjectID `json:"ruleId,omitempty" bson:"ruleId,omitempty"`               // Unset when a built-in rule applied
+	Fingerprint   ReferralFingerprint `json:"fingerprint" bson:"fingerprint,omitempty"`
+	FraudSignals  []string            `json:"fraudSignals,omitempty" bson:"fraudSignals,omitempty"`
+	ReviewStatus  string              `json:"reviewStatus,omitempty" bson:"reviewStatus,omitempty"` // Set when fraud signals sent the referral to review
+	ReviewedBy    *primitive.ObjectID `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewNote    string              `json:"reviewNote,omitempty" bson:"reviewNote,omitempty"`
+	ReviewedAt    *time.Time          `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// ReferralReward is a reward given, or withheld, for a referral event
+type ReferralReward struct {
+	ID            primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	EventID       primitive.ObjectID  `json:"eventId" bson:"eventId"`
+	RuleID        *primitive.ObjectID `json:"ruleId,omitempty" bson:"ruleId,omitempty"`
+	Event         string              `json:"event" bson:"event"`
+	Beneficiary   string              `json:"beneficiary" bson:"beneficiary"` // "referrer" or "referee"
+	AccountType   string              `json:"accountType" bson:"accountType"`
+	AccountID     primitive.ObjectID  `json:"accountId" bson:"accountId"`
+	RewardType    string              `json:"rewardType" bson:"rewardType"`
+	Amount        float64             `json:"amount" bson:"amount"`
+	Status        string              `json:"status" bson:"status"`                             // "granted", "pending", "paid", "capped", "held" or "rejected"
+	HoldReason    string              `json:"holdReason,omitempty" bson:"holdReason,omitempty"` // "vesting" or "review" while held
+	LedgerEntryID *primitive.ObjectID `json:"ledgerEntryId,omitempty" bson:"ledgerEntryId,omitempty"`
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+	ReleasedAt    *time.Time          `json:"releasedAt,omitempty" bson:"releasedAt,omitempty"`
+	PaidAt        *time.Time          `json:"paidAt,omitempty" bson:"paidAt,omitempty"`
+	PaidBy        *primitive.ObjectID `json:"paidBy,omitempty" bson:"paidBy,omitempty"`
+}
@@ -24,6 +24,7 @@ type Salesperson struct {
 	CompanyID         primitive.ObjectID   `json:"companyId" bson:"companyId"`
 	Commissions       []Commission         `json:"commissions,omitempty" bson:"commissions,omitempty"`
 	CommissionPercent float64              `json:"commissionPercent" bson:"commissionPercent"`
+	CommissionTier    string               `json:"commissionTier,omitempty" bson:"commissionTier,omitempty"` // Matched by commission rules
 }
 
 // type Commission struct {
@@ -33,15 +34,25 @@ type Salesperson struct {
 // 	Status  string             `json:"status" bson:"status"` // pending, paid
 // }
 
-// CommissionRecord tracks commissions for both salesperson and sales manager
+// CommissionRecord tracks commissions for both salesperson and sales manager,
+// with the sale and the rule that set the amount so payouts can be audited
 // Role: "salesperson" or "sales_manager"
 type CommissionRecord struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	SubscriptionID primitive.ObjectID `bson:"subscriptionId" json:"subscriptionId"`
-	SalespersonID  primitive.ObjectID `bson:"salespersonId" json:"salespersonId"`
-	SalesManagerID primitive.ObjectID `bson:"salesManagerId" json:"salesManagerId"`
-	Amount         float64            `bson:"amount" json:"amount"`
-	Role           string             `bson:"role" json:"role"`
-	Status         string             `bson:"status" json:"status"` // pending, paid
-	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	CommissionID   primitive.ObjectID  `bson:"commissionId,omitempty" json:"commissionId,omitempty"` // Split of the sale in the commissions collection
+	SubscriptionID primitive.ObjectID  `bson:"subscriptionId" json:"subscriptionId"`
+	SalespersonID  primitive.ObjectID  `bson:"salespersonId" json:"salespersonId"`
+	SalesManagerID primitive.ObjectID  `bson:"salesManagerId" json:"salesManagerId"`
+	EntityType     string              `bson:"entityType,omitempty" json:"entityType,omitempty"`
+	EntityID       primitive.ObjectID  `bson:"entityId,omitempty" json:"entityId,omitempty"`
+	PlanID         primitive.ObjectID  `bson:"planId,omitempty" json:"planId,omitempty"`
+	SaleKind       string              `bson:"saleKind,omitempty" json:"saleKind,omitempty"`
+	BaseAmount     float64             `bson:"baseAmount,omitempty" json:"baseAmount,omitempty"` // Amount paid the percent applied to
+	Percent        float64             `bson:"percent,omitempty" json:"percent,omitempty"`
+	RuleID         *primitive.ObjectID `bson:"ruleId,omitempty" json:"ruleId,omitempty"` // Unset when the personal rates applied
+	RuleName       string              `bson:"ruleName,omitempty" json:"ruleName,omitempty"`
+	Amount         float64             `bson:"amount" json:"amount"`
+	Role           string              `bson:"role" json:"role"`
+	Status         string              `bson:"status" json:"status"` // pending, paid
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
 }
@@ -0,0 +1,39 @@
+package models
+
+// SearchDocument is one searchable company, wholesaler, branch or service provider
+type SearchDocument struct {
+	Type        string    `json:"type"` // "company", "wholesaler", "branch" or "serviceProvider"
+	ID          string    `json:"id"`
+	OwnerID     string    `json:"ownerId,omitempty"`   // Company or wholesaler owning a branch
+	OwnerType   string    `json:"ownerType,omitempty"` // "company" or "wholesaler"
+	Name        string    `json:"name"`
+	Category    string    `json:"category,omitempty"`
+	SubCategory string    `json:"subCategory,omitempty"`
+	ServiceType string    `json:"serviceType,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Governorate string    `json:"governorate,omitempty"`
+	District    string    `json:"district,omitempty"`
+	City        string    `json:"city,omitempty"`
+	LogoURL     string    `json:"logoUrl,omitempty"`
+	Location    *GeoPoint `json:"location,omitempty"`
+	Sponsored   bool      `json:"sponsored"`
+}
+
+// SearchHit is a search result with its relevance score
+type SearchHit struct {
+	SearchDocument
+	Score float64 `json:"score"`
+}
+
+// SearchFacet counts the results sharing one facet value
+type SearchFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchResults is a page of search hits with facet counts over all matches
+type SearchResults struct {
+	Hits   []SearchHit              `json:"hits"`
+	Total  int                      `json:"total"`
+	Facets map[string][]SearchFacet `json:"facets"` // Keyed by "type", "category", "governorate", "district"
+}
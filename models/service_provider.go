@@ -66,6 +66,9 @@ type ServiceProvider struct {
 	ReferralCode      string               `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
 	Referrals         []primitive.ObjectID `json:"referrals,omitempty" bson:"referrals,omitempty"` // List of referred entities
 	Points            int                  `json:"points" bson:"points"`
+	LoyaltyTier       string               `json:"loyaltyTier,omitempty" bson:"loyaltyTier,omitempty"` // Kept up to date by RefreshLoyaltyTiers
+	Rating            float64              `json:"rating,omitempty" bson:"rating,omitempty"`           // Maintained from reviews, see RatingAggregate
+	RatingCount       int                  `json:"ratingCount,omitempty" bson:"ratingCount,omitempty"`
 	CommissionPercent float64              `bson:"commissionPercent,omitempty" json:"commissionPercent,omitempty"`
 	Sponsorship       bool                 `json:"sponsorship,omitempty" bson:"sponsorship,omitempty"` // Whether the service provider has active sponsorship
 	CreatedBy         primitive.ObjectID   `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
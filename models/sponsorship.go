@@ -58,6 +58,7 @@ type Sponsorship struct {
 	DurationInfo DurationInfo      `json:"durationInfo,omitempty" bson:"durationInfo,omitempty"`       // Calculated duration breakdown
 	Discount    float64            `json:"discount" bson:"discount" validate:"gte=0,lte=100"` // Discount percentage
 	UsedCount   int                `json:"usedCount" bson:"usedCount"`                        // Current usage count
+	MaxSubscriptions int           `json:"maxSubscriptions" bson:"maxSubscriptions"`          // Most subscriptions that can be sold, 0 for unlimited
 	StartDate   time.Time          `json:"startDate" bson:"startDate" validate:"required"`
 	EndDate     time.Time          `json:"endDate" bson:"endDate" validate:"required"`
 	CreatedBy   primitive.ObjectID `json:"createdBy" bson:"createdBy"` // Admin who created this sponsorship
@@ -71,6 +72,7 @@ type SponsorshipRequest struct {
 	Price     float64   `json:"price" validate:"required,gt=0"`
 	Duration  int       `json:"duration" validate:"required,min=1,max=365"`
 	Discount  float64   `json:"discount" validate:"gte=0,lte=100"`
+	MaxSubscriptions int `json:"maxSubscriptions" validate:"gte=0"` // 0 for unlimited
 	StartDate time.Time `json:"startDate" validate:"required"`
 	EndDate   time.Time `json:"endDate" validate:"required"`
 }
@@ -81,6 +83,7 @@ type SponsorshipUpdateRequest struct {
 	Price     *float64   `json:"price,omitempty" validate:"omitempty,gt=0"`
 	Duration  *int       `json:"duration,omitempty" validate:"omitempty,min=1,max=365"`
 	Discount  *float64   `json:"discount,omitempty" validate:"omitempty,gte=0,lte=100"`
+	MaxSubscriptions *int `json:"maxSubscriptions,omitempty" validate:"omitempty,gte=0"`
 	StartDate *time.Time `json:"startDate,omitempty"`
 	EndDate   *time.Time `json:"endDate,omitempty"`
 }
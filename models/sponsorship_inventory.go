@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sponsorship slot statuses
+const (
+	SponsorshipSlotHeld     = "held"     // Reserved while the request waits for payment or approval
+	SponsorshipSlotBooked   = "booked"   // Paid or approved, running or starting later
+	SponsorshipSlotReleased = "released" // Given back after a failed payment, rejection or expired hold
+)
+
+// Sponsorship waiting list statuses
+const (
+	SponsorshipWaitlistWaiting   = "waiting"
+	SponsorshipWaitlistNotified  = "notified"
+	SponsorshipWaitlistCancelled = "cancelled"
+)
+
+// SponsorshipSubscriptionScheduled is the status of a paid subscription that starts later
+const SponsorshipSubscriptionScheduled = "scheduled"
+
+// SponsorshipInventoryCap limits how many entities can be sponsored at the same
+// time in a category and governorate. An empty category or governorate matches any.
+type SponsorshipInventoryCap struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Category    string             `json:"category" bson:"category"`       // Lower-cased
+	Governorate string             `json:"governorate" bson:"governorate"` // Lower-cased
+	MaxSponsors int                `json:"maxSponsors" bson:"maxSponsors"` // 0 for unlimited
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// SponsorshipInventoryCapRequest is the body for setting a cap
+type SponsorshipInventoryCapRequest struct {
+	Category    string `json:"category"`
+	Governorate string `json:"governorate"`
+	MaxSponsors int    `json:"maxSponsors"`
+}
+
+// SponsorshipSlot reserves one of the sponsor places of a category and
+// governorate for the length of a sponsorship
+type SponsorshipSlot struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SponsorshipID primitive.ObjectID `json:"sponsorshipId" bson:"sponsorshipId"`
+	RequestID     primitive.ObjectID `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	EntityType    string             `json:"entityType" bson:"entityType"`
+	EntityID      primitive.ObjectID `json:"entityId" bson:"entityId"`
+	Category      string             `json:"category" bson:"category"`
+	Governorate   string             `json:"governorate" bson:"governorate"`
+	StartDate     time.Time          `json:"startDate" bson:"startDate"`
+	EndDate       time.Time          `json:"endDate" bson:"endDate"`
+	Status        string             `json:"status" bson:"status"`
+	ExpiresAt     *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"` // When a hold lapses; nil holds until released
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// SponsorshipSlotOptions are the scheduling choices of a sponsorship request
+type SponsorshipSlotOptions struct {
+	StartDate      *time.Time `json:"startDate,omitempty"`      // Future start; defaults to as soon as possible
+	AcceptNextSlot bool       `json:"acceptNextSlot,omitempty"` // Take the next free start when the requested one is full
+	JoinWaitlist   bool       `json:"joinWaitlist,omitempty"`   // Queue for a free place when the area is full
+}
+
+// SponsorshipWaitlistEntry queues an entity for a sponsor place in a full category and governorate
+type SponsorshipWaitlistEntry struct {
+	ID            primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SponsorshipID primitive.ObjectID `json:"sponsorshipId" bson:"sponsorshipId"`
+	EntityType    string             `json:"entityType" bson:"entityType"`
+	EntityID      primitive.ObjectID `json:"entityId" bson:"entityId"`
+	EntityName    string             `json:"entityName,omitempty" bson:"entityName,omitempty"`
+	Category      string             `json:"category" bson:"category"`
+	Governorate   string             `json:"governorate" bson:"governorate"`
+	RequestedBy   primitive.ObjectID `json:"requestedBy,omitempty" bson:"requestedBy,omitempty"` // User to notify
+	Status        string             `json:"status" bson:"status"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	NotifiedAt    *time.Time         `json:"notifiedAt,omitempty" bson:"notifiedAt,omitempty"`
+}
+
+// SponsorshipAvailability describes the sponsor places of a category and governorate
+type SponsorshipAvailability struct {
+	SponsorshipID   primitive.ObjectID `json:"sponsorshipId"`
+	Category        string             `json:"category"`
+	Governorate     string             `json:"governorate"`
+	MaxSponsors     int                `json:"maxSponsors"` // 0 for unlimited
+	ActiveSponsors  int                `json:"activeSponsors"`
+	AvailableNow    bool               `json:"availableNow"`
+	NextStartDate   time.Time          `json:"nextStartDate"`
+	SoldOut         bool               `json:"soldOut"` // The sponsorship has sold all its subscriptions
+	RemainingToSell int                `json:"remainingToSell,omitempty"`
+	WaitlistedAhead int                `json:"waitlistedAhead"`
+}
@@ -14,7 +14,7 @@ type SponsorshipSubscription struct {
 	EntityID        primitive.ObjectID `json:"entityId" bson:"entityId"`               // ID of the service provider or branch
 	StartDate       time.Time          `json:"startDate" bson:"startDate"`             // When the subscription becomes active
 	EndDate         time.Time          `json:"endDate" bson:"endDate"`                 // When the subscription expires
-	Status          string             `json:"status" bson:"status"`                   // "active", "expired", "cancelled"
+	Status          string             `json:"status" bson:"status"`                   // "scheduled", "active", "expired", "cancelled"
 	AutoRenew       bool               `json:"autoRenew" bson:"autoRenew"`             // Whether to auto-renew
 	DiscountApplied float64            `json:"discountApplied" bson:"discountApplied"` // Actual discount applied
 	CreatedAt       time.Time          `json:"createdAt" bson:"createdAt"`
@@ -44,6 +44,9 @@ type SponsorshipSubscriptionRequest struct {
 	PaymentStatus string    `json:"paymentStatus,omitempty" bson:"paymentStatus,omitempty"` // "pending", "success", "failed"
 	CollectURL    string    `json:"collectUrl,omitempty" bson:"collectUrl,omitempty"`       // Whish payment URL
 	PaidAt        time.Time `json:"paidAt,omitempty" bson:"paidAt,omitempty"`
+	// Reserved sponsor place
+	SlotID    primitive.ObjectID `json:"slotId,omitempty" bson:"slotId,omitempty"`
+	StartDate time.Time          `json:"startDate,omitempty" bson:"startDate,omitempty"` // Scheduled start of the sponsorship
 }
 
 // SponsorshipSubscriptionApprovalRequest represents the request body for approving/rejecting subscriptions
@@ -58,6 +61,7 @@ type SponsorshipSubscriptionRequestRequest struct {
 	SponsorshipID primitive.ObjectID `json:"sponsorshipId" validate:"required"`
 	EntityType    string             `json:"entityType" validate:"required,oneof=service_provider company_branch wholesaler_branch"`
 	EntityID      primitive.ObjectID `json:"entityId" validate:"required"`
+	SponsorshipSlotOptions
 }
 
 // SponsorshipSubscriptionResponse represents the response format for sponsorship subscriptions
@@ -24,6 +24,7 @@ type User struct {
 	ContactPerson            string               `json:"contactPerson,omitempty" bson:"contactPerson,omitempty"`
 	ContactPhone             string               `json:"contactPhone,omitempty" bson:"contactPhone,omitempty"`
 	Points                   int                  `json:"points" bson:"points"`
+	LoyaltyTier              string               `json:"loyaltyTier,omitempty" bson:"loyaltyTier,omitempty"` // Kept up to date by RefreshLoyaltyTiers
 	Referrals                []primitive.ObjectID `json:"referrals,omitempty" bson:"referrals,omitempty"`
 	ReferralCode             string               `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
 	InterestedDeals          []string             `json:"interestedDeals,omitempty" bson:"interestedDeals,omitempty"`
@@ -48,6 +49,8 @@ type User struct {
 	FirebaseUID              string               `json:"firebaseUID,omitempty" bson:"firebaseUID,omitempty"`
 	AppleUserID              string               `bson:"appleUserID,omitempty" json:"appleUserID,omitempty"`
 	FCMToken                 string               `json:"fcmToken,omitempty" bson:"fcmToken,omitempty"`
+	LastIP                   string               `json:"-" bson:"lastIp,omitempty"`       // Seen by the activity tracker, for referral fraud checks
+	LastDeviceID             string               `json:"-" bson:"lastDeviceId,omitempty"` // From the X-Device-ID header
 }
 
 type ReferralRequest struct {
@@ -93,23 +96,24 @@ type SocialLinks struct {
 // Update ServiceProviderInfo to include SocialLinks
 // Adding description field to ServiceProviderInfo struct
 type ServiceProviderInfo struct {
-	ServiceType              string               `json:"serviceType" bson:"serviceType"`
-	CustomServiceType        string               `json:"customServiceType,omitempty" bson:"customServiceType,omitempty"`
-	Description              string               `json:"description,omitempty" bson:"description,omitempty"`
-	YearsExperience          interface{}          `json:"yearsExperience" bson:"yearsExperience"`
-	ProfilePhoto             string               `json:"profilePhoto,omitempty" bson:"profilePhoto,omitempty"`
-	CertificateImages        []string             `json:"certificateImages,omitempty" bson:"certificateImages,omitempty"`
-	PortfolioImages          []string             `json:"portfolioImages,omitempty" bson:"portfolioImages,omitempty"`
-	AvailableHours           []string             `json:"availableHours,omitempty" bson:"availableHours,omitempty"`
-	AvailableDays            []string             `json:"availableDays,omitempty" bson:"availableDays,omitempty"`
-	ApplyToAllMonths         bool                 `json:"applyToAllMonths,omitempty" bson:"applyToAllMonths,omitempty"`
-	AvailableWeekdays        []string             `json:"availableWeekdays,omitempty" bson:"availableWeekdays,omitempty"`
-	Rating                   float64              `json:"rating" bson:"rating"`
-	ReferralCode             string               `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
-	Points                   int                  `json:"points" bson:"points"`
-	Status                   string               `json:"status" bson:"status"` // "available" or "not_available"
-	ReferredServiceProviders []primitive.ObjectID `json:"referredServiceProviders,omitempty" bson:"referredServiceProviders,omitempty"`
-	SocialLinks              *SocialLinks         `json:"socialLinks,omitempty" bson:"socialLinks,omitempty"`
+	ServiceType              string                `json:"serviceType" bson:"serviceType"`
+	CustomServiceType        string                `json:"customServiceType,omitempty" bson:"customServiceType,omitempty"`
+	Description              string                `json:"description,omitempty" bson:"description,omitempty"`
+	YearsExperience          interface{}           `json:"yearsExperience" bson:"yearsExperience"`
+	ProfilePhoto             string                `json:"profilePhoto,omitempty" bson:"profilePhoto,omitempty"`
+	CertificateImages        []string              `json:"certificateImages,omitempty" bson:"certificateImages,omitempty"`
+	PortfolioImages          []string              `json:"portfolioImages,omitempty" bson:"portfolioImages,omitempty"`
+	AvailableHours           []string              `json:"availableHours,omitempty" bson:"availableHours,omitempty"`
+	AvailableDays            []string              `json:"availableDays,omitempty" bson:"availableDays,omitempty"`
+	ApplyToAllMonths         bool                  `json:"applyToAllMonths,omitempty" bson:"applyToAllMonths,omitempty"`
+	AvailableWeekdays        []string              `json:"availableWeekdays,omitempty" bson:"availableWeekdays,omitempty"`
+	Rating                   float64               `json:"rating" bson:"rating"`
+	ReferralCode             string                `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
+	Points                   int                   `json:"points" bson:"points"`
+	Status                   string                `json:"status" bson:"status"` // "available" or "not_available"
+	ReferredServiceProviders []primitive.ObjectID  `json:"referredServiceProviders,omitempty" bson:"referredServiceProviders,omitempty"`
+	SocialLinks              *SocialLinks          `json:"socialLinks,omitempty" bson:"socialLinks,omitempty"`
+	Availability             *ProviderAvailability `json:"availability,omitempty" bson:"availability,omitempty"`
 }
 
 // Update the UpdateServiceProviderRequest to include description
@@ -186,6 +190,12 @@ type Review struct {
 	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt"`
 	Reply             *ReviewReply       `json:"reply,omitempty" bson:"reply,omitempty"`
+
+	// A review tied to a completed booking is verified; one review per booking
+	BookingID *primitive.ObjectID `json:"bookingId,omitempty" bson:"bookingId,omitempty"`
+	EditedAt  *time.Time          `json:"editedAt,omitempty" bson:"editedAt,omitempty"`
+
+	ModerationInfo `bson:",inline"`
 }
 
 type ReviewReply struct {
@@ -194,6 +204,12 @@ type ReviewReply struct {
 	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
 }
 
+// ReviewUpdateRequest is the body for editing a review within the edit window
+type ReviewUpdateRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
 // ReviewRequest is the model for creating a review (JSON version)
 type ReviewRequest struct {
 	ServiceProviderID string `json:"serviceProviderId"`
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,17 +20,118 @@ type Voucher struct {
 	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
 	// User-type specific voucher fields
 	TargetUserType string `json:"targetUserType,omitempty" bson:"targetUserType,omitempty"` // "user", "company", "serviceProvider", "wholesaler"
+	SoldCount      int    `json:"soldCount" bson:"soldCount"`
+	// Partner vouchers, created by a company or wholesaler for its branches
+	IssuerType string               `json:"issuerType,omitempty" bson:"issuerType,omitempty"` // "company" or "wholesaler"; unset for admin vouchers
+	IssuerID   *primitive.ObjectID  `json:"issuerId,omitempty" bson:"issuerId,omitempty"`
+	BranchIDs  []primitive.ObjectID `json:"branchIds,omitempty" bson:"branchIds,omitempty"` // Branches redeeming it, all of the issuer's when empty
+
+	VoucherLimits   `bson:",inline"`
+	VoucherAudience `bson:",inline"`
+}
+
+// VoucherLimits are the optional stock, per-account, validity and loyalty tier limits of a voucher
+type VoucherLimits struct {
+	Stock           int        `json:"stock" bson:"stock"`                     // Vouchers that can be sold, 0 for unlimited
+	PerAccountLimit int        `json:"perAccountLimit" bson:"perAccountLimit"` // Purchases per account, one when 0
+	ValidFrom       *time.Time `json:"validFrom,omitempty" bson:"validFrom,omitempty"`
+	ValidUntil      *time.Time `json:"validUntil,omitempty" bson:"validUntil,omitempty"`     // Last day the voucher can be bought
+	ValidityDays    int        `json:"validityDays,omitempty" bson:"validityDays,omitempty"` // Days a purchased voucher stays usable, 0 for no expiry
+	MinTier         string     `json:"minTier,omitempty" bson:"minTier,omitempty"`           // Lowest loyalty tier that can buy it, any when empty
+}
+
+// VoucherAudience narrows the users a voucher is shown to; an empty field matches everyone
+type VoucherAudience struct {
+	Category     string   `json:"category,omitempty" bson:"category,omitempty"`
+	TargetDeals  []string `json:"targetDeals,omitempty" bson:"targetDeals,omitempty"`   // Shown to users with one of these interested deals
+	TargetCities []string `json:"targetCities,omitempty" bson:"targetCities,omitempty"` // Shown to users located in one of these cities
 }
 
-// VoucherPurchase represents a user's purchase of a voucher
+// Voucher issuer types: the businesses that can fund their own vouchers
+const (
+	VoucherIssuerCompany    = PointsAccountCompany
+	VoucherIssuerWholesaler = PointsAccountWholesaler
+)
+
+// Voucher owner types, the same as the points account types
+const (
+	VoucherOwnerUser            = PointsAccountUser
+	VoucherOwnerCompany         = PointsAccountCompany
+	VoucherOwnerWholesaler      = PointsAccountWholesaler
+	VoucherOwnerServiceProvider = PointsAccountServiceProvider
+)
+
+// VoucherPurchase is a voucher bought with points by a user, company,
+// wholesaler or service provider
 type VoucherPurchase struct {
 	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
+	OwnerType   string             `json:"ownerType" bson:"ownerType"` // "user", "company", "wholesaler" or "serviceProvider"
+	OwnerID     primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	UserID      primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"` // Owner of user purchases, kept for older clients
 	VoucherID   primitive.ObjectID `json:"voucherId" bson:"voucherId"`
 	PointsUsed  int                `json:"pointsUsed" bson:"pointsUsed"`
 	PurchasedAt time.Time          `json:"purchasedAt" bson:"purchasedAt"`
+	ExpiresAt   *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
 	IsUsed      bool               `json:"isUsed" bson:"isUsed"`
 	UsedAt      time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+
+	// Signed code shown to a partner as a QR code to redeem the voucher
+	RedemptionCode       string              `json:"redemptionCode,omitempty" bson:"redemptionCode,omitempty"`
+	RedeemedCompanyID    *primitive.ObjectID `json:"redeemedCompanyId,omitempty" bson:"redeemedCompanyId,omitempty"`
+	RedeemedWholesalerID *primitive.ObjectID `json:"redeemedWholesalerId,omitempty" bson:"redeemedWholesalerId,omitempty"`
+	RedeemedBranchID     *primitive.ObjectID `json:"redeemedBranchId,omitempty" bson:"redeemedBranchId,omitempty"`
+	RedeemedBy           *primitive.ObjectID `json:"redeemedBy,omitempty" bson:"redeemedBy,omitempty"` // Staff member who scanned the code
+}
+
+// Outcomes of an attempt to redeem a voucher
+const (
+	VoucherRedemptionRedeemed        = "redeemed"
+	VoucherRedemptionInvalidCode     = "invalid_code"
+	VoucherRedemptionAlreadyRedeemed = "already_redeemed"
+	VoucherRedemptionExpired         = "expired"
+	VoucherRedemptionWrongMerchant   = "wrong_merchant"
+	VoucherRedemptionFailed          = "failed"
+)
+
+// VoucherRedemptionAttempt records a partner company or wholesaler scanning a
+// voucher code, whether or not the voucher was redeemed
+type VoucherRedemptionAttempt struct {
+	ID           primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	PurchaseID   *primitive.ObjectID `json:"purchaseId,omitempty" bson:"purchaseId,omitempty"` // Unset when the code is not a valid one
+	VoucherID    *primitive.ObjectID `json:"voucherId,omitempty" bson:"voucherId,omitempty"`
+	CompanyID    *primitive.ObjectID `json:"companyId,omitempty" bson:"companyId,omitempty"`
+	WholesalerID *primitive.ObjectID `json:"wholesalerId,omitempty" bson:"wholesalerId,omitempty"`
+	BranchID     *primitive.ObjectID `json:"branchId,omitempty" bson:"branchId,omitempty"`
+	UserID       primitive.ObjectID  `json:"userId" bson:"userId"` // Staff member who scanned the code
+	Result       string              `json:"result" bson:"result"` // "redeemed", "invalid_code", "already_redeemed", "expired", "wrong_merchant" or "failed"
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// VoucherRedemptionRequest is the body of a partner redeeming a scanned voucher code
+type VoucherRedemptionRequest struct {
+	Code     string `json:"code" validate:"required"`
+	BranchID string `json:"branchId,omitempty"`
+}
+
+// MarshalJSON also writes the owner under the key of the per-type purchase
+// models this one replaced, such as companyId, for clients built against them
+func (p VoucherPurchase) MarshalJSON() ([]byte, error) {
+	type purchase VoucherPurchase
+	out := struct {
+		purchase
+		CompanyID         *primitive.ObjectID `json:"companyId,omitempty"`
+		WholesalerID      *primitive.ObjectID `json:"wholesalerId,omitempty"`
+		ServiceProviderID *primitive.ObjectID `json:"serviceProviderId,omitempty"`
+	}{purchase: purchase(p)}
+	switch p.OwnerType {
+	case VoucherOwnerCompany:
+		out.CompanyID = &p.OwnerID
+	case VoucherOwnerWholesaler:
+		out.WholesalerID = &p.OwnerID
+	case VoucherOwnerServiceProvider:
+		out.ServiceProviderID = &p.OwnerID
+	}
+	return json.Marshal(out)
 }
 
 // VoucherRequest represents the request body for creating/updating vouchers
@@ -38,6 +140,38 @@ type VoucherRequest struct {
 	Description string `json:"description" validate:"required"`
 	Image       string `json:"image" validate:"required"`
 	Points      int    `json:"points" validate:"required,min=1"`
+
+	VoucherLimits
+}
+
+// VoucherApprovalRequest holds a partner voucher until an admin approves it,
+// which creates the voucher
+type VoucherApprovalRequest struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	IssuerType  string             `json:"issuerType" bson:"issuerType"` // "company" or "wholesaler"
+	IssuerID    primitive.ObjectID `json:"issuerId" bson:"issuerId"`
+	VoucherData Voucher            `json:"voucherData" bson:"voucherData"`
+	Status      string             `json:"status" bson:"status"` // "pending", "approved", "rejected"
+	SubmittedBy primitive.ObjectID `json:"submittedBy" bson:"submittedBy"`
+	AdminID     primitive.ObjectID `json:"adminId,omitempty" bson:"adminId,omitempty"`
+	AdminNote   string             `json:"adminNote,omitempty" bson:"adminNote,omitempty"`
+	SubmittedAt time.Time          `json:"submittedAt" bson:"submittedAt"`
+	ProcessedAt time.Time          `json:"processedAt,omitempty" bson:"processedAt,omitempty"`
+}
+
+// VoucherAnalytics sums up the sales and redemptions of a voucher
+type VoucherAnalytics struct {
+	Sold                int            `json:"sold"`
+	Redeemed            int            `json:"redeemed"`
+	RedemptionRate      float64        `json:"redemptionRate"`      // Share of sold vouchers redeemed, from 0 to 1
+	RedemptionsByBranch map[string]int `json:"redemptionsByBranch"` // Keyed by branch ID
+	RefusedAttempts     int            `json:"refusedAttempts"`     // Scans that did not redeem the voucher
+}
+
+// IssuedVoucher is a partner voucher with its analytics, as seen by its issuer
+type IssuedVoucher struct {
+	Voucher   Voucher          `json:"voucher"`
+	Analytics VoucherAnalytics `json:"analytics"`
 }
 
 // UserTypeVoucherRequest represents the request body for creating user-type specific vouchers
@@ -70,59 +204,26 @@ type UserVoucher struct {
 	UserPoints  int             `json:"userPoints"`
 }
 
-// CompanyVoucherPurchase represents a company's purchase of a voucher
-type CompanyVoucherPurchase struct {
-	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	CompanyID   primitive.ObjectID `json:"companyId" bson:"companyId"`
-	VoucherID   primitive.ObjectID `json:"voucherId" bson:"voucherId"`
-	PointsUsed  int                `json:"pointsUsed" bson:"pointsUsed"`
-	PurchasedAt time.Time          `json:"purchasedAt" bson:"purchasedAt"`
-	IsUsed      bool               `json:"isUsed" bson:"isUsed"`
-	UsedAt      time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
-}
-
 // CompanyVoucher represents a voucher with purchase information for a company
 type CompanyVoucher struct {
-	Voucher       Voucher                `json:"voucher"`
-	Purchase      CompanyVoucherPurchase `json:"purchase"`
-	CanPurchase   bool                   `json:"canPurchase"`
-	CompanyPoints int                    `json:"companyPoints"`
-}
-
-// ServiceProviderVoucherPurchase represents a service provider's purchase of a voucher
-type ServiceProviderVoucherPurchase struct {
-	ID                primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	ServiceProviderID primitive.ObjectID `json:"serviceProviderId" bson:"serviceProviderId"`
-	VoucherID         primitive.ObjectID `json:"voucherId" bson:"voucherId"`
-	PointsUsed        int                `json:"pointsUsed" bson:"pointsUsed"`
-	PurchasedAt       time.Time          `json:"purchasedAt" bson:"purchasedAt"`
-	IsUsed            bool               `json:"isUsed" bson:"isUsed"`
-	UsedAt            time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	Voucher       Voucher         `json:"voucher"`
+	Purchase      VoucherPurchase `json:"purchase"`
+	CanPurchase   bool            `json:"canPurchase"`
+	CompanyPoints int             `json:"companyPoints"`
 }
 
 // ServiceProviderVoucher represents a voucher with purchase information for a service provider
 type ServiceProviderVoucher struct {
-	Voucher               Voucher                        `json:"voucher"`
-	Purchase              ServiceProviderVoucherPurchase `json:"purchase"`
-	CanPurchase           bool                           `json:"canPurchase"`
-	ServiceProviderPoints int                            `json:"serviceProviderPoints"`
-}
-
-// WholesalerVoucherPurchase represents a wholesaler's purchase of a voucher
-type WholesalerVoucherPurchase struct {
-	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	WholesalerID primitive.ObjectID `json:"wholesalerId" bson:"wholesalerId"`
-	VoucherID    primitive.ObjectID `json:"voucherId" bson:"voucherId"`
-	PointsUsed   int                `json:"pointsUsed" bson:"pointsUsed"`
-	PurchasedAt  time.Time          `json:"purchasedAt" bson:"purchasedAt"`
-	IsUsed       bool               `json:"isUsed" bson:"isUsed"`
-	UsedAt       time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	Voucher               Voucher         `json:"voucher"`
+	Purchase              VoucherPurchase `json:"purchase"`
+	CanPurchase           bool            `json:"canPurchase"`
+	ServiceProviderPoints int             `json:"serviceProviderPoints"`
 }
 
 // WholesalerVoucher represents a voucher with purchase information for a wholesaler
 type WholesalerVoucher struct {
-	Voucher          Voucher                   `json:"voucher"`
-	Purchase         WholesalerVoucherPurchase `json:"purchase"`
-	CanPurchase      bool                      `json:"canPurchase"`
-	WholesalerPoints int                       `json:"wholesalerPoints"`
+	Voucher          Voucher         `json:"voucher"`
+	Purchase         VoucherPurchase `json:"purchase"`
+	CanPurchase      bool            `json:"canPurchase"`
+	WholesalerPoints int             `json:"wholesalerPoints"`
 }
@@ -19,6 +19,7 @@ type Wholesaler struct {
 	ReferralCode     string               `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
 	Referrals        []primitive.ObjectID `json:"referrals,omitempty" bson:"referrals,omitempty"` // Added: List of referred wholesalers
 	Points           int                  `json:"points" bson:"points"`
+	LoyaltyTier      string               `json:"loyaltyTier,omitempty" bson:"loyaltyTier,omitempty"` // Kept up to date by RefreshLoyaltyTiers
 	ContactInfo      ContactInfo          `json:"contactInfo" bson:"contactInfo"`
 	ContactPerson    string               `json:"contactPerson,omitempty" bson:"contactPerson,omitempty"`
 	SocialMedia      SocialMedia          `json:"socialMedia,omitempty" bson:"socialMedia,omitempty"`
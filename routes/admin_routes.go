@@ -54,6 +54,11 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 	// All entities route
 	protected.GET("/all-entities", adminController.GetAllEntities)
 
+	// Sponsorship and engagement analytics
+	analyticsController := controllers.NewAnalyticsController(client)
+	protected.GET("/analytics/sponsorships", analyticsController.GetSponsorshipAnalyticsForAdmin)
+	protected.GET("/analytics/entities/:type/:id", analyticsController.GetEntityAnalyticsForAdmin)
+
 	// Sales manager routes
 	protected.POST("/sales-managers", adminController.CreateSalesManager)
 	protected.GET("/sales-managers", adminController.GetAllSalesManagers)
@@ -104,6 +109,58 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 	protected.POST("/sponsorship-subscriptions/requests/:id/process", sponsorshipSubscriptionController.ProcessSponsorshipSubscriptionRequest)
 	protected.GET("/sponsorship-subscriptions/active", sponsorshipSubscriptionController.GetActiveSponsorshipSubscriptions)
 
+	// Sponsorship inventory caps and waiting list
+	protected.GET("/sponsorship-caps", sponsorshipSubscriptionController.GetSponsorshipInventoryCaps)
+	protected.PUT("/sponsorship-caps", sponsorshipSubscriptionController.SetSponsorshipInventoryCap)
+	protected.DELETE("/sponsorship-caps/:id", sponsorshipSubscriptionController.DeleteSponsorshipInventoryCap)
+	protected.GET("/sponsorship-waitlist", sponsorshipSubscriptionController.GetSponsorshipWaitlist)
+
+	// Promo codes and their redemptions
+	promoCodeController := controllers.NewPromoCodeController(client)
+	protected.POST("/promo-codes", promoCodeController.CreatePromoCode)
+	protected.GET("/promo-codes", promoCodeController.GetPromoCodes)
+	protected.GET("/promo-codes/redemptions", promoCodeController.GetPromoCodeRedemptions)
+	protected.GET("/promo-codes/report", promoCodeController.GetPromoCodeReport)
+	protected.PUT("/promo-codes/:id", promoCodeController.UpdatePromoCode)
+	protected.DELETE("/promo-codes/:id", promoCodeController.DeactivatePromoCode)
+
+	// Points ledger and manual adjustments
+	pointsController := controllers.NewPointsController(client)
+	protected.GET("/points/:accountType/:id/history", pointsController.GetAccountPointsHistory)
+	protected.POST("/points/adjustments", pointsController.AdjustPoints)
+
+	// Referral reward rules, campaigns and cash payouts
+	referralRuleController := controllers.NewReferralRuleController(client)
+	protected.GET("/referral-rules", referralRuleController.GetReferralRules)
+	protected.POST("/referral-rules", referralRuleController.CreateReferralRule)
+	protected.PUT("/referral-rules/:id", referralRuleController.UpdateReferralRule)
+	protected.DELETE("/referral-rules/:id", referralRuleController.DeactivateReferralRule)
+	protected.GET("/referral-rewards", referralRuleController.GetReferralRewards)
+	protected.POST("/referral-rewards/:id/paid", referralRuleController.MarkReferralRewardPaid)
+	protected.GET("/referral-reviews", referralRuleController.GetReferralReviews)
+	protected.POST("/referral-reviews/:id/process", referralRuleController.ProcessReferralReview)
+
+	// Subscription commission rules and the records they produced
+	commissionRuleController := controllers.NewCommissionRuleController(client)
+	protected.GET("/commission-rules", commissionRuleController.GetCommissionRules)
+	protected.POST("/commission-rules", commissionRuleController.CreateCommissionRule)
+	protected.PUT("/commission-rules/:id", commissionRuleController.UpdateCommissionRule)
+	protected.DELETE("/commission-rules/:id", commissionRuleController.DeactivateCommissionRule)
+	protected.GET("/commission-records", commissionRuleController.GetCommissionRecords)
+
+	// Referral graph and attribution reports
+	referralGraphController := controllers.NewReferralGraphController(client)
+	protected.GET("/referral-graph/funnel", referralGraphController.GetReferralFunnel)
+	protected.GET("/referral-graph/top-referrers", referralGraphController.GetTopReferrers)
+	protected.GET("/referral-graph/clicks", referralGraphController.GetReferralClickStats)
+	protected.GET("/referral-graph/:type/:id/tree", referralGraphController.GetAccountReferralTree)
+
+	// Loyalty program: points expiry and tiers
+	loyaltyController := controllers.NewLoyaltyController(client)
+	protected.GET("/loyalty/settings", loyaltyController.GetLoyaltySettings)
+	protected.PUT("/loyalty/settings", loyaltyController.UpdateLoyaltySettings)
+	protected.GET("/loyalty/:accountType/:id", loyaltyController.GetAccountLoyaltyStatus)
+
 	// Admin sponsorship subscription time remaining routes
 	protected.GET("/sponsorship-subscriptions/company-branch/:branchId/time-remaining", sponsorshipSubscriptionController.GetTimeRemainingForCompanyBranch)
 	protected.GET("/sponsorship-subscriptions/wholesaler-branch/:branchId/time-remaining", sponsorshipSubscriptionController.GetTimeRemainingForWholesalerBranch)
@@ -186,6 +243,7 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 	bookingController := controllers.NewBookingController(client, hub)
 	protected.GET("/bookings", bookingController.GetAllBookingsForAdmin)
 	protected.DELETE("/bookings/:id", bookingController.DeleteBookingForAdmin)
+	protected.PUT("/bookings/:id/status", bookingController.UpdateBookingStatusForAdmin)
 
 	// Review management routes
 	reviewController := controllers.NewReviewController(client)
@@ -193,6 +251,19 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 	protected.PUT("/reviews/:id/verify", reviewController.ToggleReviewVerification)
 	protected.DELETE("/reviews/:id", reviewController.DeleteReview)
 
+	// Review and comment moderation
+	moderationController := controllers.NewModerationController(client)
+	protected.GET("/moderation/queue", moderationController.GetModerationQueue)
+	protected.POST("/moderation/:type/:id", moderationController.ModerateContent)
+	protected.GET("/moderation/reports", moderationController.GetContentReports)
+	protected.GET("/moderation/words", moderationController.GetModerationWords)
+	protected.POST("/moderation/words", moderationController.AddModerationWords)
+	protected.DELETE("/moderation/words/:id", moderationController.DeleteModerationWord)
+
+	// Rating aggregates
+	ratingController := controllers.NewRatingController(client)
+	protected.POST("/ratings/recompute", ratingController.RecomputeRatings)
+
 	// Delete entity by ID
 	protected.DELETE("/entities/:entityType/:id", adminController.DeleteEntity)
 
@@ -208,6 +279,11 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 	protected.PUT("/vouchers/:id", voucherController.UpdateVoucher)
 	protected.DELETE("/vouchers/:id", voucherController.DeleteVoucher)
 	protected.PUT("/vouchers/:id/toggle-status", voucherController.ToggleVoucherStatus)
+	protected.GET("/vouchers/redemptions", voucherController.GetVoucherRedemptions)
+
+	// Partner voucher approval routes
+	protected.GET("/voucher-requests/pending", voucherController.GetPendingVoucherRequests)
+	protected.POST("/voucher-requests/:id/process", voucherController.ProcessVoucherRequest)
 
 	// Pending requests from admin-created salespersons
 	protected.GET("/pending-requests", adminController.GetPendingRequestsFromAdminSalespersons)
@@ -222,6 +298,7 @@ func RegisterAdminRoutes(e *echo.Echo, db *mongo.Database, hub *websocket.Hub) {
 
 	manager.GET("/bookings", bookingController.GetAllBookingsForAdmin)
 	manager.DELETE("/bookings/:id", bookingController.DeleteBookingForAdmin)
+	manager.PUT("/bookings/:id/status", bookingController.UpdateBookingStatusForAdmin)
 
 	salesManager := admin.Group("/salesmanager")
 	salesManager.Use(middleware.JWTMiddleware())
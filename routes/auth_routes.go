@@ -59,6 +59,23 @@ func RegisterAuthRoutes(e *echo.Echo, db *mongo.Client, authController *controll
 	// Public company and wholesaler filter
 	e.GET("/filter/companies-wholesalers", userController.FilterCompaniesAndWholesalers)
 
+	// Public radius and bounding-box search over branches and service providers
+	geoSearchController := controllers.NewGeoSearchController(db)
+	e.GET("/api/search/nearby", geoSearchController.SearchNearby)
+
+	// Public full-text search across companies, wholesalers, branches and service providers
+	searchController := controllers.NewSearchController(db)
+	e.GET("/api/search", searchController.Search)
+
+	// Public ratings of service providers and branches
+	ratingController := controllers.NewRatingController(db)
+	e.GET("/api/ratings/top", ratingController.GetTopRated)
+	e.GET("/api/ratings/:entityType/:id", ratingController.GetEntityRating)
+
+	// Public engagement tracking for views and contact taps
+	analyticsController := controllers.NewAnalyticsController(db)
+	e.POST("/api/analytics/events", analyticsController.TrackEvent)
+
 	// Public sponsorship routes
 	e.GET("/api/sponsorships", func(c echo.Context) error {
 		sponsorshipController := controllers.NewSponsorshipController(db.Database("barrim"))
@@ -76,6 +93,10 @@ func RegisterAuthRoutes(e *echo.Echo, db *mongo.Client, authController *controll
 		sponsorshipController := controllers.NewSponsorshipController(db.Database("barrim"))
 		return sponsorshipController.GetSponsorship(c)
 	})
+	e.GET("/api/sponsorships/:id/availability", func(c echo.Context) error {
+		sponsorshipSubscriptionController := controllers.NewSponsorshipSubscriptionController(db.Database("barrim"))
+		return sponsorshipSubscriptionController.GetSponsorshipAvailability(c)
+	})
 
 	// Public sponsorship subscription routes
 	e.POST("/api/sponsorship-subscriptions/request", func(c echo.Context) error {
@@ -103,6 +103,16 @@ func RegisterCompanyRoutes(e *echo.Echo, companyController *controllers.CompanyC
 	companyGroup.POST("/vouchers/purchase", companyVoucherController.PurchaseVoucherForCompany)
 	companyGroup.GET("/vouchers/purchased", companyVoucherController.GetCompanyVouchers)
 	companyGroup.PUT("/vouchers/:id/use", companyVoucherController.UseVoucherForCompany)
+	companyGroup.GET("/vouchers/:id/qr", companyVoucherController.GetCompanyVoucherQRCode)
+
+	// Redeeming vouchers scanned at the company or its branches
+	companyGroup.POST("/vouchers/redeem", companyVoucherController.RedeemVoucher)
+	companyGroup.GET("/vouchers/redemptions", companyVoucherController.GetVoucherRedemptions)
+
+	// Vouchers funded by the company
+	companyGroup.POST("/vouchers/issued", companyVoucherController.SubmitCompanyVoucher)
+	companyGroup.GET("/vouchers/issued", companyVoucherController.GetCompanyIssuedVouchers)
+	companyGroup.GET("/vouchers/issued/requests", companyVoucherController.GetCompanyVoucherRequests)
 
 	// Example for wholesaler branch subscription routes (to be added in wholesaler_routes.go):
 	// wholesalerGroup.POST("/subscription/:branchId/request", wholesalerBranchSubscriptionController.CreateBranchSubscriptionRequest)
@@ -104,6 +104,19 @@ func RegisterServiceProviderRoutes(e *echo.Echo, db *mongo.Database, serviceProv
 	})
 	log.Println("Registered /description endpoint")
 
+	// Availability calendar routes - service providers manage their booking calendar
+	protected.GET("/availability", func(c echo.Context) error {
+		log.Printf("Received request for availability calendar from %s", c.Request().RemoteAddr)
+		return serviceProviderController.GetAvailabilityCalendar(c)
+	})
+	log.Println("Registered /availability endpoint")
+
+	protected.PUT("/availability", func(c echo.Context) error {
+		log.Printf("Received availability calendar update from %s", c.Request().RemoteAddr)
+		return serviceProviderController.UpdateAvailabilityCalendar(c)
+	})
+	log.Println("Registered /availability update endpoint")
+
 	// Portfolio image routes - service providers can manage portfolio images
 	protected.POST("/portfolio/upload", func(c echo.Context) error {
 		log.Printf("Received portfolio image upload request from %s", c.Request().RemoteAddr)
@@ -203,5 +216,11 @@ func RegisterServiceProviderRoutes(e *echo.Echo, db *mongo.Database, serviceProv
 	})
 	log.Println("Registered /vouchers/:id/use endpoint")
 
+	protected.GET("/vouchers/:id/qr", func(c echo.Context) error {
+		log.Printf("Received voucher QR code request from %s", c.Request().RemoteAddr)
+		return serviceProviderVoucherController.GetServiceProviderVoucherQRCode(c)
+	})
+	log.Println("Registered /vouchers/:id/qr endpoint")
+
 	log.Println("Finished registering all service provider routes")
 }
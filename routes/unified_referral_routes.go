@@ -21,4 +21,9 @@ func RegisterUnifiedReferralRoutes(e *echo.Echo, db *mongo.Client) {
 	referralGroup.POST("/apply", unifiedReferralController.HandleReferral)
 	referralGroup.GET("/data", unifiedReferralController.GetReferralData)
 	referralGroup.GET("/qrcode", unifiedReferralController.GetReferralQRCode)
+
+	// Tracked referral links are opened before the visitor has an account
+	referralLinkController := controllers.NewReferralLinkController(db)
+	e.GET("/r/:code", referralLinkController.FollowReferralLink)
+	e.GET("/api/referral-links/attribution", referralLinkController.GetReferralAttribution)
 }
@@ -49,17 +49,29 @@ func RegisterUserRoutes(e *echo.Echo, db *mongo.Client, userController *controll
 	r.POST("/vouchers/purchase", voucherController.PurchaseVoucher)
 	r.GET("/vouchers/my-vouchers", voucherController.GetUserVouchers)
 	r.PUT("/vouchers/:id/use", voucherController.UseVoucher)
+	r.GET("/vouchers/:id/qr", voucherController.GetVoucherQRCode)
 
 	// Review routes
 	r.POST("/reviews", reviewController.CreateReview)
+	r.PUT("/reviews/:id", reviewController.UpdateReview)
 	r.POST("/reviews/:id/reply", reviewController.PostReviewReply)
 	r.GET("/reviews/:id/reply", reviewController.GetReviewReply)
 
+	// Reporting reviews and branch comments for moderation
+	moderationController := controllers.NewModerationController(db)
+	r.POST("/reviews/:id/report", moderationController.ReportReview)
+	r.POST("/comments/:id/report", moderationController.ReportBranchComment)
+
 	// Booking routes
 	r.POST("/bookings", bookingController.CreateBooking)
 	r.GET("/bookings/user", bookingController.GetUserBookings)
 	r.PUT("/bookings/:id/status", bookingController.UpdateBookingStatus)
 	r.PUT("/bookings/:id/cancel", bookingController.CancelBooking)
+	r.POST("/bookings/:id/reschedule", bookingController.RequestReschedule)
+	r.PUT("/bookings/:id/reschedule/respond", bookingController.RespondToReschedule)
+	r.POST("/bookings/emergency", bookingController.CreateEmergencyBooking)
+	r.GET("/bookings/emergency/:id", bookingController.GetEmergencyDispatch)
+	r.PUT("/bookings/emergency/:id/cancel", bookingController.CancelEmergencyDispatch)
 
 	// Favorites routes
 	r.POST("/users/favorites", userController.AddBranchToFavorites)
@@ -84,6 +96,28 @@ func RegisterUserRoutes(e *echo.Echo, db *mongo.Client, userController *controll
 		return websocket.HandleWebSocket(c, hub, user.ID)
 	})
 
+	// Engagement and sponsorship analytics for business owners
+	analyticsController := controllers.NewAnalyticsController(db)
+	analytics := r.Group("/analytics")
+	analytics.Use(middleware.RequireUserType("serviceProvider", "company", "wholesaler"))
+	analytics.GET("/me", analyticsController.GetMyAnalytics)
+
+	// Promo code preview at checkout
+	promoCodeController := controllers.NewPromoCodeController(db)
+	r.POST("/promo-codes/validate", promoCodeController.ValidatePromoCode)
+
+	// Points balance and ledger of the logged-in account
+	pointsController := controllers.NewPointsController(db)
+	r.GET("/points/history", pointsController.GetPointsHistory)
+
+	// Loyalty tier and expiring points of the logged-in account
+	loyaltyController := controllers.NewLoyaltyController(db)
+	r.GET("/loyalty", loyaltyController.GetMyLoyaltyStatus)
+
+	// Referral tree of the logged-in account
+	referralGraphController := controllers.NewReferralGraphController(db)
+	r.GET("/referrals/tree", referralGraphController.GetMyReferralTree)
+
 	// Company-specific routes
 	company := r.Group("/company")
 	company.Use(middleware.RequireUserType("company", "user"))
@@ -119,6 +153,8 @@ func RegisterUserRoutes(e *echo.Echo, db *mongo.Client, userController *controll
 	serviceProvider.GET("/bookings", bookingController.GetProviderBookings)
 	serviceProvider.GET("/bookings/pending", bookingController.GetPendingBookings)
 	serviceProvider.PUT("/bookings/:id/respond", bookingController.AcceptBooking)
+	serviceProvider.GET("/emergency", bookingController.GetOpenEmergencyDispatches)
+	serviceProvider.PUT("/emergency/:id/respond", bookingController.RespondToEmergencyDispatch)
 	serviceProvider.POST("/referral", func(c echo.Context) error {
 		serviceProviderController := controllers.NewServiceProviderReferralController(db)
 		return serviceProviderController.HandleServiceProviderReferral(c)
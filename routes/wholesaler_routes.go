@@ -100,6 +100,16 @@ func RegisterWholesalerRoutes(e *echo.Echo, db *mongo.Database, wholesalerVouche
 	protected.POST("/vouchers/purchase", wholesalerVoucherController.PurchaseVoucherForWholesaler)
 	protected.GET("/vouchers/purchased", wholesalerVoucherController.GetWholesalerVouchers)
 	protected.PUT("/vouchers/:id/use", wholesalerVoucherController.UseVoucherForWholesaler)
+	protected.GET("/vouchers/:id/qr", wholesalerVoucherController.GetWholesalerVoucherQRCode)
+
+	// Redeeming vouchers scanned at the wholesaler or its branches
+	protected.POST("/vouchers/redeem", wholesalerVoucherController.RedeemVoucher)
+	protected.GET("/vouchers/redemptions", wholesalerVoucherController.GetVoucherRedemptions)
+
+	// Vouchers funded by the wholesaler
+	protected.POST("/vouchers/issued", wholesalerVoucherController.SubmitWholesalerVoucher)
+	protected.GET("/vouchers/issued", wholesalerVoucherController.GetWholesalerIssuedVouchers)
+	protected.GET("/vouchers/issued/requests", wholesalerVoucherController.GetWholesalerVoucherRequests)
 
 	log.Println("Registered wholesaler voucher endpoints")
 
@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+const (
+	// DefaultSlotDurationMinutes is used when a provider has not configured a slot duration
+	DefaultSlotDurationMinutes = 30
+	// TimeSlotLayout is the display format used for booking time slots
+	TimeSlotLayout = "3:04 PM"
+)
+
+// defaultTimeSlots is offered to providers that have not configured any hours
+var defaultTimeSlots = []string{
+	"9:00 AM", "9:30 AM", "10:00 AM", "10:30 AM", "11:00 AM", "11:30 AM",
+	"1:00 PM", "1:30 PM", "2:00 PM", "2:30 PM", "3:00 PM", "3:30 PM",
+	"4:00 PM", "4:30 PM", "5:00 PM",
+}
+
+// TimeSlot is a bookable slot on a given date in the provider's time zone
+type TimeSlot struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// BookedInterval is a period already taken by an active booking
+type BookedInterval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ProviderLocation returns the provider's configured time zone, or the server's local zone
+func ProviderLocation(info *models.ServiceProviderInfo) *time.Location {
+	if info != nil && info.Availability != nil && info.Availability.TimeZone != "" {
+		if loc, err := time.LoadLocation(info.Availability.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// BookingDateFor returns the booking date stored for the calendar day t falls
+// on in its own zone: that day's midnight in UTC
+func BookingDateFor(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// BookingDay returns the calendar day a stored booking date stands for, as
+// UTC midnight. Older bookings were stored as midnight in the client's zone,
+// which is within 12 hours of that day's UTC midnight.
+func BookingDay(bookingDate time.Time) time.Time {
+	return bookingDate.UTC().Add(12 * time.Hour).Truncate(24 * time.Hour)
+}
+
+// ParseTimeSlot parses a time slot in either "15:04" or "3:04 PM" format
+func ParseTimeSlot(slot string) (time.Time, error) {
+	slot = strings.TrimSpace(slot)
+	if t, err := time.Parse("15:04", slot); err == nil {
+		return t, nil
+	}
+	return time.Parse(TimeSlotLayout, slot)
+}
+
+// NormalizeTimeSlot converts a time slot to the "3:04 PM" display format
+func NormalizeTimeSlot(slot string) (string, error) {
+	t, err := ParseTimeSlot(slot)
+	if err != nil {
+		return "", fmt.Errorf("invalid time slot %q", slot)
+	}
+	return t.Format(TimeSlotLayout), nil
+}
+
+// ServiceDurationMinutes returns the booking duration for a service type
+func ServiceDurationMinutes(info *models.ServiceProviderInfo, serviceType string) int {
+	if info == nil || info.Availability == nil {
+		return DefaultSlotDurationMinutes
+	}
+	for _, sd := range info.Availability.ServiceDurations {
+		if sd.DurationMinutes > 0 && strings.EqualFold(sd.ServiceType, serviceType) {
+			return sd.DurationMinutes
+		}
+	}
+	if info.Availability.SlotDurationMinutes > 0 {
+		return info.Availability.SlotDurationMinutes
+	}
+	return DefaultSlotDurationMinutes
+}
+
+// BufferMinutes returns the buffer a provider keeps after each booking
+func BufferMinutes(info *models.ServiceProviderInfo) int {
+	if info == nil || info.Availability == nil || info.Availability.BufferMinutes < 0 {
+		return 0
+	}
+	return info.Availability.BufferMinutes
+}
+
+// DailyCapacity returns the maximum number of active bookings per day, 0 meaning unlimited
+func DailyCapacity(info *models.ServiceProviderInfo) int {
+	if info == nil || info.Availability == nil || info.Availability.DailyCapacity < 0 {
+		return 0
+	}
+	return info.Availability.DailyCapacity
+}
+
+// ValidateProviderAvailability checks a calendar submitted by a provider
+func ValidateProviderAvailability(a *models.ProviderAvailability) error {
+	if a.TimeZone != "" {
+		if _, err := time.LoadLocation(a.TimeZone); err != nil {
+			return fmt.Errorf("invalid time zone %q", a.TimeZone)
+		}
+	}
+	if a.SlotDurationMinutes < 0 || a.BufferMinutes < 0 || a.DailyCapacity < 0 {
+		return fmt.Errorf("slot duration, buffer and daily capacity cannot be negative")
+	}
+	seen := make(map[string]bool)
+	for _, rule := range a.WeeklyRules {
+		if _, ok := parseWeekday(rule.Weekday); !ok {
+			return fmt.Errorf("invalid weekday %q", rule.Weekday)
+		}
+		if seen[rule.Weekday] {
+			return fmt.Errorf("duplicate rule for %s", rule.Weekday)
+		}
+		seen[rule.Weekday] = true
+		if err := validateIntervals(rule.Intervals); err != nil {
+			return fmt.Errorf("%s: %w", rule.Weekday, err)
+		}
+	}
+	for _, sd := range a.ServiceDurations {
+		if sd.ServiceType == "" || sd.DurationMinutes <= 0 {
+			return fmt.Errorf("service durations need a service type and a positive duration")
+		}
+	}
+	for _, day := range a.Holidays {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return fmt.Errorf("invalid holiday date %q", day)
+		}
+	}
+	for _, ex := range a.Exceptions {
+		if _, err := time.Parse("2006-01-02", ex.Date); err != nil {
+			return fmt.Errorf("invalid exception date %q", ex.Date)
+		}
+		if !ex.Closed {
+			if err := validateIntervals(ex.Intervals); err != nil {
+				return fmt.Errorf("%s: %w", ex.Date, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateIntervals(intervals []models.TimeInterval) error {
+	for _, iv := range intervals {
+		start, err1 := time.Parse("15:04", iv.Start)
+		end, err2 := time.Parse("15:04", iv.End)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("intervals must use HH:MM format")
+		}
+		if !start.Before(end) {
+			return fmt.Errorf("interval %s-%s ends before it starts", iv.Start, iv.End)
+		}
+	}
+	return nil
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(strings.TrimSpace(name), d.String()) {
+			return d, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// workingIntervals returns the provider's working intervals on a date. A nil
+// slice means the provider does not work that day; legacy providers without
+// configured hours get the default slots through the useDefaults flag.
+func workingIntervals(info *models.ServiceProviderInfo, date string, weekday time.Weekday) (intervals []models.TimeInterval, legacySlots []string, useDefaults bool) {
+	if info == nil {
+		return nil, nil, false
+	}
+
+	if a := info.Availability; a != nil {
+		for _, holiday := range a.Holidays {
+			if holiday == date {
+				return nil, nil, false
+			}
+		}
+		for _, ex := range a.Exceptions {
+			if ex.Date == date {
+				if ex.Closed {
+					return nil, nil, false
+				}
+				return ex.Intervals, nil, false
+			}
+		}
+		for _, rule := range a.WeeklyRules {
+			if d, ok := parseWeekday(rule.Weekday); ok && d == weekday {
+				return rule.Intervals, nil, false
+			}
+		}
+		return nil, nil, false
+	}
+
+	// Legacy calendar: explicit dates or comma-separated weekday names
+	dateAvailable := false
+	for _, day := range info.AvailableDays {
+		if day == date {
+			dateAvailable = true
+			break
+		}
+	}
+	if !dateAvailable {
+		for _, weekdayStr := range info.AvailableWeekdays {
+			for _, name := range strings.Split(weekdayStr, ",") {
+				if d, ok := parseWeekday(name); ok && d == weekday {
+					dateAvailable = true
+				}
+			}
+		}
+	}
+	if !dateAvailable {
+		return nil, nil, false
+	}
+	if len(info.AvailableHours) == 0 {
+		return nil, nil, true
+	}
+
+	// Legacy hours are stored as "09:00,10:00,...,15:00" in the first element
+	var hours []string
+	for _, h := range strings.Split(strings.Join(info.AvailableHours, ","), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hours = append(hours, h)
+		}
+	}
+	if len(hours) >= 2 {
+		return []models.TimeInterval{{Start: hours[0], End: hours[len(hours)-1]}}, nil, false
+	}
+	return nil, hours, false
+}
+
+// GenerateTimeSlots returns every slot the provider offers on a date for a
+// booking lasting durationMinutes, before removing booked slots. Date is
+// interpreted as a calendar day in the provider's time zone.
+func GenerateTimeSlots(info *models.ServiceProviderInfo, date time.Time, durationMinutes int) []TimeSlot {
+	loc := ProviderLocation(info)
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	dateStr := day.Format("2006-01-02")
+
+	duration := time.Duration(durationMinutes) * time.Minute
+	step := duration + time.Duration(BufferMinutes(info))*time.Minute
+
+	intervals, legacySlots, useDefaults := workingIntervals(info, dateStr, day.Weekday())
+
+	var slots []TimeSlot
+	addSlot := func(clock time.Time, end time.Time) {
+		start := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+		slots = append(slots, TimeSlot{Label: start.Format(TimeSlotLayout), Start: start, End: end})
+	}
+
+	if useDefaults {
+		legacySlots = defaultTimeSlots
+	}
+	for _, s := range legacySlots {
+		if clock, err := ParseTimeSlot(s); err == nil {
+			start := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+			addSlot(clock, start.Add(duration))
+		}
+	}
+
+	for _, iv := range intervals {
+		startClock, err1 := time.Parse("15:04", iv.Start)
+		endClock, err2 := time.Parse("15:04", iv.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ivStart := time.Date(day.Year(), day.Month(), day.Day(), startClock.Hour(), startClock.Minute(), 0, 0, loc)
+		ivEnd := time.Date(day.Year(), day.Month(), day.Day(), endClock.Hour(), endClock.Minute(), 0, 0, loc)
+		for s := ivStart; !s.Add(duration).After(ivEnd); s = s.Add(step) {
+			addSlot(s, s.Add(duration))
+		}
+	}
+
+	return slots
+}
+
+// FilterFreeSlots removes slots that overlap a booked interval (including the
+// provider's buffer) and slots that have already started
+func FilterFreeSlots(info *models.ServiceProviderInfo, slots []TimeSlot, booked []BookedInterval, now time.Time) []TimeSlot {
+	buffer := time.Duration(BufferMinutes(info)) * time.Minute
+	free := make([]TimeSlot, 0, len(slots))
+	for _, slot := range slots {
+		if !slot.Start.After(now) {
+			continue
+		}
+		taken := false
+		for _, b := range booked {
+			if slot.Start.Before(b.End.Add(buffer)) && b.Start.Before(slot.End.Add(buffer)) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			free = append(free, slot)
+		}
+	}
+	return free
+}
+
+// BookedIntervalFor returns the interval covered by a booking on the provider's calendar
+func BookedIntervalFor(info *models.ServiceProviderInfo, booking models.Booking) (BookedInterval, bool) {
+	clock, err := ParseTimeSlot(booking.TimeSlot)
+	if err != nil {
+		return BookedInterval{}, false
+	}
+	loc := ProviderLocation(info)
+	d := BookingDay(booking.BookingDate)
+	start := time.Date(d.Year(), d.Month(), d.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	minutes := booking.DurationMinutes
+	if minutes <= 0 {
+		minutes = ServiceDurationMinutes(info, "")
+	}
+	return BookedInterval{Start: start, End: start.Add(time.Duration(minutes) * time.Minute)}, true
+}
+
+// SlotLabels returns the display labels of a list of slots
+func SlotLabels(slots []TimeSlot) []string {
+	labels := make([]string, 0, len(slots))
+	for _, s := range slots {
+		labels = append(labels, s.Label)
+	}
+	return labels
+}
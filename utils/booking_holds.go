@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// DefaultBookingHoldDuration is how long a pending booking keeps its slot while
+// waiting for the provider, unless BOOKING_HOLD_DURATION overrides it
+const DefaultBookingHoldDuration = 24 * time.Hour
+
+// Default reschedule policy, overridable with BOOKING_RESCHEDULE_MIN_NOTICE and BOOKING_RESCHEDULE_MAX_COUNT
+const (
+	DefaultRescheduleMinNotice = 2 * time.Hour
+	DefaultMaxReschedules      = 2
+)
+
+// ErrSlotTaken is returned when another active booking already holds the slot
+var ErrSlotTaken = errors.New("time slot already reserved")
+
+// BookingHoldDuration returns the configured hold duration for pending bookings
+func BookingHoldDuration() time.Duration {
+	if v := os.Getenv("BOOKING_HOLD_DURATION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid BOOKING_HOLD_DURATION %q, using default", v)
+	}
+	return DefaultBookingHoldDuration
+}
+
+// RescheduleMinNotice returns how long before the appointment a booking can still be rescheduled
+func RescheduleMinNotice() time.Duration {
+	if v := os.Getenv("BOOKING_RESCHEDULE_MIN_NOTICE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Invalid BOOKING_RESCHEDULE_MIN_NOTICE %q, using default", v)
+	}
+	return DefaultRescheduleMinNotice
+}
+
+// MaxReschedules returns how many times a booking may be rescheduled
+func MaxReschedules() int {
+	if v := os.Getenv("BOOKING_RESCHEDULE_MAX_COUNT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Invalid BOOKING_RESCHEDULE_MAX_COUNT %q, using default", v)
+	}
+	return DefaultMaxReschedules
+}
+
+// SlotHoldMinutes is the size of the calendar cells a booking holds. A booking
+// holds every cell from its start to the end of its buffer, so that bookings
+// of any length and start time collide when they overlap.
+const SlotHoldMinutes = 5
+
+// slotHoldCell is one cell of a provider's calendar, as stored in a hold
+type slotHoldCell struct {
+	Date     string // "2006-01-02" in the provider's time zone
+	TimeSlot string // "15:04" start of the cell
+}
+
+// slotHoldCells returns the cells a booking covers on the provider's calendar,
+// from its start to the end of the provider's buffer after it
+func slotHoldCells(info *models.ServiceProviderInfo, booking models.Booking) []slotHoldCell {
+	interval, ok := BookedIntervalFor(info, booking)
+	if !ok {
+		return nil
+	}
+	loc := ProviderLocation(info)
+	step := SlotHoldMinutes * time.Minute
+	end := interval.End.Add(time.Duration(BufferMinutes(info)) * time.Minute)
+
+	var cells []slotHoldCell
+	for t := interval.Start.Truncate(step); t.Before(end); t = t.Add(step) {
+		local := t.In(loc)
+		cells = append(cells, slotHoldCell{Date: local.Format("2006-01-02"), TimeSlot: local.Format("15:04")})
+	}
+	return cells
+}
+
+// reserveSlotCells holds cells for a booking under one reservation, in a
+// transaction so that a cell held by another booking leaves none of them held
+func reserveSlotCells(db *mongo.Client, booking models.Booking, cells []slotHoldCell, expiresAt *time.Time) (primitive.ObjectID, error) {
+	reservationID := primitive.NewObjectID()
+	if len(cells) == 0 {
+		return reservationID, nil
+	}
+	now := time.Now()
+	holds := make([]interface{}, 0, len(cells))
+	covered := make(bson.A, 0, len(cells))
+	for _, cell := range cells {
+		covered = append(covered, bson.M{"date": cell.Date, "timeSlot": cell.TimeSlot})
+		holds = append(holds, models.BookingSlotHold{
+			ID:                primitive.NewObjectID(),
+			ServiceProviderID: booking.ServiceProviderID,
+			Date:              cell.Date,
+			TimeSlot:          cell.TimeSlot,
+			BookingID:         booking.ID,
+			ReservationID:     reservationID,
+			ExpiresAt:         expiresAt,
+			CreatedAt:         now,
+		})
+	}
+
+	collection := db.Database("barrim").Collection("booking_slot_holds")
+	err := RunTransaction(context.Background(), db, func(ctx context.Context) error {
+		// Holds that lapsed since the last expiry run no longer block the cells
+		if _, err := collection.DeleteMany(ctx, bson.M{
+			"serviceProviderId": booking.ServiceProviderID,
+			"expiresAt":         bson.M{"$lte": now},
+			"$or":               covered,
+		}); err != nil {
+			return err
+		}
+		_, err := collection.InsertMany(ctx, holds)
+		return err
+	})
+	if err != nil {
+		// Without transactions the cells inserted before the conflict remain
+		if _, cleanupErr := collection.DeleteMany(context.Background(), bson.M{"reservationId": reservationID}); cleanupErr != nil {
+			log.Printf("Failed to release partial slot holds of booking %s: %v", booking.ID.Hex(), cleanupErr)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, ErrSlotTaken
+		}
+		return primitive.NilObjectID, err
+	}
+	return reservationID, nil
+}
+
+// ReserveBookingSlot atomically reserves the provider's time covered by a
+// booking. The holds expire at expiresAt unless the provider accepts the
+// booking first.
+func ReserveBookingSlot(db *mongo.Client, info *models.ServiceProviderInfo, booking models.Booking, expiresAt time.Time) error {
+	_, err := reserveSlotCells(db, booking, slotHoldCells(info, booking), &expiresAt)
+	return err
+}
+
+// ConfirmBookingSlot keeps the time held for an accepted booking until it is released
+func ConfirmBookingSlot(db *mongo.Client, bookingID primitive.ObjectID) error {
+	_, err := db.Database("barrim").Collection("booking_slot_holds").UpdateMany(
+		context.Background(),
+		bson.M{"bookingId": bookingID},
+		bson.M{"$unset": bson.M{"expiresAt": ""}},
+	)
+	return err
+}
+
+// ReleaseBookingSlot frees the time held by a booking
+func ReleaseBookingSlot(db *mongo.Client, bookingID primitive.ObjectID) error {
+	_, err := db.Database("barrim").Collection("booking_slot_holds").DeleteMany(
+		context.Background(),
+		bson.M{"bookingId": bookingID},
+	)
+	return err
+}
+
+// ReserveRescheduledSlot atomically reserves the time a booking would cover at
+// a new date and slot, alongside the holds it already has; cells it already
+// holds are kept as they are. expiresAt is nil for bookings already accepted.
+// It returns the reservation of the new cells; the caller releases the
+// previous time once the move is committed, or the reservation if it fails.
+func ReserveRescheduledSlot(db *mongo.Client, info *models.ServiceProviderInfo, booking models.Booking, newDate time.Time, newTimeSlot string, expiresAt *time.Time) (primitive.ObjectID, error) {
+	moved := booking
+	moved.BookingDate = newDate
+	moved.TimeSlot = newTimeSlot
+
+	held, err := heldSlotCells(db, booking.ID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var cells []slotHoldCell
+	for _, cell := range slotHoldCells(info, moved) {
+		if !held[cell] {
+			cells = append(cells, cell)
+		}
+	}
+	return reserveSlotCells(db, booking, cells, expiresAt)
+}
+
+// heldSlotCells returns the cells a booking holds
+func heldSlotCells(db *mongo.Client, bookingID primitive.ObjectID) (map[slotHoldCell]bool, error) {
+	cursor, err := db.Database("barrim").Collection("booking_slot_holds").Find(context.Background(), bson.M{"bookingId": bookingID})
+	if err != nil {
+		return nil, err
+	}
+	var holds []models.BookingSlotHold
+	if err := cursor.All(context.Background(), &holds); err != nil {
+		return nil, err
+	}
+	held := make(map[slotHoldCell]bool, len(holds))
+	for _, h := range holds {
+		held[slotHoldCell{Date: h.Date, TimeSlot: h.TimeSlot}] = true
+	}
+	return held, nil
+}
+
+// ReleaseBookingSlotHold frees the cells of a single reservation
+func ReleaseBookingSlotHold(db *mongo.Client, reservationID primitive.ObjectID) error {
+	_, err := db.Database("barrim").Collection("booking_slot_holds").DeleteMany(context.Background(), bson.M{"reservationId": reservationID})
+	return err
+}
+
+// ReleaseBookingSlotsExcept frees the holds of a rescheduled booking outside
+// the time it now covers
+func ReleaseBookingSlotsExcept(db *mongo.Client, info *models.ServiceProviderInfo, booking models.Booking) error {
+	keep := bson.A{}
+	for _, cell := range slotHoldCells(info, booking) {
+		keep = append(keep, bson.M{"date": cell.Date, "timeSlot": cell.TimeSlot})
+	}
+	filter := bson.M{"bookingId": booking.ID}
+	if len(keep) > 0 {
+		filter["$nor"] = keep
+	}
+	_, err := db.Database("barrim").Collection("booking_slot_holds").DeleteMany(context.Background(), filter)
+	return err
+}
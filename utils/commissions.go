@@ -0,0 +1,465 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// commissionEntityTypes are the entities and plan types commission is paid on
+var commissionEntityTypes = map[string]bool{
+	"company":         true,
+	"wholesaler":      true,
+	"serviceProvider": true,
+}
+
+// CommissionSale is a paid subscription that may earn commission
+type CommissionSale struct {
+	SubscriptionID primitive.ObjectID
+	EntityType     string // "company", "wholesaler" or "serviceProvider"
+	EntityID       primitive.ObjectID
+	EntityName     string              // For the log
+	BranchID       *primitive.ObjectID // Set for branch subscriptions, which renew per branch
+	CreatedBy      primitive.ObjectID  // Who created the entity; commission is paid when it is a salesperson
+	Plan           models.SubscriptionPlan
+	AmountPaid     *float64 // What was charged after any promo code; nil when the plan price was
+}
+
+// base returns the amount the commission percents apply to
+func (sale CommissionSale) base() float64 {
+	if sale.AmountPaid != nil {
+		return *sale.AmountPaid
+	}
+	return sale.Plan.Price
+}
+
+// AdminWalletShare returns the admin's share of a sale as recorded in the admin
+// wallet. Wallet income is recorded at the list price and a promo code's
+// discount is booked against the wallet on its own, so the discount the
+// commission was spared is added back.
+func AdminWalletShare(commission *models.Commission) float64 {
+	return commission.AdminCommission + commission.PlanPrice - commission.BaseAmount
+}
+
+// ValidateCommissionRule checks the criteria and percents of a rule
+func ValidateCommissionRule(rule models.CommissionRule) error {
+	entityType := func(t string) bool {
+		return commissionEntityTypes[t] || t == models.CommissionAny
+	}
+	switch {
+	case rule.Name == "":
+		return errors.New("name is required")
+	case !entityType(rule.PlanType) || !entityType(rule.EntityType):
+		return errors.New("planType and entityType must be 'company', 'wholesaler', 'serviceProvider' or 'any'")
+	case rule.SalespersonTier == "":
+		return errors.New("salespersonTier is required, use 'any' to match every tier")
+	case rule.SaleKind != models.CommissionSaleFirst && rule.SaleKind != models.CommissionSaleRenewal && rule.SaleKind != models.CommissionAny:
+		return errors.New("saleKind must be 'first', 'renewal' or 'any'")
+	case rule.SalespersonPercent < 0 || rule.ManagerOverridePercent < 0:
+		return errors.New("percents cannot be negative")
+	case rule.SalespersonPercent+rule.ManagerOverridePercent > 100:
+		return errors.New("salespersonPercent and managerOverridePercent cannot add up to more than 100")
+	case rule.StartsAt != nil && rule.EndsAt != nil && rule.EndsAt.Before(*rule.StartsAt):
+		return errors.New("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// commissionRuleSpecificity counts the criteria a rule names instead of "any"
+func commissionRuleSpecificity(rule models.CommissionRule) int {
+	n := 0
+	for _, criterion := range []string{rule.PlanType, rule.EntityType, rule.SalespersonTier, rule.SaleKind} {
+		if criterion != models.CommissionAny {
+			n++
+		}
+	}
+	return n
+}
+
+// MatchCommissionRule returns the configured rule setting the commission on a
+// sale now, if any
+func MatchCommissionRule(ctx context.Context, db *mongo.Client, planType, entityType, tier, saleKind string, now time.Time) (models.CommissionRule, bool, error) {
+	cursor, err := db.Database("barrim").Collection("commission_rules").Find(ctx, bson.M{
+		"isActive":        true,
+		"planType":        bson.M{"$in": bson.A{planType, models.CommissionAny}},
+		"entityType":      bson.M{"$in": bson.A{entityType, models.CommissionAny}},
+		"salespersonTier": bson.M{"$in": bson.A{tier, models.CommissionAny}},
+		"saleKind":        bson.M{"$in": bson.A{saleKind, models.CommissionAny}},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"startsAt": nil}, bson.M{"startsAt": bson.M{"$lte": now}}}},
+			bson.M{"$or": bson.A{bson.M{"endsAt": nil}, bson.M{"endsAt": bson.M{"$gte": now}}}},
+		},
+	})
+	if err != nil {
+		return models.CommissionRule{}, false, err
+	}
+	var rules []models.CommissionRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return models.CommissionRule{}, false, err
+	}
+	if len(rules) == 0 {
+		return models.CommissionRule{}, false, nil
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return commissionRuleSpecificity(rules[i]) > commissionRuleSpecificity(rules[j])
+	})
+	return rules[0], true, nil
+}
+
+// commissionSaleKind tells a first sale from a renewal by the commissions
+// already recorded on the entity itself, or on the branch for branch
+// subscriptions. Commissions from before entities were recorded on them are
+// filled in by BackfillCommissionEntities.
+func commissionSaleKind(ctx context.Context, database *mongo.Database, sale CommissionSale) (string, error) {
+	filter := bson.M{"entityType": sale.EntityType, "entityId": sale.EntityID, "branchId": nil}
+	if sale.BranchID != nil {
+		filter["branchId"] = *sale.BranchID
+	}
+	count, err := database.Collection("commissions").CountDocuments(ctx, filter)
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return models.CommissionSaleRenewal, nil
+	}
+	return models.CommissionSaleFirst, nil
+}
+
+// commissionSubscriptionSources are the collections the subscription of an
+// older commission may be in, with the field naming what was subscribed. For
+// branch subscriptions that is a branch of the entity.
+var commissionSubscriptionSources = []struct {
+	Collection string
+	EntityType string
+	Field      string
+	Branch     bool
+}{
+	{"company_subscriptions", "company", "companyId", false},
+	{"branch_subscriptions", "company", "branchId", true},
+	{"branch_subscription_requests", "company", "branchId", true},
+	{"subscription_requests", "company", "companyId", false},
+	{"subscription_requests", "serviceProvider", "serviceProviderId", false},
+	{"serviceProviders_subscriptions", "serviceProvider", "serviceProviderId", false},
+	{"wholesaler_subscriptions", "wholesaler", "wholesalerId", false},
+	{"wholesaler_subscription_requests", "wholesaler", "wholesalerId", false},
+	{"wholesaler_branch_subscriptions", "wholesaler", "branchId", true},
+	{"wholesaler_branch_subscription_requests", "wholesaler", "branchId", true},
+}
+
+// commissionEntityCollections are the collections of the entities commission is paid on
+var commissionEntityCollections = map[string]string{
+	"company":         "companies",
+	"wholesaler":      "wholesalers",
+	"serviceProvider": "serviceProviders",
+}
+
+// BackfillCommissionEntities records the entity, and branch if any, on
+// commissions from before they were, by looking up their subscription, so
+// that their renewals are told from first sales. It returns how many
+// commissions it updated; those whose subscription is gone are left as they are.
+func BackfillCommissionEntities(ctx context.Context, db *mongo.Client) (int, error) {
+	database := db.Database("barrim")
+	commissions := database.Collection("commissions")
+	cursor, err := commissions.Find(ctx, bson.M{"entityType": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var commission models.Commission
+		if err := cursor.Decode(&commission); err != nil {
+			return updated, err
+		}
+		set, err := commissionSubscriptionEntity(ctx, database, commission.SubscriptionID)
+		if err != nil {
+			return updated, err
+		}
+		if set == nil {
+			continue
+		}
+		if _, err := commissions.UpdateOne(ctx, bson.M{"_id": commission.ID}, bson.M{"$set": set}); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, cursor.Err()
+}
+
+// commissionSubscriptionEntity returns the entity fields of the commission on
+// a subscription, or nil when the subscription cannot be found
+func commissionSubscriptionEntity(ctx context.Context, database *mongo.Database, subscriptionID primitive.ObjectID) (bson.M, error) {
+	for _, source := range commissionSubscriptionSources {
+		var subscription bson.M
+		err := database.Collection(source.Collection).FindOne(ctx, bson.M{"_id": subscriptionID}).Decode(&subscription)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, ok := subscription[source.Field].(primitive.ObjectID)
+		if !ok || id.IsZero() {
+			continue
+		}
+		if !source.Branch {
+			return bson.M{"entityType": source.EntityType, "entityId": id}, nil
+		}
+
+		// Branches are embedded in their company or wholesaler; some company
+		// subscriptions were stored with the company in place of the branch
+		entities := database.Collection(commissionEntityCollections[source.EntityType])
+		var owner struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err = entities.FindOne(ctx, bson.M{"branches._id": id}).Decode(&owner)
+		if err == nil {
+			return bson.M{"entityType": source.EntityType, "entityId": owner.ID, "branchId": id}, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if err = entities.FindOne(ctx, bson.M{"_id": id}).Decode(&owner); err == nil {
+			return bson.M{"entityType": source.EntityType, "entityId": owner.ID}, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// commissionSalesManager returns the sales manager of a salesperson, if any
+func commissionSalesManager(ctx context.Context, database *mongo.Database, salesperson models.Salesperson) (*models.SalesManager, error) {
+	if salesperson.SalesManagerID.IsZero() {
+		return nil, nil
+	}
+	var manager models.SalesManager
+	err := database.Collection("sales_managers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&manager)
+	if err == mongo.ErrNoDocuments {
+		// Older sales managers were stored under another collection name
+		err = database.Collection("salesManagers").FindOne(ctx, bson.M{"_id": salesperson.SalesManagerID}).Decode(&manager)
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &manager, nil
+}
+
+// CalculateCommission splits a sale between the salesperson who created the
+// entity, their sales manager and the admin, by the commission rule matching
+// it or else by their own commission percents. It returns nil when the entity
+// was not created by a salesperson, so the admin keeps the whole price.
+func CalculateCommission(ctx context.Context, db *mongo.Client, sale CommissionSale) (*models.Commission, error) {
+	if sale.CreatedBy.IsZero() {
+		return nil, nil
+	}
+	database := db.Database("barrim")
+
+	var salesperson models.Salesperson
+	err := database.Collection("salespersons").FindOne(ctx, bson.M{"_id": sale.CreatedBy}).Decode(&salesperson)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find salesperson: %w", err)
+	}
+	manager, err := commissionSalesManager(ctx, database, salesperson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sales manager: %w", err)
+	}
+	saleKind, err := commissionSaleKind(ctx, database, sale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count earlier commissions: %w", err)
+	}
+
+	now := time.Now()
+	rule, ok, err := MatchCommissionRule(ctx, db, sale.Plan.Type, sale.EntityType, salesperson.CommissionTier, saleKind, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match commission rule: %w", err)
+	}
+
+	commission := &models.Commission{
+		ID:              primitive.NewObjectID(),
+		SubscriptionID:  sale.SubscriptionID,
+		PlanID:          sale.Plan.ID,
+		PlanPrice:       sale.Plan.Price,
+		BaseAmount:      sale.base(),
+		EntityType:      sale.EntityType,
+		EntityID:        sale.EntityID,
+		BranchID:        sale.BranchID,
+		PlanType:        sale.Plan.Type,
+		SaleKind:        saleKind,
+		SalespersonTier: salesperson.CommissionTier,
+		RuleName:        models.CommissionPersonalRates,
+		SalespersonID:   salesperson.ID,
+		CreatedAt:       now,
+	}
+	if sale.EntityType == "company" {
+		commission.CompanyID = sale.EntityID
+	}
+	if salesperson.SalesManagerID.IsZero() {
+		// Salespersons without a sales manager were created by an admin
+		commission.AdminID = salesperson.CreatedBy
+	}
+
+	salespersonPercent := salesperson.CommissionPercent
+	managerPercent := 0.0
+	if manager != nil {
+		managerPercent = manager.CommissionPercent
+	}
+	if ok {
+		commission.RuleID = &rule.ID
+		commission.RuleName = rule.Name
+		salespersonPercent = rule.SalespersonPercent
+		managerPercent = rule.ManagerOverridePercent
+	}
+	if manager != nil {
+		commission.AdminID = manager.CreatedBy
+		commission.SalesManagerID = manager.ID
+		commission.SalesManagerCommissionPercent = managerPercent
+		commission.SalesManagerCommission = sale.base() * managerPercent / 100.0
+	}
+	commission.SalespersonCommissionPercent = salespersonPercent
+	commission.SalespersonCommission = sale.base() * salespersonPercent / 100.0
+
+	// The admin keeps what the salesperson and sales manager do not get
+	commission.AdminCommissionPercent = 100.0 - salespersonPercent - commission.SalesManagerCommissionPercent
+	if commission.AdminCommissionPercent < 0 {
+		commission.AdminCommissionPercent = 0
+	}
+	commission.AdminCommission = sale.base() - commission.SalespersonCommission - commission.SalesManagerCommission
+	if commission.AdminCommission < 0 {
+		commission.AdminCommission = 0
+	}
+	return commission, nil
+}
+
+// ApplyCommission calculates the commission on a sale and records it: the
+// earners' commission balances, then the split in the commissions collection
+// and a commission record for each earner with the rule that set it. The
+// writes run in one transaction; without transactions the balances are
+// taken back when a later write fails, so a failed sale leaves no commission.
+// Crediting the admin's share to the admin wallet is left to the caller. It
+// returns nil when no salesperson earns commission on the sale.
+func ApplyCommission(ctx context.Context, db *mongo.Client, sale CommissionSale) (*models.Commission, error) {
+	commission, err := CalculateCommission(ctx, db, sale)
+	if err != nil || commission == nil {
+		return nil, err
+	}
+	database := db.Database("barrim")
+
+	record := func(role string, amount, percent float64) models.CommissionRecord {
+		r := models.CommissionRecord{
+			ID:             primitive.NewObjectID(),
+			CommissionID:   commission.ID,
+			SubscriptionID: commission.SubscriptionID,
+			EntityType:     commission.EntityType,
+			EntityID:       commission.EntityID,
+			PlanID:         commission.PlanID,
+			SaleKind:       commission.SaleKind,
+			BaseAmount:     commission.BaseAmount,
+			Percent:        percent,
+			RuleID:         commission.RuleID,
+			RuleName:       commission.RuleName,
+			Amount:         amount,
+			Role:           role,
+			Status:         "pending", // Will be marked as paid when processed
+			CreatedAt:      commission.CreatedAt,
+		}
+		if role == models.CommissionRoleSalesManager {
+			r.SalesManagerID = commission.SalesManagerID
+		} else {
+			r.SalespersonID = commission.SalespersonID
+		}
+		return r
+	}
+	records := []interface{}{
+		record(models.CommissionRoleSalesperson, commission.SalespersonCommission, commission.SalespersonCommissionPercent),
+	}
+	if !commission.SalesManagerID.IsZero() {
+		records = append(records, record(models.CommissionRoleSalesManager, commission.SalesManagerCommission, commission.SalesManagerCommissionPercent))
+	}
+
+	err = RunTransaction(ctx, db, func(ctx context.Context) error {
+		var undo []func()
+		fail := func(err error) error {
+			if mongo.SessionFromContext(ctx) == nil {
+				for i := len(undo) - 1; i >= 0; i-- {
+					undo[i]()
+				}
+			}
+			return err
+		}
+		credit := func(collection string, id primitive.ObjectID, amount float64) error {
+			_, err := database.Collection(collection).UpdateOne(ctx,
+				bson.M{"_id": id},
+				bson.M{
+					"$inc": bson.M{"commissionBalance": amount},
+					"$set": bson.M{"updatedAt": time.Now()},
+				},
+			)
+			if err == nil {
+				undo = append(undo, func() {
+					if _, err := database.Collection(collection).UpdateOne(context.Background(),
+						bson.M{"_id": id}, bson.M{"$inc": bson.M{"commissionBalance": -amount}}); err != nil {
+						log.Printf("Failed to take back commission of %s %s: %v", collection, id.Hex(), err)
+					}
+				})
+			}
+			return err
+		}
+
+		if err := credit("salespersons", commission.SalespersonID, commission.SalespersonCommission); err != nil {
+			return fail(fmt.Errorf("failed to update salesperson commission balance: %w", err))
+		}
+		if !commission.SalesManagerID.IsZero() {
+			if err := credit("sales_managers", commission.SalesManagerID, commission.SalesManagerCommission); err != nil {
+				return fail(fmt.Errorf("failed to update sales manager commission balance: %w", err))
+			}
+		}
+		if _, err := database.Collection("commissions").InsertOne(ctx, commission); err != nil {
+			return fail(fmt.Errorf("failed to insert commission: %w", err))
+		}
+		undo = append(undo, func() {
+			if _, err := database.Collection("commissions").DeleteOne(context.Background(), bson.M{"_id": commission.ID}); err != nil {
+				log.Printf("Failed to remove commission %s: %v", commission.ID.Hex(), err)
+			}
+		})
+		// Records inserted before a failure go with the commission
+		undo = append(undo, func() {
+			if _, err := database.Collection("commission_records").DeleteMany(context.Background(), bson.M{"commissionId": commission.ID}); err != nil {
+				log.Printf("Failed to remove records of commission %s: %v", commission.ID.Hex(), err)
+			}
+		})
+		if _, err := database.Collection("commission_records").InsertMany(ctx, records); err != nil {
+			return fail(fmt.Errorf("failed to insert commission records: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Commission on %s %s (%s sale, %s): paid $%.2f, salesperson $%.2f (%.1f%%), sales manager $%.2f (%.1f%%), admin $%.2f",
+		sale.EntityType, sale.EntityName, commission.SaleKind, commission.RuleName, commission.BaseAmount,
+		commission.SalespersonCommission, commission.SalespersonCommissionPercent,
+		commission.SalesManagerCommission, commission.SalesManagerCommissionPercent, commission.AdminCommission)
+	return commission, nil
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Default emergency dispatch policy, overridable with EMERGENCY_DISPATCH_FANOUT,
+// EMERGENCY_DISPATCH_TIMEOUT, EMERGENCY_DISPATCH_RADIUS_KM and EMERGENCY_DISPATCH_MAX_ROUNDS
+const (
+	DefaultEmergencyFanout    = 5
+	DefaultEmergencyTimeout   = 2 * time.Minute
+	DefaultEmergencyRadiusKm  = 10.0
+	DefaultEmergencyMaxRounds = 3
+)
+
+// EmergencyFanout returns how many providers are offered an emergency request per round
+func EmergencyFanout() int {
+	return envPositiveInt("EMERGENCY_DISPATCH_FANOUT", DefaultEmergencyFanout)
+}
+
+// EmergencyMaxRounds returns how many times an unanswered emergency request is offered before giving up
+func EmergencyMaxRounds() int {
+	return envPositiveInt("EMERGENCY_DISPATCH_MAX_ROUNDS", DefaultEmergencyMaxRounds)
+}
+
+// EmergencyTimeout returns how long providers have to answer an emergency request each round
+func EmergencyTimeout() time.Duration {
+	if v := os.Getenv("EMERGENCY_DISPATCH_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid EMERGENCY_DISPATCH_TIMEOUT %q, using default", v)
+	}
+	return DefaultEmergencyTimeout
+}
+
+// EmergencyRadiusKm returns the search radius for a dispatch round; it doubles on every escalation
+func EmergencyRadiusKm(round int) float64 {
+	radius := DefaultEmergencyRadiusKm
+	if v := os.Getenv("EMERGENCY_DISPATCH_RADIUS_KM"); v != "" {
+		if r, err := strconv.ParseFloat(v, 64); err == nil && r > 0 {
+			radius = r
+		} else {
+			log.Printf("Invalid EMERGENCY_DISPATCH_RADIUS_KM %q, using default", v)
+		}
+	}
+	if round > 1 {
+		radius *= math.Pow(2, float64(round-1))
+	}
+	return radius
+}
+
+func envPositiveInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using default", name, v)
+	}
+	return def
+}
+
+// FindEmergencyCandidates returns up to limit available providers of a service type
+// within radiusKm of a point, nearest first, skipping the excluded providers
+func FindEmergencyCandidates(db *mongo.Client, serviceType string, point models.GeoPoint, radiusKm float64, limit int, exclude []primitive.ObjectID) ([]models.EmergencyCandidate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"serviceProviderInfo.status":      "available",
+		"serviceProviderInfo.serviceType": bson.M{"$regex": "^" + regexp.QuoteMeta(serviceType) + "$", "$options": "i"},
+		"contactInfo.address.point":       GeoWithinRadius(point.Lat, point.Lng, radiusKm),
+	}
+	if len(exclude) > 0 {
+		filter["_id"] = bson.M{"$nin": exclude}
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "contactInfo.address": 1})
+	cursor, err := db.Database("barrim").Collection("serviceProviders").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var providers []models.ServiceProvider
+	if err := cursor.All(ctx, &providers); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]models.EmergencyCandidate, 0, len(providers))
+	for _, sp := range providers {
+		addr := sp.ContactInfo.Address
+		distance := HaversineKm(point.Lat, point.Lng, addr.Lat, addr.Lng)
+		if distance <= radiusKm {
+			candidates = append(candidates, models.EmergencyCandidate{ServiceProviderID: sp.ID, DistanceKm: distance})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].DistanceKm < candidates[j].DistanceKm })
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
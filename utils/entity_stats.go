@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// StatsDateLayout is the layout of the day key of entity daily stats
+const StatsDateLayout = "2006-01-02"
+
+// EntityEventDedupWindow is how long repeated views and taps by the same visitor count once
+const EntityEventDedupWindow = 30 * time.Minute
+
+// entityEventFields maps each event to the daily stats counter it increments
+var entityEventFields = map[string]string{
+	models.EntityEventImpression:  "impressions",
+	models.EntityEventProfileView: "profileViews",
+	models.EntityEventBranchView:  "branchViews",
+	models.EntityEventPhoneTap:    "phoneTaps",
+	models.EntityEventWhatsAppTap: "whatsappTaps",
+	models.EntityEventFavorite:    "favorites",
+	models.EntityEventBooking:     "bookings",
+}
+
+var (
+	entityEventSeenMu sync.Mutex
+	entityEventSeen   = map[string]time.Time{}
+)
+
+// StatsDay returns the daily stats key of a time
+func StatsDay(t time.Time) string {
+	return t.UTC().Format(StatsDateLayout)
+}
+
+// dailyStatsWrite returns an upsert adding inc to an entity's stats for the day of now
+func dailyStatsWrite(entityType string, entityID primitive.ObjectID, inc bson.M, now time.Time) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"entityType": entityType, "entityId": entityID, "date": StatsDay(now)}).
+		SetUpdate(bson.M{
+			"$inc":         inc,
+			"$set":         bson.M{"updatedAt": now},
+			"$setOnInsert": bson.M{"createdAt": now},
+		}).
+		SetUpsert(true)
+}
+
+// writeDailyStats applies daily stats upserts, logging rather than returning failures
+// since stats must never break the request that produced them
+func writeDailyStats(db *mongo.Client, writes []mongo.WriteModel) {
+	if len(writes) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.BulkWrite().SetOrdered(false)
+	if _, err := db.Database("barrim").Collection("entity_daily_stats").BulkWrite(ctx, writes, opts); err != nil {
+		log.Printf("Failed to record entity stats: %v", err)
+	}
+}
+
+// IsEntityEvent reports whether event is a known entity event
+func IsEntityEvent(event string) bool {
+	_, ok := entityEventFields[event]
+	return ok
+}
+
+// RecordEntityEvent counts one event for an entity in today's stats
+func RecordEntityEvent(db *mongo.Client, entityType string, entityID primitive.ObjectID, event string) {
+	field, ok := entityEventFields[event]
+	if !ok || entityID.IsZero() {
+		return
+	}
+	writeDailyStats(db, []mongo.WriteModel{dailyStatsWrite(entityType, entityID, bson.M{field: 1}, time.Now())})
+}
+
+// ShouldCountEntityEvent reports whether an event from a visitor should be
+// counted, ignoring repeats of the same event within EntityEventDedupWindow
+func ShouldCountEntityEvent(visitorKey, entityType string, entityID primitive.ObjectID, event string) bool {
+	key := visitorKey + "|" + entityType + "|" + entityID.Hex() + "|" + event
+	now := time.Now()
+
+	entityEventSeenMu.Lock()
+	defer entityEventSeenMu.Unlock()
+
+	if seen, ok := entityEventSeen[key]; ok && now.Sub(seen) < EntityEventDedupWindow {
+		return false
+	}
+	if len(entityEventSeen) > 50000 {
+		for k, seen := range entityEventSeen {
+			if now.Sub(seen) >= EntityEventDedupWindow {
+				delete(entityEventSeen, k)
+			}
+		}
+	}
+	entityEventSeen[key] = now
+	return true
+}
+
+// LoadEntityDailyStats returns an entity's daily stats between two days
+// (inclusive, StatsDateLayout), oldest first
+func LoadEntityDailyStats(ctx context.Context, db *mongo.Client, entityType string, entityID primitive.ObjectID, from, to string) ([]models.EntityDailyStats, error) {
+	filter := bson.M{
+		"entityType": entityType,
+		"entityId":   entityID,
+		"date":       bson.M{"$gte": from, "$lte": to},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+	cursor, err := db.Database("barrim").Collection("entity_daily_stats").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	days := []models.EntityDailyStats{}
+	if err := cursor.All(ctx, &days); err != nil {
+		return nil, err
+	}
+	return days, nil
+}
+
+// SumEntityStats adds up daily stats
+func SumEntityStats(days []models.EntityDailyStats) models.EntityStatsTotals {
+	var t models.EntityStatsTotals
+	for _, d := range days {
+		t.Impressions += d.Impressions
+		t.PinnedImpressions += d.PinnedImpressions
+		t.ProfileViews += d.ProfileViews
+		t.BranchViews += d.BranchViews
+		t.PhoneTaps += d.PhoneTaps
+		t.WhatsAppTaps += d.WhatsAppTaps
+		t.Favorites += d.Favorites
+		t.Bookings += d.Bookings
+	}
+	return t
+}
+
+// statsMetrics lists the compared metrics of totals by name
+func statsMetrics(t models.EntityStatsTotals) map[string]int64 {
+	return map[string]int64{
+		"impressions":  t.Impressions,
+		"profileViews": t.ProfileViews,
+		"branchViews":  t.BranchViews,
+		"phoneTaps":    t.PhoneTaps,
+		"whatsappTaps": t.WhatsAppTaps,
+		"favorites":    t.Favorites,
+		"bookings":     t.Bookings,
+	}
+}
+
+// CompareSponsoredPeriods compares every sponsorship an entity has had so far
+// with the period of the same length just before it, most recent first
+func CompareSponsoredPeriods(ctx context.Context, db *mongo.Client, entityType string, entityID primitive.ObjectID) ([]models.SponsorshipPeriodComparison, error) {
+	now := time.Now()
+	filter := bson.M{
+		"entityType": entityType,
+		"entityId":   entityID,
+		"startDate":  bson.M{"$lte": now},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "startDate", Value: -1}})
+	cursor, err := db.Database("barrim").Collection("sponsorship_subscriptions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []models.SponsorshipSubscription
+	err = cursor.All(ctx, &subscriptions)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons := make([]models.SponsorshipPeriodComparison, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		end := sub.EndDate
+		if end.IsZero() || end.After(now) {
+			end = now
+		}
+		startDay := sub.StartDate.UTC().Truncate(24 * time.Hour)
+		endDay := end.UTC().Truncate(24 * time.Hour)
+		days := int(endDay.Sub(startDay).Hours()/24) + 1
+		if days < 1 {
+			continue
+		}
+
+		sponsoredDays, err := LoadEntityDailyStats(ctx, db, entityType, entityID, StatsDay(startDay), StatsDay(endDay))
+		if err != nil {
+			return nil, err
+		}
+		beforeDays, err := LoadEntityDailyStats(ctx, db, entityType, entityID,
+			StatsDay(startDay.AddDate(0, 0, -days)), StatsDay(startDay.AddDate(0, 0, -1)))
+		if err != nil {
+			return nil, err
+		}
+
+		comparison := models.SponsorshipPeriodComparison{
+			SubscriptionID: sub.ID,
+			Status:         sub.Status,
+			StartDate:      sub.StartDate,
+			EndDate:        sub.EndDate,
+			Days:           days,
+			Before:         SumEntityStats(beforeDays),
+			Sponsored:      SumEntityStats(sponsoredDays),
+			Metrics:        map[string]models.MetricComparison{},
+		}
+		before, sponsored := statsMetrics(comparison.Before), statsMetrics(comparison.Sponsored)
+		for name, value := range sponsored {
+			metric := models.MetricComparison{
+				BeforeDailyAvg:    roundStat(float64(before[name]) / float64(days)),
+				SponsoredDailyAvg: roundStat(float64(value) / float64(days)),
+			}
+			if before[name] > 0 {
+				change := roundStat(float64(value-before[name]) / float64(before[name]) * 100)
+				metric.ChangePercent = &change
+			}
+			comparison.Metrics[name] = metric
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+// AggregateEntityStats sums the stats of every entity between two days
+// (inclusive), optionally of one entity type, most impressions first
+func AggregateEntityStats(ctx context.Context, db *mongo.Client, entityType, from, to string) ([]models.EntityStatsSummary, error) {
+	match := bson.M{"date": bson.M{"$gte": from, "$lte": to}}
+	if entityType != "" {
+		match["entityType"] = entityType
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               bson.M{"entityType": "$entityType", "entityId": "$entityId"},
+			"impressions":       bson.M{"$sum": "$impressions"},
+			"pinnedImpressions": bson.M{"$sum": "$pinnedImpressions"},
+			"profileViews":      bson.M{"$sum": "$profileViews"},
+			"branchViews":       bson.M{"$sum": "$branchViews"},
+			"phoneTaps":         bson.M{"$sum": "$phoneTaps"},
+			"whatsappTaps":      bson.M{"$sum": "$whatsappTaps"},
+			"favorites":         bson.M{"$sum": "$favorites"},
+			"bookings":          bson.M{"$sum": "$bookings"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"entityType": "$_id.entityType",
+			"entityId":   "$_id.entityId",
+			"totals": bson.M{
+				"impressions":       "$impressions",
+				"pinnedImpressions": "$pinnedImpressions",
+				"profileViews":      "$profileViews",
+				"branchViews":       "$branchViews",
+				"phoneTaps":         "$phoneTaps",
+				"whatsappTaps":      "$whatsappTaps",
+				"favorites":         "$favorites",
+				"bookings":          "$bookings",
+			},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totals.impressions", Value: -1}, {Key: "entityId", Value: 1}}}},
+	}
+
+	cursor, err := db.Database("barrim").Collection("entity_daily_stats").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	summaries := []models.EntityStatsSummary{}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// roundStat rounds a reported average or percentage to two decimals
+func roundStat(v float64) float64 {
+	return math.Round(v*100) / 100
+}
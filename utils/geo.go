@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+const earthRadiusKm = 6371.0
+
+// Geo search result types
+const (
+	GeoResultBranch          = "branch"
+	GeoResultServiceProvider = "serviceProvider"
+)
+
+// hiddenBranchStatuses are branch statuses left out of public geo searches
+var hiddenBranchStatuses = []string{"inactive", "rejected", "pending"}
+
+// GeoSearchParams describes a radius or bounding-box search. When BBox is set
+// (minLng, minLat, maxLng, maxLat) it takes precedence over the radius, and
+// distances are measured from Lat/Lng or, if unset, from the box centre.
+type GeoSearchParams struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	BBox     *[4]float64
+	Types    []string // GeoResultBranch and/or GeoResultServiceProvider; empty means both
+	Category string
+	Page     int
+	Limit    int
+}
+
+// HaversineKm returns the great-circle distance between two points in kilometres
+func HaversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// GeoWithinRadius returns a query matching GeoJSON points within radiusKm of a point
+func GeoWithinRadius(lat, lng, radiusKm float64) bson.M {
+	return bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{bson.A{lng, lat}, radiusKm / earthRadiusKm},
+	}}
+}
+
+// GeoWithinBox returns a query matching the coordinates of GeoJSON points
+// inside a bounding box. $box compares them as flat longitude and latitude
+// ranges, the same way a box is checked in Go, where a GeoJSON polygon would
+// follow great circles between its corners.
+func GeoWithinBox(box [4]float64) bson.M {
+	minLng, minLat, maxLng, maxLat := box[0], box[1], box[2], box[3]
+	return bson.M{"$geoWithin": bson.M{
+		"$box": bson.A{bson.A{minLng, minLat}, bson.A{maxLng, maxLat}},
+	}}
+}
+
+// geoPointExpr is an aggregation expression building the GeoJSON point of the
+// lat/lng fields under prefix (e.g. "$location"), or removing it when unset or invalid
+func geoPointExpr(prefix string) bson.M {
+	lat, lng := prefix+".lat", prefix+".lng"
+	return bson.M{"$cond": bson.A{
+		bson.M{"$and": bson.A{
+			bson.M{"$isNumber": lat},
+			bson.M{"$isNumber": lng},
+			bson.M{"$gte": bson.A{lat, -90}},
+			bson.M{"$lte": bson.A{lat, 90}},
+			bson.M{"$gte": bson.A{lng, -180}},
+			bson.M{"$lte": bson.A{lng, 180}},
+			bson.M{"$or": bson.A{bson.M{"$ne": bson.A{lat, 0}}, bson.M{"$ne": bson.A{lng, 0}}}},
+		}},
+		bson.M{"type": "Point", "coordinates": bson.A{lng, lat}},
+		"$$REMOVE",
+	}}
+}
+
+// SyncGeoPoint recomputes the GeoJSON point of an embedded address (e.g.
+// "contactInfo.address") from its stored lat/lng. Use it after updates that set
+// lat or lng field by field instead of writing a whole Address.
+func SyncGeoPoint(ctx context.Context, collection *mongo.Collection, filter interface{}, path string) error {
+	_, err := collection.UpdateMany(ctx, filter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{path + ".point": geoPointExpr("$" + path)}}},
+	})
+	return err
+}
+
+// syncBranchGeoPoints recomputes the GeoJSON point of every embedded branch
+func syncBranchGeoPoints(ctx context.Context, collection *mongo.Collection, filter interface{}) error {
+	_, err := collection.UpdateMany(ctx, filter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"branches": bson.M{"$map": bson.M{
+			"input": "$branches",
+			"as":    "b",
+			"in": bson.M{"$mergeObjects": bson.A{"$$b", bson.M{
+				"location": bson.M{"$mergeObjects": bson.A{
+					"$$b.location",
+					bson.M{"point": geoPointExpr("$$b.location")},
+				}},
+			}}},
+		}}}}},
+	})
+	return err
+}
+
+// BackfillGeoPoints adds GeoJSON points to users, service providers, companies and
+// branches saved before points were stored. It only touches documents with
+// coordinates but no point, so it is safe to run on every start.
+func BackfillGeoPoints(db *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	database := db.Database("barrim")
+	missing := func(path string) bson.M {
+		return bson.M{path + ".lat": bson.M{"$exists": true}, path + ".point": bson.M{"$exists": false}}
+	}
+
+	for _, target := range []struct {
+		collection string
+		path       string
+	}{
+		{"users", "location"},
+		{"serviceProviders", "contactInfo.address"},
+		{"companies", "contactInfo.address"},
+		{"wholesalers", "contactInfo.address"},
+	} {
+		if err := SyncGeoPoint(ctx, database.Collection(target.collection), missing(target.path), target.path); err != nil {
+			log.Printf("Failed to backfill geo points for %s.%s: %v", target.collection, target.path, err)
+		}
+	}
+
+	for _, collection := range []string{"companies", "wholesalers"} {
+		filter := bson.M{"branches": bson.M{"$elemMatch": missing("location")}}
+		if err := syncBranchGeoPoints(ctx, database.Collection(collection), filter); err != nil {
+			log.Printf("Failed to backfill branch geo points for %s: %v", collection, err)
+		}
+	}
+
+	log.Println("Geo point backfill complete")
+}
+
+// nearestFirst decodes into out, nearest to a point first, at most limit
+// documents of a collection matching filter, key being their GeoJSON point
+func nearestFirst(ctx context.Context, collection *mongo.Collection, key string, lat, lng float64, filter bson.M, limit int, projection bson.M, out interface{}) error {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          bson.M{"type": "Point", "coordinates": bson.A{lng, lat}},
+			"key":           key,
+			"distanceField": "geoDistance",
+			"spherical":     true,
+			"query":         filter,
+		}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: projection}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, out)
+}
+
+// countPipeline returns the count a pipeline ending in {$count: "total"} produces
+func countPipeline(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) (int, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var counts []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].Total, nil
+}
+
+// SearchNearby returns branches and service providers within a radius or
+// bounding box, nearest first, along with the total number of matches. Each
+// collection is read nearest first up to the end of the requested page, which
+// is all the merged page can draw from; the totals are counted in the database.
+func SearchNearby(db *mongo.Client, params GeoSearchParams) ([]models.GeoSearchResult, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	originLat, originLng := params.Lat, params.Lng
+	if params.BBox != nil && originLat == 0 && originLng == 0 {
+		originLng = (params.BBox[0] + params.BBox[2]) / 2
+		originLat = (params.BBox[1] + params.BBox[3]) / 2
+	}
+	fetch := params.Page * params.Limit
+
+	// A box is matched on the point's [lng, lat] pair, a radius on the point itself
+	geoFilter := func(point string) bson.M {
+		if params.BBox != nil {
+			return bson.M{point + ".coordinates": GeoWithinBox(*params.BBox)}
+		}
+		return bson.M{point: GeoWithinRadius(params.Lat, params.Lng, params.RadiusKm)}
+	}
+	inArea := func(lat, lng float64) (float64, bool) {
+		if models.NewGeoJSONPoint(lat, lng) == nil {
+			return 0, false
+		}
+		distance := HaversineKm(originLat, originLng, lat, lng)
+		if params.BBox != nil {
+			b := params.BBox
+			return distance, lng >= b[0] && lat >= b[1] && lng <= b[2] && lat <= b[3]
+		}
+		return distance, distance <= params.RadiusKm
+	}
+
+	wants := func(t string) bool {
+		return len(params.Types) == 0 || containsString(params.Types, t)
+	}
+
+	var results []models.GeoSearchResult
+	total := 0
+
+	if wants(GeoResultBranch) {
+		for _, owner := range []struct {
+			collection string
+			ownerType  string
+		}{{"companies", "company"}, {"wholesalers", "wholesaler"}} {
+			collection := db.Database("barrim").Collection(owner.collection)
+			filter := geoFilter("branches.location.point")
+			branchFilter := geoFilter("branches.location.point")
+			branchFilter["branches.status"] = bson.M{"$nin": hiddenBranchStatuses}
+			if params.Category != "" {
+				filter["$or"] = bson.A{bson.M{"category": params.Category}, bson.M{"branches.category": params.Category}}
+				branchFilter["$or"] = filter["$or"]
+			}
+
+			count, err := countPipeline(ctx, collection, mongo.Pipeline{
+				{{Key: "$match", Value: filter}},
+				{{Key: "$unwind", Value: "$branches"}},
+				{{Key: "$match", Value: branchFilter}},
+				{{Key: "$count", Value: "total"}},
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+			total += count
+
+			// Owners come nearest branch first, so the nearest branches are
+			// among the branches of as many owners as the page needs
+			var owners []models.Company // Wholesalers embed branches the same way companies do
+			err = nearestFirst(ctx, collection, "branches.location.point", originLat, originLng, filter, fetch,
+				bson.M{"businessName": 1, "category": 1, "branches": 1}, &owners)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			for _, o := range owners {
+				for _, branch := range o.Branches {
+					if containsString(hiddenBranchStatuses, branch.Status) {
+						continue
+					}
+					if params.Category != "" && branch.Category != params.Category && o.Category != params.Category {
+						continue
+					}
+					distance, ok := inArea(branch.Location.Lat, branch.Location.Lng)
+					if !ok {
+						continue
+					}
+					results = append(results, models.GeoSearchResult{
+						Type:        GeoResultBranch,
+						ID:          branch.ID.Hex(),
+						OwnerID:     o.ID.Hex(),
+						OwnerType:   owner.ownerType,
+						Name:        branch.Name,
+						Category:    branch.Category,
+						SubCategory: branch.SubCategory,
+						Location:    branch.Location,
+						DistanceKm:  distance,
+						Sponsored:   branch.Sponsorship,
+						Details:     branch,
+					})
+				}
+			}
+		}
+	}
+
+	if wants(GeoResultServiceProvider) {
+		collection := db.Database("barrim").Collection("serviceProviders")
+		filter := geoFilter("contactInfo.address.point")
+		if params.Category != "" {
+			filter["$or"] = bson.A{bson.M{"category": params.Category}, bson.M{"serviceProviderInfo.serviceType": params.Category}}
+		}
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		total += int(count)
+
+		var providers []models.ServiceProvider
+		err = nearestFirst(ctx, collection, "contactInfo.address.point", originLat, originLng, filter, fetch,
+			bson.M{"password": 0, "fcmToken": 0, "geoDistance": 0}, &providers)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, sp := range providers {
+			distance, ok := inArea(sp.ContactInfo.Address.Lat, sp.ContactInfo.Address.Lng)
+			if !ok {
+				continue
+			}
+			category := sp.Category
+			if category == "" && sp.ServiceProviderInfo != nil {
+				category = sp.ServiceProviderInfo.ServiceType
+			}
+			results = append(results, models.GeoSearchResult{
+				Type:       GeoResultServiceProvider,
+				ID:         sp.ID.Hex(),
+				Name:       sp.BusinessName,
+				Category:   category,
+				Location:   sp.ContactInfo.Address,
+				DistanceKm: distance,
+				Sponsored:  sp.Sponsorship,
+				Details:    sp,
+			})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].DistanceKm < results[j].DistanceKm })
+
+	start := (params.Page - 1) * params.Limit
+	if start >= len(results) {
+		return []models.GeoSearchResult{}, total, nil
+	}
+	end := start + params.Limit
+	if end > len(results) {
+		end = len(results)
+	}
+	return results[start:end], total, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,499 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// expiryLookaheadDays is how far ahead an account's status shows expiring
+// points when no reminder period is set
+const expiryLookaheadDays = 30
+
+// defaultLoyaltySettings apply until an admin saves settings: points never
+// expire and tiers follow the points earned over the last year
+func defaultLoyaltySettings() models.LoyaltySettings {
+	return models.LoyaltySettings{
+		ID:                 models.LoyaltySettingsID,
+		ExpiryReminderDays: 30,
+		TierBasis:          models.LoyaltyBasisPoints,
+		TierWindowDays:     365,
+		Tiers: []models.LoyaltyTierThreshold{
+			{Tier: models.LoyaltyTierSilver, Threshold: 1000},
+			{Tier: models.LoyaltyTierGold, Threshold: 5000},
+		},
+	}
+}
+
+// GetLoyaltySettings returns the loyalty settings, or the defaults when none were saved
+func GetLoyaltySettings(ctx context.Context, db *mongo.Client) (models.LoyaltySettings, error) {
+	var settings models.LoyaltySettings
+	err := db.Database("barrim").Collection("loyalty_settings").FindOne(ctx,
+		bson.M{"_id": models.LoyaltySettingsID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return defaultLoyaltySettings(), nil
+	}
+	return settings, err
+}
+
+// ValidateLoyaltySettings checks loyalty settings sent by an admin and sorts
+// their tiers from lowest to highest
+func ValidateLoyaltySettings(settings *models.LoyaltySettings) error {
+	switch {
+	case settings.PointsExpiryMonths < 0 || settings.PointsExpiryMonths > 120:
+		return errors.New("pointsExpiryMonths must be between 0 and 120")
+	case settings.ExpiryReminderDays < 0 || settings.ExpiryReminderDays > 365:
+		return errors.New("expiryReminderDays must be between 0 and 365")
+	case settings.TierBasis != models.LoyaltyBasisPoints && settings.TierBasis != models.LoyaltyBasisBookings:
+		return errors.New("tierBasis must be 'points' or 'bookings'")
+	case settings.TierWindowDays < 1 || settings.TierWindowDays > 1095:
+		return errors.New("tierWindowDays must be between 1 and 1095")
+	}
+
+	seen := map[string]bool{}
+	for _, t := range settings.Tiers {
+		switch {
+		case models.LoyaltyTierRank(t.Tier) < 1:
+			return errors.New("tiers can only set thresholds for 'silver' and 'gold'")
+		case seen[t.Tier]:
+			return fmt.Errorf("tier %s is listed twice", t.Tier)
+		case t.Threshold <= 0:
+			return errors.New("tier thresholds must be positive")
+		}
+		seen[t.Tier] = true
+	}
+	sort.Slice(settings.Tiers, func(i, j int) bool {
+		return models.LoyaltyTierRank(settings.Tiers[i].Tier) < models.LoyaltyTierRank(settings.Tiers[j].Tier)
+	})
+	for i := 1; i < len(settings.Tiers); i++ {
+		if settings.Tiers[i].Threshold <= settings.Tiers[i-1].Threshold {
+			return errors.New("a higher tier needs a higher threshold")
+		}
+	}
+	return nil
+}
+
+// SaveLoyaltySettings replaces the loyalty settings
+func SaveLoyaltySettings(ctx context.Context, db *mongo.Client, settings models.LoyaltySettings) (models.LoyaltySettings, error) {
+	settings.ID = models.LoyaltySettingsID
+	settings.UpdatedAt = time.Now()
+	_, err := db.Database("barrim").Collection("loyalty_settings").ReplaceOne(ctx,
+		bson.M{"_id": models.LoyaltySettingsID}, settings, options.Replace().SetUpsert(true))
+	return settings, err
+}
+
+// loyaltyTierFor returns the highest tier a score reaches
+func loyaltyTierFor(settings models.LoyaltySettings, score int) string {
+	tier := models.LoyaltyTierBronze
+	for _, t := range settings.Tiers {
+		if score >= t.Threshold {
+			tier = t.Tier
+		}
+	}
+	return tier
+}
+
+// loyaltyTiersUpTo lists the tiers at or below a tier, for matching vouchers an account can buy
+func loyaltyTiersUpTo(tier string) bson.A {
+	rank := models.LoyaltyTierRank(tier)
+	if rank < 0 {
+		rank = 0
+	}
+	tiers := bson.A{}
+	for _, t := range models.LoyaltyTiers[:rank+1] {
+		tiers = append(tiers, t)
+	}
+	return tiers
+}
+
+// LoyaltyTier returns the loyalty tier of a points account, bronze until one is computed
+func LoyaltyTier(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID) (string, error) {
+	collectionName, ok := PointsCollection(accountType)
+	if !ok {
+		return "", ErrPointsAccountNotFound
+	}
+	var account struct {
+		LoyaltyTier string `bson:"loyaltyTier"`
+	}
+	err := db.Database("barrim").Collection(collectionName).FindOne(ctx, bson.M{"_id": accountID},
+		options.FindOne().SetProjection(bson.M{"loyaltyTier": 1})).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrPointsAccountNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if models.LoyaltyTierRank(account.LoyaltyTier) < 0 {
+		return models.LoyaltyTierBronze, nil
+	}
+	return account.LoyaltyTier, nil
+}
+
+// loyaltyScore is the tier score of one points account
+type loyaltyScore struct {
+	AccountType string             `bson:"accountType"`
+	AccountID   primitive.ObjectID `bson:"accountId"`
+	Score       int                `bson:"score"`
+}
+
+// loyaltyScores counts the points earned, or the bookings completed as a
+// customer, over the tier window by every account with any, or by one
+// account when match narrows them. Only users book, so on the bookings
+// basis businesses stay bronze.
+func loyaltyScores(ctx context.Context, db *mongo.Client, settings models.LoyaltySettings, now time.Time, match bson.M) ([]loyaltyScore, error) {
+	since := now.AddDate(0, 0, -settings.TierWindowDays)
+	database := db.Database("barrim")
+
+	var cursor *mongo.Cursor
+	var err error
+	if settings.TierBasis == models.LoyaltyBasisBookings {
+		filter := bson.M{"status": models.BookingStatusCompleted, "bookingDate": bson.M{"$gte": since, "$lte": now}}
+		if match != nil {
+			if match["accountType"] != models.PointsAccountUser {
+				return []loyaltyScore{}, nil
+			}
+			filter["userId"] = match["accountId"]
+		}
+		cursor, err = database.Collection("bookings").Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$group", Value: bson.M{"_id": "$userId", "score": bson.M{"$sum": 1}}}},
+			{{Key: "$project", Value: bson.M{
+				"_id":         0,
+				"accountType": bson.M{"$literal": models.PointsAccountUser},
+				"accountId":   "$_id",
+				"score":       1,
+			}}},
+		})
+	} else {
+		filter := bson.M{"type": models.PointsEarn, "createdAt": bson.M{"$gte": since}}
+		for field, value := range match {
+			filter[field] = value
+		}
+		cursor, err = database.Collection("points_ledger").Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"accountType": "$accountType", "accountId": "$accountId"},
+				"score": bson.M{"$sum": "$points"},
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"_id":         0,
+				"accountType": "$_id.accountType",
+				"accountId":   "$_id.accountId",
+				"score":       1,
+			}}},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scores := []loyaltyScore{}
+	if err := cursor.All(ctx, &scores); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// RefreshLoyaltyTiers recomputes the tier of every points account and tells
+// the accounts that moved up. It returns how many tiers changed.
+func RefreshLoyaltyTiers(ctx context.Context, db *mongo.Client) (int, error) {
+	settings, err := GetLoyaltySettings(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	scores, err := loyaltyScores(ctx, db, settings, now, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	ranked := map[string][]primitive.ObjectID{}
+	changed := 0
+	for _, s := range scores {
+		tier := loyaltyTierFor(settings, s.Score)
+		if tier == models.LoyaltyTierBronze {
+			continue
+		}
+		collectionName, ok := PointsCollection(s.AccountType)
+		if !ok {
+			continue
+		}
+		ranked[s.AccountType] = append(ranked[s.AccountType], s.AccountID)
+
+		var before struct {
+			LoyaltyTier string `bson:"loyaltyTier"`
+		}
+		err := db.Database("barrim").Collection(collectionName).FindOneAndUpdate(ctx,
+			bson.M{"_id": s.AccountID, "loyaltyTier": bson.M{"$ne": tier}},
+			bson.M{"$set": bson.M{"loyaltyTier": tier, "loyaltyTierUpdatedAt": now}},
+			options.FindOneAndUpdate().SetProjection(bson.M{"loyaltyTier": 1})).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed++
+		if models.LoyaltyTierRank(tier) > models.LoyaltyTierRank(before.LoyaltyTier) {
+			notifyLoyaltyAccount(ctx, db, s.AccountType, s.AccountID, "New Loyalty Tier",
+				fmt.Sprintf("Congratulations, you reached the %s tier", tier),
+				"loyalty_tier", map[string]interface{}{"type": "loyalty_tier", "tier": tier})
+		}
+	}
+
+	// Accounts that no longer reach silver fall back to bronze
+	for accountType, collectionName := range pointsCollections {
+		result, err := db.Database("barrim").Collection(collectionName).UpdateMany(ctx,
+			bson.M{
+				"_id":         bson.M{"$nin": ranked[accountType]},
+				"loyaltyTier": bson.M{"$in": bson.A{models.LoyaltyTierSilver, models.LoyaltyTierGold}},
+			},
+			bson.M{"$set": bson.M{"loyaltyTier": models.LoyaltyTierBronze, "loyaltyTierUpdatedAt": now}})
+		if err != nil {
+			return changed, err
+		}
+		changed += int(result.ModifiedCount)
+	}
+	return changed, nil
+}
+
+// notifyLoyaltyAccount notifies the user behind a points account
+func notifyLoyaltyAccount(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID, title, message, notifType string, data map[string]interface{}) {
+	user, ok := referralAccountUser(ctx, db, accountType, accountID)
+	if !ok {
+		return
+	}
+	if err := SendFCMNotificationToUser(db, user.ID, title, message, data); err != nil {
+		log.Printf("Failed to send FCM %s notification: %v", notifType, err)
+	}
+	if err := SaveNotification(db, user.ID, title, message, notifType, data); err != nil {
+		log.Printf("Failed to save %s notification: %v", notifType, err)
+	}
+}
+
+// expiringPoints is how many points of an account are due to expire
+type expiringPoints struct {
+	AccountType string             `bson:"accountType"`
+	AccountID   primitive.ObjectID `bson:"accountId"`
+	Due         int                `bson:"due"`
+}
+
+// pointsExpiringBefore finds the points earned before a cutoff that are still
+// unspent, taking every debit from the oldest points first: what the
+// accounts earned before the cutoff less all they ever spent, expired or
+// had deducted. match narrows the accounts looked at.
+func pointsExpiringBefore(ctx context.Context, db *mongo.Client, cutoff time.Time, match bson.M) ([]expiringPoints, error) {
+	pipeline := mongo.Pipeline{}
+	if match != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"accountType": "$accountType", "accountId": "$accountId"},
+			"earned": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$and": bson.A{bson.M{"$gt": bson.A{"$points", 0}}, bson.M{"$lt": bson.A{"$createdAt", cutoff}}}},
+				"$points", 0,
+			}}},
+			"debited": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$lt": bson.A{"$points", 0}}, bson.M{"$abs": "$points"}, 0,
+			}}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"accountType": "$_id.accountType",
+			"accountId":   "$_id.accountId",
+			"due":         bson.M{"$subtract": bson.A{"$earned", "$debited"}},
+		}}},
+		bson.D{{Key: "$match", Value: bson.M{"due": bson.M{"$gt": 0}}}},
+	)
+
+	cursor, err := db.Database("barrim").Collection("points_ledger").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	due := []expiringPoints{}
+	if err := cursor.All(ctx, &due); err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// ExpirePoints expires the points earned longer ago than the expiry period
+// that are still unspent, oldest first. It returns how many accounts lost points.
+func ExpirePoints(ctx context.Context, db *mongo.Client) (int, error) {
+	settings, err := GetLoyaltySettings(ctx, db)
+	if err != nil || settings.PointsExpiryMonths == 0 {
+		return 0, err
+	}
+	cutoff := time.Now().AddDate(0, -settings.PointsExpiryMonths, 0)
+	due, err := pointsExpiringBefore(ctx, db, cutoff, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, d := range due {
+		balance, err := PointsBalance(ctx, db, d.AccountType, d.AccountID)
+		if err != nil {
+			continue
+		}
+		if d.Due > balance {
+			d.Due = balance
+		}
+		if d.Due <= 0 {
+			continue
+		}
+		_, err = ApplyPoints(ctx, db, PointsChange{
+			AccountType: d.AccountType,
+			AccountID:   d.AccountID,
+			Type:        models.PointsExpire,
+			Points:      -d.Due,
+			SourceType:  models.PointsSourceExpiry,
+			Reason:      fmt.Sprintf("Points earned before %s expired", cutoff.Format(StatsDateLayout)),
+		})
+		if err == ErrInsufficientPoints || err == ErrPointsAccountNotFound {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// NotifyExpiringPoints tells the accounts whose points expire within the
+// reminder period, once per period. It returns how many it told.
+func NotifyExpiringPoints(ctx context.Context, db *mongo.Client) (int, error) {
+	settings, err := GetLoyaltySettings(ctx, db)
+	if err != nil || settings.PointsExpiryMonths == 0 || settings.ExpiryReminderDays == 0 {
+		return 0, err
+	}
+	now := time.Now()
+	period := time.Duration(settings.ExpiryReminderDays) * 24 * time.Hour
+	due, err := pointsExpiringBefore(ctx, db, now.AddDate(0, -settings.PointsExpiryMonths, 0).Add(period), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	expiringBy := now.Add(period)
+	notified := 0
+	for _, d := range due {
+		collectionName, ok := PointsCollection(d.AccountType)
+		if !ok {
+			continue
+		}
+		// Claim the reminder first so that it goes out once per period
+		result, err := db.Database("barrim").Collection(collectionName).UpdateOne(ctx,
+			bson.M{"_id": d.AccountID, "points": bson.M{"$gt": 0}, "$or": bson.A{
+				bson.M{"pointsExpiryNotifiedAt": nil},
+				bson.M{"pointsExpiryNotifiedAt": bson.M{"$lt": now.Add(-period)}},
+			}},
+			bson.M{"$set": bson.M{"pointsExpiryNotifiedAt": now}})
+		if err != nil {
+			return notified, err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		notifyLoyaltyAccount(ctx, db, d.AccountType, d.AccountID, "Points Expiring Soon",
+			fmt.Sprintf("%d of your points expire by %s. Use them before they're gone!", d.Due, expiringBy.Format(StatsDateLayout)),
+			"points_expiring", map[string]interface{}{
+				"type":       "points_expiring",
+				"points":     fmt.Sprint(d.Due),
+				"expiringBy": expiringBy.Format(StatsDateLayout),
+			})
+		notified++
+	}
+	return notified, nil
+}
+
+// LoyaltyStatusFor returns a points account's tier, its progress to the next
+// tier and the points it is about to lose
+func LoyaltyStatusFor(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID) (models.LoyaltyStatus, error) {
+	collectionName, ok := PointsCollection(accountType)
+	if !ok {
+		return models.LoyaltyStatus{}, ErrPointsAccountNotFound
+	}
+	settings, err := GetLoyaltySettings(ctx, db)
+	if err != nil {
+		return models.LoyaltyStatus{}, err
+	}
+	var account struct {
+		Points               int        `bson:"points"`
+		LoyaltyTier          string     `bson:"loyaltyTier"`
+		LoyaltyTierUpdatedAt *time.Time `bson:"loyaltyTierUpdatedAt"`
+	}
+	err = db.Database("barrim").Collection(collectionName).FindOne(ctx, bson.M{"_id": accountID},
+		options.FindOne().SetProjection(bson.M{"points": 1, "loyaltyTier": 1, "loyaltyTierUpdatedAt": 1})).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return models.LoyaltyStatus{}, ErrPointsAccountNotFound
+	}
+	if err != nil {
+		return models.LoyaltyStatus{}, err
+	}
+
+	status := models.LoyaltyStatus{
+		Tier:            account.LoyaltyTier,
+		TierBasis:       settings.TierBasis,
+		TierWindowDays:  settings.TierWindowDays,
+		Points:          account.Points,
+		ExpiryMonths:    settings.PointsExpiryMonths,
+		TierRefreshedAt: account.LoyaltyTierUpdatedAt,
+	}
+	if models.LoyaltyTierRank(status.Tier) < 0 {
+		status.Tier = models.LoyaltyTierBronze
+	}
+
+	now := time.Now()
+	accountMatch := bson.M{"accountType": accountType, "accountId": accountID}
+	scores, err := loyaltyScores(ctx, db, settings, now, accountMatch)
+	if err != nil {
+		return models.LoyaltyStatus{}, err
+	}
+	if len(scores) > 0 {
+		status.Score = scores[0].Score
+	}
+	for _, t := range settings.Tiers {
+		if models.LoyaltyTierRank(t.Tier) > models.LoyaltyTierRank(status.Tier) {
+			status.NextTier = t.Tier
+			if t.Threshold > status.Score {
+				status.ToNextTier = t.Threshold - status.Score
+			}
+			break
+		}
+	}
+
+	if settings.PointsExpiryMonths > 0 {
+		days := settings.ExpiryReminderDays
+		if days == 0 {
+			days = expiryLookaheadDays
+		}
+		by := now.AddDate(0, 0, days)
+		due, err := pointsExpiringBefore(ctx, db, by.AddDate(0, -settings.PointsExpiryMonths, 0), accountMatch)
+		if err != nil {
+			return models.LoyaltyStatus{}, err
+		}
+		if len(due) > 0 {
+			status.ExpiringPoints = due[0].Due
+			if status.ExpiringPoints > status.Points {
+				status.ExpiringPoints = status.Points
+			}
+			status.ExpiringBy = &by
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Moderation defaults, overridable with MODERATION_REPORT_THRESHOLD,
+// MODERATION_FLOOD_LIMIT and MODERATION_FLOOD_WINDOW
+const (
+	DefaultModerationReportThreshold = 3 // Distinct reports that hold published content
+	DefaultModerationFloodLimit      = 5 // Posts by one author within the flood window
+	DefaultModerationFloodWindow     = 10 * time.Minute
+)
+
+// Reasons content is held for moderation
+const (
+	FlagBlockedWords       = "blocked_words"
+	FlagLinks              = "links"
+	FlagPhoneNumber        = "phone_number"
+	FlagRepeatedCharacters = "repeated_characters"
+	FlagShouting           = "shouting"
+	FlagDuplicate          = "duplicate"
+	FlagFlooding           = "flooding"
+	FlagReported           = "reported"
+)
+
+// ErrAlreadyReported is returned when a user reports the same content twice
+var ErrAlreadyReported = errors.New("content already reported by this user")
+
+var (
+	moderationLinkPattern  = regexp.MustCompile(`(?i)(https?://|www\.|\b[a-z0-9-]+\.(com|net|org|info|biz|io|me|lb|co)\b)`)
+	moderationPhonePattern = regexp.MustCompile(`\+?\d[\d\s-]{6,}\d`)
+)
+
+// ModerationCheck is the outcome of the automatic content filter
+type ModerationCheck struct {
+	Flagged bool
+	Reasons []string
+}
+
+// ModerationCollection returns the collection holding a kind of moderated content
+func ModerationCollection(contentType string) (string, bool) {
+	switch contentType {
+	case models.ModerationContentReview:
+		return "reviews", true
+	case models.ModerationContentBranchComment:
+		return "branch_comments", true
+	}
+	return "", false
+}
+
+// PublishedModeration matches the moderationStatus of content that may be shown publicly
+func PublishedModeration() bson.M {
+	return bson.M{"$nin": bson.A{models.ModerationPending, models.ModerationRejected}}
+}
+
+// ModerationReportThreshold returns how many reports hold published content
+func ModerationReportThreshold() int {
+	return envPositiveInt("MODERATION_REPORT_THRESHOLD", DefaultModerationReportThreshold)
+}
+
+// NormalizeModerationWord returns the stored form of a blocked word
+func NormalizeModerationWord(word string) string {
+	return strings.Join(moderationTokens(word), " ")
+}
+
+// moderationTokens splits text into normalized words. Spelling variants, the
+// Arabic article and English plurals are folded the same way as in search so
+// that a listed word matches its variants.
+func moderationTokens(text string) []string {
+	fields := strings.FieldsFunc(normalizeSearchText(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, f := range fields {
+		fields[i] = stemSearchToken(f)
+	}
+	return fields
+}
+
+// loadBlockedWords returns the admin-managed word list and the words listed in
+// MODERATION_BLOCKED_WORDS
+func loadBlockedWords(ctx context.Context, db *mongo.Client) ([]string, error) {
+	var words []string
+	for _, w := range strings.Split(os.Getenv("MODERATION_BLOCKED_WORDS"), ",") {
+		if w = NormalizeModerationWord(w); w != "" {
+			words = append(words, w)
+		}
+	}
+
+	cursor, err := db.Database("barrim").Collection("moderation_words").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.ModerationWord
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		words = append(words, e.Word)
+	}
+	return words, nil
+}
+
+// containsBlockedWord reports whether text contains any of the words, matched
+// on whole words so that a listed word inside a longer one is not flagged
+func containsBlockedWord(text string, words []string) bool {
+	padded := " " + strings.Join(moderationTokens(text), " ") + " "
+	for _, w := range words {
+		if w != "" && strings.Contains(padded, " "+w+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPhoneNumber reports whether text holds a run of at least 8 digits,
+// allowing spaces and dashes in between
+func containsPhoneNumber(text string) bool {
+	for _, match := range moderationPhonePattern.FindAllString(normalizeSearchText(text), -1) {
+		digits := 0
+		for _, r := range match {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		if digits >= 8 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRepeatedRun reports whether text repeats one character at least n times in a row
+func hasRepeatedRun(text string, n int) bool {
+	var last rune
+	run := 0
+	for _, r := range text {
+		if r == last && !unicode.IsSpace(r) {
+			run++
+			if run >= n {
+				return true
+			}
+			continue
+		}
+		last, run = r, 1
+	}
+	return false
+}
+
+// isShouting reports whether a reasonably long Latin text is mostly capitals
+func isShouting(text string) bool {
+	var letters, upper int
+	for _, r := range text {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	return letters >= 20 && float64(upper) >= 0.7*float64(letters)
+}
+
+// CheckContent runs the automatic filter on a review or comment before it is
+// published: blocked words, links, phone numbers, repeated characters, shouting,
+// and the author repeating the same text or posting in bursts
+func CheckContent(ctx context.Context, db *mongo.Client, contentType string, authorID primitive.ObjectID, text string) (ModerationCheck, error) {
+	var check ModerationCheck
+	flag := func(reason string) {
+		check.Flagged = true
+		check.Reasons = append(check.Reasons, reason)
+	}
+
+	words, err := loadBlockedWords(ctx, db)
+	if err != nil {
+		return check, err
+	}
+	if containsBlockedWord(text, words) {
+		flag(FlagBlockedWords)
+	}
+	if moderationLinkPattern.MatchString(text) {
+		flag(FlagLinks)
+	}
+	if containsPhoneNumber(text) {
+		flag(FlagPhoneNumber)
+	}
+	if hasRepeatedRun(text, 6) {
+		flag(FlagRepeatedCharacters)
+	}
+	if isShouting(text) {
+		flag(FlagShouting)
+	}
+
+	collectionName, ok := ModerationCollection(contentType)
+	if !ok {
+		return check, nil
+	}
+	collection := db.Database("barrim").Collection(collectionName)
+	if strings.TrimSpace(text) != "" {
+		duplicates, err := collection.CountDocuments(ctx, bson.M{
+			"userId":    authorID,
+			"comment":   text,
+			"createdAt": bson.M{"$gte": time.Now().Add(-24 * time.Hour)},
+		})
+		if err != nil {
+			return check, err
+		}
+		if duplicates > 0 {
+			flag(FlagDuplicate)
+		}
+	}
+	recent, err := collection.CountDocuments(ctx, bson.M{
+		"userId":    authorID,
+		"createdAt": bson.M{"$gte": time.Now().Add(-envDuration("MODERATION_FLOOD_WINDOW", DefaultModerationFloodWindow))},
+	})
+	if err != nil {
+		return check, err
+	}
+	if int(recent) >= envPositiveInt("MODERATION_FLOOD_LIMIT", DefaultModerationFloodLimit) {
+		flag(FlagFlooding)
+	}
+	return check, nil
+}
+
+// ReportContent records a user's report and holds the content for moderation
+// once it reaches the report threshold. Content an admin already approved stays
+// published; further reports only show up in the queue. It returns whether the
+// content was held by this report.
+func ReportContent(ctx context.Context, db *mongo.Client, contentType string, contentID, reporterID primitive.ObjectID, req models.ContentReportRequest) (bool, error) {
+	collectionName, ok := ModerationCollection(contentType)
+	if !ok {
+		return false, errors.New("unknown content type")
+	}
+
+	report := models.ContentReport{
+		ID:          primitive.NewObjectID(),
+		ContentType: contentType,
+		ContentID:   contentID,
+		ReporterID:  reporterID,
+		Reason:      strings.TrimSpace(req.Reason),
+		Details:     strings.TrimSpace(req.Details),
+		Status:      models.ContentReportOpen,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := db.Database("barrim").Collection("content_reports").InsertOne(ctx, report); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, ErrAlreadyReported
+		}
+		return false, err
+	}
+
+	collection := db.Database("barrim").Collection(collectionName)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": contentID}, bson.M{"$inc": bson.M{"reportCount": 1}}); err != nil {
+		return false, err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{
+			"_id":              contentID,
+			"moderationStatus": PublishedModeration(),
+			"moderatedBy":      bson.M{"$exists": false},
+			"reportCount":      bson.M{"$gte": ModerationReportThreshold()},
+		},
+		bson.M{
+			"$set":      bson.M{"moderationStatus": models.ModerationPending, "updatedAt": time.Now()},
+			"$addToSet": bson.M{"flagReasons": FlagReported},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// CloseContentReports resolves or dismisses the open reports of moderated content
+func CloseContentReports(ctx context.Context, db *mongo.Client, contentType string, contentID, adminID primitive.ObjectID, status string) error {
+	now := time.Now()
+	_, err := db.Database("barrim").Collection("content_reports").UpdateMany(ctx,
+		bson.M{"contentType": contentType, "contentId": contentID, "status": models.ContentReportOpen},
+		bson.M{"$set": bson.M{"status": status, "resolvedAt": now, "resolvedBy": adminID}},
+	)
+	return err
+}
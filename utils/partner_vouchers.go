@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// IssuedVouchers lists the vouchers a company or wholesaler funds, newest
+// first, with how many were sold and redeemed, where, and how many scans
+// were refused
+func IssuedVouchers(ctx context.Context, db *mongo.Client, issuerType string, issuerID primitive.ObjectID) ([]models.IssuedVoucher, error) {
+	database := db.Database("barrim")
+	cursor, err := database.Collection("vouchers").Find(ctx,
+		bson.M{"issuerType": issuerType, "issuerId": issuerID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var vouchers []models.Voucher
+	if err := cursor.All(ctx, &vouchers); err != nil {
+		return nil, err
+	}
+	if len(vouchers) == 0 {
+		return []models.IssuedVoucher{}, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(vouchers))
+	analytics := make(map[primitive.ObjectID]*models.VoucherAnalytics, len(vouchers))
+	for _, v := range vouchers {
+		ids = append(ids, v.ID)
+		analytics[v.ID] = &models.VoucherAnalytics{RedemptionsByBranch: map[string]int{}}
+	}
+
+	// Sales and redemptions per voucher and branch
+	cursor, err = voucherPurchases(db).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"voucherId": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"voucherId": "$voucherId", "isUsed": "$isUsed", "branchId": "$redeemedBranchId"},
+			"quantity": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sales []struct {
+		Key struct {
+			VoucherID primitive.ObjectID  `bson:"voucherId"`
+			IsUsed    bool                `bson:"isUsed"`
+			BranchID  *primitive.ObjectID `bson:"branchId"`
+		} `bson:"_id"`
+		Quantity int `bson:"quantity"`
+	}
+	if err := cursor.All(ctx, &sales); err != nil {
+		return nil, err
+	}
+	for _, s := range sales {
+		a := analytics[s.Key.VoucherID]
+		a.Sold += s.Quantity
+		if !s.Key.IsUsed {
+			continue
+		}
+		a.Redeemed += s.Quantity
+		if s.Key.BranchID != nil {
+			a.RedemptionsByBranch[s.Key.BranchID.Hex()] += s.Quantity
+		}
+	}
+
+	// Scans that did not redeem the voucher
+	cursor, err = database.Collection("voucher_redemption_attempts").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"voucherId": bson.M{"$in": ids}, "result": bson.M{"$ne": models.VoucherRedemptionRedeemed}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$voucherId", "quantity": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var refused []struct {
+		VoucherID primitive.ObjectID `bson:"_id"`
+		Quantity  int                `bson:"quantity"`
+	}
+	if err := cursor.All(ctx, &refused); err != nil {
+		return nil, err
+	}
+	for _, r := range refused {
+		analytics[r.VoucherID].RefusedAttempts = r.Quantity
+	}
+
+	issued := make([]models.IssuedVoucher, 0, len(vouchers))
+	for _, v := range vouchers {
+		a := analytics[v.ID]
+		if a.Sold > 0 {
+			a.RedemptionRate = float64(a.Redeemed) / float64(a.Sold)
+		}
+		issued = append(issued, models.IssuedVoucher{Voucher: v, Analytics: *a})
+	}
+	return issued, nil
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Reasons a points change is refused
+var (
+	ErrInsufficientPoints    = errors.New("insufficient points")
+	ErrPointsAccountNotFound = errors.New("points account not found")
+)
+
+// pointsCollections maps each points account type to the collection holding its balance
+var pointsCollections = map[string]string{
+	models.PointsAccountUser:            "users",
+	models.PointsAccountCompany:         "companies",
+	models.PointsAccountWholesaler:      "wholesalers",
+	models.PointsAccountServiceProvider: "serviceProviders",
+}
+
+// PointsCollection returns the collection holding the balance of a points account type
+func PointsCollection(accountType string) (string, bool) {
+	name, ok := pointsCollections[accountType]
+	return name, ok
+}
+
+// PointsChange is a balance change to apply and record in the points ledger
+type PointsChange struct {
+	AccountType string
+	AccountID   primitive.ObjectID
+	Type        string // "earn", "spend", "expire" or "adjust"
+	Points      int    // Signed; a debit never takes the balance below zero
+	SourceType  string
+	SourceID    *primitive.ObjectID
+	Reason      string
+	CreatedBy   *primitive.ObjectID
+	Update      bson.M // Further update operators applied to the account along with the change
+}
+
+// RunPointsTransaction runs fn in a Mongo transaction so that a balance change,
+// its ledger entry and any record written with them commit together. On a
+// standalone server fn runs without one; debits still cannot overspend since
+// they are conditional on the balance.
+func RunPointsTransaction(ctx context.Context, db *mongo.Client, fn func(ctx context.Context) error) error {
+	return RunTransaction(ctx, db, fn)
+}
+
+// foldLegacyProviderPoints moves points held in the embedded serviceProviderInfo
+// of a service provider document into its points balance
+func foldLegacyProviderPoints(ctx context.Context, collection *mongo.Collection, providerID primitive.ObjectID) error {
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": providerID, "serviceProviderInfo.points": bson.M{"$gt": 0}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"points":                     bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$points", 0}}, "$serviceProviderInfo.points"}},
+			"serviceProviderInfo.points": 0,
+		}}}})
+	return err
+}
+
+// ChangePoints applies a balance change and records it in the ledger. It must
+// run inside RunPointsTransaction; use ApplyPoints otherwise. The first entry
+// of an account holding points from before the ledger is preceded by an
+// opening balance entry so that its history adds up to the balance.
+func ChangePoints(ctx context.Context, db *mongo.Client, change PointsChange) (models.PointsLedgerEntry, error) {
+	collectionName, ok := PointsCollection(change.AccountType)
+	if !ok {
+		return models.PointsLedgerEntry{}, errors.New("unknown points account type")
+	}
+	if change.Points == 0 {
+		return models.PointsLedgerEntry{}, errors.New("points change cannot be zero")
+	}
+	collection := db.Database("barrim").Collection(collectionName)
+
+	if change.AccountType == models.PointsAccountServiceProvider {
+		if err := foldLegacyProviderPoints(ctx, collection, change.AccountID); err != nil {
+			return models.PointsLedgerEntry{}, err
+		}
+	}
+
+	filter := bson.M{"_id": change.AccountID}
+	if change.Points < 0 {
+		filter["points"] = bson.M{"$gte": -change.Points}
+	}
+	update := bson.M{}
+	for op, fields := range change.Update {
+		update[op] = fields
+	}
+	inc := bson.M{"points": change.Points}
+	if extra, ok := update["$inc"].(bson.M); ok {
+		for field, value := range extra {
+			inc[field] = value
+		}
+	}
+	update["$inc"] = inc
+
+	var account struct {
+		Points int `bson:"points"`
+	}
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"points": 1})).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		if change.Points < 0 {
+			exists, err := collection.CountDocuments(ctx, bson.M{"_id": change.AccountID})
+			if err != nil {
+				return models.PointsLedgerEntry{}, err
+			}
+			if exists > 0 {
+				return models.PointsLedgerEntry{}, ErrInsufficientPoints
+			}
+		}
+		return models.PointsLedgerEntry{}, ErrPointsAccountNotFound
+	}
+	if err != nil {
+		return models.PointsLedgerEntry{}, err
+	}
+
+	ledger := db.Database("barrim").Collection("points_ledger")
+	now := time.Now()
+	if before := account.Points - change.Points; before != 0 {
+		entries, err := ledger.CountDocuments(ctx, bson.M{"accountType": change.AccountType, "accountId": change.AccountID})
+		if err != nil {
+			return models.PointsLedgerEntry{}, err
+		}
+		if entries == 0 {
+			opening := models.PointsLedgerEntry{
+				ID:           primitive.NewObjectID(),
+				AccountType:  change.AccountType,
+				AccountID:    change.AccountID,
+				Type:         models.PointsAdjust,
+				Points:       before,
+				BalanceAfter: before,
+				SourceType:   models.PointsSourceOpeningBalance,
+				CreatedAt:    now.Add(-time.Millisecond),
+			}
+			if _, err := ledger.InsertOne(ctx, opening); err != nil {
+				return models.PointsLedgerEntry{}, err
+			}
+		}
+	}
+
+	entry := models.PointsLedgerEntry{
+		ID:           primitive.NewObjectID(),
+		AccountType:  change.AccountType,
+		AccountID:    change.AccountID,
+		Type:         change.Type,
+		Points:       change.Points,
+		BalanceAfter: account.Points,
+		SourceType:   change.SourceType,
+		SourceID:     change.SourceID,
+		Reason:       change.Reason,
+		CreatedBy:    change.CreatedBy,
+		CreatedAt:    now,
+	}
+	if _, err := ledger.InsertOne(ctx, entry); err != nil {
+		return models.PointsLedgerEntry{}, err
+	}
+	return entry, nil
+}
+
+// ApplyPoints applies a balance change and records it in the ledger in one transaction
+func ApplyPoints(ctx context.Context, db *mongo.Client, change PointsChange) (models.PointsLedgerEntry, error) {
+	var entry models.PointsLedgerEntry
+	err := RunPointsTransaction(ctx, db, func(ctx context.Context) error {
+		var err error
+		entry, err = ChangePoints(ctx, db, change)
+		return err
+	})
+	return entry, err
+}
+
+// PointsAccountForUser returns the points account of a logged-in user: the
+// user itself, or the company, wholesaler or service provider it manages
+func PointsAccountForUser(ctx context.Context, db *mongo.Client, userType string, userID primitive.ObjectID) (string, primitive.ObjectID, error) {
+	database := db.Database("barrim")
+	var account struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	projection := options.FindOne().SetProjection(bson.M{"_id": 1})
+
+	var err error
+	switch userType {
+	case models.PointsAccountUser:
+		return models.PointsAccountUser, userID, nil
+	case models.PointsAccountCompany:
+		err = database.Collection("companies").FindOne(ctx, bson.M{"userId": userID}, projection).Decode(&account)
+	case models.PointsAccountWholesaler:
+		err = database.Collection("wholesalers").FindOne(ctx,
+			bson.M{"$or": bson.A{bson.M{"userId": userID}, bson.M{"createdBy": userID}}}, projection).Decode(&account)
+	case models.PointsAccountServiceProvider:
+		var user models.User
+		if err := database.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err == nil && user.ServiceProviderID != nil {
+			return models.PointsAccountServiceProvider, *user.ServiceProviderID, nil
+		}
+		err = database.Collection("serviceProviders").FindOne(ctx, bson.M{"userId": userID}, projection).Decode(&account)
+	default:
+		return "", primitive.NilObjectID, ErrPointsAccountNotFound
+	}
+	if err == mongo.ErrNoDocuments {
+		return "", primitive.NilObjectID, ErrPointsAccountNotFound
+	}
+	if err != nil {
+		return "", primitive.NilObjectID, err
+	}
+	return userType, account.ID, nil
+}
+
+// PointsBalance returns the current balance of a points account
+func PointsBalance(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID) (int, error) {
+	collectionName, ok := PointsCollection(accountType)
+	if !ok {
+		return 0, ErrPointsAccountNotFound
+	}
+	var account struct {
+		Points int `bson:"points"`
+	}
+	err := db.Database("barrim").Collection(collectionName).FindOne(ctx, bson.M{"_id": accountID},
+		options.FindOne().SetProjection(bson.M{"points": 1})).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return 0, ErrPointsAccountNotFound
+	}
+	return account.Points, err
+}
+
+// HasPointsEntry reports whether an account was ever credited or debited for a kind of source
+func HasPointsEntry(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID, sourceType string) (bool, error) {
+	n, err := db.Database("barrim").Collection("points_ledger").CountDocuments(ctx, bson.M{
+		"accountType": accountType,
+		"accountId":   accountID,
+		"sourceType":  sourceType,
+	})
+	return n > 0, err
+}
+
+// PointsHistory returns a page of an account's ledger entries, newest first, and their total
+func PointsHistory(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID, page, limit int) ([]models.PointsLedgerEntry, int64, error) {
+	ledger := db.Database("barrim").Collection("points_ledger")
+	filter := bson.M{"accountType": accountType, "accountId": accountID}
+	total, err := ledger.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := ledger.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := []models.PointsLedgerEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
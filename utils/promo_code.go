@@ -0,0 +1,405 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// DefaultPromoCodeHold is how long a promo code stays applied to an unpaid
+// checkout, overridable with PROMO_CODE_HOLD
+const DefaultPromoCodeHold = 24 * time.Hour
+
+// PromoCodeError is a promo code a checkout cannot use, with a message for the client
+type PromoCodeError struct {
+	Message string
+}
+
+func (e *PromoCodeError) Error() string {
+	return e.Message
+}
+
+// PromoCheckout describes the checkout a promo code is applied to
+type PromoCheckout struct {
+	Code       string
+	Checkout   string // One of the models.PromoCheckout kinds
+	PlanType   string // Subscription plan type, or models.PromoPlanSponsorship
+	EntityType string
+	EntityID   primitive.ObjectID
+	UserID     primitive.ObjectID
+	RequestID  primitive.ObjectID
+	ExternalID int64
+	Amount     float64
+}
+
+// PromoCodeHold returns how long a promo code stays applied to an unpaid checkout
+func PromoCodeHold() time.Duration {
+	return envDuration("PROMO_CODE_HOLD", DefaultPromoCodeHold)
+}
+
+// NormalizePromoCode returns the stored form of a promo code
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// roundMoney rounds an amount to cents
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// PromoDiscount returns how much a promo code takes off an amount
+func PromoDiscount(promo models.PromoCode, amount float64) float64 {
+	var discount float64
+	switch promo.DiscountType {
+	case models.PromoDiscountPercentage:
+		discount = amount * promo.Value / 100
+		if promo.MaxDiscount > 0 && discount > promo.MaxDiscount {
+			discount = promo.MaxDiscount
+		}
+	case models.PromoDiscountFixed:
+		discount = promo.Value
+	}
+	if discount > amount {
+		discount = amount
+	}
+	return roundMoney(discount)
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPromoCode returns why a promo code cannot be used for a checkout, if it cannot
+func checkPromoCode(promo models.PromoCode, checkout PromoCheckout, now time.Time) error {
+	if !promo.IsActive {
+		return &PromoCodeError{Message: "This promo code is no longer active"}
+	}
+	if promo.ValidFrom != nil && now.Before(*promo.ValidFrom) {
+		return &PromoCodeError{Message: "This promo code is not valid yet"}
+	}
+	if promo.ValidUntil != nil && now.After(*promo.ValidUntil) {
+		return &PromoCodeError{Message: "This promo code has expired"}
+	}
+	if len(promo.PlanTypes) > 0 && !containsFold(promo.PlanTypes, checkout.PlanType) {
+		return &PromoCodeError{Message: "This promo code does not apply to this plan"}
+	}
+	if len(promo.EntityTypes) > 0 && !containsFold(promo.EntityTypes, checkout.EntityType) {
+		return &PromoCodeError{Message: "This promo code does not apply to this account type"}
+	}
+	if promo.MaxRedemptions > 0 && promo.RedemptionCount >= promo.MaxRedemptions {
+		return &PromoCodeError{Message: "This promo code has been fully used"}
+	}
+	if checkout.Amount-PromoDiscount(promo, checkout.Amount) <= 0 {
+		// Payments cannot be collected for nothing
+		return &PromoCodeError{Message: "This promo code cannot cover the full price"}
+	}
+	return nil
+}
+
+// findPromoCode loads a promo code by its code
+func findPromoCode(ctx context.Context, db *mongo.Client, code string) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := db.Database("barrim").Collection("promo_codes").FindOne(ctx, bson.M{"code": NormalizePromoCode(code)}).Decode(&promo)
+	if err == mongo.ErrNoDocuments {
+		return nil, &PromoCodeError{Message: "Promo code not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// checkPromoAccountLimit refuses a promo code the account has used as often as it may
+func checkPromoAccountLimit(ctx context.Context, db *mongo.Client, promo models.PromoCode, userID primitive.ObjectID) error {
+	if promo.PerAccountLimit <= 0 {
+		return nil
+	}
+	used, err := db.Database("barrim").Collection("promo_code_redemptions").CountDocuments(ctx, bson.M{
+		"promoCodeId": promo.ID,
+		"userId":      userID,
+		"status":      bson.M{"$in": bson.A{models.PromoRedemptionPending, models.PromoRedemptionRedeemed}},
+	})
+	if err != nil {
+		return err
+	}
+	if int(used) >= promo.PerAccountLimit {
+		return &PromoCodeError{Message: "You have already used this promo code"}
+	}
+	return nil
+}
+
+// promoRedemption builds the redemption of a promo code for a checkout
+func promoRedemption(promo models.PromoCode, checkout PromoCheckout, now time.Time) models.PromoCodeRedemption {
+	discount := PromoDiscount(promo, checkout.Amount)
+	return models.PromoCodeRedemption{
+		ID:             primitive.NewObjectID(),
+		PromoCodeID:    promo.ID,
+		Code:           promo.Code,
+		UserID:         checkout.UserID,
+		Checkout:       checkout.Checkout,
+		RequestID:      checkout.RequestID,
+		ExternalID:     checkout.ExternalID,
+		PlanType:       checkout.PlanType,
+		EntityType:     checkout.EntityType,
+		EntityID:       checkout.EntityID,
+		OriginalAmount: roundMoney(checkout.Amount),
+		DiscountAmount: discount,
+		FinalAmount:    roundMoney(checkout.Amount - discount),
+		Currency:       "USD",
+		Status:         models.PromoRedemptionPending,
+		CreatedAt:      now,
+	}
+}
+
+// PreviewPromoCode checks a promo code against a checkout without using it and
+// returns the redemption it would make
+func PreviewPromoCode(ctx context.Context, db *mongo.Client, checkout PromoCheckout) (*models.PromoCodeRedemption, error) {
+	promo, err := findPromoCode(ctx, db, checkout.Code)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := checkPromoCode(*promo, checkout, now); err != nil {
+		return nil, err
+	}
+	if err := checkPromoAccountLimit(ctx, db, *promo, checkout.UserID); err != nil {
+		return nil, err
+	}
+	redemption := promoRedemption(*promo, checkout, now)
+	return &redemption, nil
+}
+
+// ApplyPromoCode applies a promo code to a checkout about to be sent for
+// payment. The use counts against the code's limits straight away and is
+// recorded as pending until the payment succeeds or fails. Codes the checkout
+// cannot use return a *PromoCodeError.
+func ApplyPromoCode(ctx context.Context, db *mongo.Client, checkout PromoCheckout) (*models.PromoCodeRedemption, error) {
+	promo, err := findPromoCode(ctx, db, checkout.Code)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := checkPromoCode(*promo, checkout, now); err != nil {
+		return nil, err
+	}
+	if err := checkPromoAccountLimit(ctx, db, *promo, checkout.UserID); err != nil {
+		return nil, err
+	}
+
+	// Claim a use, unless concurrent checkouts took the last ones
+	codes := db.Database("barrim").Collection("promo_codes")
+	result, err := codes.UpdateOne(ctx,
+		bson.M{"_id": promo.ID, "$or": bson.A{
+			bson.M{"maxRedemptions": bson.M{"$not": bson.M{"$gt": 0}}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptionCount", "$maxRedemptions"}}},
+		}},
+		bson.M{"$inc": bson.M{"redemptionCount": 1}, "$set": bson.M{"updatedAt": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, &PromoCodeError{Message: "This promo code has been fully used"}
+	}
+
+	redemption := promoRedemption(*promo, checkout, now)
+	if _, err := db.Database("barrim").Collection("promo_code_redemptions").InsertOne(ctx, redemption); err != nil {
+		returnPromoUse(ctx, db, promo.ID)
+		return nil, err
+	}
+	return &redemption, nil
+}
+
+// returnPromoUse gives a claimed use back to a promo code
+func returnPromoUse(ctx context.Context, db *mongo.Client, promoCodeID primitive.ObjectID) {
+	_, err := db.Database("barrim").Collection("promo_codes").UpdateOne(ctx,
+		bson.M{"_id": promoCodeID, "redemptionCount": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"redemptionCount": -1}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Failed to return promo code use for %s: %v", promoCodeID.Hex(), err)
+	}
+}
+
+// RedeemPromoCode marks the promo code of a paid checkout as redeemed and books
+// its discount against the admin wallet, since wallet income is recorded at the
+// list price. Checkouts without a promo code are left alone.
+func RedeemPromoCode(ctx context.Context, db *mongo.Client, checkout string, requestID primitive.ObjectID) {
+	now := time.Now()
+	var before models.PromoCodeRedemption
+	err := db.Database("barrim").Collection("promo_code_redemptions").FindOneAndUpdate(ctx,
+		bson.M{"checkout": checkout, "requestId": requestID, "status": bson.M{"$ne": models.PromoRedemptionRedeemed}},
+		bson.M{"$set": bson.M{"status": models.PromoRedemptionRedeemed, "redeemedAt": now}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to redeem promo code of %s %s: %v", checkout, requestID.Hex(), err)
+		return
+	}
+
+	// A use given back after the hold lapsed is taken again now the checkout is paid
+	if before.Status == models.PromoRedemptionReleased {
+		if _, err := db.Database("barrim").Collection("promo_codes").UpdateOne(ctx,
+			bson.M{"_id": before.PromoCodeID},
+			bson.M{"$inc": bson.M{"redemptionCount": 1}, "$set": bson.M{"updatedAt": now}},
+		); err != nil {
+			log.Printf("Failed to count late promo code redemption %s: %v", before.ID.Hex(), err)
+		}
+	}
+
+	if before.DiscountAmount <= 0 {
+		return
+	}
+	_, err = db.Database("barrim").Collection("admin_wallet").InsertOne(ctx, models.AdminWallet{
+		ID:          primitive.NewObjectID(),
+		Type:        "promo_discount",
+		Amount:      -before.DiscountAmount,
+		Description: fmt.Sprintf("Promo code %s discount on %s", before.Code, strings.ReplaceAll(checkout, "_", " ")),
+		EntityID:    requestID,
+		EntityType:  checkout,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	if err != nil {
+		log.Printf("Failed to record promo code discount %s in admin wallet: %v", before.ID.Hex(), err)
+	}
+}
+
+// PromoAmountPaid returns what a checkout was charged after the promo code
+// applied to it, or nil when it had none
+func PromoAmountPaid(ctx context.Context, db *mongo.Client, checkout string, requestID primitive.ObjectID) *float64 {
+	var redemption models.PromoCodeRedemption
+	err := db.Database("barrim").Collection("promo_code_redemptions").FindOne(ctx, bson.M{
+		"checkout":  checkout,
+		"requestId": requestID,
+		"status":    bson.M{"$ne": models.PromoRedemptionReleased},
+	}).Decode(&redemption)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to find promo code of %s %s: %v", checkout, requestID.Hex(), err)
+		}
+		return nil
+	}
+	return &redemption.FinalAmount
+}
+
+// releasePromoRedemption releases the pending redemption matching filter and
+// gives its use back to the promo code
+func releasePromoRedemption(ctx context.Context, db *mongo.Client, filter bson.M) error {
+	filter["status"] = models.PromoRedemptionPending
+	var redemption models.PromoCodeRedemption
+	err := db.Database("barrim").Collection("promo_code_redemptions").FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$set": bson.M{"status": models.PromoRedemptionReleased, "releasedAt": time.Now()}},
+	).Decode(&redemption)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	returnPromoUse(ctx, db, redemption.PromoCodeID)
+	return nil
+}
+
+// ReleasePromoCode gives back the promo code of a checkout that will not be paid
+func ReleasePromoCode(ctx context.Context, db *mongo.Client, checkout string, requestID primitive.ObjectID) {
+	if err := releasePromoRedemption(ctx, db, bson.M{"checkout": checkout, "requestId": requestID}); err != nil {
+		log.Printf("Failed to release promo code of %s %s: %v", checkout, requestID.Hex(), err)
+	}
+}
+
+// ReleasePromoCodeByExternalID gives back the promo code of a checkout whose
+// payment failed, found by its Whish external ID
+func ReleasePromoCodeByExternalID(ctx context.Context, db *mongo.Client, checkout string, externalID int64) {
+	if err := releasePromoRedemption(ctx, db, bson.M{"checkout": checkout, "externalId": externalID}); err != nil {
+		log.Printf("Failed to release promo code of %s payment %d: %v", checkout, externalID, err)
+	}
+}
+
+// ReleaseStalePromoRedemptions releases promo codes applied to checkouts that
+// were not paid within PromoCodeHold and returns how many were released
+func ReleaseStalePromoRedemptions(ctx context.Context, db *mongo.Client) (int, error) {
+	cursor, err := db.Database("barrim").Collection("promo_code_redemptions").Find(ctx, bson.M{
+		"status":    models.PromoRedemptionPending,
+		"createdAt": bson.M{"$lte": time.Now().Add(-PromoCodeHold())},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var stale []models.PromoCodeRedemption
+	if err := cursor.All(ctx, &stale); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, r := range stale {
+		if err := releasePromoRedemption(ctx, db, bson.M{"_id": r.ID}); err != nil {
+			log.Printf("Failed to release stale promo code redemption %s: %v", r.ID.Hex(), err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// PromoCodeReport sums the redeemed promo codes between two times per code and
+// checkout, largest discount first
+func PromoCodeReport(ctx context.Context, db *mongo.Client, from, to time.Time) ([]models.PromoCodeReportRow, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":     models.PromoRedemptionRedeemed,
+			"redeemedAt": bson.M{"$gte": from, "$lte": to},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"code": "$code", "checkout": "$checkout"},
+			"redemptions":   bson.M{"$sum": 1},
+			"grossAmount":   bson.M{"$sum": "$originalAmount"},
+			"discountTotal": bson.M{"$sum": "$discountAmount"},
+			"netAmount":     bson.M{"$sum": "$finalAmount"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":           0,
+			"code":          "$_id.code",
+			"checkout":      "$_id.checkout",
+			"redemptions":   1,
+			"grossAmount":   1,
+			"discountTotal": 1,
+			"netAmount":     1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "discountTotal", Value: -1}, {Key: "code", Value: 1}}}},
+	}
+
+	cursor, err := db.Database("barrim").Collection("promo_code_redemptions").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	rows := []models.PromoCodeReportRow{}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].GrossAmount = roundMoney(rows[i].GrossAmount)
+		rows[i].DiscountTotal = roundMoney(rows[i].DiscountTotal)
+		rows[i].NetAmount = roundMoney(rows[i].NetAmount)
+	}
+	return rows, nil
+}
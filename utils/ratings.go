@@ -0,0 +1,574 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Rating defaults, overridable with RATING_PRIOR_WEIGHT, RATING_PRIOR_MEAN and
+// RATING_RECOMPUTE_INTERVAL. The Bayesian average behaves as if every entity had
+// RATING_PRIOR_WEIGHT extra ratings at the mean of its entity type.
+const (
+	DefaultRatingPriorWeight       = 5.0
+	DefaultRatingPriorMean         = 3.5 // Used until an entity type has ratings
+	DefaultRatingRecomputeInterval = 6 * time.Hour
+)
+
+// RatingVote is what one review or comment adds to a rating
+type RatingVote struct {
+	Rating int
+	Weight float64
+}
+
+// RatingTarget is the entity a review or comment rates
+type RatingTarget struct {
+	EntityType string
+	EntityID   primitive.ObjectID
+}
+
+var (
+	ratingPriorsMu sync.RWMutex
+	ratingPriors   = map[string]float64{}
+)
+
+// RatingRecomputeInterval returns how often all rating aggregates are rebuilt
+func RatingRecomputeInterval() time.Duration {
+	return envDuration("RATING_RECOMPUTE_INTERVAL", DefaultRatingRecomputeInterval)
+}
+
+// ratingPriorWeight returns how many ratings the prior counts for
+func ratingPriorWeight() float64 {
+	if v := os.Getenv("RATING_PRIOR_WEIGHT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			return f
+		}
+		log.Printf("Invalid RATING_PRIOR_WEIGHT %q, using default", v)
+	}
+	return DefaultRatingPriorWeight
+}
+
+// defaultRatingPriorMean returns the prior of an entity type without ratings
+func defaultRatingPriorMean() float64 {
+	if v := os.Getenv("RATING_PRIOR_MEAN"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 1 && f <= 5 {
+			return f
+		}
+		log.Printf("Invalid RATING_PRIOR_MEAN %q, using default", v)
+	}
+	return DefaultRatingPriorMean
+}
+
+// IsRatedEntity reports whether entities of a type can be rated
+func IsRatedEntity(entityType string) bool {
+	switch entityType {
+	case SponsoredEntityServiceProvider, SponsoredEntityCompanyBranch, SponsoredEntityWholesalerBranch:
+		return true
+	}
+	return false
+}
+
+// ReviewVote returns what a review adds to its provider's rating, or nil when
+// it is not published. Reviews verified by a completed booking weigh more.
+func ReviewVote(review models.Review) *RatingVote {
+	if review.Rating < 1 || review.Rating > 5 || !isPublished(review.ModerationStatus) {
+		return nil
+	}
+	weight := 1.0
+	if !review.IsVerified {
+		weight = ReviewUnverifiedWeight()
+	}
+	return &RatingVote{Rating: review.Rating, Weight: weight}
+}
+
+// CommentVote returns what a branch comment adds to its branch's rating, or nil
+// when it has no rating or is not published
+func CommentVote(comment models.BranchComment) *RatingVote {
+	if comment.Rating < 1 || comment.Rating > 5 || !isPublished(comment.ModerationStatus) {
+		return nil
+	}
+	return &RatingVote{Rating: comment.Rating, Weight: 1}
+}
+
+func isPublished(moderationStatus string) bool {
+	return moderationStatus != models.ModerationPending && moderationStatus != models.ModerationRejected
+}
+
+// providerRatingID returns the service provider document a review refers to.
+// Reviews store the provider's user ID, older ones the document ID.
+func providerRatingID(ctx context.Context, db *mongo.Client, ref primitive.ObjectID) (primitive.ObjectID, error) {
+	var sp models.ServiceProvider
+	err := db.Database("barrim").Collection("serviceProviders").FindOne(ctx,
+		bson.M{"$or": bson.A{bson.M{"_id": ref}, bson.M{"userId": ref}}},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&sp)
+	if err == mongo.ErrNoDocuments {
+		return ref, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return sp.ID, nil
+}
+
+// branchRatingType returns whether a commented branch belongs to a company or a wholesaler
+func branchRatingType(ctx context.Context, db *mongo.Client, branchID primitive.ObjectID) (string, error) {
+	n, err := db.Database("barrim").Collection("wholesalers").CountDocuments(ctx, bson.M{"branches._id": branchID})
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return SponsoredEntityWholesalerBranch, nil
+	}
+	return SponsoredEntityCompanyBranch, nil
+}
+
+// LoadContentRating returns the entity a review or branch comment rates and
+// what it currently adds to that rating
+func LoadContentRating(ctx context.Context, db *mongo.Client, contentType string, contentID primitive.ObjectID) (RatingTarget, *RatingVote, error) {
+	switch contentType {
+	case models.ModerationContentReview:
+		var review models.Review
+		if err := db.Database("barrim").Collection("reviews").FindOne(ctx, bson.M{"_id": contentID}).Decode(&review); err != nil {
+			return RatingTarget{}, nil, err
+		}
+		id, err := providerRatingID(ctx, db, review.ServiceProviderID)
+		if err != nil {
+			return RatingTarget{}, nil, err
+		}
+		return RatingTarget{EntityType: SponsoredEntityServiceProvider, EntityID: id}, ReviewVote(review), nil
+
+	case models.ModerationContentBranchComment:
+		var comment models.BranchComment
+		if err := db.Database("barrim").Collection("branch_comments").FindOne(ctx, bson.M{"_id": contentID}).Decode(&comment); err != nil {
+			return RatingTarget{}, nil, err
+		}
+		entityType, err := branchRatingType(ctx, db, comment.BranchID)
+		if err != nil {
+			return RatingTarget{}, nil, err
+		}
+		return RatingTarget{EntityType: entityType, EntityID: comment.BranchID}, CommentVote(comment), nil
+	}
+	return RatingTarget{}, nil, fmt.Errorf("invalid content type: %s", contentType)
+}
+
+// RefreshContentRating applies the change of a review or branch comment to its
+// entity's rating. before is what the content added prior to the change, with
+// target, both taken from LoadContentRating; a new content passes a zero
+// target and nil. Deleted content counts as adding nothing.
+func RefreshContentRating(db *mongo.Client, contentType string, contentID primitive.ObjectID, target RatingTarget, before *RatingVote) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	current, after, err := LoadContentRating(ctx, db, contentType, contentID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to load rating of %s %s: %v", contentType, contentID.Hex(), err)
+		return
+	}
+	if target.EntityID.IsZero() {
+		target = current
+	}
+	if target.EntityID.IsZero() {
+		return
+	}
+	if err := ChangeRating(ctx, db, target, before, after); err != nil {
+		log.Printf("Failed to update rating of %s %s: %v", target.EntityType, target.EntityID.Hex(), err)
+	}
+}
+
+// ChangeRating replaces one vote of an entity's rating with another; either
+// may be nil for a vote being added or removed
+func ChangeRating(ctx context.Context, db *mongo.Client, target RatingTarget, before, after *RatingVote) error {
+	if (before == nil && after == nil) || (before != nil && after != nil && *before == *after) {
+		return nil
+	}
+
+	inc := bson.M{}
+	add := func(vote *RatingVote, sign int) {
+		if vote == nil {
+			return
+		}
+		inc["count"] = toInt(inc["count"]) + sign
+		inc["sum"] = toInt(inc["sum"]) + sign*vote.Rating
+		inc["weightedSum"] = toFloat(inc["weightedSum"]) + float64(sign*vote.Rating)*vote.Weight
+		inc["totalWeight"] = toFloat(inc["totalWeight"]) + float64(sign)*vote.Weight
+		key := fmt.Sprintf("histogram.%d", vote.Rating)
+		inc[key] = toInt(inc[key]) + sign
+	}
+	add(before, -1)
+	add(after, 1)
+
+	collection := db.Database("barrim").Collection("rating_aggregates")
+	filter := bson.M{"entityType": target.EntityType, "entityId": target.EntityID}
+	_, err := collection.UpdateOne(ctx, filter,
+		bson.M{"$inc": inc, "$set": bson.M{"updatedAt": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	// Derive the averages from the stored totals in the same document update,
+	// so that concurrent changes cannot leave them out of step
+	prior, err := ratingPrior(ctx, db, target.EntityType)
+	if err != nil {
+		return err
+	}
+	_, err = collection.UpdateOne(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: ratingAverages(prior)}}})
+	if err != nil {
+		return err
+	}
+	return syncEntityRating(ctx, db, target)
+}
+
+func toInt(v interface{}) int {
+	n, _ := v.(int)
+	return n
+}
+
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+// ratingAverages returns the $set stage computing the averages of an aggregate
+func ratingAverages(prior float64) bson.M {
+	weight := ratingPriorWeight()
+	return bson.M{
+		"average": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$totalWeight", 0}},
+			bson.M{"$divide": bson.A{"$weightedSum", "$totalWeight"}},
+			0.0,
+		}},
+		"bayesianAverage": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$add": bson.A{"$totalWeight", weight}}, 0}},
+			bson.M{"$divide": bson.A{
+				bson.M{"$add": bson.A{"$weightedSum", weight * prior}},
+				bson.M{"$add": bson.A{"$totalWeight", weight}},
+			}},
+			prior,
+		}},
+	}
+}
+
+// ratingPrior returns the mean rating of an entity type, computed once and then
+// refreshed by RecomputeRatings
+func ratingPrior(ctx context.Context, db *mongo.Client, entityType string) (float64, error) {
+	ratingPriorsMu.RLock()
+	prior, ok := ratingPriors[entityType]
+	ratingPriorsMu.RUnlock()
+	if ok {
+		return prior, nil
+	}
+	priors, err := loadRatingPriors(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	return priors[entityType], nil
+}
+
+// loadRatingPriors computes and caches the mean rating of every entity type
+func loadRatingPriors(ctx context.Context, db *mongo.Client) (map[string]float64, error) {
+	cursor, err := db.Database("barrim").Collection("rating_aggregates").Aggregate(ctx, []bson.M{
+		{"$group": bson.M{
+			"_id":         "$entityType",
+			"weightedSum": bson.M{"$sum": "$weightedSum"},
+			"totalWeight": bson.M{"$sum": "$totalWeight"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		EntityType  string  `bson:"_id"`
+		WeightedSum float64 `bson:"weightedSum"`
+		TotalWeight float64 `bson:"totalWeight"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	priors := map[string]float64{}
+	for _, entityType := range []string{SponsoredEntityServiceProvider, SponsoredEntityCompanyBranch, SponsoredEntityWholesalerBranch} {
+		priors[entityType] = defaultRatingPriorMean()
+	}
+	for _, row := range rows {
+		if row.TotalWeight > 0 {
+			priors[row.EntityType] = row.WeightedSum / row.TotalWeight
+		}
+	}
+
+	ratingPriorsMu.Lock()
+	ratingPriors = priors
+	ratingPriorsMu.Unlock()
+	return priors, nil
+}
+
+// syncEntityRating copies a provider's rating onto its service provider
+// document and, for older clients, onto its user
+func syncEntityRating(ctx context.Context, db *mongo.Client, target RatingTarget) error {
+	if target.EntityType != SponsoredEntityServiceProvider {
+		return nil
+	}
+	aggregate, err := GetRatingAggregate(ctx, db, target.EntityType, target.EntityID)
+	if err != nil {
+		return err
+	}
+
+	database := db.Database("barrim")
+	var sp models.ServiceProvider
+	err = database.Collection("serviceProviders").FindOneAndUpdate(ctx,
+		bson.M{"_id": target.EntityID},
+		bson.M{"$set": bson.M{"rating": aggregate.Average, "ratingCount": aggregate.Count}},
+	).Decode(&sp)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	userID := target.EntityID
+	if !sp.UserID.IsZero() {
+		userID = sp.UserID
+	}
+	_, err = database.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "serviceProviderInfo": bson.M{"$ne": nil}},
+		bson.M{"$set": bson.M{"serviceProviderInfo.rating": aggregate.Average}},
+	)
+	return err
+}
+
+// emptyRatingAggregate is the rating of an entity nobody rated yet
+func emptyRatingAggregate(entityType string, entityID primitive.ObjectID, prior float64) models.RatingAggregate {
+	return models.RatingAggregate{
+		EntityType:      entityType,
+		EntityID:        entityID,
+		Histogram:       map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0},
+		BayesianAverage: prior,
+	}
+}
+
+// GetRatingAggregate returns the rating of an entity
+func GetRatingAggregate(ctx context.Context, db *mongo.Client, entityType string, entityID primitive.ObjectID) (models.RatingAggregate, error) {
+	if entityType == SponsoredEntityServiceProvider {
+		id, err := providerRatingID(ctx, db, entityID)
+		if err != nil {
+			return models.RatingAggregate{}, err
+		}
+		entityID = id
+	}
+
+	var aggregate models.RatingAggregate
+	err := db.Database("barrim").Collection("rating_aggregates").FindOne(ctx,
+		bson.M{"entityType": entityType, "entityId": entityID}).Decode(&aggregate)
+	if err == mongo.ErrNoDocuments {
+		prior, err := ratingPrior(ctx, db, entityType)
+		if err != nil {
+			return models.RatingAggregate{}, err
+		}
+		return emptyRatingAggregate(entityType, entityID, prior), nil
+	}
+	if err != nil {
+		return models.RatingAggregate{}, err
+	}
+	for star := 1; star <= 5; star++ {
+		if aggregate.Histogram == nil {
+			aggregate.Histogram = map[string]int{}
+		}
+		if _, ok := aggregate.Histogram[strconv.Itoa(star)]; !ok {
+			aggregate.Histogram[strconv.Itoa(star)] = 0
+		}
+	}
+	return aggregate, nil
+}
+
+// TopRatedEntities returns the best rated entities of a type by Bayesian
+// average, skipping those with fewer than minCount ratings
+func TopRatedEntities(ctx context.Context, db *mongo.Client, entityType string, minCount, limit int) ([]models.RatingAggregate, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "bayesianAverage", Value: -1}, {Key: "count", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := db.Database("barrim").Collection("rating_aggregates").Find(ctx, bson.M{
+		"entityType": entityType,
+		"count":      bson.M{"$gte": minCount},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	aggregates := []models.RatingAggregate{}
+	if err := cursor.All(ctx, &aggregates); err != nil {
+		return nil, err
+	}
+	return aggregates, nil
+}
+
+// ratingTally accumulates the votes of one entity during a rebuild
+type ratingTally struct {
+	target RatingTarget
+	models.RatingAggregate
+}
+
+func (t *ratingTally) add(vote *RatingVote) {
+	if vote == nil {
+		return
+	}
+	t.Count++
+	t.Sum += vote.Rating
+	t.WeightedSum += float64(vote.Rating) * vote.Weight
+	t.TotalWeight += vote.Weight
+	t.Histogram[strconv.Itoa(vote.Rating)]++
+}
+
+// RecomputeRatings rebuilds every rating aggregate from the published reviews
+// and branch comments, correcting any drift of the incremental updates, and
+// refreshes the mean of each entity type used by the Bayesian average. It
+// returns the number of aggregates written.
+func RecomputeRatings(ctx context.Context, db *mongo.Client) (int, error) {
+	database := db.Database("barrim")
+	tallies := map[RatingTarget]*ratingTally{}
+	tally := func(target RatingTarget) *ratingTally {
+		t, ok := tallies[target]
+		if !ok {
+			t = &ratingTally{target: target, RatingAggregate: emptyRatingAggregate(target.EntityType, target.EntityID, 0)}
+			tallies[target] = t
+		}
+		return t
+	}
+
+	// Reviews, keyed by the service provider document
+	providerIDs := map[primitive.ObjectID]primitive.ObjectID{}
+	cursor, err := database.Collection("serviceProviders").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1, "userId": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var providers []models.ServiceProvider
+	if err := cursor.All(ctx, &providers); err != nil {
+		return 0, err
+	}
+	for _, p := range providers {
+		providerIDs[p.ID] = p.ID
+		if !p.UserID.IsZero() {
+			providerIDs[p.UserID] = p.ID
+		}
+	}
+
+	cursor, err = database.Collection("reviews").Find(ctx, bson.M{"moderationStatus": PublishedModeration()},
+		options.Find().SetProjection(bson.M{"serviceProviderId": 1, "rating": 1, "isVerified": 1, "moderationStatus": 1}))
+	if err != nil {
+		return 0, err
+	}
+	for cursor.Next(ctx) {
+		var review models.Review
+		if err := cursor.Decode(&review); err != nil {
+			cursor.Close(ctx)
+			return 0, err
+		}
+		id, ok := providerIDs[review.ServiceProviderID]
+		if !ok {
+			id = review.ServiceProviderID
+		}
+		tally(RatingTarget{EntityType: SponsoredEntityServiceProvider, EntityID: id}).add(ReviewVote(review))
+	}
+	cursor.Close(ctx)
+
+	// Branch comments, keyed by branch
+	wholesalerBranches := map[primitive.ObjectID]bool{}
+	cursor, err = database.Collection("wholesalers").Find(ctx, bson.M{"branches.0": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"branches._id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var wholesalers []models.Wholesaler
+	if err := cursor.All(ctx, &wholesalers); err != nil {
+		return 0, err
+	}
+	for _, w := range wholesalers {
+		for _, b := range w.Branches {
+			wholesalerBranches[b.ID] = true
+		}
+	}
+
+	cursor, err = database.Collection("branch_comments").Find(ctx, bson.M{
+		"rating":           bson.M{"$gte": 1},
+		"moderationStatus": PublishedModeration(),
+	}, options.Find().SetProjection(bson.M{"branchId": 1, "rating": 1, "moderationStatus": 1}))
+	if err != nil {
+		return 0, err
+	}
+	for cursor.Next(ctx) {
+		var comment models.BranchComment
+		if err := cursor.Decode(&comment); err != nil {
+			cursor.Close(ctx)
+			return 0, err
+		}
+		entityType := SponsoredEntityCompanyBranch
+		if wholesalerBranches[comment.BranchID] {
+			entityType = SponsoredEntityWholesalerBranch
+		}
+		tally(RatingTarget{EntityType: entityType, EntityID: comment.BranchID}).add(CommentVote(comment))
+	}
+	cursor.Close(ctx)
+
+	// Entities that lost all their ratings are reset rather than left stale
+	collection := database.Collection("rating_aggregates")
+	cursor, err = collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"entityType": 1, "entityId": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var existing []models.RatingAggregate
+	if err := cursor.All(ctx, &existing); err != nil {
+		return 0, err
+	}
+	for _, a := range existing {
+		tally(RatingTarget{EntityType: a.EntityType, EntityID: a.EntityID})
+	}
+
+	now := time.Now()
+	var writes []mongo.WriteModel
+	for target, t := range tallies {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"entityType": target.EntityType, "entityId": target.EntityID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"count":        t.Count,
+				"sum":          t.Sum,
+				"histogram":    t.Histogram,
+				"weightedSum":  t.WeightedSum,
+				"totalWeight":  t.TotalWeight,
+				"updatedAt":    now,
+				"recomputedAt": now,
+			}}).
+			SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+
+	// With fresh totals, refresh the means and every average derived from them
+	priors, err := loadRatingPriors(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	for entityType, prior := range priors {
+		if _, err := collection.UpdateMany(ctx, bson.M{"entityType": entityType},
+			mongo.Pipeline{{{Key: "$set", Value: ratingAverages(prior)}}}); err != nil {
+			return 0, err
+		}
+	}
+	for target := range tallies {
+		if err := syncEntityRating(ctx, db, target); err != nil {
+			log.Printf("Failed to sync rating of %s %s: %v", target.EntityType, target.EntityID.Hex(), err)
+		}
+	}
+	return len(writes), nil
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// How long after a click a signup can still be attributed to it, and how long
+// a click from the same IP address is still suggested to a new install
+const (
+	referralClickWindow   = 7 * 24 * time.Hour
+	referralClickIPWindow = time.Hour
+)
+
+// ErrReferralCodeNotFound is returned when no account has a referral code
+var ErrReferralCodeNotFound = errors.New("referral code not found")
+
+// referralCodeOwners lists where referral codes live, businesses before
+// users since a business's user shares its code
+var referralCodeOwners = []string{
+	models.PointsAccountCompany,
+	models.PointsAccountWholesaler,
+	models.PointsAccountServiceProvider,
+	models.ReferrerSalesperson,
+	models.PointsAccountUser,
+}
+
+func referralClicks(db *mongo.Client) *mongo.Collection {
+	return db.Database("barrim").Collection("referral_clicks")
+}
+
+// referralBaseURL is the public address of the backend
+func referralBaseURL() string {
+	if base := os.Getenv("BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "https://barrim.online"
+}
+
+// ReferralLinkURL returns the tracked link of a referral code, which records
+// the click and sends the visitor on to the app or the web
+func ReferralLinkURL(code, source string) string {
+	return referralBaseURL() + "/r/" + url.PathEscape(code) + "?src=" + source
+}
+
+// ReferralQRCodePNG renders the tracked link of a referral code as a PNG QR code
+func ReferralQRCodePNG(code string, size int) ([]byte, error) {
+	qrCode, err := qr.Encode(ReferralLinkURL(code, models.ReferralSourceQR), qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+	qrCode, err = barcode.Scale(qrCode, size, size)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qrCode); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReferralQRCode renders the tracked link of a referral code as a base64 PNG QR code
+func ReferralQRCode(code string) (string, error) {
+	image, err := ReferralQRCodePNG(code, 300)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(image), nil
+}
+
+// ReferralPlatform tells the platform of a visitor from its user agent
+func ReferralPlatform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "android"):
+		return models.ReferralPlatformAndroid
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return models.ReferralPlatformIOS
+	}
+	return models.ReferralPlatformWeb
+}
+
+// ResolveReferralCode finds the referrer a referral code belongs to
+func ResolveReferralCode(ctx context.Context, db *mongo.Client, code string) (string, primitive.ObjectID, error) {
+	database := db.Database("barrim")
+	for _, referrerType := range referralCodeOwners {
+		var referrer struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := database.Collection(referralGraphAccounts[referrerType].collection).FindOne(ctx,
+			bson.M{"referralCode": code},
+			options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&referrer)
+		if err == nil {
+			return referrerType, referrer.ID, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return "", primitive.NilObjectID, err
+		}
+	}
+	return "", primitive.NilObjectID, ErrReferralCodeNotFound
+}
+
+// RecordReferralClick records a scan or click of a tracked referral link
+func RecordReferralClick(ctx context.Context, db *mongo.Client, code, source string, fingerprint models.ReferralFingerprint, userAgent string) (models.ReferralClick, error) {
+	referrerType, referrerID, err := ResolveReferralCode(ctx, db, code)
+	if err != nil {
+		return models.ReferralClick{}, err
+	}
+	if source != models.ReferralSourceQR {
+		source = models.ReferralSourceLink
+	}
+	click := models.ReferralClick{
+		ID:           primitive.NewObjectID(),
+		Code:         code,
+		ReferrerType: referrerType,
+		ReferrerID:   referrerID,
+		Source:       source,
+		Platform:     ReferralPlatform(userAgent),
+		IP:           fingerprint.IP,
+		DeviceID:     fingerprint.DeviceID,
+		UserAgent:    userAgent,
+		CreatedAt:    time.Now(),
+	}
+	if _, err := referralClicks(db).InsertOne(ctx, click); err != nil {
+		return models.ReferralClick{}, err
+	}
+	return click, nil
+}
+
+// ReferralRedirectURL returns where a click is sent: the store of its
+// platform, or the web signup when the store is not configured. The Play
+// Store hands its referrer parameter to the installed app; the App Store
+// drops it, so iOS installs are matched to their click at signup instead.
+func ReferralRedirectURL(click models.ReferralClick) string {
+	web := os.Getenv("REFERRAL_WEB_URL")
+	if web == "" {
+		web = referralBaseURL() + "/signup"
+	}
+	switch click.Platform {
+	case models.ReferralPlatformAndroid:
+		if store := os.Getenv("REFERRAL_ANDROID_URL"); store != "" {
+			referrer := url.Values{"referralCode": {click.Code}, "referralClickId": {click.ID.Hex()}}
+			return withQuery(store, url.Values{"referrer": {referrer.Encode()}})
+		}
+	case models.ReferralPlatformIOS:
+		if store := os.Getenv("REFERRAL_IOS_URL"); store != "" {
+			return store
+		}
+	}
+	return withQuery(web, url.Values{"referralCode": {click.Code}, "referralClickId": {click.ID.Hex()}})
+}
+
+// withQuery adds query parameters to a URL that may already have some
+func withQuery(rawURL string, values url.Values) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + values.Encode()
+}
+
+// FindReferralClick finds the click a new install or signup came from that
+// has not been attributed yet: by the click ID carried through the install
+// when there is one, else by the device. An IP address is shared by everyone
+// behind the same carrier or Wi-Fi, so it never attributes a click; see
+// SuggestReferralClick.
+func FindReferralClick(ctx context.Context, db *mongo.Client, clickID string, fingerprint models.ReferralFingerprint) (models.ReferralClick, bool, error) {
+	filter := bson.M{"convertedAt": nil, "createdAt": bson.M{"$gte": time.Now().Add(-referralClickWindow)}}
+	switch id, err := primitive.ObjectIDFromHex(clickID); {
+	case err == nil:
+		filter["_id"] = id
+	case fingerprint.DeviceID != "":
+		filter["deviceId"] = fingerprint.DeviceID
+	default:
+		return models.ReferralClick{}, false, nil
+	}
+	return findReferralClick(ctx, db, filter)
+}
+
+// SuggestReferralClick finds a recent unattributed click from the same IP
+// address. It may be someone else's, so the app only offers its code for the
+// user to confirm, and it is never applied to a signup by itself.
+func SuggestReferralClick(ctx context.Context, db *mongo.Client, fingerprint models.ReferralFingerprint) (models.ReferralClick, bool, error) {
+	if fingerprint.IP == "" {
+		return models.ReferralClick{}, false, nil
+	}
+	return findReferralClick(ctx, db, bson.M{
+		"convertedAt": nil,
+		"ip":          fingerprint.IP,
+		"createdAt":   bson.M{"$gte": time.Now().Add(-referralClickIPWindow)},
+	})
+}
+
+// findReferralClick returns the most recent click matching a filter
+func findReferralClick(ctx context.Context, db *mongo.Client, filter bson.M) (models.ReferralClick, bool, error) {
+	var click models.ReferralClick
+	err := referralClicks(db).FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})).Decode(&click)
+	if err == mongo.ErrNoDocuments {
+		return models.ReferralClick{}, false, nil
+	}
+	if err != nil {
+		return models.ReferralClick{}, false, err
+	}
+	return click, true, nil
+}
+
+// ConvertReferralClick attributes a click to the account that signed up from it
+func ConvertReferralClick(ctx context.Context, db *mongo.Client, clickID primitive.ObjectID, refereeType string, refereeID primitive.ObjectID) error {
+	_, err := referralClicks(db).UpdateOne(ctx,
+		bson.M{"_id": clickID, "convertedAt": nil},
+		bson.M{"$set": bson.M{
+			"convertedAt": time.Now(),
+			"refereeType": refereeType,
+			"refereeId":   refereeID,
+		}})
+	return err
+}
+
+// ReferralClickStatsFor counts the clicks of a period by source and platform,
+// with the signups attributed to them
+func ReferralClickStatsFor(ctx context.Context, db *mongo.Client, filter bson.M) ([]models.ReferralClickStats, error) {
+	cursor, err := referralClicks(db).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"source": "$source", "platform": "$platform"},
+			"clicks": bson.M{"$sum": 1},
+			"signups": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$convertedAt", nil}}, 1, 0,
+			}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"source":   "$_id.source",
+			"platform": "$_id.platform",
+			"clicks":   1,
+			"signups":  1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "clicks", Value: -1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	stats := []models.ReferralClickStats{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		if stats[i].Clicks > 0 {
+			stats[i].ConversionRate = float64(stats[i].Signups) / float64(stats[i].Clicks)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,424 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// ErrReferralNotInReview is returned when reviewing a referral that awaits no review
+var ErrReferralNotInReview = errors.New("referral is not awaiting review")
+
+// Thresholds of the referral fraud checks and of reward vesting
+const (
+	referralVelocityLimit     = 10             // Signups one referrer may bring in a day
+	referralSharedIPLimit     = 5              // Signups from one IP in a week before it is suspicious
+	referralSharedDeviceLimit = 2              // Signups from one device in a week before it is suspicious
+	referralRingDepth         = 5              // Referrers up the chain checked for rings
+	referralVestingPeriod     = 72 * time.Hour // Signup rewards are held at least this long
+	referralActivityGap       = 24 * time.Hour // The referee must come back this long after signing up
+)
+
+// referralAccountUser returns the user account behind a referral account
+func referralAccountUser(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID) (models.User, bool) {
+	database := db.Database("barrim")
+	userID := accountID
+	if accountType != models.PointsAccountUser {
+		collection, ok := PointsCollection(accountType)
+		if !ok {
+			return models.User{}, false
+		}
+		var account struct {
+			UserID primitive.ObjectID `bson:"userId"`
+		}
+		err := database.Collection(collection).FindOne(ctx, bson.M{"_id": accountID},
+			options.FindOne().SetProjection(bson.M{"userId": 1})).Decode(&account)
+		if err != nil || account.UserID.IsZero() {
+			return models.User{}, false
+		}
+		userID = account.UserID
+	}
+	var user models.User
+	err := database.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	return user, err == nil
+}
+
+// phoneDigits returns the digits of a phone number
+func phoneDigits(phone string) string {
+	digits := make([]rune, 0, len(phone))
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+	return string(digits)
+}
+
+// samePhonePrefix reports whether two phone numbers differ at most in their
+// last two digits, as numbers bought in a batch do
+func samePhonePrefix(a, b string) bool {
+	a, b = phoneDigits(a), phoneDigits(b)
+	if len(a) < 8 || len(a) != len(b) {
+		return false
+	}
+	return a[:len(a)-2] == b[:len(b)-2]
+}
+
+// refereeIDs returns the IDs a referee is known by: its account and the user behind it
+func refereeIDs(ev ReferralEvent) bson.A {
+	ids := bson.A{ev.RefereeID}
+	if ev.RefereeUserID != nil && *ev.RefereeUserID != ev.RefereeID {
+		ids = append(ids, *ev.RefereeUserID)
+	}
+	return ids
+}
+
+// ReferralFraudSignals checks a signup referral for self-referral through a
+// second account, signups from the referrer's IP, device or phone range,
+// mass signups from one IP or device, referrer velocity and referral rings
+func ReferralFraudSignals(ctx context.Context, db *mongo.Client, ev ReferralEvent, now time.Time) ([]string, error) {
+	events := db.Database("barrim").Collection("referral_events")
+	signals := []string{}
+	fp := ev.Fingerprint
+
+	referrer, referrerFound := referralAccountUser(ctx, db, ev.ReferrerType, ev.ReferrerID)
+	referee, refereeFound := referralAccountUser(ctx, db, ev.RefereeType, ev.RefereeID)
+	if !refereeFound && ev.RefereeUserID != nil {
+		referee, refereeFound = referralAccountUser(ctx, db, models.PointsAccountUser, *ev.RefereeUserID)
+	}
+	if fp.Phone == "" && refereeFound {
+		fp.Phone = referee.Phone
+	}
+
+	if referrerFound {
+		if refereeFound && referee.ID == referrer.ID {
+			signals = append(signals, models.ReferralSignalSelfReferral)
+		}
+
+		// Where the referrer connects from and signed up from
+		ips, devices := map[string]bool{referrer.LastIP: true}, map[string]bool{referrer.LastDeviceID: true}
+		var own models.ReferralEventRecord
+		err := events.FindOne(ctx, bson.M{
+			"event":     models.ReferralEventSignup,
+			"refereeId": bson.M{"$in": bson.A{ev.ReferrerID, referrer.ID}},
+		}).Decode(&own)
+		if err == nil {
+			ips[own.Fingerprint.IP] = true
+			devices[own.Fingerprint.DeviceID] = true
+		} else if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if fp.IP != "" && ips[fp.IP] {
+			signals = append(signals, models.ReferralSignalSameIP)
+		}
+		if fp.DeviceID != "" && devices[fp.DeviceID] {
+			signals = append(signals, models.ReferralSignalSameDevice)
+		}
+		if fp.Phone != "" && samePhonePrefix(fp.Phone, referrer.Phone) {
+			signals = append(signals, models.ReferralSignalSamePhonePrefix)
+		}
+	}
+
+	// Mass signups from one IP or device, whoever referred them
+	week := now.Add(-7 * 24 * time.Hour)
+	if fp.IP != "" {
+		n, err := events.CountDocuments(ctx, bson.M{"event": models.ReferralEventSignup, "fingerprint.ip": fp.IP, "createdAt": bson.M{"$gte": week}})
+		if err != nil {
+			return nil, err
+		}
+		if n >= referralSharedIPLimit {
+			signals = append(signals, models.ReferralSignalSharedIP)
+		}
+	}
+	if fp.DeviceID != "" {
+		n, err := events.CountDocuments(ctx, bson.M{"event": models.ReferralEventSignup, "fingerprint.deviceId": fp.DeviceID, "createdAt": bson.M{"$gte": week}})
+		if err != nil {
+			return nil, err
+		}
+		if n >= referralSharedDeviceLimit {
+			signals = append(signals, models.ReferralSignalSharedDevice)
+		}
+	}
+
+	// Referrer velocity
+	n, err := events.CountDocuments(ctx, bson.M{
+		"event":      models.ReferralEventSignup,
+		"referrerId": ev.ReferrerID,
+		"createdAt":  bson.M{"$gte": now.Add(-24 * time.Hour)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n >= referralVelocityLimit {
+		signals = append(signals, models.ReferralSignalVelocity)
+	}
+
+	ring, err := referralRing(ctx, db, ev)
+	if err != nil {
+		return nil, err
+	}
+	if ring {
+		signals = append(signals, models.ReferralSignalRing)
+	}
+	return signals, nil
+}
+
+// referralRing reports whether the referee already is up the referrer's
+// referral chain, or referred the referrer before events were recorded
+func referralRing(ctx context.Context, db *mongo.Client, ev ReferralEvent) (bool, error) {
+	database := db.Database("barrim")
+	referee := map[primitive.ObjectID]bool{}
+	for _, id := range refereeIDs(ev) {
+		referee[id.(primitive.ObjectID)] = true
+	}
+
+	if collection, ok := PointsCollection(ev.RefereeType); ok {
+		n, err := database.Collection(collection).CountDocuments(ctx, bson.M{"_id": ev.RefereeID, "referrals": ev.ReferrerID})
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+	}
+
+	current := ev.ReferrerID
+	for depth := 0; depth < referralRingDepth; depth++ {
+		var signup models.ReferralEventRecord
+		err := database.Collection("referral_events").FindOne(ctx, bson.M{
+			"event":     models.ReferralEventSignup,
+			"refereeId": current,
+		}).Decode(&signup)
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if referee[signup.ReferrerID] {
+			return true, nil
+		}
+		current = signup.ReferrerID
+	}
+	return false, nil
+}
+
+// refereeSignup returns the signup record of a referee, if it was recorded
+func refereeSignup(ctx context.Context, db *mongo.Client, ids bson.A) (models.ReferralEventRecord, bool, error) {
+	var signup models.ReferralEventRecord
+	err := db.Database("barrim").Collection("referral_events").FindOne(ctx, bson.M{
+		"event":     models.ReferralEventSignup,
+		"refereeId": bson.M{"$in": ids},
+	}).Decode(&signup)
+	if err == mongo.ErrNoDocuments {
+		return signup, false, nil
+	}
+	return signup, err == nil, err
+}
+
+// releaseReferralRewards gives the rewards of referral events held for a
+// reason, and returns how many it gave
+func releaseReferralRewards(ctx context.Context, db *mongo.Client, eventIDs []primitive.ObjectID, holdReason string) (int, error) {
+	database := db.Database("barrim")
+	released := 0
+	err := RunPointsTransaction(ctx, db, func(ctx context.Context) error {
+		released = 0
+		cursor, err := database.Collection("referral_events").Find(ctx, bson.M{"_id": bson.M{"$in": eventIDs}})
+		if err != nil {
+			return err
+		}
+		var records []models.ReferralEventRecord
+		if err := cursor.All(ctx, &records); err != nil {
+			return err
+		}
+		byID := make(map[primitive.ObjectID]models.ReferralEventRecord, len(records))
+		for _, r := range records {
+			byID[r.ID] = r
+		}
+
+		cursor, err = database.Collection("referral_rewards").Find(ctx, bson.M{
+			"eventId":    bson.M{"$in": eventIDs},
+			"status":     models.ReferralRewardHeld,
+			"holdReason": holdReason,
+		})
+		if err != nil {
+			return err
+		}
+		var rewards []models.ReferralReward
+		if err := cursor.All(ctx, &rewards); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, reward := range rewards {
+			err := grantReferralReward(ctx, db, &reward, byID[reward.EventID])
+			if err == ErrPointsAccountNotFound {
+				// Kept held until the account exists
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			set := bson.M{"status": reward.Status, "releasedAt": now}
+			if reward.LedgerEntryID != nil {
+				set["ledgerEntryId"] = *reward.LedgerEntryID
+			}
+			_, err = database.Collection("referral_rewards").UpdateOne(ctx,
+				bson.M{"_id": reward.ID, "status": models.ReferralRewardHeld},
+				bson.M{"$set": set})
+			if err != nil {
+				return err
+			}
+			released++
+		}
+		return nil
+	})
+	return released, err
+}
+
+// ReviewReferral approves or rejects a referral sent to review, with the
+// later events of the same referee held behind it. Approving gives their
+// held rewards, rejecting withholds them for good.
+func ReviewReferral(ctx context.Context, db *mongo.Client, eventID primitive.ObjectID, approve bool, adminID *primitive.ObjectID, note string) (models.ReferralEventRecord, error) {
+	events := db.Database("barrim").Collection("referral_events")
+	status := models.ReferralReviewRejected
+	if approve {
+		status = models.ReferralReviewApproved
+	}
+	now := time.Now()
+	set := bson.M{"reviewStatus": status, "reviewNote": note, "reviewedAt": now}
+	if adminID != nil {
+		set["reviewedBy"] = *adminID
+	}
+
+	var record models.ReferralEventRecord
+	err := events.FindOneAndUpdate(ctx,
+		bson.M{"_id": eventID, "reviewStatus": models.ReferralReviewPending},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return record, ErrReferralNotInReview
+	}
+	if err != nil {
+		return record, err
+	}
+
+	// Later events of the referee waited on this review
+	ids := bson.A{record.RefereeID}
+	if record.RefereeUserID != nil {
+		ids = append(ids, *record.RefereeUserID)
+	}
+	eventIDs := []primitive.ObjectID{record.ID}
+	if record.Event == models.ReferralEventSignup {
+		cursor, err := events.Find(ctx, bson.M{
+			"refereeId":  bson.M{"$in": ids},
+			"referrerId": record.ReferrerID,
+			"event":      bson.M{"$ne": models.ReferralEventSignup},
+		}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return record, err
+		}
+		var later []models.ReferralEventRecord
+		if err := cursor.All(ctx, &later); err != nil {
+			return record, err
+		}
+		for _, e := range later {
+			eventIDs = append(eventIDs, e.ID)
+		}
+	}
+
+	if approve {
+		_, err = releaseReferralRewards(ctx, db, eventIDs, models.ReferralHoldReview)
+		return record, err
+	}
+	_, err = db.Database("barrim").Collection("referral_rewards").UpdateMany(ctx,
+		bson.M{"eventId": bson.M{"$in": eventIDs}, "status": models.ReferralRewardHeld},
+		bson.M{"$set": bson.M{"status": models.ReferralRewardRejected, "releasedAt": now}})
+	return record, err
+}
+
+// ReferralReviews returns a page of referrals with a review status, newest first, and their total
+func ReferralReviews(ctx context.Context, db *mongo.Client, status string, page, limit int) ([]models.ReferralEventRecord, int64, error) {
+	events := db.Database("barrim").Collection("referral_events")
+	filter := bson.M{"reviewStatus": status}
+	total, err := events.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := events.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	result := []models.ReferralEventRecord{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
+
+// refereeActive reports whether a referee came back after signing up: it
+// reached a later referral event, or was active a day after the signup
+func refereeActive(ctx context.Context, db *mongo.Client, signup models.ReferralEventRecord) (bool, error) {
+	database := db.Database("barrim")
+	ids := bson.A{signup.RefereeID}
+	if signup.RefereeUserID != nil {
+		ids = append(ids, *signup.RefereeUserID)
+	}
+	n, err := database.Collection("referral_events").CountDocuments(ctx, bson.M{
+		"refereeId": bson.M{"$in": ids},
+		"event":     bson.M{"$ne": models.ReferralEventSignup},
+	})
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	n, err = database.Collection("users").CountDocuments(ctx, bson.M{
+		"_id":            bson.M{"$in": ids},
+		"lastActivityAt": bson.M{"$gte": signup.CreatedAt.Add(referralActivityGap)},
+	})
+	return n > 0, err
+}
+
+// VestReferralRewards gives the signup rewards held past the vesting period
+// whose referee has shown activity since, and returns how many it gave
+func VestReferralRewards(ctx context.Context, db *mongo.Client) (int, error) {
+	database := db.Database("barrim")
+	eventIDs, err := database.Collection("referral_rewards").Distinct(ctx, "eventId", bson.M{
+		"status":     models.ReferralRewardHeld,
+		"holdReason": models.ReferralHoldVesting,
+		"createdAt":  bson.M{"$lte": time.Now().Add(-referralVestingPeriod)},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range eventIDs {
+		var signup models.ReferralEventRecord
+		if err := database.Collection("referral_events").FindOne(ctx, bson.M{"_id": id}).Decode(&signup); err != nil {
+			log.Printf("Error loading referral event %v: %v", id, err)
+			continue
+		}
+		active, err := refereeActive(ctx, db, signup)
+		if err != nil {
+			return released, err
+		}
+		if !active {
+			continue
+		}
+		n, err := releaseReferralRewards(ctx, db, []primitive.ObjectID{signup.ID}, models.ReferralHoldVesting)
+		if err != nil {
+			return released, err
+		}
+		released += n
+	}
+	return released, nil
+}
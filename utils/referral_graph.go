@@ -0,0 +1,382 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// referralTreeLimit bounds the referees a subtree returns
+const referralTreeLimit = 1000
+
+// referralGraphAccounts maps each referrer type to its collection and the field naming it
+var referralGraphAccounts = map[string]struct{ collection, nameField string }{
+	models.PointsAccountUser:            {"users", "fullName"},
+	models.PointsAccountCompany:         {"companies", "businessName"},
+	models.PointsAccountWholesaler:      {"wholesalers", "businessName"},
+	models.PointsAccountServiceProvider: {"serviceProviders", "businessName"},
+	models.ReferrerSalesperson:          {"salespersons", "fullName"},
+}
+
+// ReferralGraphType reports whether a type is a referrer type of the referral graph
+func ReferralGraphType(accountType string) bool {
+	_, ok := referralGraphAccounts[accountType]
+	return ok
+}
+
+func referralLinks(db *mongo.Client) *mongo.Collection {
+	return db.Database("barrim").Collection("referral_links")
+}
+
+// RecordReferralLink adds who brought whom to the referral graph, once per
+// referrer and referee
+func RecordReferralLink(ctx context.Context, db *mongo.Client, link models.ReferralLink) (bool, error) {
+	if link.ID.IsZero() {
+		link.ID = primitive.NewObjectID()
+	}
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	link.RefereeAccounts = []primitive.ObjectID{link.RefereeID}
+	if link.RefereeUserID != nil && *link.RefereeUserID != link.RefereeID {
+		link.RefereeAccounts = append(link.RefereeAccounts, *link.RefereeUserID)
+	}
+	result, err := referralLinks(db).UpdateOne(ctx,
+		bson.M{"referrerId": link.ReferrerID, "refereeId": link.RefereeID},
+		bson.M{"$setOnInsert": link},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// referralGraphAccount finds the type, user and creation time of an account
+// listed in a referrals array, which does not say what it refers to
+func referralGraphAccount(ctx context.Context, db *mongo.Client, id primitive.ObjectID) (string, *primitive.ObjectID, time.Time, bool) {
+	database := db.Database("barrim")
+	for _, accountType := range []string{models.PointsAccountUser, models.PointsAccountCompany, models.PointsAccountWholesaler, models.PointsAccountServiceProvider} {
+		var account struct {
+			UserID    primitive.ObjectID `bson:"userId"`
+			CreatedAt time.Time          `bson:"createdAt"`
+		}
+		collection := referralGraphAccounts[accountType].collection
+		err := database.Collection(collection).FindOne(ctx, bson.M{"_id": id},
+			options.FindOne().SetProjection(bson.M{"userId": 1, "createdAt": 1})).Decode(&account)
+		if err != nil {
+			continue
+		}
+		var userID *primitive.ObjectID
+		if !account.UserID.IsZero() {
+			userID = &account.UserID
+		}
+		return accountType, userID, account.CreatedAt, true
+	}
+	return "", nil, time.Time{}, false
+}
+
+// BackfillReferralGraph adds the referrals recorded before the graph to it:
+// the signup referral events, then the referrals lists of every referrer
+// type. It returns how many links it added.
+func BackfillReferralGraph(ctx context.Context, db *mongo.Client) (int, error) {
+	database := db.Database("barrim")
+	added := 0
+
+	cursor, err := database.Collection("referral_events").Find(ctx, bson.M{"event": models.ReferralEventSignup})
+	if err != nil {
+		return added, err
+	}
+	var signups []models.ReferralEventRecord
+	if err := cursor.All(ctx, &signups); err != nil {
+		return added, err
+	}
+	for _, e := range signups {
+		ok, err := RecordReferralLink(ctx, db, models.ReferralLink{
+			ReferrerType:  e.ReferrerType,
+			ReferrerID:    e.ReferrerID,
+			RefereeType:   e.RefereeType,
+			RefereeID:     e.RefereeID,
+			RefereeUserID: e.RefereeUserID,
+			Channel:       models.ReferralChannelSignup,
+			CreatedAt:     e.CreatedAt,
+		})
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+
+	for referrerType, account := range referralGraphAccounts {
+		cursor, err := database.Collection(account.collection).Find(ctx,
+			bson.M{"referrals.0": bson.M{"$exists": true}},
+			options.Find().SetProjection(bson.M{"referrals": 1, "referralCode": 1, "updatedAt": 1}))
+		if err != nil {
+			return added, err
+		}
+		var referrers []struct {
+			ID           primitive.ObjectID   `bson:"_id"`
+			Referrals    []primitive.ObjectID `bson:"referrals"`
+			ReferralCode string               `bson:"referralCode"`
+			UpdatedAt    time.Time            `bson:"updatedAt"`
+		}
+		if err := cursor.All(ctx, &referrers); err != nil {
+			return added, err
+		}
+		for _, referrer := range referrers {
+			for _, refereeID := range referrer.Referrals {
+				n, err := referralLinks(db).CountDocuments(ctx, bson.M{"referrerId": referrer.ID, "refereeAccounts": refereeID})
+				if err != nil {
+					return added, err
+				}
+				if n > 0 {
+					continue
+				}
+				link := models.ReferralLink{
+					ReferrerType: referrerType,
+					ReferrerID:   referrer.ID,
+					RefereeID:    refereeID,
+					Code:         referrer.ReferralCode,
+					Channel:      models.ReferralChannelBackfill,
+					CreatedAt:    referrer.UpdatedAt,
+				}
+				if refereeType, userID, createdAt, found := referralGraphAccount(ctx, db, refereeID); found {
+					link.RefereeType, link.RefereeUserID = refereeType, userID
+					if !createdAt.IsZero() {
+						link.CreatedAt = createdAt
+					}
+				}
+				ok, err := RecordReferralLink(ctx, db, link)
+				if err != nil {
+					return added, err
+				}
+				if ok {
+					added++
+				}
+			}
+		}
+	}
+	return added, nil
+}
+
+// ReferralAccountIDs returns the IDs an account's referrals may be recorded
+// under: the account itself and the user behind a business
+func ReferralAccountIDs(ctx context.Context, db *mongo.Client, accountType string, accountID primitive.ObjectID) []primitive.ObjectID {
+	ids := []primitive.ObjectID{accountID}
+	if accountType == models.PointsAccountUser || accountType == models.ReferrerSalesperson {
+		return ids
+	}
+	if user, ok := referralAccountUser(ctx, db, accountType, accountID); ok {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// ReferralSubtree returns the referees an account brought, with the
+// referees they brought in turn, down to a depth, and how many there are
+func ReferralSubtree(ctx context.Context, db *mongo.Client, rootIDs []primitive.ObjectID, maxDepth int) ([]*models.ReferralTreeNode, int, error) {
+	roots := []*models.ReferralTreeNode{}
+	byAccount := map[primitive.ObjectID]*models.ReferralTreeNode{}
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range rootIDs {
+		seen[id] = true
+	}
+
+	total := 0
+	frontier := rootIDs
+	for depth := 1; depth <= maxDepth && len(frontier) > 0 && total < referralTreeLimit; depth++ {
+		cursor, err := referralLinks(db).Find(ctx, bson.M{"referrerId": bson.M{"$in": frontier}},
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetLimit(int64(referralTreeLimit-total)))
+		if err != nil {
+			return nil, 0, err
+		}
+		var links []models.ReferralLink
+		if err := cursor.All(ctx, &links); err != nil {
+			return nil, 0, err
+		}
+
+		frontier = nil
+		for _, link := range links {
+			// A referee met again is a ring, shown once
+			if seen[link.RefereeID] {
+				continue
+			}
+			node := &models.ReferralTreeNode{ReferralLink: link, Depth: depth, Children: []*models.ReferralTreeNode{}}
+			if parent, ok := byAccount[link.ReferrerID]; ok && depth > 1 {
+				parent.Children = append(parent.Children, node)
+			} else {
+				roots = append(roots, node)
+			}
+			for _, id := range link.RefereeAccounts {
+				seen[id] = true
+				byAccount[id] = node
+				frontier = append(frontier, id)
+			}
+			total++
+		}
+	}
+	return roots, total, nil
+}
+
+// convertedReferees returns which of the referees' accounts verified their
+// phone and which took a first paid subscription
+func convertedReferees(ctx context.Context, db *mongo.Client, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, map[primitive.ObjectID]bool, error) {
+	database := db.Database("barrim")
+	verified, paid := map[primitive.ObjectID]bool{}, map[primitive.ObjectID]bool{}
+	if len(ids) == 0 {
+		return verified, paid, nil
+	}
+
+	values, err := database.Collection("users").Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}, "phoneVerified": true})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			verified[id] = true
+		}
+	}
+	values, err = database.Collection("referral_events").Distinct(ctx, "refereeId", bson.M{
+		"event":     models.ReferralEventFirstPaidSubscription,
+		"refereeId": bson.M{"$in": ids},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			paid[id] = true
+		}
+	}
+	return verified, paid, nil
+}
+
+// anyOf reports whether any of the IDs is in a set
+func anyOf(set map[primitive.ObjectID]bool, ids []primitive.ObjectID) bool {
+	for _, id := range ids {
+		if set[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferralFunnel follows the referees of the links matching a filter from
+// signup to a verified phone to a first paid subscription. Paid
+// subscriptions are known from the referral events recorded for them.
+func ReferralFunnel(ctx context.Context, db *mongo.Client, filter bson.M) (models.ReferralFunnel, error) {
+	funnel := models.ReferralFunnel{ByRefereeType: map[string]models.ReferralFunnelStages{}}
+	cursor, err := referralLinks(db).Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"refereeType": 1, "refereeAccounts": 1}))
+	if err != nil {
+		return funnel, err
+	}
+	var links []models.ReferralLink
+	if err := cursor.All(ctx, &links); err != nil {
+		return funnel, err
+	}
+	var ids []primitive.ObjectID
+	for _, link := range links {
+		ids = append(ids, link.RefereeAccounts...)
+	}
+	verified, paid, err := convertedReferees(ctx, db, ids)
+	if err != nil {
+		return funnel, err
+	}
+
+	for _, link := range links {
+		stages := funnel.ByRefereeType[link.RefereeType]
+		stages.Signups++
+		funnel.Signups++
+		if anyOf(verified, link.RefereeAccounts) {
+			stages.Verified++
+			funnel.Verified++
+		}
+		if anyOf(paid, link.RefereeAccounts) {
+			stages.PaidSubscription++
+			funnel.PaidSubscription++
+		}
+		funnel.ByRefereeType[link.RefereeType] = stages
+	}
+	if funnel.Signups > 0 {
+		funnel.VerifiedRate = float64(funnel.Verified) / float64(funnel.Signups)
+		funnel.PaidRate = float64(funnel.PaidSubscription) / float64(funnel.Signups)
+	}
+	return funnel, nil
+}
+
+// TopReferrers ranks referrers by the referees they brought between two
+// times, with how many of those took a paid subscription
+func TopReferrers(ctx context.Context, db *mongo.Client, from, to time.Time, referrerType string, limit int) ([]models.TopReferrer, error) {
+	match := bson.M{"createdAt": bson.M{"$gte": from, "$lt": to}}
+	if referrerType != "" {
+		match["referrerType"] = referrerType
+	}
+	cursor, err := referralLinks(db).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"referrerType": "$referrerType", "referrerId": "$referrerId"},
+			"referrals": bson.M{"$sum": 1},
+			"referees":  bson.M{"$push": "$refereeAccounts"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "referrals", Value: -1}, {Key: "_id.referrerId", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Key struct {
+			ReferrerType string             `bson:"referrerType"`
+			ReferrerID   primitive.ObjectID `bson:"referrerId"`
+		} `bson:"_id"`
+		Referrals int                    `bson:"referrals"`
+		Referees  [][]primitive.ObjectID `bson:"referees"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	var ids []primitive.ObjectID
+	for _, g := range groups {
+		for _, accounts := range g.Referees {
+			ids = append(ids, accounts...)
+		}
+	}
+	_, paid, err := convertedReferees(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	database := db.Database("barrim")
+	top := make([]models.TopReferrer, 0, len(groups))
+	for _, g := range groups {
+		referrer := models.TopReferrer{
+			ReferrerType: g.Key.ReferrerType,
+			ReferrerID:   g.Key.ReferrerID,
+			Referrals:    g.Referrals,
+		}
+		for _, accounts := range g.Referees {
+			if anyOf(paid, accounts) {
+				referrer.PaidSubscription++
+			}
+		}
+		if account, ok := referralGraphAccounts[g.Key.ReferrerType]; ok {
+			var doc bson.M
+			err := database.Collection(account.collection).FindOne(ctx, bson.M{"_id": g.Key.ReferrerID},
+				options.FindOne().SetProjection(bson.M{account.nameField: 1})).Decode(&doc)
+			if err == nil {
+				referrer.Name, _ = doc[account.nameField].(string)
+			}
+		}
+		top = append(top, referrer)
+	}
+	return top, nil
+}
@@ -0,0 +1,480 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Reasons a referral event is not rewarded
+var (
+	ErrReferralEventRecorded = errors.New("referral event already recorded")
+	ErrNoReferrer            = errors.New("referee was not referred")
+)
+
+// defaultReferralRules apply when no configured rule matches an event. They
+// are the rewards given before rules could be configured: 5 points to the
+// referrer of a signup, and 1 point to a service provider referred by another.
+var defaultReferralRules = []models.ReferralRule{
+	{
+		Name:           "Signup",
+		ReferrerType:   models.ReferralAnyType,
+		RefereeType:    models.ReferralAnyType,
+		Event:          models.ReferralEventSignup,
+		RewardType:     models.ReferralRewardPoints,
+		ReferrerReward: 5,
+		IsActive:       true,
+	},
+	{
+		Name:           "Service provider signup",
+		ReferrerType:   models.PointsAccountServiceProvider,
+		RefereeType:    models.PointsAccountServiceProvider,
+		Event:          models.ReferralEventSignup,
+		RewardType:     models.ReferralRewardPoints,
+		ReferrerReward: 5,
+		RefereeReward:  1,
+		IsActive:       true,
+	},
+}
+
+// ReferralEvent is a referee reaching an event that may reward its referrer
+type ReferralEvent struct {
+	Event        string
+	ReferrerType string
+	ReferrerID   primitive.ObjectID // Looked up from the referee's signup when unset
+	RefereeType  string
+	RefereeID    primitive.ObjectID
+	// User account behind a company, wholesaler or service provider referee,
+	// which may be the one that signed up with the referral code
+	RefereeUserID *primitive.ObjectID
+	Fingerprint   models.ReferralFingerprint // Where a signup came from, for the fraud checks
+	Code          string                     // Referral code a signup used
+	Channel       string                     // How a signup used it, "signup" when unset
+}
+
+// ReferralOutcome is what a referral event recorded and gave
+type ReferralOutcome struct {
+	Event   models.ReferralEventRecord
+	Rule    models.ReferralRule
+	Rewards []models.ReferralReward
+}
+
+// ReferrerPoints returns the points the referrer was credited
+func (o ReferralOutcome) ReferrerPoints() int {
+	points := 0
+	for _, r := range o.Rewards {
+		if r.Beneficiary == models.ReferralBeneficiaryReferrer && r.RewardType == models.ReferralRewardPoints && r.Status == models.ReferralRewardGranted {
+			points += int(r.Amount)
+		}
+	}
+	return points
+}
+
+// ValidateReferralRule checks the types, event, reward and cap of a rule
+func ValidateReferralRule(rule models.ReferralRule) error {
+	accountType := func(t string) bool {
+		_, ok := PointsCollection(t)
+		return ok || t == models.ReferralAnyType
+	}
+	switch {
+	case rule.Name == "":
+		return errors.New("name is required")
+	case !accountType(rule.ReferrerType) || !accountType(rule.RefereeType):
+		return errors.New("referrerType and refereeType must be 'user', 'company', 'wholesaler', 'serviceProvider' or 'any'")
+	case rule.Event != models.ReferralEventSignup && rule.Event != models.ReferralEventFirstBooking && rule.Event != models.ReferralEventFirstPaidSubscription:
+		return errors.New("event must be 'signup', 'first_booking' or 'first_paid_subscription'")
+	case rule.RewardType != models.ReferralRewardPoints && rule.RewardType != models.ReferralRewardCash:
+		return errors.New("rewardType must be 'points' or 'cash'")
+	case rule.ReferrerReward < 0 || rule.RefereeReward < 0:
+		return errors.New("rewards cannot be negative")
+	case rule.RewardType == models.ReferralRewardPoints && (rule.ReferrerReward != math.Trunc(rule.ReferrerReward) || rule.RefereeReward != math.Trunc(rule.RefereeReward)):
+		return errors.New("points rewards must be whole numbers")
+	case rule.CapPerPeriod < 0:
+		return errors.New("capPerPeriod cannot be negative")
+	case rule.CapPerPeriod > 0 && !referralCapPeriods[rule.CapPeriod]:
+		return errors.New("capPeriod must be 'day', 'week', 'month' or 'total'")
+	case rule.StartsAt != nil && rule.EndsAt != nil && rule.EndsAt.Before(*rule.StartsAt):
+		return errors.New("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// referralCapPeriods are the periods a rule can cap its rewards over
+var referralCapPeriods = map[string]bool{
+	models.ReferralCapDay:   true,
+	models.ReferralCapWeek:  true,
+	models.ReferralCapMonth: true,
+	models.ReferralCapTotal: true,
+}
+
+// capPeriodStart returns when the rolling cap period of a rule began, or nil for no bound
+func capPeriodStart(period string, now time.Time) *time.Time {
+	var start time.Time
+	switch period {
+	case models.ReferralCapDay:
+		start = now.AddDate(0, 0, -1)
+	case models.ReferralCapWeek:
+		start = now.AddDate(0, 0, -7)
+	case models.ReferralCapMonth:
+		start = now.AddDate(0, -1, 0)
+	default:
+		return nil
+	}
+	return &start
+}
+
+// ruleSpecificity ranks an exact type above "any"
+func ruleSpecificity(rule models.ReferralRule) int {
+	n := 0
+	if rule.ReferrerType != models.ReferralAnyType {
+		n++
+	}
+	if rule.RefereeType != models.ReferralAnyType {
+		n++
+	}
+	return n
+}
+
+// pickReferralRule returns the rule applying among matching ones, if any
+func pickReferralRule(rules []models.ReferralRule) (models.ReferralRule, bool) {
+	if len(rules) == 0 {
+		return models.ReferralRule{}, false
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return ruleSpecificity(rules[i]) > ruleSpecificity(rules[j])
+	})
+	return rules[0], true
+}
+
+// MatchReferralRule returns the rule rewarding an event between a referrer and
+// referee type now: the configured one that applies, or else the built-in one
+func MatchReferralRule(ctx context.Context, db *mongo.Client, event, referrerType, refereeType string, now time.Time) (models.ReferralRule, bool, error) {
+	cursor, err := db.Database("barrim").Collection("referral_rules").Find(ctx, bson.M{
+		"isActive":     true,
+		"event":        event,
+		"referrerType": bson.M{"$in": bson.A{referrerType, models.ReferralAnyType}},
+		"refereeType":  bson.M{"$in": bson.A{refereeType, models.ReferralAnyType}},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"startsAt": nil}, bson.M{"startsAt": bson.M{"$lte": now}}}},
+			bson.M{"$or": bson.A{bson.M{"endsAt": nil}, bson.M{"endsAt": bson.M{"$gte": now}}}},
+		},
+	})
+	if err != nil {
+		return models.ReferralRule{}, false, err
+	}
+	var rules []models.ReferralRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return models.ReferralRule{}, false, err
+	}
+	if rule, ok := pickReferralRule(rules); ok {
+		return rule, true, nil
+	}
+
+	var defaults []models.ReferralRule
+	for _, rule := range defaultReferralRules {
+		if rule.Event == event &&
+			(rule.ReferrerType == referrerType || rule.ReferrerType == models.ReferralAnyType) &&
+			(rule.RefereeType == refereeType || rule.RefereeType == models.ReferralAnyType) {
+			defaults = append(defaults, rule)
+		}
+	}
+	rule, ok := pickReferralRule(defaults)
+	return rule, ok, nil
+}
+
+// findReferrer returns who referred a referee: the referrer recorded at its
+// signup, or else the account listing it among its referrals
+func findReferrer(ctx context.Context, db *mongo.Client, ids bson.A) (string, primitive.ObjectID, error) {
+	database := db.Database("barrim")
+	signup, found, err := refereeSignup(ctx, db, ids)
+	if err != nil {
+		return "", primitive.NilObjectID, err
+	}
+	if found {
+		return signup.ReferrerType, signup.ReferrerID, nil
+	}
+
+	// Referrals made before referral events were recorded
+	for _, accountType := range []string{models.PointsAccountUser, models.PointsAccountCompany, models.PointsAccountWholesaler, models.PointsAccountServiceProvider} {
+		collection, _ := PointsCollection(accountType)
+		var account struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := database.Collection(collection).FindOne(ctx, bson.M{"referrals": bson.M{"$in": ids}}).Decode(&account)
+		if err == nil {
+			return accountType, account.ID, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return "", primitive.NilObjectID, err
+		}
+	}
+	return "", primitive.NilObjectID, ErrNoReferrer
+}
+
+// ApplyReferralEvent rewards a referral event by the rule that applies to it.
+// Every referral path goes through here. The event is recorded once per
+// referee, so a repeated event returns ErrReferralEventRecorded; a signup
+// also adds the referee to the referrer's referrals. Points are credited to
+// the ledger and cash rewards are recorded for payout, both in one
+// ApplyReferralEvent records a referee reaching an event and rewards the
+// referrer and referee by the rule that matches it. Each event is recorded,
+// and so rewarded, once per referee. Signup rewards are held until the
+// referee shows activity, and a signup with fraud signals goes to admin
+// review with its rewards, and those of the referee's later events, held.
+func ApplyReferralEvent(ctx context.Context, db *mongo.Client, ev ReferralEvent) (ReferralOutcome, error) {
+	var outcome ReferralOutcome
+	database := db.Database("barrim")
+
+	if ev.ReferrerID.IsZero() {
+		var err error
+		ev.ReferrerType, ev.ReferrerID, err = findReferrer(ctx, db, refereeIDs(ev))
+		if err != nil {
+			return outcome, err
+		}
+	}
+	if _, ok := PointsCollection(ev.ReferrerType); !ok {
+		return outcome, fmt.Errorf("unknown referrer type: %s", ev.ReferrerType)
+	}
+
+	now := time.Now()
+	rule, ok, err := MatchReferralRule(ctx, db, ev.Event, ev.ReferrerType, ev.RefereeType, now)
+	if err != nil {
+		return outcome, err
+	}
+
+	// Signups vest, or wait for review when suspicious; later events follow their signup
+	record := models.ReferralEventRecord{
+		ID:            primitive.NewObjectID(),
+		Event:         ev.Event,
+		ReferrerType:  ev.ReferrerType,
+		ReferrerID:    ev.ReferrerID,
+		RefereeType:   ev.RefereeType,
+		RefereeID:     ev.RefereeID,
+		RefereeUserID: ev.RefereeUserID,
+		Fingerprint:   ev.Fingerprint,
+		CreatedAt:     now,
+	}
+	if !rule.ID.IsZero() {
+		record.RuleID = &rule.ID
+	}
+	holdReason, rejected := "", false
+	var signup models.ReferralEventRecord
+	var signupFound bool
+	if ev.Event == models.ReferralEventSignup {
+		signals, err := ReferralFraudSignals(ctx, db, ev, now)
+		if err != nil {
+			return outcome, err
+		}
+		holdReason = models.ReferralHoldVesting
+		if len(signals) > 0 {
+			record.FraudSignals = signals
+			record.ReviewStatus = models.ReferralReviewPending
+			holdReason = models.ReferralHoldReview
+		}
+	} else {
+		signup, signupFound, err = refereeSignup(ctx, db, refereeIDs(ev))
+		if err != nil {
+			return outcome, err
+		}
+		switch {
+		case !signupFound:
+		case signup.ReviewStatus == models.ReferralReviewPending:
+			record.FraudSignals = []string{models.ReferralSignalRefereeInReview}
+			holdReason = models.ReferralHoldReview
+		case signup.ReviewStatus == models.ReferralReviewRejected:
+			rejected = true
+		}
+	}
+
+	err = RunPointsTransaction(ctx, db, func(ctx context.Context) error {
+		outcome = ReferralOutcome{Rule: rule}
+		if _, err := database.Collection("referral_events").InsertOne(ctx, record); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrReferralEventRecorded
+			}
+			return err
+		}
+		outcome.Event = record
+
+		if ev.Event == models.ReferralEventSignup {
+			collection, _ := PointsCollection(ev.ReferrerType)
+			_, err := database.Collection(collection).UpdateOne(ctx, bson.M{"_id": ev.ReferrerID}, bson.M{
+				"$addToSet": bson.M{"referrals": ev.RefereeID},
+				"$set":      bson.M{"updatedAt": now},
+			})
+			if err != nil {
+				return err
+			}
+			channel := ev.Channel
+			if channel == "" {
+				channel = models.ReferralChannelSignup
+			}
+			_, err = RecordReferralLink(ctx, db, models.ReferralLink{
+				ReferrerType:  ev.ReferrerType,
+				ReferrerID:    ev.ReferrerID,
+				RefereeType:   ev.RefereeType,
+				RefereeID:     ev.RefereeID,
+				RefereeUserID: ev.RefereeUserID,
+				Code:          ev.Code,
+				Channel:       channel,
+				CreatedAt:     now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if !ok {
+			return nil
+		}
+
+		beneficiaries := []struct {
+			kind, accountType string
+			accountID         primitive.ObjectID
+			amount            float64
+		}{
+			{models.ReferralBeneficiaryReferrer, ev.ReferrerType, ev.ReferrerID, rule.ReferrerReward},
+			{models.ReferralBeneficiaryReferee, ev.RefereeType, ev.RefereeID, rule.RefereeReward},
+		}
+		for _, b := range beneficiaries {
+			if b.amount <= 0 {
+				continue
+			}
+			if _, ok := PointsCollection(b.accountType); !ok {
+				continue
+			}
+			reward := models.ReferralReward{
+				ID:          primitive.NewObjectID(),
+				EventID:     record.ID,
+				RuleID:      record.RuleID,
+				Event:       ev.Event,
+				Beneficiary: b.kind,
+				AccountType: b.accountType,
+				AccountID:   b.accountID,
+				RewardType:  rule.RewardType,
+				Amount:      b.amount,
+				CreatedAt:   now,
+			}
+
+			capped, err := referralCapReached(ctx, db, rule, b.kind, b.accountType, b.accountID, now)
+			if err != nil {
+				return err
+			}
+			switch {
+			case capped:
+				reward.Status = models.ReferralRewardCapped
+			case rejected:
+				reward.Status = models.ReferralRewardRejected
+			case holdReason != "":
+				reward.Status = models.ReferralRewardHeld
+				reward.HoldReason = holdReason
+			default:
+				err := grantReferralReward(ctx, db, &reward, record)
+				if err == ErrPointsAccountNotFound && b.kind == models.ReferralBeneficiaryReferee {
+					// The referee's account is not created yet
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := database.Collection("referral_rewards").InsertOne(ctx, reward); err != nil {
+				return err
+			}
+			outcome.Rewards = append(outcome.Rewards, reward)
+		}
+		return nil
+	})
+	if err != nil {
+		return outcome, err
+	}
+
+	// A later event is the activity the signup rewards were vesting for
+	if signupFound && signup.ReviewStatus != models.ReferralReviewPending && signup.ReviewStatus != models.ReferralReviewRejected {
+		if _, err := releaseReferralRewards(ctx, db, []primitive.ObjectID{signup.ID}, models.ReferralHoldVesting); err != nil {
+			log.Printf("Failed to vest referral rewards of signup %s: %v", signup.ID.Hex(), err)
+		}
+	}
+	return outcome, nil
+}
+
+// grantReferralReward gives a reward: points are credited to the
+// beneficiary's ledger, cash is left pending payout
+func grantReferralReward(ctx context.Context, db *mongo.Client, reward *models.ReferralReward, record models.ReferralEventRecord) error {
+	if reward.RewardType == models.ReferralRewardCash {
+		reward.Status = models.ReferralRewardPending
+		return nil
+	}
+
+	// A referrer's entry points to the referee, a referee's to the referrer
+	source, sourceID := models.PointsSourceReferral, record.RefereeID
+	if reward.Beneficiary == models.ReferralBeneficiaryReferee {
+		sourceID = record.ReferrerID
+		if record.Event == models.ReferralEventSignup {
+			source = models.PointsSourceReferralSignup
+		}
+	}
+	entry, err := ChangePoints(ctx, db, PointsChange{
+		AccountType: reward.AccountType,
+		AccountID:   reward.AccountID,
+		Type:        models.PointsEarn,
+		Points:      int(reward.Amount),
+		SourceType:  source,
+		SourceID:    &sourceID,
+		Reason:      "Referral " + record.Event,
+	})
+	if err != nil {
+		return err
+	}
+	reward.Status = models.ReferralRewardGranted
+	reward.LedgerEntryID = &entry.ID
+	return nil
+}
+
+// referralCapReached reports whether an account has had as many rewards
+// from a rule as its cap allows for the period. Only referrers are capped.
+func referralCapReached(ctx context.Context, db *mongo.Client, rule models.ReferralRule, beneficiary, accountType string, accountID primitive.ObjectID, now time.Time) (bool, error) {
+	if rule.CapPerPeriod <= 0 || rule.ID.IsZero() || beneficiary != models.ReferralBeneficiaryReferrer {
+		return false, nil
+	}
+	filter := bson.M{
+		"ruleId":      rule.ID,
+		"beneficiary": models.ReferralBeneficiaryReferrer,
+		"accountType": accountType,
+		"accountId":   accountID,
+		"status":      bson.M{"$nin": bson.A{models.ReferralRewardCapped, models.ReferralRewardRejected}},
+	}
+	if start := capPeriodStart(rule.CapPeriod, now); start != nil {
+		filter["createdAt"] = bson.M{"$gte": *start}
+	}
+	n, err := db.Database("barrim").Collection("referral_rewards").CountDocuments(ctx, filter)
+	return n >= int64(rule.CapPerPeriod), err
+}
+
+// TriggerReferralEvent rewards the referrer of a referee reaching an event,
+// if it was referred. It runs in the background of the request that saw the
+// event and only logs failures.
+func TriggerReferralEvent(db *mongo.Client, ev ReferralEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	outcome, err := ApplyReferralEvent(ctx, db, ev)
+	switch err {
+	case nil:
+		log.Printf("Referral %s of %s %s gave %d rewards", ev.Event, ev.RefereeType, ev.RefereeID.Hex(), len(outcome.Rewards))
+	case ErrNoReferrer, ErrReferralEventRecorded:
+	default:
+		log.Printf("Failed to reward referral %s of %s %s: %v", ev.Event, ev.RefereeType, ev.RefereeID.Hex(), err)
+	}
+}
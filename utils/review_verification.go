@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Review defaults, overridable with REVIEW_EDIT_WINDOW and REVIEW_UNVERIFIED_WEIGHT.
+// REVIEWS_REQUIRE_BOOKING=true refuses reviews without a completed booking.
+const (
+	DefaultReviewEditWindow       = 72 * time.Hour
+	DefaultReviewUnverifiedWeight = 0.5 // Weight of an unverified review in the provider rating
+)
+
+// Reasons a review cannot be created
+var (
+	ErrReviewBookingNotFound     = errors.New("booking not found")
+	ErrReviewBookingNotCompleted = errors.New("only completed bookings can be reviewed")
+	ErrReviewBookingReviewed     = errors.New("this booking has already been reviewed")
+	ErrReviewBookingRequired     = errors.New("a completed booking with this provider is required to leave a review")
+	ErrReviewAlreadyExists       = errors.New("you have already reviewed this provider; book and complete a service to review again")
+)
+
+// ReviewEditWindow returns how long after posting a review its author may edit it
+func ReviewEditWindow() time.Duration {
+	return envDuration("REVIEW_EDIT_WINDOW", DefaultReviewEditWindow)
+}
+
+// ReviewsRequireBooking reports whether reviews must come from a completed booking
+func ReviewsRequireBooking() bool {
+	return os.Getenv("REVIEWS_REQUIRE_BOOKING") == "true"
+}
+
+// ReviewUnverifiedWeight returns the weight of an unverified review in the provider rating
+func ReviewUnverifiedWeight() float64 {
+	if v := os.Getenv("REVIEW_UNVERIFIED_WEIGHT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			return f
+		}
+		log.Printf("Invalid REVIEW_UNVERIFIED_WEIGHT %q, using default", v)
+	}
+	return DefaultReviewUnverifiedWeight
+}
+
+// reviewedBooking reports whether a booking already has a review
+func reviewedBooking(ctx context.Context, db *mongo.Client, bookingID primitive.ObjectID) (bool, error) {
+	n, err := db.Database("barrim").Collection("reviews").CountDocuments(ctx, bson.M{"bookingId": bookingID})
+	return n > 0, err
+}
+
+// FindReviewBooking returns the completed booking a new review is tied to. With
+// a booking ID that booking must be the user's, with this provider, completed
+// and not yet reviewed. Without one the user's latest unreviewed completed
+// booking with the provider is used. A nil booking means the review is
+// unverified, which is allowed once per provider unless bookings are required.
+func FindReviewBooking(ctx context.Context, db *mongo.Client, userID, providerID primitive.ObjectID, bookingID *primitive.ObjectID) (*models.Booking, error) {
+	bookings := db.Database("barrim").Collection("bookings")
+
+	if bookingID != nil {
+		var booking models.Booking
+		err := bookings.FindOne(ctx, bson.M{
+			"_id":               *bookingID,
+			"userId":            userID,
+			"serviceProviderId": providerID,
+		}).Decode(&booking)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrReviewBookingNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if booking.Status != models.BookingStatusCompleted {
+			return nil, ErrReviewBookingNotCompleted
+		}
+		reviewed, err := reviewedBooking(ctx, db, booking.ID)
+		if err != nil {
+			return nil, err
+		}
+		if reviewed {
+			return nil, ErrReviewBookingReviewed
+		}
+		return &booking, nil
+	}
+
+	cursor, err := bookings.Find(ctx, bson.M{
+		"userId":            userID,
+		"serviceProviderId": providerID,
+		"status":            models.BookingStatusCompleted,
+	}, options.Find().SetSort(bson.D{{Key: "bookingDate", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var completed []models.Booking
+	if err := cursor.All(ctx, &completed); err != nil {
+		return nil, err
+	}
+	for i := range completed {
+		reviewed, err := reviewedBooking(ctx, db, completed[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if !reviewed {
+			return &completed[i], nil
+		}
+	}
+
+	if ReviewsRequireBooking() {
+		if len(completed) > 0 {
+			return nil, ErrReviewBookingReviewed
+		}
+		return nil, ErrReviewBookingRequired
+	}
+	unverified, err := db.Database("barrim").Collection("reviews").CountDocuments(ctx, bson.M{
+		"userId":            userID,
+		"serviceProviderId": providerID,
+		"bookingId":         bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unverified > 0 {
+		return nil, ErrReviewAlreadyExists
+	}
+	return nil, nil
+}
@@ -0,0 +1,565 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Search entity types
+const (
+	SearchTypeCompany         = "company"
+	SearchTypeWholesaler      = "wholesaler"
+	SearchTypeBranch          = "branch"
+	SearchTypeServiceProvider = "serviceProvider"
+)
+
+// Defaults for the search index, overridable with SEARCH_INDEX_REFRESH and SEARCH_SPONSORED_BOOST
+const (
+	DefaultSearchIndexRefresh   = 5 * time.Minute
+	DefaultSponsoredSearchBoost = 1.5
+)
+
+// Field weights: a hit on the name counts more than one on the description
+const (
+	searchWeightName        = 4.0
+	searchWeightCategory    = 2.0
+	searchWeightDescription = 1.0
+	searchWeightPlace       = 1.0
+)
+
+// searchStopwords are ignored in both documents and queries
+var searchStopwords = map[string]bool{
+	"the": true, "and": true, "of": true, "in": true, "for": true, "a": true, "an": true,
+	"في": true, "من": true, "و": true, "على": true, "الى": true, "عن": true,
+}
+
+// SearchParams describes a unified search request
+type SearchParams struct {
+	Query       string
+	Types       []string
+	Category    string
+	Governorate string
+	District    string
+	Page        int
+	Limit       int
+}
+
+type searchPosting struct {
+	doc    int
+	weight float64
+}
+
+// searchIndex is an in-memory inverted index over every searchable entity
+type searchIndex struct {
+	docs     []models.SearchDocument
+	postings map[string][]searchPosting
+	vocab    []string // Sorted tokens, for prefix and typo matching
+}
+
+var (
+	searchIndexMu      sync.RWMutex
+	currentSearchIndex *searchIndex
+)
+
+// SearchIndexRefreshInterval returns how often the search index is rebuilt
+func SearchIndexRefreshInterval() time.Duration {
+	if v := os.Getenv("SEARCH_INDEX_REFRESH"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid SEARCH_INDEX_REFRESH %q, using default", v)
+	}
+	return DefaultSearchIndexRefresh
+}
+
+// SponsoredSearchBoost returns the score multiplier applied to sponsored entities
+func SponsoredSearchBoost() float64 {
+	if v := os.Getenv("SEARCH_SPONSORED_BOOST"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 1 {
+			return f
+		}
+		log.Printf("Invalid SEARCH_SPONSORED_BOOST %q, using default", v)
+	}
+	return DefaultSponsoredSearchBoost
+}
+
+// SearchIndexReady reports whether the search index has been built at least once
+func SearchIndexReady() bool {
+	searchIndexMu.RLock()
+	defer searchIndexMu.RUnlock()
+	return currentSearchIndex != nil
+}
+
+// RefreshSearchIndex rebuilds the search index from the database and swaps it in
+func RefreshSearchIndex(db *mongo.Client) error {
+	docs, err := loadSearchDocuments(db)
+	if err != nil {
+		return err
+	}
+	idx := buildSearchIndex(docs)
+
+	searchIndexMu.Lock()
+	currentSearchIndex = idx
+	searchIndexMu.Unlock()
+	return nil
+}
+
+func loadSearchDocuments(db *mongo.Client) ([]models.SearchDocument, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	database := db.Database("barrim")
+	notRejected := bson.M{"CreationRequest": bson.M{"$ne": "rejected"}}
+
+	var docs []models.SearchDocument
+
+	for _, owner := range []struct {
+		collection string
+		ownerType  string
+	}{{"companies", SearchTypeCompany}, {"wholesalers", SearchTypeWholesaler}} {
+		opts := options.Find().SetProjection(bson.M{
+			"businessName": 1, "category": 1, "subCategory": 1, "contactInfo.address": 1,
+			"logoUrl": 1, "sponsorship": 1, "branches": 1,
+		})
+		cursor, err := database.Collection(owner.collection).Find(ctx, notRejected, opts)
+		if err != nil {
+			return nil, err
+		}
+		// Wholesalers embed branches the same way companies do
+		var owners []models.Company
+		err = cursor.All(ctx, &owners)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range owners {
+			addr := o.ContactInfo.Address
+			docs = append(docs, models.SearchDocument{
+				Type:        owner.ownerType,
+				ID:          o.ID.Hex(),
+				Name:        o.BusinessName,
+				Category:    o.Category,
+				SubCategory: o.SubCategory,
+				Governorate: addr.Governorate,
+				District:    addr.District,
+				City:        addr.City,
+				LogoURL:     o.LogoURL,
+				Location:    geoPointOf(addr),
+				Sponsored:   o.Sponsorship,
+			})
+			for _, b := range o.Branches {
+				if containsString(hiddenBranchStatuses, b.Status) {
+					continue
+				}
+				docs = append(docs, models.SearchDocument{
+					Type:        SearchTypeBranch,
+					ID:          b.ID.Hex(),
+					OwnerID:     o.ID.Hex(),
+					OwnerType:   owner.ownerType,
+					Name:        b.Name,
+					Category:    b.Category,
+					SubCategory: b.SubCategory,
+					Description: b.Description,
+					Governorate: b.Location.Governorate,
+					District:    b.Location.District,
+					City:        b.Location.City,
+					LogoURL:     o.LogoURL,
+					Location:    geoPointOf(b.Location),
+					Sponsored:   b.Sponsorship,
+				})
+			}
+		}
+	}
+
+	opts := options.Find().SetProjection(bson.M{"password": 0, "fcmToken": 0, "referrals": 0})
+	cursor, err := database.Collection("serviceProviders").Find(ctx, notRejected, opts)
+	if err != nil {
+		return nil, err
+	}
+	var providers []models.ServiceProvider
+	err = cursor.All(ctx, &providers)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sp := range providers {
+		addr := sp.ContactInfo.Address
+		doc := models.SearchDocument{
+			Type:        SearchTypeServiceProvider,
+			ID:          sp.ID.Hex(),
+			Name:        sp.BusinessName,
+			Category:    sp.Category,
+			Governorate: firstNonEmpty(addr.Governorate, sp.Governorate),
+			District:    firstNonEmpty(addr.District, sp.District),
+			City:        firstNonEmpty(addr.City, sp.City),
+			LogoURL:     sp.LogoURL,
+			Location:    geoPointOf(addr),
+			Sponsored:   sp.Sponsorship,
+		}
+		if info := sp.ServiceProviderInfo; info != nil {
+			doc.ServiceType = firstNonEmpty(info.CustomServiceType, info.ServiceType)
+			doc.Description = info.Description
+		}
+		if doc.Name == "" {
+			doc.Name = sp.ContactPerson
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+func buildSearchIndex(docs []models.SearchDocument) *searchIndex {
+	idx := &searchIndex{docs: docs, postings: make(map[string][]searchPosting)}
+	for i, d := range docs {
+		best := make(map[string]float64)
+		add := func(text string, weight float64) {
+			for _, tok := range TokenizeSearchText(text) {
+				if weight > best[tok] {
+					best[tok] = weight
+				}
+			}
+		}
+		add(d.Name, searchWeightName)
+		add(d.Category, searchWeightCategory)
+		add(d.SubCategory, searchWeightCategory)
+		add(d.ServiceType, searchWeightCategory)
+		add(d.Description, searchWeightDescription)
+		add(d.Governorate, searchWeightPlace)
+		add(d.District, searchWeightPlace)
+		add(d.City, searchWeightPlace)
+		for tok, w := range best {
+			idx.postings[tok] = append(idx.postings[tok], searchPosting{doc: i, weight: w})
+		}
+	}
+
+	idx.vocab = make([]string, 0, len(idx.postings))
+	for tok := range idx.postings {
+		idx.vocab = append(idx.vocab, tok)
+	}
+	sort.Strings(idx.vocab)
+	return idx
+}
+
+// TokenizeSearchText splits Arabic or English text into normalized search tokens
+func TokenizeSearchText(text string) []string {
+	fields := strings.FieldsFunc(normalizeSearchText(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if searchStopwords[f] {
+			continue
+		}
+		if tok := stemSearchToken(f); tok != "" && !searchStopwords[tok] {
+			tokens = append(tokens, tok)
+		}
+	}
+	return tokens
+}
+
+// normalizeSearchText lowercases text and folds Arabic spelling variants: diacritics
+// and tatweel are dropped, hamza forms of alef, taa marbuta and alef maqsura are
+// unified, and Arabic-Indic digits become ASCII
+func normalizeSearchText(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 0x064B && r <= 0x0652, r == 0x0670, r == 0x0640:
+			continue
+		case r == 'أ', r == 'إ', r == 'آ', r == 'ٱ':
+			r = 'ا'
+		case r == 'ة':
+			r = 'ه'
+		case r == 'ى', r == 'ئ':
+			r = 'ي'
+		case r == 'ؤ':
+			r = 'و'
+		case r >= '٠' && r <= '٩':
+			r = '0' + (r - '٠')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stemSearchToken strips the Arabic definite article and English plural endings
+func stemSearchToken(tok string) string {
+	if isArabicToken(tok) {
+		for _, prefix := range []string{"وال", "بال", "كال", "فال", "لل", "ال"} {
+			if strings.HasPrefix(tok, prefix) && utf8.RuneCountInString(tok)-utf8.RuneCountInString(prefix) >= 2 {
+				return strings.TrimPrefix(tok, prefix)
+			}
+		}
+		return tok
+	}
+
+	n := len(tok)
+	switch {
+	case n > 4 && strings.HasSuffix(tok, "ies"):
+		return tok[:n-3] + "y"
+	case n > 3 && strings.HasSuffix(tok, "s") && !strings.HasSuffix(tok, "ss") && !strings.HasSuffix(tok, "us"):
+		return tok[:n-1]
+	}
+	return tok
+}
+
+func isArabicToken(tok string) bool {
+	for _, r := range tok {
+		if unicode.Is(unicode.Arabic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedSearchEdits returns how many typos a query token of a given length tolerates
+func allowedSearchEdits(length int) int {
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	}
+	return 0
+}
+
+// matchToken scores every document containing a query token exactly, as a prefix
+// or within the allowed edit distance; closer matches score higher
+func (idx *searchIndex) matchToken(q string) map[int]float64 {
+	scores := make(map[int]float64)
+	consider := func(tok string, quality float64) {
+		for _, p := range idx.postings[tok] {
+			if s := quality * p.weight; s > scores[p.doc] {
+				scores[p.doc] = s
+			}
+		}
+	}
+
+	consider(q, 1)
+
+	qr := []rune(q)
+	if len(qr) >= 2 {
+		for i := sort.SearchStrings(idx.vocab, q); i < len(idx.vocab) && strings.HasPrefix(idx.vocab[i], q); i++ {
+			if idx.vocab[i] != q {
+				consider(idx.vocab[i], 0.8)
+			}
+		}
+	}
+
+	if maxEdits := allowedSearchEdits(len(qr)); maxEdits > 0 {
+		for _, tok := range idx.vocab {
+			if tok == q {
+				continue
+			}
+			tr := []rune(tok)
+			if diff := len(tr) - len(qr); diff > maxEdits || -diff > maxEdits {
+				continue
+			}
+			if d := editDistance(qr, tr, maxEdits); d <= maxEdits {
+				consider(tok, 0.9-0.2*float64(d))
+			}
+		}
+	}
+	return scores
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// giving up with max+1 once the distance is known to exceed max
+func editDistance(a, b []rune, max int) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// SearchEntities runs a query against the search index. Documents matching every
+// query term rank first; if none do, documents matching any term are returned.
+func SearchEntities(params SearchParams) models.SearchResults {
+	searchIndexMu.RLock()
+	idx := currentSearchIndex
+	searchIndexMu.RUnlock()
+
+	results := models.SearchResults{Hits: []models.SearchHit{}, Facets: map[string][]models.SearchFacet{}}
+	if idx == nil {
+		return results
+	}
+
+	terms := uniqueStrings(TokenizeSearchText(params.Query))
+	scores := make(map[int]float64)
+	if len(terms) == 0 {
+		for i := range idx.docs {
+			scores[i] = 1
+		}
+	} else {
+		matched := make(map[int]int)
+		for _, term := range terms {
+			for doc, s := range idx.matchToken(term) {
+				scores[doc] += s
+				matched[doc]++
+			}
+		}
+		all := false
+		for _, n := range matched {
+			if n == len(terms) {
+				all = true
+				break
+			}
+		}
+		if all {
+			for doc, n := range matched {
+				if n < len(terms) {
+					delete(scores, doc)
+				}
+			}
+		}
+	}
+
+	sameText := func(filter, value string) bool {
+		return filter == "" || normalizeSearchText(strings.TrimSpace(filter)) == normalizeSearchText(strings.TrimSpace(value))
+	}
+	boost := SponsoredSearchBoost()
+
+	var hits []models.SearchHit
+	for doc, score := range scores {
+		d := idx.docs[doc]
+		if len(params.Types) > 0 && !containsString(params.Types, d.Type) {
+			continue
+		}
+		if !sameText(params.Governorate, d.Governorate) || !sameText(params.District, d.District) {
+			continue
+		}
+		if params.Category != "" && !sameText(params.Category, d.Category) && !sameText(params.Category, d.ServiceType) {
+			continue
+		}
+		if d.Sponsored {
+			score *= boost
+		}
+		hits = append(hits, models.SearchHit{SearchDocument: d, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Name < hits[j].Name
+	})
+
+	results.Total = len(hits)
+	results.Facets = searchFacets(hits)
+
+	start := (params.Page - 1) * params.Limit
+	if start < len(hits) {
+		end := start + params.Limit
+		if end > len(hits) {
+			end = len(hits)
+		}
+		results.Hits = hits[start:end]
+	}
+	return results
+}
+
+func searchFacets(hits []models.SearchHit) map[string][]models.SearchFacet {
+	counts := map[string]map[string]int{
+		"type":        {},
+		"category":    {},
+		"governorate": {},
+		"district":    {},
+	}
+	for _, h := range hits {
+		counts["type"][h.Type]++
+		counts["category"][firstNonEmpty(h.Category, h.ServiceType)]++
+		counts["governorate"][h.Governorate]++
+		counts["district"][h.District]++
+	}
+
+	facets := make(map[string][]models.SearchFacet, len(counts))
+	for name, values := range counts {
+		list := []models.SearchFacet{}
+		for value, n := range values {
+			if value != "" {
+				list = append(list, models.SearchFacet{Value: value, Count: n})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Value < list[j].Value
+		})
+		facets[name] = list
+	}
+	return facets
+}
+
+func geoPointOf(addr models.Address) *models.GeoPoint {
+	if models.NewGeoJSONPoint(addr.Lat, addr.Lng) == nil {
+		return nil
+	}
+	return &models.GeoPoint{Lat: addr.Lat, Lng: addr.Lng}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
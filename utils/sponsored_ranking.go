@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Sponsored entity types, matching the normalized entity types of sponsorship subscriptions
+const (
+	SponsoredEntityServiceProvider  = "service_provider"
+	SponsoredEntityCompanyBranch    = "company_branch"
+	SponsoredEntityWholesalerBranch = "wholesaler_branch"
+	SponsoredEntityWholesaler       = "wholesaler"
+	SponsoredEntityCompany          = "company"
+)
+
+// Placements of a listing entry
+const (
+	PlacementPinned  = "pinned"
+	PlacementBoosted = "boosted"
+	PlacementOrganic = "organic"
+)
+
+// SponsoredLabel is shown next to every sponsored listing entry
+const SponsoredLabel = "Sponsored"
+
+// Default ranking settings, overridable with SPONSORED_PIN_SLOTS and SPONSORED_BOOST_POSITIONS
+const (
+	DefaultSponsoredPinSlots       = 3
+	DefaultSponsoredBoostPositions = 5
+)
+
+// SponsoredCandidate is one entry of a listing to rank
+type SponsoredCandidate struct {
+	EntityType string
+	EntityID   primitive.ObjectID
+	Sponsored  bool
+}
+
+// SponsoredPlacement is the position an entry takes in a ranked listing
+type SponsoredPlacement struct {
+	Index     int    // Position of the entry in the original listing
+	Placement string // PlacementPinned, PlacementBoosted or PlacementOrganic
+}
+
+var (
+	sponsoredRotationMu sync.Mutex
+	sponsoredRotation   = map[string]int{}
+)
+
+// SponsoredPinSlots returns how many sponsors are pinned at the top of a listing
+func SponsoredPinSlots() int {
+	return envPositiveInt("SPONSORED_PIN_SLOTS", DefaultSponsoredPinSlots)
+}
+
+// SponsoredBoostPositions returns how many places the sponsors that are not pinned move up
+func SponsoredBoostPositions() int {
+	return envPositiveInt("SPONSORED_BOOST_POSITIONS", DefaultSponsoredBoostPositions)
+}
+
+// nextSponsoredRotation returns the rotation offset of a listing with n sponsors
+// and advances it, so each sponsor takes the first pinned slot in turn
+func nextSponsoredRotation(listingKey string, n int) int {
+	sponsoredRotationMu.Lock()
+	defer sponsoredRotationMu.Unlock()
+	offset := sponsoredRotation[listingKey] % n
+	sponsoredRotation[listingKey] = offset + 1
+	return offset
+}
+
+// RankSponsored orders a listing. Up to SponsoredPinSlots sponsors are pinned at
+// the top, rotating between requests so every sponsor of the listing gets the
+// same share of the top slots. The remaining sponsors move up
+// SponsoredBoostPositions places and everything else keeps its original order.
+//
+// listingKey identifies the listing and its category or region filter
+// (e.g. "company_branches:restaurants"); sponsors rotate separately per key.
+func RankSponsored(listingKey string, candidates []SponsoredCandidate) []SponsoredPlacement {
+	var sponsors []int
+	for i, c := range candidates {
+		if c.Sponsored {
+			sponsors = append(sponsors, i)
+		}
+	}
+
+	ranked := make([]SponsoredPlacement, 0, len(candidates))
+	pinned := make(map[int]bool)
+	if len(sponsors) > 0 {
+		offset := nextSponsoredRotation(listingKey, len(sponsors))
+		for k := 0; k < minInt(SponsoredPinSlots(), len(sponsors)); k++ {
+			idx := sponsors[(offset+k)%len(sponsors)]
+			pinned[idx] = true
+			ranked = append(ranked, SponsoredPlacement{Index: idx, Placement: PlacementPinned})
+		}
+	}
+
+	type entry struct {
+		index int
+		rank  float64
+	}
+	boost := float64(SponsoredBoostPositions())
+	rest := make([]entry, 0, len(candidates)-len(pinned))
+	for i, c := range candidates {
+		if pinned[i] {
+			continue
+		}
+		rank := float64(len(rest))
+		if c.Sponsored {
+			// The half place puts a boosted sponsor ahead of the organic entry it lands on
+			rank -= boost + 0.5
+		}
+		rest = append(rest, entry{index: i, rank: rank})
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return rest[i].rank < rest[j].rank })
+
+	for _, e := range rest {
+		placement := PlacementOrganic
+		if candidates[e.index].Sponsored {
+			placement = PlacementBoosted
+		}
+		ranked = append(ranked, SponsoredPlacement{Index: e.index, Placement: placement})
+	}
+	return ranked
+}
+
+// SponsoredLabels returns the fields added to a listing entry to mark its placement
+func SponsoredLabels(sponsored bool, placement string) map[string]interface{} {
+	labels := map[string]interface{}{
+		"sponsored": sponsored,
+		"placement": placement,
+	}
+	if sponsored {
+		labels["sponsoredLabel"] = SponsoredLabel
+	}
+	return labels
+}
+
+// RecordSponsoredImpressions counts one impression for every sponsored entry
+// shown in a listing, aggregated per entity per day. It is meant to run in its
+// own goroutine so listings are not slowed down by the writes.
+func RecordSponsoredImpressions(db *mongo.Client, candidates []SponsoredCandidate, shown []SponsoredPlacement) {
+	now := time.Now()
+	var writes []mongo.WriteModel
+	for _, p := range shown {
+		c := candidates[p.Index]
+		if !c.Sponsored || c.EntityID.IsZero() {
+			continue
+		}
+		inc := bson.M{"impressions": 1}
+		if p.Placement == PlacementPinned {
+			inc["pinnedImpressions"] = 1
+		}
+		writes = append(writes, dailyStatsWrite(c.EntityType, c.EntityID, inc, now))
+	}
+	writeDailyStats(db, writes)
+}
+
+// SponsoredProviders maps the users of sponsored service providers to their
+// service provider documents, which is where sponsorships are attached
+type SponsoredProviders struct {
+	byUserID     map[primitive.ObjectID]primitive.ObjectID
+	byProviderID map[primitive.ObjectID]bool
+}
+
+// LoadSponsoredProviders loads every service provider with an active sponsorship
+func LoadSponsoredProviders(ctx context.Context, db *mongo.Client) (*SponsoredProviders, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "userId": 1})
+	cursor, err := db.Database("barrim").Collection("serviceProviders").Find(ctx, bson.M{"sponsorship": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var providers []models.ServiceProvider
+	if err := cursor.All(ctx, &providers); err != nil {
+		return nil, err
+	}
+
+	sp := &SponsoredProviders{
+		byUserID:     make(map[primitive.ObjectID]primitive.ObjectID, len(providers)),
+		byProviderID: make(map[primitive.ObjectID]bool, len(providers)),
+	}
+	for _, p := range providers {
+		sp.byProviderID[p.ID] = true
+		if !p.UserID.IsZero() {
+			sp.byUserID[p.UserID] = p.ID
+		}
+	}
+	return sp, nil
+}
+
+// Lookup returns the sponsored service provider of a user, if any
+func (sp *SponsoredProviders) Lookup(user models.User) (primitive.ObjectID, bool) {
+	if user.ServiceProviderID != nil && sp.byProviderID[*user.ServiceProviderID] {
+		return *user.ServiceProviderID, true
+	}
+	if id, ok := sp.byUserID[user.ID]; ok {
+		return id, true
+	}
+	if sp.byProviderID[user.ID] {
+		return user.ID, true
+	}
+	return primitive.NilObjectID, false
+}
+
+// UserIDs returns the users of every sponsored service provider
+func (sp *SponsoredProviders) UserIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(sp.byUserID)+len(sp.byProviderID))
+	for userID := range sp.byUserID {
+		ids = append(ids, userID)
+	}
+	for providerID := range sp.byProviderID {
+		ids = append(ids, providerID)
+	}
+	return ids
+}
+
+// ProviderIDs returns the ids of every sponsored service provider
+func (sp *SponsoredProviders) ProviderIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(sp.byProviderID))
+	for providerID := range sp.byProviderID {
+		ids = append(ids, providerID)
+	}
+	return ids
+}
@@ -0,0 +1,561 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Default inventory settings, overridable with SPONSORSHIP_AREA_CAP (0 for unlimited)
+// and SPONSORSHIP_PAYMENT_HOLD
+const (
+	DefaultSponsorshipAreaCap     = 0
+	DefaultSponsorshipPaymentHold = time.Hour
+)
+
+// sponsorshipReserveAttempts bounds retries when concurrent reservations race for an area
+const sponsorshipReserveAttempts = 5
+
+// ErrSponsorshipSlotLost is returned when confirming a hold that was released or lapsed
+var ErrSponsorshipSlotLost = errors.New("sponsorship slot is no longer held")
+
+// SponsorshipUnavailableError explains why a sponsor place could not be reserved
+type SponsorshipUnavailableError struct {
+	SoldOut       bool // The sponsorship has sold all its subscriptions
+	Category      string
+	Governorate   string
+	MaxSponsors   int
+	NextStartDate time.Time // Earliest start with a free place; zero when sold out
+}
+
+func (e *SponsorshipUnavailableError) Error() string {
+	if e.SoldOut {
+		return "sponsorship is sold out"
+	}
+	return fmt.Sprintf("all %d sponsor places in %s/%s are taken until %s",
+		e.MaxSponsors, e.Category, e.Governorate, e.NextStartDate.Format("2006-01-02"))
+}
+
+// SponsorshipReservation describes a sponsor place to reserve
+type SponsorshipReservation struct {
+	Sponsorship    models.Sponsorship
+	EntityType     string
+	EntityID       primitive.ObjectID
+	RequestID      primitive.ObjectID
+	StartDate      time.Time     // Zero starts as soon as possible
+	AcceptNextSlot bool          // Take the next free start if StartDate is full
+	HoldFor        time.Duration // How long the hold lasts before payment; 0 holds until confirmed or released
+	Paid           bool          // Already paid for, so the sale counts even past MaxSubscriptions
+}
+
+// SponsorshipPaymentHold returns how long a sponsor place is held for a pending payment
+func SponsorshipPaymentHold() time.Duration {
+	return envDuration("SPONSORSHIP_PAYMENT_HOLD", DefaultSponsorshipPaymentHold)
+}
+
+// envDuration reads a positive duration from the environment
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using default", name, v)
+	}
+	return def
+}
+
+// normalizeArea lower-cases and trims a category or governorate
+func normalizeArea(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// SponsorshipAreaOf returns the category and governorate a sponsorable entity competes in
+func SponsorshipAreaOf(ctx context.Context, db *mongo.Client, entityType string, entityID primitive.ObjectID) (string, string, error) {
+	database := db.Database("barrim")
+	switch entityType {
+	case SponsoredEntityServiceProvider:
+		var sp models.ServiceProvider
+		err := database.Collection("serviceProviders").FindOne(ctx, bson.M{"_id": entityID}).Decode(&sp)
+		if err != nil {
+			return "", "", err
+		}
+		category := sp.Category
+		if category == "" && sp.ServiceProviderInfo != nil {
+			category = sp.ServiceProviderInfo.ServiceType
+		}
+		return normalizeArea(category), normalizeArea(sp.ContactInfo.Address.Governorate), nil
+
+	case SponsoredEntityCompanyBranch, SponsoredEntityWholesalerBranch:
+		collection := "companies"
+		if entityType == SponsoredEntityWholesalerBranch {
+			collection = "wholesalers"
+		}
+		// Wholesalers embed branches the same way companies do
+		var owner models.Company
+		err := database.Collection(collection).FindOne(ctx, bson.M{"branches._id": entityID},
+			options.FindOne().SetProjection(bson.M{"category": 1, "branches.$": 1})).Decode(&owner)
+		if err != nil {
+			return "", "", err
+		}
+		if len(owner.Branches) == 0 {
+			return "", "", mongo.ErrNoDocuments
+		}
+		branch := owner.Branches[0]
+		category := branch.Category
+		if category == "" {
+			category = owner.Category
+		}
+		return normalizeArea(category), normalizeArea(branch.Location.Governorate), nil
+
+	default:
+		return "", "", fmt.Errorf("invalid entity type: %s", entityType)
+	}
+}
+
+// SponsorshipAreaCap returns how many entities can be sponsored at once in a
+// category and governorate, 0 meaning unlimited. The most specific cap wins:
+// category and governorate, then category alone, then governorate alone, then
+// SPONSORSHIP_AREA_CAP.
+func SponsorshipAreaCap(ctx context.Context, db *mongo.Client, category, governorate string) (int, error) {
+	cursor, err := db.Database("barrim").Collection("sponsorship_inventory_caps").Find(ctx, bson.M{
+		"category":    bson.M{"$in": bson.A{category, ""}},
+		"governorate": bson.M{"$in": bson.A{governorate, ""}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var caps []models.SponsorshipInventoryCap
+	if err := cursor.All(ctx, &caps); err != nil {
+		return 0, err
+	}
+
+	rank := func(c models.SponsorshipInventoryCap) int {
+		switch {
+		case c.Category != "" && c.Governorate != "":
+			return 3
+		case c.Category != "":
+			return 2
+		case c.Governorate != "":
+			return 1
+		}
+		return 0
+	}
+	best, bestRank := -1, -1
+	for _, c := range caps {
+		if r := rank(c); r > bestRank {
+			best, bestRank = c.MaxSponsors, r
+		}
+	}
+	if best >= 0 {
+		return best, nil
+	}
+
+	if v := os.Getenv("SPONSORSHIP_AREA_CAP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n, nil
+		}
+		log.Printf("Invalid SPONSORSHIP_AREA_CAP %q, using default", v)
+	}
+	return DefaultSponsorshipAreaCap, nil
+}
+
+// liveSlotsFilter matches the slots of an area that still occupy a place at or after from
+func liveSlotsFilter(category, governorate string, from time.Time) bson.M {
+	now := time.Now()
+	return bson.M{
+		"category":    category,
+		"governorate": governorate,
+		"endDate":     bson.M{"$gt": from},
+		"$or": bson.A{
+			bson.M{"status": models.SponsorshipSlotBooked},
+			bson.M{"status": models.SponsorshipSlotHeld, "$or": bson.A{
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				bson.M{"expiresAt": bson.M{"$gt": now}},
+			}},
+		},
+	}
+}
+
+// loadLiveSlots returns the slots of an area that occupy a place at or after from
+func loadLiveSlots(ctx context.Context, db *mongo.Client, category, governorate string, from time.Time) ([]models.SponsorshipSlot, error) {
+	cursor, err := db.Database("barrim").Collection("sponsorship_slots").Find(ctx, liveSlotsFilter(category, governorate, from))
+	if err != nil {
+		return nil, err
+	}
+	var slots []models.SponsorshipSlot
+	if err := cursor.All(ctx, &slots); err != nil {
+		return nil, err
+	}
+	return slots, nil
+}
+
+// peakOccupancy returns the most slots running at the same moment within [start, end)
+func peakOccupancy(slots []models.SponsorshipSlot, start, end time.Time) int {
+	points := []time.Time{start}
+	for _, s := range slots {
+		if s.StartDate.After(start) && s.StartDate.Before(end) {
+			points = append(points, s.StartDate)
+		}
+	}
+	peak := 0
+	for _, p := range points {
+		n := 0
+		for _, s := range slots {
+			if !s.StartDate.After(p) && s.EndDate.After(p) {
+				n++
+			}
+		}
+		if n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+// earliestSponsorshipStart returns the first start at or after from at which a
+// sponsorship of the given length fits under the cap
+func earliestSponsorshipStart(slots []models.SponsorshipSlot, from time.Time, length time.Duration, maxSponsors int) time.Time {
+	candidates := []time.Time{from}
+	for _, s := range slots {
+		if s.EndDate.After(from) {
+			candidates = append(candidates, s.EndDate)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, t := range candidates {
+		if peakOccupancy(slots, t, t.Add(length)) < maxSponsors {
+			return t
+		}
+	}
+	// Unreachable: once every slot has ended the area is empty
+	return candidates[len(candidates)-1]
+}
+
+// sponsorshipLength returns how long a sponsorship runs
+func sponsorshipLength(sponsorship models.Sponsorship) time.Duration {
+	return time.Duration(sponsorship.Duration) * 24 * time.Hour
+}
+
+// claimSponsorshipSale counts one more subscription sold, refusing when the
+// sponsorship has sold all it may unless the sale was already paid for
+func claimSponsorshipSale(ctx context.Context, db *mongo.Client, sponsorshipID primitive.ObjectID, paid bool) error {
+	filter := bson.M{"_id": sponsorshipID}
+	if !paid {
+		filter["$or"] = bson.A{
+			bson.M{"maxSubscriptions": bson.M{"$not": bson.M{"$gt": 0}}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$usedCount", "$maxSubscriptions"}}},
+		}
+	}
+	result, err := db.Database("barrim").Collection("sponsorships").UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"usedCount": 1}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &SponsorshipUnavailableError{SoldOut: true}
+	}
+	return nil
+}
+
+// returnSponsorshipSale undoes claimSponsorshipSale
+func returnSponsorshipSale(ctx context.Context, db *mongo.Client, sponsorshipID primitive.ObjectID) {
+	_, err := db.Database("barrim").Collection("sponsorships").UpdateOne(ctx,
+		bson.M{"_id": sponsorshipID, "usedCount": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"usedCount": -1}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Failed to return sponsorship sale for %s: %v", sponsorshipID.Hex(), err)
+	}
+}
+
+// ReserveSponsorshipSlot holds a sponsor place for an entity. It counts the sale
+// against the sponsorship's MaxSubscriptions, which a paid reservation may
+// exceed, and, if the entity's category and
+// governorate are capped, finds a start at which the area has a free place.
+// Concurrent reservations of one area are serialized through a version counter
+// so two requests can never both take its last place.
+//
+// It returns a *SponsorshipUnavailableError when the sponsorship is sold out or
+// the requested start is full and the caller did not accept the next free one.
+func ReserveSponsorshipSlot(ctx context.Context, db *mongo.Client, r SponsorshipReservation) (*models.SponsorshipSlot, error) {
+	category, governorate, err := SponsorshipAreaOf(ctx, db, r.EntityType, r.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	maxSponsors, err := SponsorshipAreaCap(ctx, db, category, governorate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := claimSponsorshipSale(ctx, db, r.Sponsorship.ID, r.Paid); err != nil {
+		return nil, err
+	}
+	slot, err := reserveAreaSlot(ctx, db, r, category, governorate, maxSponsors)
+	if err != nil {
+		returnSponsorshipSale(ctx, db, r.Sponsorship.ID)
+		return nil, err
+	}
+	return slot, nil
+}
+
+// reserveAreaSlot inserts the slot of a reservation at the first start the area allows
+func reserveAreaSlot(ctx context.Context, db *mongo.Client, r SponsorshipReservation, category, governorate string, maxSponsors int) (*models.SponsorshipSlot, error) {
+	database := db.Database("barrim")
+	slots := database.Collection("sponsorship_slots")
+	locks := database.Collection("sponsorship_area_locks")
+	lockID := category + "|" + governorate
+	length := sponsorshipLength(r.Sponsorship)
+
+	now := time.Now()
+	start := r.StartDate
+	if start.Before(now) {
+		start = now
+	}
+
+	for attempt := 0; attempt < sponsorshipReserveAttempts; attempt++ {
+		var lock struct {
+			Version int64 `bson:"version"`
+		}
+		if maxSponsors > 0 {
+			err := locks.FindOneAndUpdate(ctx,
+				bson.M{"_id": lockID},
+				bson.M{"$setOnInsert": bson.M{"version": int64(0)}},
+				options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+			).Decode(&lock)
+			if err != nil {
+				return nil, err
+			}
+
+			live, err := loadLiveSlots(ctx, db, category, governorate, start)
+			if err != nil {
+				return nil, err
+			}
+			next := earliestSponsorshipStart(live, start, length, maxSponsors)
+			if next.After(start) && !r.AcceptNextSlot {
+				return nil, &SponsorshipUnavailableError{
+					Category:      category,
+					Governorate:   governorate,
+					MaxSponsors:   maxSponsors,
+					NextStartDate: next,
+				}
+			}
+			start = next
+		}
+
+		slot := models.SponsorshipSlot{
+			ID:            primitive.NewObjectID(),
+			SponsorshipID: r.Sponsorship.ID,
+			RequestID:     r.RequestID,
+			EntityType:    r.EntityType,
+			EntityID:      r.EntityID,
+			Category:      category,
+			Governorate:   governorate,
+			StartDate:     start,
+			EndDate:       start.Add(length),
+			Status:        models.SponsorshipSlotHeld,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if r.HoldFor > 0 {
+			expiresAt := now.Add(r.HoldFor)
+			slot.ExpiresAt = &expiresAt
+		}
+		if _, err := slots.InsertOne(ctx, slot); err != nil {
+			return nil, err
+		}
+		if maxSponsors == 0 {
+			return &slot, nil
+		}
+
+		// Only the reservation that moves the version on keeps its slot
+		result, err := locks.UpdateOne(ctx,
+			bson.M{"_id": lockID, "version": lock.Version},
+			bson.M{"$inc": bson.M{"version": 1}},
+		)
+		if err == nil && result.ModifiedCount == 1 {
+			return &slot, nil
+		}
+		if _, delErr := slots.DeleteOne(ctx, bson.M{"_id": slot.ID}); delErr != nil {
+			log.Printf("Failed to remove contended sponsorship slot %s: %v", slot.ID.Hex(), delErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("sponsorship area %s is busy, please try again", lockID)
+}
+
+// ConfirmSponsorshipSlot books a held slot once its request is paid or approved
+func ConfirmSponsorshipSlot(ctx context.Context, db *mongo.Client, slotID primitive.ObjectID) (*models.SponsorshipSlot, error) {
+	now := time.Now()
+	var slot models.SponsorshipSlot
+	err := db.Database("barrim").Collection("sponsorship_slots").FindOneAndUpdate(ctx,
+		bson.M{"_id": slotID, "$or": bson.A{
+			bson.M{"status": models.SponsorshipSlotBooked},
+			bson.M{"status": models.SponsorshipSlotHeld, "$or": bson.A{
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				bson.M{"expiresAt": bson.M{"$gt": now}},
+			}},
+		}},
+		bson.M{
+			"$set":   bson.M{"status": models.SponsorshipSlotBooked, "updatedAt": now},
+			"$unset": bson.M{"expiresAt": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&slot)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSponsorshipSlotLost
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+// ReleaseSponsorshipSlot gives a held or booked place back and returns its sale
+func ReleaseSponsorshipSlot(ctx context.Context, db *mongo.Client, slotID primitive.ObjectID) error {
+	var slot models.SponsorshipSlot
+	err := db.Database("barrim").Collection("sponsorship_slots").FindOneAndUpdate(ctx,
+		bson.M{"_id": slotID, "status": bson.M{"$ne": models.SponsorshipSlotReleased}},
+		bson.M{"$set": bson.M{"status": models.SponsorshipSlotReleased, "updatedAt": time.Now()}},
+	).Decode(&slot)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	returnSponsorshipSale(ctx, db, slot.SponsorshipID)
+	return nil
+}
+
+// ReleaseExpiredSponsorshipHolds releases holds whose payment never arrived and
+// returns how many were released
+func ReleaseExpiredSponsorshipHolds(ctx context.Context, db *mongo.Client) (int, error) {
+	cursor, err := db.Database("barrim").Collection("sponsorship_slots").Find(ctx, bson.M{
+		"status":    models.SponsorshipSlotHeld,
+		"expiresAt": bson.M{"$lte": time.Now()},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var expired []models.SponsorshipSlot
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, slot := range expired {
+		if err := ReleaseSponsorshipSlot(ctx, db, slot.ID); err != nil {
+			log.Printf("Failed to release expired sponsorship hold %s: %v", slot.ID.Hex(), err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// GetSponsorshipAvailability describes the places left for an entity's category
+// and governorate, and the earliest start a sponsorship could get there
+func GetSponsorshipAvailability(ctx context.Context, db *mongo.Client, sponsorship models.Sponsorship, entityType string, entityID primitive.ObjectID) (*models.SponsorshipAvailability, error) {
+	category, governorate, err := SponsorshipAreaOf(ctx, db, entityType, entityID)
+	if err != nil {
+		return nil, err
+	}
+	maxSponsors, err := SponsorshipAreaCap(ctx, db, category, governorate)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	availability := &models.SponsorshipAvailability{
+		SponsorshipID: sponsorship.ID,
+		Category:      category,
+		Governorate:   governorate,
+		MaxSponsors:   maxSponsors,
+		NextStartDate: now,
+		AvailableNow:  true,
+	}
+	if sponsorship.MaxSubscriptions > 0 {
+		availability.RemainingToSell = sponsorship.MaxSubscriptions - sponsorship.UsedCount
+		if availability.RemainingToSell <= 0 {
+			availability.RemainingToSell = 0
+			availability.SoldOut = true
+			availability.AvailableNow = false
+		}
+	}
+
+	live, err := loadLiveSlots(ctx, db, category, governorate, now)
+	if err != nil {
+		return nil, err
+	}
+	availability.ActiveSponsors = peakOccupancy(live, now, now.Add(time.Second))
+	if maxSponsors > 0 {
+		availability.NextStartDate = earliestSponsorshipStart(live, now, sponsorshipLength(sponsorship), maxSponsors)
+		if availability.NextStartDate.After(now) {
+			availability.AvailableNow = false
+		}
+	}
+
+	waiting, err := db.Database("barrim").Collection("sponsorship_waitlist").CountDocuments(ctx, bson.M{
+		"category":    category,
+		"governorate": governorate,
+		"status":      models.SponsorshipWaitlistWaiting,
+	})
+	if err != nil {
+		return nil, err
+	}
+	availability.WaitlistedAhead = int(waiting)
+	return availability, nil
+}
+
+// JoinSponsorshipWaitlist queues an entity for a place in its category and
+// governorate, returning its entry and how many entries are ahead of it
+func JoinSponsorshipWaitlist(ctx context.Context, db *mongo.Client, sponsorshipID primitive.ObjectID, entityType string, entityID primitive.ObjectID, entityName string, requestedBy primitive.ObjectID) (*models.SponsorshipWaitlistEntry, int, error) {
+	category, governorate, err := SponsorshipAreaOf(ctx, db, entityType, entityID)
+	if err != nil {
+		return nil, 0, err
+	}
+	collection := db.Database("barrim").Collection("sponsorship_waitlist")
+
+	var entry models.SponsorshipWaitlistEntry
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"sponsorshipId": sponsorshipID, "entityType": entityType, "entityId": entityID, "status": models.SponsorshipWaitlistWaiting},
+		bson.M{"$setOnInsert": bson.M{
+			"entityName":  entityName,
+			"category":    category,
+			"governorate": governorate,
+			"requestedBy": requestedBy,
+			"createdAt":   time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ahead, err := collection.CountDocuments(ctx, bson.M{
+		"category":    category,
+		"governorate": governorate,
+		"status":      models.SponsorshipWaitlistWaiting,
+		"createdAt":   bson.M{"$lt": entry.CreatedAt},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &entry, int(ahead), nil
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var warnNoTransactions sync.Once
+
+// transactionsUnsupported reports whether the server refused a transaction
+// because it is a standalone instance rather than a replica set
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 20 // IllegalOperation
+}
+
+// RunTransaction runs fn in a Mongo transaction so that its writes commit
+// together. On a standalone server, which has no transactions, fn runs
+// without one; it can tell by mongo.SessionFromContext returning nil and
+// undo its own writes when it fails.
+func RunTransaction(ctx context.Context, db *mongo.Client, fn func(ctx context.Context) error) error {
+	session, err := db.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if transactionsUnsupported(err) {
+		warnNoTransactions.Do(func() {
+			log.Println("MongoDB does not support transactions, writes run without them")
+		})
+		return fn(ctx)
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Reasons a scanned voucher code is refused
+var (
+	ErrVoucherCodeInvalid   = errors.New("invalid voucher code")
+	ErrVoucherWrongMerchant = errors.New("voucher cannot be redeemed here")
+	ErrVoucherCodesDisabled = errors.New("voucher codes are not configured")
+)
+
+// voucherCodeSecret returns the key redemption codes are signed with, from
+// VOUCHER_CODE_SECRET. Without it no code is issued or accepted.
+func voucherCodeSecret() []byte {
+	return []byte(os.Getenv("VOUCHER_CODE_SECRET"))
+}
+
+// CheckVoucherCodeSecret logs at startup when redemption codes cannot be signed
+func CheckVoucherCodeSecret() {
+	if len(voucherCodeSecret()) == 0 {
+		log.Println("Warning: VOUCHER_CODE_SECRET is not set; voucher codes cannot be issued or redeemed")
+	}
+}
+
+// voucherCodeSignature signs the purchase and nonce parts of a redemption code
+func voucherCodeSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// NewVoucherRedemptionCode returns a unique code for a purchase, made of the
+// purchase ID, a random nonce and a signature of both
+func NewVoucherRedemptionCode(purchaseID primitive.ObjectID) (string, error) {
+	secret := voucherCodeSecret()
+	if len(secret) == 0 {
+		return "", ErrVoucherCodesDisabled
+	}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := purchaseID.Hex() + "." + hex.EncodeToString(nonce)
+	return payload + "." + voucherCodeSignature(secret, payload), nil
+}
+
+// parseVoucherRedemptionCode returns the purchase a redemption code was issued for
+func parseVoucherRedemptionCode(code string) (primitive.ObjectID, bool) {
+	secret := voucherCodeSecret()
+	parts := strings.Split(code, ".")
+	if len(secret) == 0 || len(parts) != 3 {
+		return primitive.NilObjectID, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(voucherCodeSignature(secret, payload))) {
+		return primitive.NilObjectID, false
+	}
+	purchaseID, err := primitive.ObjectIDFromHex(parts[0])
+	return purchaseID, err == nil
+}
+
+// VoucherRedemptionCode returns an owner's purchase with its redemption code,
+// issuing one for purchases made before codes were
+func VoucherRedemptionCode(ctx context.Context, db *mongo.Client, ownerType string, ownerID, purchaseID primitive.ObjectID) (models.VoucherPurchase, error) {
+	filter := bson.M{"_id": purchaseID, "ownerType": ownerType, "ownerId": ownerID}
+	var purchase models.VoucherPurchase
+	if err := voucherPurchases(db).FindOne(ctx, filter).Decode(&purchase); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return purchase, ErrVoucherPurchaseNotFound
+		}
+		return purchase, err
+	}
+	if purchase.RedemptionCode != "" {
+		return purchase, nil
+	}
+
+	code, err := NewVoucherRedemptionCode(purchaseID)
+	if err != nil {
+		return purchase, err
+	}
+	filter["redemptionCode"] = bson.M{"$exists": false}
+	if _, err := voucherPurchases(db).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"redemptionCode": code}}); err != nil {
+		return purchase, err
+	}
+	// Read it back in case another request issued the code first
+	err = voucherPurchases(db).FindOne(ctx, bson.M{"_id": purchaseID}).Decode(&purchase)
+	return purchase, err
+}
+
+// VoucherRedeemer is the partner redeeming a voucher: its company or
+// wholesaler, the branch it is redeemed at if any, and the staff member who
+// scanned the code
+type VoucherRedeemer struct {
+	MerchantType string // "company" or "wholesaler"
+	MerchantID   primitive.ObjectID
+	BranchID     *primitive.ObjectID
+	UserID       primitive.ObjectID
+}
+
+// redeemedField returns the purchase field holding the redeeming company or wholesaler
+func (r VoucherRedeemer) redeemedField() string {
+	if r.MerchantType == models.VoucherIssuerWholesaler {
+		return "redeemedWholesalerId"
+	}
+	return "redeemedCompanyId"
+}
+
+// canRedeem reports whether the redeemer honours a purchased voucher. No
+// partner redeems a voucher it bought itself. Admin vouchers are redeemed by
+// any company or wholesaler, only those of the voucher's category when it has
+// one; partner vouchers only by their issuer at the branches they were
+// issued for.
+func (r VoucherRedeemer) canRedeem(voucher models.Voucher, purchase models.VoucherPurchase, merchantCategory string) bool {
+	if purchase.OwnerType == r.MerchantType && purchase.OwnerID == r.MerchantID {
+		return false
+	}
+	if voucher.IssuerID == nil {
+		return voucher.Category == "" || strings.EqualFold(voucher.Category, merchantCategory)
+	}
+	if voucher.IssuerType != r.MerchantType || *voucher.IssuerID != r.MerchantID {
+		return false
+	}
+	if len(voucher.BranchIDs) == 0 || r.BranchID == nil {
+		return true
+	}
+	for _, id := range voucher.BranchIDs {
+		if id == *r.BranchID {
+			return true
+		}
+	}
+	return false
+}
+
+// merchantCategory returns the business category of a company or wholesaler
+func merchantCategory(ctx context.Context, db *mongo.Client, merchantType string, merchantID primitive.ObjectID) (string, error) {
+	collection, ok := PointsCollection(merchantType)
+	if !ok {
+		return "", nil
+	}
+	var merchant struct {
+		Category string `bson:"category"`
+	}
+	err := db.Database("barrim").Collection(collection).FindOne(ctx, bson.M{"_id": merchantID},
+		options.FindOne().SetProjection(bson.M{"category": 1})).Decode(&merchant)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	return merchant.Category, nil
+}
+
+// RedeemVoucher redeems the purchase a scanned code was issued for. A code is
+// redeemed once; every attempt, including refused ones, is recorded in
+// voucher_redemption_attempts.
+func RedeemVoucher(ctx context.Context, db *mongo.Client, code string, redeemer VoucherRedeemer) (models.VoucherPurchase, error) {
+	attempt := models.VoucherRedemptionAttempt{
+		ID:        primitive.NewObjectID(),
+		BranchID:  redeemer.BranchID,
+		UserID:    redeemer.UserID,
+		CreatedAt: time.Now(),
+	}
+	if redeemer.MerchantType == models.VoucherIssuerWholesaler {
+		attempt.WholesalerID = &redeemer.MerchantID
+	} else {
+		attempt.CompanyID = &redeemer.MerchantID
+	}
+	purchase, err := redeemVoucherCode(ctx, db, code, redeemer, attempt.CreatedAt)
+
+	switch err {
+	case nil:
+		attempt.Result = models.VoucherRedemptionRedeemed
+	case ErrVoucherCodeInvalid, ErrVoucherPurchaseNotFound:
+		attempt.Result = models.VoucherRedemptionInvalidCode
+		err = ErrVoucherCodeInvalid
+	case ErrVoucherAlreadyUsed:
+		attempt.Result = models.VoucherRedemptionAlreadyRedeemed
+	case ErrVoucherExpired:
+		attempt.Result = models.VoucherRedemptionExpired
+	case ErrVoucherWrongMerchant:
+		attempt.Result = models.VoucherRedemptionWrongMerchant
+	default:
+		attempt.Result = models.VoucherRedemptionFailed
+	}
+	if !purchase.ID.IsZero() {
+		attempt.PurchaseID = &purchase.ID
+		attempt.VoucherID = &purchase.VoucherID
+	}
+	if attempt.Result == models.VoucherRedemptionAlreadyRedeemed {
+		log.Printf("Repeated redemption of voucher purchase %s by %s %s", purchase.ID.Hex(), redeemer.MerchantType, redeemer.MerchantID.Hex())
+	}
+
+	if _, insertErr := db.Database("barrim").Collection("voucher_redemption_attempts").InsertOne(context.Background(), attempt); insertErr != nil {
+		log.Printf("Error recording voucher redemption attempt: %v", insertErr)
+	}
+	return purchase, err
+}
+
+// redeemVoucherCode marks the purchase of a valid, unused and unexpired code
+// as redeemed, if the redeemer honours its voucher
+func redeemVoucherCode(ctx context.Context, db *mongo.Client, code string, redeemer VoucherRedeemer, now time.Time) (models.VoucherPurchase, error) {
+	var purchase models.VoucherPurchase
+	if len(voucherCodeSecret()) == 0 {
+		return purchase, ErrVoucherCodesDisabled
+	}
+	purchaseID, ok := parseVoucherRedemptionCode(code)
+	if !ok {
+		return purchase, ErrVoucherCodeInvalid
+	}
+
+	err := voucherPurchases(db).FindOne(ctx, bson.M{"_id": purchaseID, "redemptionCode": code}).Decode(&purchase)
+	if err == mongo.ErrNoDocuments {
+		return purchase, ErrVoucherPurchaseNotFound
+	}
+	if err != nil {
+		return purchase, err
+	}
+	var voucher models.Voucher
+	err = db.Database("barrim").Collection("vouchers").FindOne(ctx, bson.M{"_id": purchase.VoucherID},
+		options.FindOne().SetProjection(bson.M{"issuerType": 1, "issuerId": 1, "branchIds": 1, "category": 1})).Decode(&voucher)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return purchase, err
+	}
+	var category string
+	if voucher.IssuerID == nil && voucher.Category != "" {
+		if category, err = merchantCategory(ctx, db, redeemer.MerchantType, redeemer.MerchantID); err != nil {
+			return purchase, err
+		}
+	}
+	if !redeemer.canRedeem(voucher, purchase, category) {
+		return purchase, ErrVoucherWrongMerchant
+	}
+
+	set := bson.M{
+		"isUsed":                 true,
+		"usedAt":                 now,
+		redeemer.redeemedField(): redeemer.MerchantID,
+		"redeemedBy":             redeemer.UserID,
+	}
+	if redeemer.BranchID != nil {
+		set["redeemedBranchId"] = *redeemer.BranchID
+	}
+	err = voucherPurchases(db).FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":            purchaseID,
+			"redemptionCode": code,
+			"isUsed":         false,
+			"$or":            bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}},
+		},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&purchase)
+	if err != mongo.ErrNoDocuments {
+		return purchase, err
+	}
+
+	// Explain why the code could not be redeemed
+	err = voucherPurchases(db).FindOne(ctx, bson.M{"_id": purchaseID, "redemptionCode": code}).Decode(&purchase)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return models.VoucherPurchase{}, ErrVoucherPurchaseNotFound
+	case err != nil:
+		return purchase, err
+	case purchase.IsUsed:
+		return purchase, ErrVoucherAlreadyUsed
+	default:
+		return purchase, ErrVoucherExpired
+	}
+}
+
+// VoucherRedemptionAttempts returns a page of redemption attempts matching a filter, newest first, and their total
+func VoucherRedemptionAttempts(ctx context.Context, db *mongo.Client, filter bson.M, page, limit int) ([]models.VoucherRedemptionAttempt, int64, error) {
+	attempts := db.Database("barrim").Collection("voucher_redemption_attempts")
+	total, err := attempts.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := attempts.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	result := []models.VoucherRedemptionAttempt{}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
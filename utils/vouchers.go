@@ -0,0 +1,431 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/HSouheill/barrim_backend/models"
+)
+
+// Reasons a voucher cannot be bought or used
+var (
+	ErrVoucherNotFound         = errors.New("voucher not found or inactive")
+	ErrVoucherNotYetValid      = errors.New("voucher is not available yet")
+	ErrVoucherEnded            = errors.New("voucher is no longer available")
+	ErrVoucherOutOfStock       = errors.New("voucher is out of stock")
+	ErrVoucherLimitReached     = errors.New("you have already purchased this voucher")
+	ErrVoucherPurchaseNotFound = errors.New("voucher purchase not found")
+	ErrVoucherAlreadyUsed      = errors.New("voucher has already been used")
+	ErrVoucherExpired          = errors.New("voucher has expired")
+	ErrVoucherTierRequired     = errors.New("voucher is reserved for a higher loyalty tier")
+)
+
+// legacyVoucherPurchases are the per-owner purchase collections merged into
+// voucher_purchases, with the field holding their owner
+var legacyVoucherPurchases = []struct {
+	collection, ownerField, ownerType string
+}{
+	{"company_voucher_purchases", "companyId", models.VoucherOwnerCompany},
+	{"service_provider_voucher_purchases", "serviceProviderId", models.VoucherOwnerServiceProvider},
+	{"wholesaler_voucher_purchases", "wholesalerId", models.VoucherOwnerWholesaler},
+}
+
+// voucherPurchases returns the collection of voucher purchases of every owner type
+func voucherPurchases(db *mongo.Client) *mongo.Collection {
+	return db.Database("barrim").Collection("voucher_purchases")
+}
+
+// voucherOpenFilter matches vouchers an owner type of a loyalty tier can buy
+// now: active, aimed at the owner type or at no type, open to the tier,
+// within their validity dates and in stock
+func voucherOpenFilter(ownerType, tier string, now time.Time) bson.M {
+	return bson.M{
+		"isActive": true,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"targetUserType": ownerType},
+				bson.M{"targetUserType": bson.M{"$in": bson.A{nil, ""}}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"minTier": bson.M{"$in": bson.A{nil, ""}}},
+				bson.M{"minTier": bson.M{"$in": loyaltyTiersUpTo(tier)}},
+			}},
+			bson.M{"$or": bson.A{bson.M{"validFrom": nil}, bson.M{"validFrom": bson.M{"$lte": now}}}},
+			bson.M{"$or": bson.A{bson.M{"validUntil": nil}, bson.M{"validUntil": bson.M{"$gte": now}}}},
+			bson.M{"$or": bson.A{
+				bson.M{"stock": bson.M{"$in": bson.A{nil, 0}}},
+				bson.M{"$expr": bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$soldCount", 0}}, "$stock"}}},
+			}},
+		},
+	}
+}
+
+// VoucherViewer is the user browsing vouchers, matched against their audience
+type VoucherViewer struct {
+	InterestedDeals []string
+	City            string
+	Category        string // Only list vouchers of this category when set
+}
+
+// audienceFilter matches vouchers whose audience includes the viewer
+func (v VoucherViewer) audienceFilter() bson.A {
+	untargeted := func(field string) bson.M {
+		return bson.M{"$or": bson.A{bson.M{field: nil}, bson.M{field: bson.M{"$size": 0}}}}
+	}
+	deals := bson.M{"$or": bson.A{untargeted("targetDeals"), bson.M{"targetDeals": bson.M{"$in": v.InterestedDeals}}}}
+	if len(v.InterestedDeals) == 0 {
+		deals = untargeted("targetDeals")
+	}
+	cities := untargeted("targetCities")
+	if v.City != "" {
+		cities = bson.M{"$or": bson.A{cities, bson.M{"targetCities": v.City}}}
+	}
+	clauses := bson.A{deals, cities}
+	if v.Category != "" {
+		clauses = append(clauses, bson.M{"category": v.Category})
+	}
+	return clauses
+}
+
+// matches reports whether a voucher's audience includes the viewer, as audienceFilter does
+func (v VoucherViewer) matches(voucher models.Voucher) bool {
+	if len(voucher.TargetDeals) > 0 && !anyIn(voucher.TargetDeals, v.InterestedDeals) {
+		return false
+	}
+	if len(voucher.TargetCities) > 0 && (v.City == "" || !anyIn(voucher.TargetCities, []string{v.City})) {
+		return false
+	}
+	return v.Category == "" || voucher.Category == v.Category
+}
+
+// anyIn reports whether the two lists share a value
+func anyIn(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VoucherViewerFor returns the audience profile of an owner: the interested
+// deals and city of a user, nil for other owner types that vouchers are not
+// targeted at
+func VoucherViewerFor(ctx context.Context, db *mongo.Client, ownerType string, ownerID primitive.ObjectID) (*VoucherViewer, error) {
+	if ownerType != models.VoucherOwnerUser {
+		return nil, nil
+	}
+	var user models.User
+	err := db.Database("barrim").Collection("users").FindOne(ctx, bson.M{"_id": ownerID},
+		options.FindOne().SetProjection(bson.M{"interestedDeals": 1, "location": 1})).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	viewer := &VoucherViewer{InterestedDeals: user.InterestedDeals}
+	if user.Location != nil {
+		viewer.City = user.Location.City
+	}
+	return viewer, nil
+}
+
+// AvailableVouchers lists the vouchers an owner type of a loyalty tier can
+// buy now. Vouchers without a target type are only listed for users, as
+// before, and a viewer only sees vouchers aimed at them.
+func AvailableVouchers(ctx context.Context, db *mongo.Client, ownerType, tier string, viewer *VoucherViewer) ([]models.Voucher, error) {
+	filter := voucherOpenFilter(ownerType, tier, time.Now())
+	if ownerType != models.VoucherOwnerUser {
+		filter["targetUserType"] = ownerType
+	}
+	if viewer != nil {
+		filter["$and"] = append(filter["$and"].(bson.A), viewer.audienceFilter()...)
+	}
+	cursor, err := db.Database("barrim").Collection("vouchers").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	vouchers := []models.Voucher{}
+	if err := cursor.All(ctx, &vouchers); err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+// voucherUnavailable explains why an active voucher cannot be bought by an
+// owner type of a loyalty tier; a viewer outside the voucher's audience is
+// told it does not exist, as it is never listed for them
+func voucherUnavailable(voucher models.Voucher, ownerType, tier string, viewer *VoucherViewer, now time.Time) error {
+	switch {
+	case voucher.TargetUserType != "" && voucher.TargetUserType != ownerType:
+		return ErrVoucherNotFound
+	case viewer != nil && !viewer.matches(voucher):
+		return ErrVoucherNotFound
+	case voucher.MinTier != "" && models.LoyaltyTierRank(tier) < models.LoyaltyTierRank(voucher.MinTier):
+		return ErrVoucherTierRequired
+	case voucher.ValidFrom != nil && now.Before(*voucher.ValidFrom):
+		return ErrVoucherNotYetValid
+	case voucher.ValidUntil != nil && now.After(*voucher.ValidUntil):
+		return ErrVoucherEnded
+	case voucher.Stock > 0 && voucher.SoldCount >= voucher.Stock:
+		return ErrVoucherOutOfStock
+	}
+	return nil
+}
+
+// PurchaseVoucher buys a voucher for an owner with its points. The stock is
+// reserved, the points debited and the purchase recorded in one transaction;
+// without transactions the earlier steps are undone when a later one fails.
+// The purchase gets a signed redemption code that a partner scans to redeem
+// it, once VOUCHER_CODE_SECRET is set.
+func PurchaseVoucher(ctx context.Context, db *mongo.Client, ownerType string, ownerID, voucherID primitive.ObjectID) (models.VoucherPurchase, *models.PointsLedgerEntry, error) {
+	var purchase models.VoucherPurchase
+	var entry *models.PointsLedgerEntry
+
+	err := RunPointsTransaction(ctx, db, func(ctx context.Context) error {
+		var undo []func()
+		fail := func(err error) error {
+			if mongo.SessionFromContext(ctx) == nil {
+				for i := len(undo) - 1; i >= 0; i-- {
+					undo[i]()
+				}
+			}
+			return err
+		}
+
+		vouchers := db.Database("barrim").Collection("vouchers")
+		var voucher models.Voucher
+		if err := vouchers.FindOne(ctx, bson.M{"_id": voucherID, "isActive": true}).Decode(&voucher); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrVoucherNotFound
+			}
+			return err
+		}
+		tier, err := LoyaltyTier(ctx, db, ownerType, ownerID)
+		if err != nil {
+			return err
+		}
+		viewer, err := VoucherViewerFor(ctx, db, ownerType, ownerID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := voucherUnavailable(voucher, ownerType, tier, viewer, now); err != nil {
+			return err
+		}
+
+		limit := voucher.PerAccountLimit
+		if limit <= 0 {
+			limit = 1
+		}
+		bought, err := voucherPurchases(db).CountDocuments(ctx, bson.M{"ownerType": ownerType, "ownerId": ownerID, "voucherId": voucherID})
+		if err != nil {
+			return err
+		}
+		if int(bought) >= limit {
+			return ErrVoucherLimitReached
+		}
+
+		// Reserve one voucher from the stock
+		reserve := voucherOpenFilter(ownerType, tier, now)
+		reserve["_id"] = voucherID
+		if viewer != nil {
+			reserve["$and"] = append(reserve["$and"].(bson.A), viewer.audienceFilter()...)
+		}
+		result, err := vouchers.UpdateOne(ctx, reserve, bson.M{"$inc": bson.M{"soldCount": 1}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return ErrVoucherOutOfStock
+		}
+		undo = append(undo, func() {
+			if _, err := vouchers.UpdateOne(context.Background(), bson.M{"_id": voucherID}, bson.M{"$inc": bson.M{"soldCount": -1}}); err != nil {
+				log.Printf("Failed to release stock of voucher %s: %v", voucherID.Hex(), err)
+			}
+		})
+
+		purchase = models.VoucherPurchase{
+			ID:          primitive.NewObjectID(),
+			OwnerType:   ownerType,
+			OwnerID:     ownerID,
+			VoucherID:   voucherID,
+			PointsUsed:  voucher.Points,
+			PurchasedAt: now,
+		}
+		// Without a signing key the code is issued later, when the owner asks for it
+		if code, err := NewVoucherRedemptionCode(purchase.ID); err == nil {
+			purchase.RedemptionCode = code
+		} else if err != ErrVoucherCodesDisabled {
+			return fail(err)
+		}
+		if ownerType == models.VoucherOwnerUser {
+			purchase.UserID = ownerID
+		}
+		if voucher.ValidityDays > 0 {
+			expiresAt := now.AddDate(0, 0, voucher.ValidityDays)
+			purchase.ExpiresAt = &expiresAt
+		}
+
+		// Free vouchers leave no trace in the points ledger
+		if voucher.Points > 0 {
+			spent, err := ChangePoints(ctx, db, PointsChange{
+				AccountType: ownerType,
+				AccountID:   ownerID,
+				Type:        models.PointsSpend,
+				Points:      -voucher.Points,
+				SourceType:  models.PointsSourceVoucherPurchase,
+				SourceID:    &purchase.ID,
+			})
+			if err != nil {
+				return fail(err)
+			}
+			entry = &spent
+			undo = append(undo, func() {
+				if _, err := ChangePoints(context.Background(), db, PointsChange{
+					AccountType: ownerType,
+					AccountID:   ownerID,
+					Type:        models.PointsAdjust,
+					Points:      voucher.Points,
+					SourceType:  models.PointsSourceVoucherPurchase,
+					SourceID:    &purchase.ID,
+					Reason:      "Refund of a purchase that could not be recorded",
+				}); err != nil {
+					log.Printf("Failed to refund points of purchase %s: %v", purchase.ID.Hex(), err)
+				}
+			})
+		}
+
+		if _, err := voucherPurchases(db).InsertOne(ctx, purchase); err != nil {
+			return fail(err)
+		}
+		return nil
+	})
+	return purchase, entry, err
+}
+
+// OwnedVoucher is a purchase with the voucher it was for
+type OwnedVoucher struct {
+	Voucher  models.Voucher
+	Purchase models.VoucherPurchase
+}
+
+// OwnerVouchers lists the vouchers an owner bought, newest first
+func OwnerVouchers(ctx context.Context, db *mongo.Client, ownerType string, ownerID primitive.ObjectID) ([]OwnedVoucher, error) {
+	cursor, err := voucherPurchases(db).Find(ctx, bson.M{"ownerType": ownerType, "ownerId": ownerID},
+		options.Find().SetSort(bson.D{{Key: "purchasedAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var purchases []models.VoucherPurchase
+	if err := cursor.All(ctx, &purchases); err != nil {
+		return nil, err
+	}
+	if len(purchases) == 0 {
+		return []OwnedVoucher{}, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(purchases))
+	for _, p := range purchases {
+		ids = append(ids, p.VoucherID)
+	}
+	cursor, err = db.Database("barrim").Collection("vouchers").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var vouchers []models.Voucher
+	if err := cursor.All(ctx, &vouchers); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.Voucher, len(vouchers))
+	for _, v := range vouchers {
+		byID[v.ID] = v
+	}
+
+	owned := make([]OwnedVoucher, 0, len(purchases))
+	for _, p := range purchases {
+		voucher, ok := byID[p.VoucherID]
+		if !ok {
+			continue
+		}
+		owned = append(owned, OwnedVoucher{Voucher: voucher, Purchase: p})
+	}
+	return owned, nil
+}
+
+// MigrateVoucherPurchases merges the per-owner purchase collections into
+// voucher_purchases, sets the owner of user purchases recorded before owner
+// types, and counts the sales of vouchers sold before soldCount was kept. It
+// can run again safely; the old collections are left in place. It returns the number of purchases moved.
+func MigrateVoucherPurchases(ctx context.Context, db *mongo.Client) (int, error) {
+	database := db.Database("barrim")
+	purchases := voucherPurchases(db)
+
+	if _, err := purchases.UpdateMany(ctx,
+		bson.M{"ownerType": bson.M{"$exists": false}, "userId": bson.M{"$exists": true}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"ownerType": models.VoucherOwnerUser, "ownerId": "$userId"}}}},
+	); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, legacy := range legacyVoucherPurchases {
+		cursor, err := database.Collection(legacy.collection).Find(ctx, bson.M{})
+		if err != nil {
+			return moved, err
+		}
+		var docs []bson.M
+		if err := cursor.All(ctx, &docs); err != nil {
+			return moved, err
+		}
+		for _, doc := range docs {
+			ownerID, ok := doc[legacy.ownerField].(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			delete(doc, legacy.ownerField)
+			doc["ownerType"] = legacy.ownerType
+			doc["ownerId"] = ownerID
+			result, err := purchases.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+			if err != nil {
+				return moved, err
+			}
+			if result.UpsertedCount > 0 {
+				moved++
+			}
+		}
+	}
+
+	// Count the earlier sales so that stock limits hold for them. Vouchers that
+	// already keep a count are left alone, as purchases may be updating it.
+	cursor, err := purchases.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$voucherId", "sold": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return moved, err
+	}
+	var counts []struct {
+		VoucherID primitive.ObjectID `bson:"_id"`
+		Sold      int                `bson:"sold"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return moved, err
+	}
+	vouchers := database.Collection("vouchers")
+	for _, count := range counts {
+		if _, err := vouchers.UpdateOne(ctx,
+			bson.M{"_id": count.VoucherID, "soldCount": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"soldCount": count.Sold}},
+		); err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}